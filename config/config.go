@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 
@@ -19,13 +20,29 @@ core:
   worker_num: 0 # default worker number is runtime.NumCPU()
   queue_num: 0 # default queue number is 8192
   max_notification: 100
+  max_body_size: 33554432 # max bytes of the push request body once decompressed, zero is unlimited
+  max_tokens_per_request: 100000 # max tokens of a notification, zero is unlimited
+  tenants: [] # the tenant_id allowed, empty allows any tenant_id of the valid format
   # set true if you need get error message from fail push notification in API response.
   # It only works when the queue engine is local.
   sync: false
+  multi_status: false # in sync mode, respond 207 when a part of the pushes failed and 502 when all of them failed
   # set webhook url if you need get error message asynchronously from fail push notification in API response.
   feedback_hook_url: ""
   feedback_timeout: 10 # default is 10 second
+  feedback_max_retry: 0 # resend the feedback on failure, default value zero is disabled
+  feedback_result: false # post the aggregated result of each push instead of each failed log
   feedback_header:
+  delivery_store: "none" # record the successful Android sends, "none" or "sqlite"
+  delivery_path: "delivery.db" # path of the sqlite database
+  delivery_retention: 7 # days the receipts are kept, zero keeps them forever
+  delivery_requested_only: false # record only the sends with delivery_receipt_requested
+  batch_store: "none" # keep the token batches uploaded to api.batch_uri, "none", "memory" or "file"
+  batch_path: "batches" # directory of the file batch store
+  result_store: "memory" # keep the results of the requests polled on api.status_uri by request ID, "none", "memory" or "redis", redis uses the stat.redis settings
+  result_ttl: 3600 # seconds the results are kept
+  max_concurrent_pushes: 0 # max concurrent sends to the providers of all platforms, zero is unlimited
+  queue_timeout: 10 # seconds a send waits for a free slot of max_concurrent_pushes
   mode: "release"
   ssl: false
   cert_path: "cert.pem"
@@ -51,6 +68,9 @@ api:
   stat_go_uri: "/api/stat/go"
   stat_app_uri: "/api/stat/app"
   config_uri: "/api/config"
+  platform_uri: "/api/platform"
+  batch_uri: "/api/batch"
+  status_uri: "/status"
   sys_stat_uri: "/sys/stats"
   metric_uri: "/metrics"
   health_uri: "/healthz"
@@ -59,6 +79,45 @@ android:
   enabled: true
   project_id: "foo-123"
   service_account_key: "/tmp/key.json"
+  credential: "" # service account key JSON content, used instead of service_account_key
+  timeout: 10 # default is 10 second
+  endpoint: "" # custom FCM endpoint, e.g. an emulator for testing
+  max_retry: 0 # resend fail notification, default value zero is disabled
+  retry_interval: 1 # default is 1 second, doubled on each retry
+  concurrency: 1 # number of 500 token batches sent at the same time
+  rate_limit: 0 # max messages per second per project, default value zero is disabled
+  rate_limit_wait: 10 # max seconds to wait for the rate limit, zero fails at once
+  per_token_rate_limit: 0 # max messages per token within the per_token_rate_window, the others are throttled, default value zero is disabled
+  per_token_rate_window: 60 # seconds
+  downgrade_priority: false # send the high priority messages as normal under quota pressure, unless the request is critical
+  circuit_breaker_threshold: 0 # consecutive failed sends which open the circuit of a project, default value zero is disabled
+  circuit_breaker_timeout: 30 # seconds the open circuit fails the sends fast before a probe send
+  health_check: false # validate the FCM credential with a dry run push on api.health_uri
+  health_check_interval: 30 # seconds the result of the health check is reused, zero checks on every probe
+  stringify_data: false # send nested data values as JSON strings
+  default_icon: "" # notification icon when the request doesn't set one
+  default_color: "" # notification color in #rrggbb format
+  default_sound: "" # notification sound when the request doesn't set one
+  default_channel_id: "" # notification channel of Android 8.0+ when the request doesn't set one
+  default_priority: "" # normal or high, message priority when the request doesn't set one
+  auto_defaults_for_high_priority: false # play the default sound and vibration of the high and max notification priorities, unless the request sets its own
+  inherit_notification_priority: true # show the notifications of the high priority messages as high priority ones, unless they set notification_priority or importance
+  transformer: "none" # name of the transformer applied to the notifications before the sends, "none" or "enforce_channel"
+  restricted_package_name: "" # only deliver to the app with this package name
+  clamp_ttl: false # clamp time_to_live above 4 weeks instead of rejecting the message
+  dedup_tokens: false # send the duplicate tokens of a request once, their result is copied to every position
+  auto_collapse_fields: [] # data keys whose values are the collapse key of the requests with auto_collapse
+  strict_token_validation: false # reject the requests with a malformed token instead of failing the token with invalid_format
+  max_data_size: 4096 # max bytes of the data payload of data only messages, zero disables the check
+  max_notification_size: 4096 # max bytes of the data and notification payloads of notification messages, zero disables the check
+  proxy: "" # http, https or socks5 proxy URL of the FCM connections, HTTPS_PROXY is used when empty
+  ca_cert_file: "" # PEM bundle of the CAs trusted by the FCM connections besides the system ones
+  token_feedback_url: "" # webhook posted the batches of the unregistered tokens to remove, with the timeout, header and retries of the feedback hook
+  http_transport: # tuning of the FCM HTTP connections, zero values keep the default transport
+    max_idle_conns: 0 # max idle connections in total
+    max_idle_conns_per_host: 0 # max idle connections to each FCM host
+    idle_conn_timeout: 0 # seconds an idle connection is kept open
+    keep_alive: 0 # seconds between the TCP keep-alive probes
 
 huawei:
   enabled: false
@@ -68,6 +127,7 @@ huawei:
 
 queue:
   engine: "local" # support "local", "nsq", "nats" and "redis" default value is "local"
+  durable: false # ack the nsq and redis messages once sent instead of once received, the unacked ones are sent again after a crash
   nsq:
     addr: 127.0.0.1:4150
     topic: gorush
@@ -78,6 +138,9 @@ queue:
     queue: gorush
   redis:
     addr: 127.0.0.1:6379
+    password: ""
+    db: 0
+    tls: false
     group: gorush
     consumer: gorush
     stream_name: gorush
@@ -102,6 +165,7 @@ log:
   error_level: "error"
   hide_token: true
   hide_messages: false
+  success_sample_rate: 1 # fraction of the succeeded pushes logged, between 0 and 1, the failed ones are always logged
 
 stat:
   engine: "memory" # support memory, redis, boltdb, buntdb or leveldb
@@ -119,19 +183,36 @@ stat:
     path: "level.db"
   badgerdb:
     path: "badger.db"
+
+dead_letter:
+  enabled: false # persist the notifications failed on unrecoverable errors, see the -replay flag
+  path: "dead_letter.jsonl"
+
+idempotency:
+  enabled: false # skip the notifications whose idempotency_key was sent successfully within the ttl
+  engine: "memory" # memory or redis, redis uses the stat.redis settings
+  ttl: 300 # default is 300 seconds
+
+schedule:
+  engine: "memory" # keeps the notifications with a future send_at, memory or sqlite to survive the restarts
+  path: "schedule.db" # path of the sqlite database
+  interval: 1 # seconds between the checks of the due notifications
 `)
 
 // ConfYaml is config structure.
 type ConfYaml struct {
-	Core    SectionCore    `yaml:"core"`
-	API     SectionAPI     `yaml:"api"`
-	Android SectionAndroid `yaml:"android"`
-	Huawei  SectionHuawei  `yaml:"huawei"`
-	Ios     SectionIos     `yaml:"ios"`
-	Queue   SectionQueue   `yaml:"queue"`
-	Log     SectionLog     `yaml:"log"`
-	Stat    SectionStat    `yaml:"stat"`
-	GRPC    SectionGRPC    `yaml:"grpc"`
+	Core        SectionCore        `yaml:"core"`
+	API         SectionAPI         `yaml:"api"`
+	Android     SectionAndroid     `yaml:"android"`
+	Huawei      SectionHuawei      `yaml:"huawei"`
+	Ios         SectionIos         `yaml:"ios"`
+	Queue       SectionQueue       `yaml:"queue"`
+	Log         SectionLog         `yaml:"log"`
+	Stat        SectionStat        `yaml:"stat"`
+	GRPC        SectionGRPC        `yaml:"grpc"`
+	DeadLetter  SectionDeadLetter  `yaml:"dead_letter"`
+	Idempotency SectionIdempotency `yaml:"idempotency"`
+	Schedule    SectionSchedule    `yaml:"schedule"`
 }
 
 // SectionCore is sub section of config.
@@ -141,10 +222,12 @@ type SectionCore struct {
 	ShutdownTimeout int64          `yaml:"shutdown_timeout"`
 	Port            string         `yaml:"port"`
 	MaxNotification int64          `yaml:"max_notification"`
+	MaxBodySize     int64          `yaml:"max_body_size"`
 	WorkerNum       int64          `yaml:"worker_num"`
 	QueueNum        int64          `yaml:"queue_num"`
 	Mode            string         `yaml:"mode"`
 	Sync            bool           `yaml:"sync"`
+	MultiStatus     bool           `yaml:"multi_status"`
 	SSL             bool           `yaml:"ssl"`
 	CertPath        string         `yaml:"cert_path"`
 	KeyPath         string         `yaml:"key_path"`
@@ -154,9 +237,27 @@ type SectionCore struct {
 	PID             SectionPID     `yaml:"pid"`
 	AutoTLS         SectionAutoTLS `yaml:"auto_tls"`
 
-	FeedbackURL     string   `yaml:"feedback_hook_url"`
-	FeedbackTimeout int64    `yaml:"feedback_timeout"`
-	FeedbackHeader  []string `yaml:"feedback_header"`
+	FeedbackURL      string   `yaml:"feedback_hook_url"`
+	FeedbackTimeout  int64    `yaml:"feedback_timeout"`
+	FeedbackHeader   []string `yaml:"feedback_header"`
+	FeedbackMaxRetry int      `yaml:"feedback_max_retry"`
+	FeedbackResult   bool     `yaml:"feedback_result"`
+
+	DeliveryStore         string `yaml:"delivery_store"`
+	DeliveryPath          string `yaml:"delivery_path"`
+	DeliveryRetention     int64  `yaml:"delivery_retention"`
+	DeliveryRequestedOnly bool   `yaml:"delivery_requested_only"`
+
+	BatchStore string `yaml:"batch_store"`
+	BatchPath  string `yaml:"batch_path"`
+
+	ResultStore string `yaml:"result_store"`
+	ResultTTL   int64  `yaml:"result_ttl"`
+
+	MaxConcurrentPushes int64    `yaml:"max_concurrent_pushes"`
+	QueueTimeout        int64    `yaml:"queue_timeout"`
+	MaxTokensPerRequest int64    `yaml:"max_tokens_per_request"`
+	Tenants             []string `yaml:"tenants"`
 }
 
 // SectionAutoTLS support Let's Encrypt setting.
@@ -168,20 +269,68 @@ type SectionAutoTLS struct {
 
 // SectionAPI is sub section of config.
 type SectionAPI struct {
-	PushURI    string `yaml:"push_uri"`
-	StatGoURI  string `yaml:"stat_go_uri"`
-	StatAppURI string `yaml:"stat_app_uri"`
-	ConfigURI  string `yaml:"config_uri"`
-	SysStatURI string `yaml:"sys_stat_uri"`
-	MetricURI  string `yaml:"metric_uri"`
-	HealthURI  string `yaml:"health_uri"`
+	PushURI     string `yaml:"push_uri"`
+	StatGoURI   string `yaml:"stat_go_uri"`
+	StatAppURI  string `yaml:"stat_app_uri"`
+	ConfigURI   string `yaml:"config_uri"`
+	PlatformURI string `yaml:"platform_uri"`
+	BatchURI    string `yaml:"batch_uri"`
+	StatusURI   string `yaml:"status_uri"`
+	SysStatURI  string `yaml:"sys_stat_uri"`
+	MetricURI   string `yaml:"metric_uri"`
+	HealthURI   string `yaml:"health_uri"`
 }
 
 // SectionAndroid is sub section of config.
 type SectionAndroid struct {
-	Enabled           bool   `yaml:"enabled"`
-	ServiceAccountKey string `yaml:"service_account_key"`
-	ProjectID         string `yaml:"project_id"`
+	Enabled               bool   `yaml:"enabled"`
+	ServiceAccountKey     string `yaml:"service_account_key"`
+	ProjectID             string `yaml:"project_id"`
+	Timeout               int64  `yaml:"timeout"`
+	MaxRetry              int    `yaml:"max_retry"`
+	RetryInterval         int64  `yaml:"retry_interval"`
+	Concurrency           int    `yaml:"concurrency"`
+	StringifyData         bool   `yaml:"stringify_data"`
+	DefaultIcon           string `yaml:"default_icon"`
+	DefaultColor          string `yaml:"default_color"`
+	DefaultSound          string `yaml:"default_sound"`
+	DefaultChannelID      string `yaml:"default_channel_id"`
+	DefaultPriority       string `yaml:"default_priority"`
+	Transformer           string `yaml:"transformer"`
+	RestrictedPackageName string `yaml:"restricted_package_name"`
+	Endpoint              string `yaml:"endpoint"`
+	Credential            string `yaml:"credential"`
+	ClampTTL              bool   `yaml:"clamp_ttl"`
+	DedupTokens           bool   `yaml:"dedup_tokens"`
+	StrictTokenValidation bool   `yaml:"strict_token_validation"`
+	Proxy                 string `yaml:"proxy"`
+	CACertFile            string `yaml:"ca_cert_file"`
+	TokenFeedbackURL      string `yaml:"token_feedback_url"`
+	RateLimit             int    `yaml:"rate_limit"`
+	RateLimitWait         int64  `yaml:"rate_limit_wait"`
+	PerTokenRateLimit     int    `yaml:"per_token_rate_limit"`
+	PerTokenRateWindow    int64  `yaml:"per_token_rate_window"`
+	DowngradePriority     bool   `yaml:"downgrade_priority"`
+	HealthCheck           bool   `yaml:"health_check"`
+	HealthCheckInterval   int64  `yaml:"health_check_interval"`
+	BreakerThreshold      int    `yaml:"circuit_breaker_threshold"`
+	BreakerTimeout        int64  `yaml:"circuit_breaker_timeout"`
+	MaxDataSize           int    `yaml:"max_data_size"`
+	MaxNotificationSize   int    `yaml:"max_notification_size"`
+
+	AutoCollapseFields          []string `yaml:"auto_collapse_fields"`
+	AutoDefaultsForHighPriority bool     `yaml:"auto_defaults_for_high_priority"`
+	InheritNotificationPriority bool     `yaml:"inherit_notification_priority"`
+
+	HTTPTransport SectionHTTPTransport `yaml:"http_transport"`
+}
+
+// SectionHTTPTransport is sub section of config.
+type SectionHTTPTransport struct {
+	MaxIdleConns        int   `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int   `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     int64 `yaml:"idle_conn_timeout"`
+	KeepAlive           int64 `yaml:"keep_alive"`
 }
 
 // SectionHuawei is sub section of config.
@@ -215,6 +364,8 @@ type SectionLog struct {
 	ErrorLevel   string `yaml:"error_level"`
 	HideToken    bool   `yaml:"hide_token"`
 	HideMessages bool   `yaml:"hide_messages"`
+
+	SuccessSampleRate float64 `yaml:"success_sample_rate"`
 }
 
 // SectionStat is sub section of config.
@@ -229,10 +380,11 @@ type SectionStat struct {
 
 // SectionQueue is sub section of config.
 type SectionQueue struct {
-	Engine string            `yaml:"engine"`
-	NSQ    SectionNSQ        `yaml:"nsq"`
-	NATS   SectionNATS       `yaml:"nats"`
-	Redis  SectionRedisQueue `yaml:"redis"`
+	Engine  string            `yaml:"engine"`
+	Durable bool              `yaml:"durable"`
+	NSQ     SectionNSQ        `yaml:"nsq"`
+	NATS    SectionNATS       `yaml:"nats"`
+	Redis   SectionRedisQueue `yaml:"redis"`
 }
 
 // SectionNSQ is sub section of config.
@@ -252,6 +404,9 @@ type SectionNATS struct {
 // SectionRedisQueue is sub section of config.
 type SectionRedisQueue struct {
 	Addr       string `yaml:"addr"`
+	Password   string `yaml:"password"`
+	DB         int    `yaml:"db"`
+	TLS        bool   `yaml:"tls"`
 	StreamName string `yaml:"stream_name"`
 	Group      string `yaml:"group"`
 	Consumer   string `yaml:"consumer"`
@@ -299,8 +454,32 @@ type SectionGRPC struct {
 	Port    string `yaml:"port"`
 }
 
+// SectionDeadLetter is sub section of config.
+type SectionDeadLetter struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// SectionIdempotency is sub section of config.
+type SectionIdempotency struct {
+	Enabled bool   `yaml:"enabled"`
+	Engine  string `yaml:"engine"`
+	TTL     int64  `yaml:"ttl"`
+}
+
+// SectionSchedule is sub section of config.
+type SectionSchedule struct {
+	Engine   string `yaml:"engine"`
+	Path     string `yaml:"path"`
+	Interval int64  `yaml:"interval"`
+}
+
+// colorPattern is the #rrggbb format of notification colors.
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
 func setDefault() {
 	viper.SetDefault("ios.max_concurrent_pushes", uint(100))
+	viper.SetDefault("log.success_sample_rate", 1.0)
 }
 
 // LoadConf load config from file and read in environment variables that match
@@ -349,15 +528,31 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.Core.QueueNum = int64(viper.GetInt("core.queue_num"))
 	conf.Core.Mode = viper.GetString("core.mode")
 	conf.Core.Sync = viper.GetBool("core.sync")
+	conf.Core.MultiStatus = viper.GetBool("core.multi_status")
 	conf.Core.FeedbackURL = viper.GetString("core.feedback_hook_url")
 	conf.Core.FeedbackTimeout = int64(viper.GetInt("core.feedback_timeout"))
 	conf.Core.FeedbackHeader = viper.GetStringSlice("core.feedback_header")
+	conf.Core.FeedbackMaxRetry = viper.GetInt("core.feedback_max_retry")
+	conf.Core.FeedbackResult = viper.GetBool("core.feedback_result")
+	conf.Core.DeliveryStore = viper.GetString("core.delivery_store")
+	conf.Core.DeliveryPath = viper.GetString("core.delivery_path")
+	conf.Core.DeliveryRetention = int64(viper.GetInt("core.delivery_retention"))
+	conf.Core.DeliveryRequestedOnly = viper.GetBool("core.delivery_requested_only")
+	conf.Core.BatchStore = viper.GetString("core.batch_store")
+	conf.Core.BatchPath = viper.GetString("core.batch_path")
+	conf.Core.ResultStore = viper.GetString("core.result_store")
+	conf.Core.ResultTTL = int64(viper.GetInt("core.result_ttl"))
+	conf.Core.MaxConcurrentPushes = int64(viper.GetInt("core.max_concurrent_pushes"))
+	conf.Core.QueueTimeout = int64(viper.GetInt("core.queue_timeout"))
 	conf.Core.SSL = viper.GetBool("core.ssl")
 	conf.Core.CertPath = viper.GetString("core.cert_path")
 	conf.Core.KeyPath = viper.GetString("core.key_path")
 	conf.Core.CertBase64 = viper.GetString("core.cert_base64")
 	conf.Core.KeyBase64 = viper.GetString("core.key_base64")
 	conf.Core.MaxNotification = int64(viper.GetInt("core.max_notification"))
+	conf.Core.MaxBodySize = viper.GetInt64("core.max_body_size")
+	conf.Core.MaxTokensPerRequest = viper.GetInt64("core.max_tokens_per_request")
+	conf.Core.Tenants = viper.GetStringSlice("core.tenants")
 	conf.Core.HTTPProxy = viper.GetString("core.http_proxy")
 	conf.Core.PID.Enabled = viper.GetBool("core.pid.enabled")
 	conf.Core.PID.Path = viper.GetString("core.pid.path")
@@ -371,6 +566,9 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.API.StatGoURI = viper.GetString("api.stat_go_uri")
 	conf.API.StatAppURI = viper.GetString("api.stat_app_uri")
 	conf.API.ConfigURI = viper.GetString("api.config_uri")
+	conf.API.PlatformURI = viper.GetString("api.platform_uri")
+	conf.API.BatchURI = viper.GetString("api.batch_uri")
+	conf.API.StatusURI = viper.GetString("api.status_uri")
 	conf.API.SysStatURI = viper.GetString("api.sys_stat_uri")
 	conf.API.MetricURI = viper.GetString("api.metric_uri")
 	conf.API.HealthURI = viper.GetString("api.health_uri")
@@ -379,6 +577,44 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.Android.Enabled = viper.GetBool("android.enabled")
 	conf.Android.ProjectID = viper.GetString("android.project_id")
 	conf.Android.ServiceAccountKey = viper.GetString("android.service_account_key")
+	conf.Android.Timeout = int64(viper.GetInt("android.timeout"))
+	conf.Android.MaxRetry = viper.GetInt("android.max_retry")
+	conf.Android.RetryInterval = int64(viper.GetInt("android.retry_interval"))
+	conf.Android.Concurrency = viper.GetInt("android.concurrency")
+	conf.Android.StringifyData = viper.GetBool("android.stringify_data")
+	conf.Android.DefaultIcon = viper.GetString("android.default_icon")
+	conf.Android.DefaultColor = viper.GetString("android.default_color")
+	conf.Android.DefaultSound = viper.GetString("android.default_sound")
+	conf.Android.DefaultChannelID = viper.GetString("android.default_channel_id")
+	conf.Android.Transformer = viper.GetString("android.transformer")
+	conf.Android.DefaultPriority = viper.GetString("android.default_priority")
+	conf.Android.RestrictedPackageName = viper.GetString("android.restricted_package_name")
+	conf.Android.Endpoint = viper.GetString("android.endpoint")
+	conf.Android.Credential = viper.GetString("android.credential")
+	conf.Android.ClampTTL = viper.GetBool("android.clamp_ttl")
+	conf.Android.DedupTokens = viper.GetBool("android.dedup_tokens")
+	conf.Android.AutoCollapseFields = viper.GetStringSlice("android.auto_collapse_fields")
+	conf.Android.AutoDefaultsForHighPriority = viper.GetBool("android.auto_defaults_for_high_priority")
+	conf.Android.InheritNotificationPriority = viper.GetBool("android.inherit_notification_priority")
+	conf.Android.StrictTokenValidation = viper.GetBool("android.strict_token_validation")
+	conf.Android.Proxy = viper.GetString("android.proxy")
+	conf.Android.CACertFile = viper.GetString("android.ca_cert_file")
+	conf.Android.TokenFeedbackURL = viper.GetString("android.token_feedback_url")
+	conf.Android.MaxDataSize = viper.GetInt("android.max_data_size")
+	conf.Android.MaxNotificationSize = viper.GetInt("android.max_notification_size")
+	conf.Android.RateLimit = viper.GetInt("android.rate_limit")
+	conf.Android.RateLimitWait = int64(viper.GetInt("android.rate_limit_wait"))
+	conf.Android.PerTokenRateLimit = viper.GetInt("android.per_token_rate_limit")
+	conf.Android.PerTokenRateWindow = int64(viper.GetInt("android.per_token_rate_window"))
+	conf.Android.DowngradePriority = viper.GetBool("android.downgrade_priority")
+	conf.Android.HealthCheck = viper.GetBool("android.health_check")
+	conf.Android.HealthCheckInterval = viper.GetInt64("android.health_check_interval")
+	conf.Android.BreakerThreshold = viper.GetInt("android.circuit_breaker_threshold")
+	conf.Android.BreakerTimeout = int64(viper.GetInt("android.circuit_breaker_timeout"))
+	conf.Android.HTTPTransport.MaxIdleConns = viper.GetInt("android.http_transport.max_idle_conns")
+	conf.Android.HTTPTransport.MaxIdleConnsPerHost = viper.GetInt("android.http_transport.max_idle_conns_per_host")
+	conf.Android.HTTPTransport.IdleConnTimeout = int64(viper.GetInt("android.http_transport.idle_conn_timeout"))
+	conf.Android.HTTPTransport.KeepAlive = int64(viper.GetInt("android.http_transport.keep_alive"))
 
 	// Huawei
 	conf.Huawei.Enabled = viper.GetBool("huawei.enabled")
@@ -406,9 +642,11 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.Log.ErrorLevel = viper.GetString("log.error_level")
 	conf.Log.HideToken = viper.GetBool("log.hide_token")
 	conf.Log.HideMessages = viper.GetBool("log.hide_messages")
+	conf.Log.SuccessSampleRate = viper.GetFloat64("log.success_sample_rate")
 
 	// Queue Engine
 	conf.Queue.Engine = viper.GetString("queue.engine")
+	conf.Queue.Durable = viper.GetBool("queue.durable")
 	conf.Queue.NSQ.Addr = viper.GetString("queue.nsq.addr")
 	conf.Queue.NSQ.Topic = viper.GetString("queue.nsq.topic")
 	conf.Queue.NSQ.Channel = viper.GetString("queue.nsq.channel")
@@ -416,6 +654,9 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.Queue.NATS.Subj = viper.GetString("queue.nats.subj")
 	conf.Queue.NATS.Queue = viper.GetString("queue.nats.queue")
 	conf.Queue.Redis.Addr = viper.GetString("queue.redis.addr")
+	conf.Queue.Redis.Password = viper.GetString("queue.redis.password")
+	conf.Queue.Redis.DB = viper.GetInt("queue.redis.db")
+	conf.Queue.Redis.TLS = viper.GetBool("queue.redis.tls")
 	conf.Queue.Redis.StreamName = viper.GetString("queue.redis.stream_name")
 	conf.Queue.Redis.Group = viper.GetString("queue.redis.group")
 	conf.Queue.Redis.Consumer = viper.GetString("queue.redis.consumer")
@@ -436,6 +677,20 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 	conf.GRPC.Enabled = viper.GetBool("grpc.enabled")
 	conf.GRPC.Port = viper.GetString("grpc.port")
 
+	// Dead Letter
+	conf.DeadLetter.Enabled = viper.GetBool("dead_letter.enabled")
+	conf.DeadLetter.Path = viper.GetString("dead_letter.path")
+
+	// Idempotency
+	conf.Idempotency.Enabled = viper.GetBool("idempotency.enabled")
+	conf.Idempotency.Engine = viper.GetString("idempotency.engine")
+	conf.Idempotency.TTL = int64(viper.GetInt("idempotency.ttl"))
+
+	// Schedule
+	conf.Schedule.Engine = viper.GetString("schedule.engine")
+	conf.Schedule.Path = viper.GetString("schedule.path")
+	conf.Schedule.Interval = int64(viper.GetInt("schedule.interval"))
+
 	if conf.Core.WorkerNum == int64(0) {
 		conf.Core.WorkerNum = int64(runtime.NumCPU())
 	}
@@ -444,5 +699,9 @@ func LoadConf(confPath ...string) (*ConfYaml, error) {
 		conf.Core.QueueNum = int64(8192)
 	}
 
+	if conf.Android.DefaultColor != "" && !colorPattern.MatchString(conf.Android.DefaultColor) {
+		return conf, fmt.Errorf("invalid android default color %q, the format must be #rrggbb", conf.Android.DefaultColor)
+	}
+
 	return conf, nil
 }
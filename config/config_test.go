@@ -24,6 +24,8 @@ func TestEmptyConfig(t *testing.T) {
 	}
 
 	assert.Equal(t, uint(100), conf.Ios.MaxConcurrentPushes)
+	// the succeeded pushes are still logged without the key
+	assert.Equal(t, 1.0, conf.Log.SuccessSampleRate)
 }
 
 type ConfigTestSuite struct {
@@ -54,15 +56,31 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	assert.Equal(suite.T(), int64(8192), suite.ConfGorushDefault.Core.QueueNum)
 	assert.Equal(suite.T(), "release", suite.ConfGorushDefault.Core.Mode)
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Core.Sync)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Core.MultiStatus)
 	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Core.FeedbackURL)
 	assert.Equal(suite.T(), 0, len(suite.ConfGorushDefault.Core.FeedbackHeader))
 	assert.Equal(suite.T(), int64(10), suite.ConfGorushDefault.Core.FeedbackTimeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Core.FeedbackMaxRetry)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Core.FeedbackResult)
+	assert.Equal(suite.T(), "none", suite.ConfGorushDefault.Core.DeliveryStore)
+	assert.Equal(suite.T(), "delivery.db", suite.ConfGorushDefault.Core.DeliveryPath)
+	assert.Equal(suite.T(), int64(7), suite.ConfGorushDefault.Core.DeliveryRetention)
+	assert.False(suite.T(), suite.ConfGorushDefault.Core.DeliveryRequestedOnly)
+	assert.Equal(suite.T(), "none", suite.ConfGorushDefault.Core.BatchStore)
+	assert.Equal(suite.T(), "batches", suite.ConfGorushDefault.Core.BatchPath)
+	assert.Equal(suite.T(), "memory", suite.ConfGorushDefault.Core.ResultStore)
+	assert.Equal(suite.T(), int64(3600), suite.ConfGorushDefault.Core.ResultTTL)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorushDefault.Core.MaxConcurrentPushes)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorushDefault.Core.QueueTimeout)
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Core.SSL)
 	assert.Equal(suite.T(), "cert.pem", suite.ConfGorushDefault.Core.CertPath)
 	assert.Equal(suite.T(), "key.pem", suite.ConfGorushDefault.Core.KeyPath)
 	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Core.KeyBase64)
 	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Core.CertBase64)
 	assert.Equal(suite.T(), int64(100), suite.ConfGorushDefault.Core.MaxNotification)
+	assert.Equal(suite.T(), int64(33554432), suite.ConfGorushDefault.Core.MaxBodySize)
+	assert.Equal(suite.T(), int64(100000), suite.ConfGorushDefault.Core.MaxTokensPerRequest)
+	assert.Equal(suite.T(), 0, len(suite.ConfGorushDefault.Core.Tenants))
 	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Core.HTTPProxy)
 	// Pid
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Core.PID.Enabled)
@@ -77,6 +95,9 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	assert.Equal(suite.T(), "/api/stat/go", suite.ConfGorushDefault.API.StatGoURI)
 	assert.Equal(suite.T(), "/api/stat/app", suite.ConfGorushDefault.API.StatAppURI)
 	assert.Equal(suite.T(), "/api/config", suite.ConfGorushDefault.API.ConfigURI)
+	assert.Equal(suite.T(), "/api/platform", suite.ConfGorushDefault.API.PlatformURI)
+	assert.Equal(suite.T(), "/api/batch", suite.ConfGorushDefault.API.BatchURI)
+	assert.Equal(suite.T(), "/status", suite.ConfGorushDefault.API.StatusURI)
 	assert.Equal(suite.T(), "/sys/stats", suite.ConfGorushDefault.API.SysStatURI)
 	assert.Equal(suite.T(), "/metrics", suite.ConfGorushDefault.API.MetricURI)
 	assert.Equal(suite.T(), "/healthz", suite.ConfGorushDefault.API.HealthURI)
@@ -85,6 +106,44 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	assert.Equal(suite.T(), true, suite.ConfGorushDefault.Android.Enabled)
 	assert.Equal(suite.T(), "foo-123", suite.ConfGorushDefault.Android.ProjectID)
 	assert.Equal(suite.T(), "/tmp/key.json", suite.ConfGorushDefault.Android.ServiceAccountKey)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorushDefault.Android.Timeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.MaxRetry)
+	assert.Equal(suite.T(), int64(1), suite.ConfGorushDefault.Android.RetryInterval)
+	assert.Equal(suite.T(), 1, suite.ConfGorushDefault.Android.Concurrency)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.StringifyData)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.DefaultIcon)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.DefaultColor)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.DefaultSound)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.DefaultChannelID)
+	assert.Equal(suite.T(), "none", suite.ConfGorushDefault.Android.Transformer)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.DefaultPriority)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.RestrictedPackageName)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.Endpoint)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.Credential)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.ClampTTL)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.DedupTokens)
+	assert.Equal(suite.T(), 0, len(suite.ConfGorushDefault.Android.AutoCollapseFields))
+	assert.False(suite.T(), suite.ConfGorushDefault.Android.AutoDefaultsForHighPriority)
+	assert.True(suite.T(), suite.ConfGorushDefault.Android.InheritNotificationPriority)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.StrictTokenValidation)
+	assert.Equal(suite.T(), 4096, suite.ConfGorushDefault.Android.MaxDataSize)
+	assert.Equal(suite.T(), 4096, suite.ConfGorushDefault.Android.MaxNotificationSize)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.RateLimit)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorushDefault.Android.RateLimitWait)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.PerTokenRateLimit)
+	assert.Equal(suite.T(), int64(60), suite.ConfGorushDefault.Android.PerTokenRateWindow)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.DowngradePriority)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Android.HealthCheck)
+	assert.Equal(suite.T(), int64(30), suite.ConfGorushDefault.Android.HealthCheckInterval)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.Proxy)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.CACertFile)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Android.TokenFeedbackURL)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.BreakerThreshold)
+	assert.Equal(suite.T(), int64(30), suite.ConfGorushDefault.Android.BreakerTimeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.HTTPTransport.MaxIdleConns)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Android.HTTPTransport.MaxIdleConnsPerHost)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorushDefault.Android.HTTPTransport.IdleConnTimeout)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorushDefault.Android.HTTPTransport.KeepAlive)
 
 	// iOS
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Ios.Enabled)
@@ -100,6 +159,7 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 
 	// queue
 	assert.Equal(suite.T(), "local", suite.ConfGorushDefault.Queue.Engine)
+	assert.False(suite.T(), suite.ConfGorushDefault.Queue.Durable)
 	assert.Equal(suite.T(), "127.0.0.1:4150", suite.ConfGorushDefault.Queue.NSQ.Addr)
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.NSQ.Topic)
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.NSQ.Channel)
@@ -109,6 +169,9 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.NATS.Queue)
 
 	assert.Equal(suite.T(), "127.0.0.1:6379", suite.ConfGorushDefault.Queue.Redis.Addr)
+	assert.Equal(suite.T(), "", suite.ConfGorushDefault.Queue.Redis.Password)
+	assert.Equal(suite.T(), 0, suite.ConfGorushDefault.Queue.Redis.DB)
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Queue.Redis.TLS)
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.Redis.StreamName)
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.Redis.Group)
 	assert.Equal(suite.T(), "gorush", suite.ConfGorushDefault.Queue.Redis.Consumer)
@@ -121,6 +184,7 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	assert.Equal(suite.T(), "error", suite.ConfGorushDefault.Log.ErrorLevel)
 	assert.Equal(suite.T(), true, suite.ConfGorushDefault.Log.HideToken)
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Log.HideMessages)
+	assert.Equal(suite.T(), float64(1), suite.ConfGorushDefault.Log.SuccessSampleRate)
 
 	assert.Equal(suite.T(), "memory", suite.ConfGorushDefault.Stat.Engine)
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Stat.Redis.Cluster)
@@ -138,6 +202,20 @@ func (suite *ConfigTestSuite) TestValidateConfDefault() {
 	// gRPC
 	assert.Equal(suite.T(), false, suite.ConfGorushDefault.GRPC.Enabled)
 	assert.Equal(suite.T(), "9000", suite.ConfGorushDefault.GRPC.Port)
+
+	// Dead Letter
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.DeadLetter.Enabled)
+	assert.Equal(suite.T(), "dead_letter.jsonl", suite.ConfGorushDefault.DeadLetter.Path)
+
+	// Idempotency
+	assert.Equal(suite.T(), false, suite.ConfGorushDefault.Idempotency.Enabled)
+	assert.Equal(suite.T(), "memory", suite.ConfGorushDefault.Idempotency.Engine)
+	assert.Equal(suite.T(), int64(300), suite.ConfGorushDefault.Idempotency.TTL)
+
+	// Schedule
+	assert.Equal(suite.T(), "memory", suite.ConfGorushDefault.Schedule.Engine)
+	assert.Equal(suite.T(), "schedule.db", suite.ConfGorushDefault.Schedule.Path)
+	assert.Equal(suite.T(), int64(1), suite.ConfGorushDefault.Schedule.Interval)
 }
 
 func (suite *ConfigTestSuite) TestValidateConf() {
@@ -149,8 +227,21 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	assert.Equal(suite.T(), int64(8192), suite.ConfGorush.Core.QueueNum)
 	assert.Equal(suite.T(), "release", suite.ConfGorush.Core.Mode)
 	assert.Equal(suite.T(), false, suite.ConfGorush.Core.Sync)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Core.MultiStatus)
 	assert.Equal(suite.T(), "", suite.ConfGorush.Core.FeedbackURL)
 	assert.Equal(suite.T(), int64(10), suite.ConfGorush.Core.FeedbackTimeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Core.FeedbackMaxRetry)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Core.FeedbackResult)
+	assert.Equal(suite.T(), "none", suite.ConfGorush.Core.DeliveryStore)
+	assert.Equal(suite.T(), "delivery.db", suite.ConfGorush.Core.DeliveryPath)
+	assert.Equal(suite.T(), int64(7), suite.ConfGorush.Core.DeliveryRetention)
+	assert.False(suite.T(), suite.ConfGorush.Core.DeliveryRequestedOnly)
+	assert.Equal(suite.T(), "none", suite.ConfGorush.Core.BatchStore)
+	assert.Equal(suite.T(), "batches", suite.ConfGorush.Core.BatchPath)
+	assert.Equal(suite.T(), "memory", suite.ConfGorush.Core.ResultStore)
+	assert.Equal(suite.T(), int64(3600), suite.ConfGorush.Core.ResultTTL)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorush.Core.MaxConcurrentPushes)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorush.Core.QueueTimeout)
 	assert.Equal(suite.T(), 1, len(suite.ConfGorush.Core.FeedbackHeader))
 	assert.Equal(suite.T(), "x-gorush-token:4e989115e09680f44a645519fed6a976", suite.ConfGorush.Core.FeedbackHeader[0])
 	assert.Equal(suite.T(), false, suite.ConfGorush.Core.SSL)
@@ -159,6 +250,9 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	assert.Equal(suite.T(), "", suite.ConfGorush.Core.CertBase64)
 	assert.Equal(suite.T(), "", suite.ConfGorush.Core.KeyBase64)
 	assert.Equal(suite.T(), int64(100), suite.ConfGorush.Core.MaxNotification)
+	assert.Equal(suite.T(), int64(33554432), suite.ConfGorush.Core.MaxBodySize)
+	assert.Equal(suite.T(), int64(100000), suite.ConfGorush.Core.MaxTokensPerRequest)
+	assert.Equal(suite.T(), 0, len(suite.ConfGorush.Core.Tenants))
 	assert.Equal(suite.T(), "", suite.ConfGorush.Core.HTTPProxy)
 	// Pid
 	assert.Equal(suite.T(), false, suite.ConfGorush.Core.PID.Enabled)
@@ -173,6 +267,9 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	assert.Equal(suite.T(), "/api/stat/go", suite.ConfGorush.API.StatGoURI)
 	assert.Equal(suite.T(), "/api/stat/app", suite.ConfGorush.API.StatAppURI)
 	assert.Equal(suite.T(), "/api/config", suite.ConfGorush.API.ConfigURI)
+	assert.Equal(suite.T(), "/api/platform", suite.ConfGorush.API.PlatformURI)
+	assert.Equal(suite.T(), "/api/batch", suite.ConfGorush.API.BatchURI)
+	assert.Equal(suite.T(), "/status", suite.ConfGorush.API.StatusURI)
 	assert.Equal(suite.T(), "/sys/stats", suite.ConfGorush.API.SysStatURI)
 	assert.Equal(suite.T(), "/metrics", suite.ConfGorush.API.MetricURI)
 	assert.Equal(suite.T(), "/healthz", suite.ConfGorush.API.HealthURI)
@@ -181,6 +278,44 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	assert.Equal(suite.T(), true, suite.ConfGorush.Android.Enabled)
 	assert.Equal(suite.T(), "foo-123", suite.ConfGorushDefault.Android.ProjectID)
 	assert.Equal(suite.T(), "/tmp/key.json", suite.ConfGorushDefault.Android.ServiceAccountKey)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorush.Android.Timeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.MaxRetry)
+	assert.Equal(suite.T(), int64(1), suite.ConfGorush.Android.RetryInterval)
+	assert.Equal(suite.T(), 1, suite.ConfGorush.Android.Concurrency)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.StringifyData)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.DefaultIcon)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.DefaultColor)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.DefaultSound)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.DefaultChannelID)
+	assert.Equal(suite.T(), "none", suite.ConfGorush.Android.Transformer)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.DefaultPriority)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.RestrictedPackageName)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.Endpoint)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.Credential)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.ClampTTL)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.DedupTokens)
+	assert.Equal(suite.T(), 0, len(suite.ConfGorush.Android.AutoCollapseFields))
+	assert.False(suite.T(), suite.ConfGorush.Android.AutoDefaultsForHighPriority)
+	assert.True(suite.T(), suite.ConfGorush.Android.InheritNotificationPriority)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.StrictTokenValidation)
+	assert.Equal(suite.T(), 4096, suite.ConfGorush.Android.MaxDataSize)
+	assert.Equal(suite.T(), 4096, suite.ConfGorush.Android.MaxNotificationSize)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.RateLimit)
+	assert.Equal(suite.T(), int64(10), suite.ConfGorush.Android.RateLimitWait)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.PerTokenRateLimit)
+	assert.Equal(suite.T(), int64(60), suite.ConfGorush.Android.PerTokenRateWindow)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.DowngradePriority)
+	assert.Equal(suite.T(), false, suite.ConfGorush.Android.HealthCheck)
+	assert.Equal(suite.T(), int64(30), suite.ConfGorush.Android.HealthCheckInterval)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.Proxy)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.CACertFile)
+	assert.Equal(suite.T(), "", suite.ConfGorush.Android.TokenFeedbackURL)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.BreakerThreshold)
+	assert.Equal(suite.T(), int64(30), suite.ConfGorush.Android.BreakerTimeout)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.HTTPTransport.MaxIdleConns)
+	assert.Equal(suite.T(), 0, suite.ConfGorush.Android.HTTPTransport.MaxIdleConnsPerHost)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorush.Android.HTTPTransport.IdleConnTimeout)
+	assert.Equal(suite.T(), int64(0), suite.ConfGorush.Android.HTTPTransport.KeepAlive)
 
 	// iOS
 	assert.Equal(suite.T(), false, suite.ConfGorush.Ios.Enabled)
@@ -201,6 +336,7 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	assert.Equal(suite.T(), "stderr", suite.ConfGorush.Log.ErrorLog)
 	assert.Equal(suite.T(), "error", suite.ConfGorush.Log.ErrorLevel)
 	assert.Equal(suite.T(), true, suite.ConfGorush.Log.HideToken)
+	assert.Equal(suite.T(), float64(1), suite.ConfGorush.Log.SuccessSampleRate)
 
 	assert.Equal(suite.T(), "memory", suite.ConfGorush.Stat.Engine)
 	assert.Equal(suite.T(), false, suite.ConfGorush.Stat.Redis.Cluster)
@@ -218,6 +354,20 @@ func (suite *ConfigTestSuite) TestValidateConf() {
 	// gRPC
 	assert.Equal(suite.T(), false, suite.ConfGorush.GRPC.Enabled)
 	assert.Equal(suite.T(), "9000", suite.ConfGorush.GRPC.Port)
+
+	// Dead Letter
+	assert.Equal(suite.T(), false, suite.ConfGorush.DeadLetter.Enabled)
+	assert.Equal(suite.T(), "dead_letter.jsonl", suite.ConfGorush.DeadLetter.Path)
+
+	// Idempotency
+	assert.Equal(suite.T(), false, suite.ConfGorush.Idempotency.Enabled)
+	assert.Equal(suite.T(), "memory", suite.ConfGorush.Idempotency.Engine)
+	assert.Equal(suite.T(), int64(300), suite.ConfGorush.Idempotency.TTL)
+
+	// Schedule
+	assert.Equal(suite.T(), "memory", suite.ConfGorush.Schedule.Engine)
+	assert.Equal(suite.T(), "schedule.db", suite.ConfGorush.Schedule.Path)
+	assert.Equal(suite.T(), int64(1), suite.ConfGorush.Schedule.Interval)
 }
 
 func TestConfigTestSuite(t *testing.T) {
@@ -248,6 +398,17 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	assert.Equal(t, "x-auth-key:0987654321", ConfGorush.Core.FeedbackHeader[1])
 }
 
+func TestLoadAndroidDefaultColor(t *testing.T) {
+	t.Setenv("GORUSH_ANDROID_DEFAULT_COLOR", "#ff0000")
+	conf, err := LoadConf("testdata/config.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "#ff0000", conf.Android.DefaultColor)
+
+	t.Setenv("GORUSH_ANDROID_DEFAULT_COLOR", "red")
+	_, err = LoadConf("testdata/config.yml")
+	assert.Error(t, err)
+}
+
 func TestLoadWrongDefaultYAMLConfig(t *testing.T) {
 	defaultConf = []byte(`a`)
 	_, err := LoadConf()
@@ -0,0 +1,20 @@
+package core
+
+// ErrorCode is the stable code of a failed push, clients can branch on it
+// instead of matching the error text.
+type ErrorCode string
+
+const (
+	// ErrorCodeInvalidToken the token is invalid or no longer registered
+	ErrorCodeInvalidToken ErrorCode = "invalid_token"
+	// ErrorCodeQuotaExceeded the sending quota or rate limit is exceeded
+	ErrorCodeQuotaExceeded ErrorCode = "quota_exceeded"
+	// ErrorCodeAuthError the credential is not allowed to send the message
+	ErrorCodeAuthError ErrorCode = "auth_error"
+	// ErrorCodeServerError the push service is unavailable or failed internally
+	ErrorCodeServerError ErrorCode = "server_error"
+	// ErrorCodeTimeout the request didn't finish in time
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeInvalidPayload the message is rejected by the push service
+	ErrorCodeInvalidPayload ErrorCode = "invalid_payload"
+)
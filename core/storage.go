@@ -22,6 +22,9 @@ const (
 
 	// HuaweiErrorKey is key name for huawei error count of storage
 	HuaweiErrorKey = "gorush-huawei-error-count"
+
+	// TenantKeyPrefix is the prefix of the key name marking a tenant as counted
+	TenantKeyPrefix = "gorush-tenant-"
 )
 
 // Storage interface
@@ -32,3 +35,9 @@ type Storage interface {
 	Get(key string) int64
 	Close() error
 }
+
+// KeyStorage is implemented by the storages listing their keys, the tenants
+// counted are listed again after a restart.
+type KeyStorage interface {
+	Keys(prefix string) []string
+}
@@ -0,0 +1,122 @@
+package durable
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// nsqWaitTime is the max time Dequeue waits for a message.
+const nsqWaitTime = time.Second
+
+// NSQBackend is a NSQ topic read by a channel. The messages are finished
+// once acked, nsqd requeues the unfinished messages after their timeout,
+// e.g. the messages of a crashed instance.
+type NSQBackend struct {
+	producer *nsq.Producer
+	consumer *nsq.Consumer
+	messages chan *nsq.Message
+	done     chan struct{}
+	topic    string
+}
+
+// NewNSQBackend connects the producer and the consumer to nsqd, at most
+// maxInFlight messages are dequeued and not acked.
+func NewNSQBackend(addr, topic, channel string, maxInFlight int) (*NSQBackend, error) {
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = maxInFlight
+
+	producer, err := nsq.NewProducer(addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := producer.Ping(); err != nil {
+		producer.Stop()
+		return nil, err
+	}
+
+	consumer, err := nsq.NewConsumer(topic, channel, cfg)
+	if err != nil {
+		producer.Stop()
+		return nil, err
+	}
+
+	b := &NSQBackend{
+		producer: producer,
+		consumer: consumer,
+		messages: make(chan *nsq.Message),
+		done:     make(chan struct{}),
+		topic:    topic,
+	}
+	consumer.AddHandler(nsq.HandlerFunc(func(msg *nsq.Message) error {
+		// the message is finished by Ack, not once handled
+		msg.DisableAutoResponse()
+		select {
+		case b.messages <- msg:
+		case <-b.done:
+			// the backend is closed, the message is sent by another instance
+			msg.Requeue(-1)
+		}
+		return nil
+	}))
+
+	if err := consumer.ConnectToNSQD(addr); err != nil {
+		producer.Stop()
+		consumer.Stop()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Enqueue implements Backend.
+func (b *NSQBackend) Enqueue(_ context.Context, body []byte) error {
+	return b.producer.Publish(b.topic, body)
+}
+
+// Dequeue implements Backend.
+func (b *NSQBackend) Dequeue(ctx context.Context) (*Message, error) {
+	select {
+	case msg := <-b.messages:
+		return &Message{ID: string(msg.ID[:]), Body: msg.Body, ack: msg}, nil
+	case <-time.After(nsqWaitTime):
+		return nil, ErrNoMessage
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ack implements Backend.
+func (b *NSQBackend) Ack(_ context.Context, msg *Message) error {
+	m, ok := msg.ack.(*nsq.Message)
+	if !ok {
+		return errors.New("not a nsq message")
+	}
+	m.Finish()
+	return nil
+}
+
+// Touch implements Backend, nsqd resets the timeout of the message.
+func (b *NSQBackend) Touch(_ context.Context, msg *Message) error {
+	m, ok := msg.ack.(*nsq.Message)
+	if !ok {
+		return errors.New("not a nsq message")
+	}
+	m.Touch()
+	return nil
+}
+
+// Recover implements Backend, nsqd already requeues the unacked messages.
+func (b *NSQBackend) Recover(context.Context) error {
+	return nil
+}
+
+// Close implements Backend.
+func (b *NSQBackend) Close() error {
+	close(b.done)
+	b.consumer.Stop()
+	<-b.consumer.StopChan
+	b.producer.Stop()
+	return nil
+}
@@ -0,0 +1,226 @@
+package durable
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBlockTime is the max time Dequeue waits for a message.
+const redisBlockTime = time.Second
+
+// redisClaimMinIdle is the min idle time of the pending messages of the
+// other consumers claimed by Recover, the messages of the live consumers are
+// sent well before.
+const redisClaimMinIdle = 5 * time.Minute
+
+// RedisOptions are the settings of the Redis backend.
+type RedisOptions struct {
+	// Addr is a comma separated list of the cluster addresses.
+	Addr     string
+	Password string
+	DB       int
+	TLS      bool
+	Stream   string
+	Group    string
+	Consumer string
+}
+
+// RedisBackend is a Redis stream read by a consumer group. The messages
+// stay pending in the group until acked, the pending messages of the
+// consumer are read again after a restart and the ones of the dead
+// consumers are claimed.
+type RedisBackend struct {
+	client   redis.UniversalClient
+	stream   string
+	group    string
+	consumer string
+
+	mu sync.Mutex
+	// pending are the messages delivered to the consumer and never acked,
+	// they are dequeued before the new ones.
+	pending []*Message
+}
+
+// NewRedisBackend connects to the stream and creates the consumer group.
+func NewRedisBackend(opts RedisOptions) (*RedisBackend, error) {
+	options := &redis.UniversalOptions{
+		Addrs:    strings.Split(opts.Addr, ","),
+		Password: opts.Password,
+		DB:       opts.DB,
+	}
+	if opts.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	b := &RedisBackend{
+		client:   redis.NewUniversalClient(options),
+		stream:   opts.Stream,
+		group:    opts.Group,
+		consumer: opts.Consumer,
+	}
+
+	ctx := context.Background()
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+
+	err := b.client.XGroupCreateMkStream(ctx, b.stream, b.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Enqueue implements Backend.
+func (b *RedisBackend) Enqueue(ctx context.Context, body []byte) error {
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		Values: map[string]interface{}{"body": body},
+	}).Err()
+}
+
+// Dequeue implements Backend.
+func (b *RedisBackend) Dequeue(ctx context.Context) (*Message, error) {
+	b.mu.Lock()
+	if len(b.pending) > 0 {
+		msg := b.pending[0]
+		b.pending = b.pending[1:]
+		b.mu.Unlock()
+		return msg, nil
+	}
+	b.mu.Unlock()
+
+	msgs, err := b.read(ctx, ">", 1, redisBlockTime)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, ErrNoMessage
+	}
+	return msgs[0], nil
+}
+
+// read reads the messages of the group after the ID, ">" reads the new
+// ones and the others the pending messages of the consumer.
+func (b *RedisBackend) read(ctx context.Context, id string, count int64, block time.Duration) ([]*Message, error) {
+	streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    b.group,
+		Consumer: b.consumer,
+		Streams:  []string{b.stream, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*Message
+	for _, stream := range streams {
+		msgs = append(msgs, redisMessages(stream.Messages)...)
+	}
+	return msgs, nil
+}
+
+// claim claims the messages of the group pending for minIdle at least from
+// the start ID, it returns the start ID of the next call, "0-0" once done.
+func (b *RedisBackend) claim(ctx context.Context, start string, count int64) ([]*Message, string, error) {
+	messages, next, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   b.stream,
+		Group:    b.group,
+		Consumer: b.consumer,
+		MinIdle:  redisClaimMinIdle,
+		Start:    start,
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	return redisMessages(messages), next, nil
+}
+
+func redisMessages(messages []redis.XMessage) []*Message {
+	msgs := make([]*Message, 0, len(messages))
+	for _, message := range messages {
+		body, _ := message.Values["body"].(string)
+		msgs = append(msgs, &Message{ID: message.ID, Body: []byte(body)})
+	}
+	return msgs
+}
+
+// Ack implements Backend.
+func (b *RedisBackend) Ack(ctx context.Context, msg *Message) error {
+	if err := b.client.XAck(ctx, b.stream, b.group, msg.ID).Err(); err != nil {
+		return err
+	}
+	return b.client.XDel(ctx, b.stream, msg.ID).Err()
+}
+
+// Touch implements Backend, the message is claimed again by the consumer to
+// reset its idle time, so that it isn't claimed by Recover.
+func (b *RedisBackend) Touch(ctx context.Context, msg *Message) error {
+	return b.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   b.stream,
+		Group:    b.group,
+		Consumer: b.consumer,
+		Messages: []string{msg.ID},
+	}).Err()
+}
+
+// Recover implements Backend, the pending messages of the consumer and the
+// ones of the dead consumers, idle for redisClaimMinIdle, are dequeued
+// before the new ones.
+func (b *RedisBackend) Recover(ctx context.Context) error {
+	var pending []*Message
+	seen := make(map[string]bool)
+	for id := "0"; ; {
+		// the history of the consumer is read without blocking
+		msgs, err := b.read(ctx, id, 100, -1)
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		for _, msg := range msgs {
+			seen[msg.ID] = true
+		}
+		pending = append(pending, msgs...)
+		id = msgs[len(msgs)-1].ID
+	}
+
+	for start := "0-0"; ; {
+		msgs, next, err := b.claim(ctx, start, 100)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				pending = append(pending, msg)
+			}
+		}
+		if next == "0-0" || next == "" {
+			break
+		}
+		start = next
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, pending...)
+	b.mu.Unlock()
+	return nil
+}
+
+// Close implements Backend.
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,213 @@
+// Package durable implements a queue worker delivering the notifications at
+// least once: a message is acked once it was sent, so the messages of a
+// crashed instance are sent again instead of being lost.
+//
+// A notification may be sent twice when the instance crashed after the send
+// and before the ack, set its idempotency_key to skip the second send.
+package durable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-queue/queue"
+	"github.com/golang-queue/queue/core"
+)
+
+var _ core.Worker = (*Worker)(nil)
+
+// touchInterval is the interval of the touches of the running messages, well
+// under the 60s default msg timeout of nsqd.
+const touchInterval = 15 * time.Second
+
+// ErrNoMessage is returned by Backend.Dequeue when no message is waiting.
+var ErrNoMessage = errors.New("no message in the queue")
+
+// Message is a message dequeued from the backend, it's delivered again until
+// it's acked.
+type Message struct {
+	ID   string
+	Body []byte
+
+	// ack is the backend handle of the message, e.g. the NSQ message.
+	ack interface{}
+}
+
+// Backend is a persistent queue.
+type Backend interface {
+	// Enqueue stores the message before returning.
+	Enqueue(ctx context.Context, body []byte) error
+	// Dequeue returns the next message, ErrNoMessage if none is waiting.
+	Dequeue(ctx context.Context) (*Message, error)
+	// Ack removes the message once it was processed.
+	Ack(ctx context.Context, msg *Message) error
+	// Touch keeps the running message in flight, so that it isn't
+	// delivered again before it's acked.
+	Touch(ctx context.Context, msg *Message) error
+	// Recover delivers again the messages dequeued and never acked, e.g.
+	// by a crashed instance. It's called once before the first Dequeue.
+	Recover(ctx context.Context) error
+	// Close releases the backend.
+	Close() error
+}
+
+// task is a job dequeued from the backend.
+type task struct {
+	job queue.Job
+	msg *Message
+}
+
+// Bytes implements core.QueuedMessage.
+func (t *task) Bytes() []byte {
+	return t.job.Bytes()
+}
+
+// Worker runs the messages of the backend and acks them once run.
+type Worker struct {
+	backend Backend
+	runFunc func(context.Context, core.QueuedMessage) error
+	logger  queue.Logger
+
+	touchInterval time.Duration
+
+	recoverOnce sync.Once
+	recoverErr  error
+	stopFlag    int32
+
+	mu      sync.RWMutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+// NewWorker returns a worker running the messages of the backend with
+// runFunc.
+func NewWorker(backend Backend, runFunc func(context.Context, core.QueuedMessage) error, logger queue.Logger) *Worker {
+	return &Worker{
+		backend:       backend,
+		runFunc:       runFunc,
+		logger:        logger,
+		touchInterval: touchInterval,
+	}
+}
+
+// Queue stores the message in the backend.
+func (w *Worker) Queue(job core.QueuedMessage) error {
+	if atomic.LoadInt32(&w.stopFlag) == 1 {
+		return queue.ErrQueueShutdown
+	}
+
+	return w.backend.Enqueue(context.Background(), job.Bytes())
+}
+
+// Request returns the next message of the backend, the unacked messages
+// of a previous run first.
+func (w *Worker) Request() (core.QueuedMessage, error) {
+	if atomic.LoadInt32(&w.stopFlag) == 1 {
+		return nil, queue.ErrQueueHasBeenClosed
+	}
+
+	w.recoverOnce.Do(func() {
+		w.recoverErr = w.backend.Recover(context.Background())
+	})
+	if w.recoverErr != nil {
+		return nil, w.recoverErr
+	}
+
+	msg, err := w.backend.Dequeue(context.Background())
+	if errors.Is(err, ErrNoMessage) {
+		return nil, queue.ErrNoTaskInQueue
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &task{msg: msg}
+	if err := json.Unmarshal(msg.Body, &t.job); err != nil {
+		// a malformed message is never delivered
+		w.ack(msg)
+		return nil, err
+	}
+	return t, nil
+}
+
+// Run runs the message and acks it, whatever the result: the failed
+// notifications are kept by the dead letter store. The message isn't acked
+// when the worker is shut down before it ran.
+func (w *Worker) Run(m core.QueuedMessage) error {
+	t, ok := m.(*task)
+	if !ok {
+		return w.runFunc(context.Background(), m)
+	}
+
+	w.mu.RLock()
+	if w.stopped {
+		w.mu.RUnlock()
+		return queue.ErrQueueShutdown
+	}
+	w.running.Add(1)
+	w.mu.RUnlock()
+	defer w.running.Done()
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if t.job.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.job.Timeout)
+	}
+	defer cancel()
+
+	// the message is touched until run, the sends may outlast the timeout
+	// of the backend
+	done, touched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(touched)
+		w.touch(t.msg, done)
+	}()
+
+	err := w.runFunc(ctx, &t.job)
+	close(done)
+	<-touched
+	w.ack(t.msg)
+	return err
+}
+
+// touch touches the message every touchInterval until done is closed.
+func (w *Worker) touch(msg *Message, done <-chan struct{}) {
+	ticker := time.NewTicker(w.touchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := w.backend.Touch(context.Background(), msg); err != nil {
+				w.logger.Errorf("can't touch the message %s: %v", msg.ID, err)
+			}
+		}
+	}
+}
+
+func (w *Worker) ack(msg *Message) {
+	if err := w.backend.Ack(context.Background(), msg); err != nil {
+		w.logger.Errorf("can't ack the message %s: %v", msg.ID, err)
+	}
+}
+
+// Shutdown stops the worker and closes the backend, the messages not run
+// yet are delivered again on restart.
+func (w *Worker) Shutdown() error {
+	if !atomic.CompareAndSwapInt32(&w.stopFlag, 0, 1) {
+		return queue.ErrQueueShutdown
+	}
+
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+
+	// the running messages are acked before the backend is closed
+	w.running.Wait()
+	return w.backend.Close()
+}
@@ -0,0 +1,230 @@
+package durable
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-queue/queue"
+	"github.com/golang-queue/queue/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBackend keeps the messages in memory, the dequeued messages stay
+// inflight until acked.
+type fakeBackend struct {
+	mu       sync.Mutex
+	nextID   int
+	waiting  []*Message
+	inflight map[string]*Message
+	touches  int
+	closed   bool
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{inflight: make(map[string]*Message)}
+}
+
+func (b *fakeBackend) Enqueue(_ context.Context, body []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	b.waiting = append(b.waiting, &Message{ID: strconv.Itoa(b.nextID), Body: body})
+	return nil
+}
+
+func (b *fakeBackend) Dequeue(context.Context) (*Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.waiting) == 0 {
+		return nil, ErrNoMessage
+	}
+	msg := b.waiting[0]
+	b.waiting = b.waiting[1:]
+	b.inflight[msg.ID] = msg
+	return msg, nil
+}
+
+func (b *fakeBackend) Ack(_ context.Context, msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.inflight, msg.ID)
+	return nil
+}
+
+func (b *fakeBackend) Touch(context.Context, *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.touches++
+	return nil
+}
+
+func (b *fakeBackend) Recover(context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, msg := range b.inflight {
+		b.waiting = append(b.waiting, msg)
+		delete(b.inflight, id)
+	}
+	return nil
+}
+
+func (b *fakeBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+func (b *fakeBackend) counts() (waiting, inflight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.waiting), len(b.inflight)
+}
+
+func newTestWorker(backend Backend, runs *[]string) *Worker {
+	var mu sync.Mutex
+	return NewWorker(backend, func(_ context.Context, m core.QueuedMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		*runs = append(*runs, string(m.Bytes()))
+		if string(m.Bytes()) == "fail" {
+			return errors.New("send failed")
+		}
+		return nil
+	}, queue.NewEmptyLogger())
+}
+
+// newTestJob wraps the payload like queue.Queue does.
+func newTestJob(payload string) *queue.Job {
+	return &queue.Job{
+		Payload: (&queue.Job{Timeout: time.Second, Payload: []byte(payload)}).Encode(),
+	}
+}
+
+func TestWorkerEnqueueDequeue(t *testing.T) {
+	backend := newFakeBackend()
+	var runs []string
+	w := newTestWorker(backend, &runs)
+
+	assert.NoError(t, w.Queue(newTestJob("hello")))
+	assert.NoError(t, w.Queue(newTestJob("fail")))
+
+	for i := 0; i < 2; i++ {
+		m, err := w.Request()
+		assert.NoError(t, err)
+		_ = w.Run(m)
+	}
+
+	_, err := w.Request()
+	assert.Equal(t, queue.ErrNoTaskInQueue, err)
+
+	// the failed message is acked too
+	assert.Equal(t, []string{"hello", "fail"}, runs)
+	waiting, inflight := backend.counts()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 0, inflight)
+}
+
+func TestWorkerRecoverUnacked(t *testing.T) {
+	backend := newFakeBackend()
+	var runs []string
+	w := newTestWorker(backend, &runs)
+
+	assert.NoError(t, w.Queue(newTestJob("hello")))
+
+	// the instance crashes after dequeuing the message
+	_, err := w.Request()
+	assert.NoError(t, err)
+	waiting, inflight := backend.counts()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 1, inflight)
+
+	restarted := newTestWorker(backend, &runs)
+	m, err := restarted.Request()
+	assert.NoError(t, err)
+	assert.NoError(t, restarted.Run(m))
+
+	assert.Equal(t, []string{"hello"}, runs)
+	waiting, inflight = backend.counts()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 0, inflight)
+}
+
+func TestWorkerMalformedMessage(t *testing.T) {
+	backend := newFakeBackend()
+	var runs []string
+	w := newTestWorker(backend, &runs)
+
+	assert.NoError(t, backend.Enqueue(context.Background(), []byte("{")))
+
+	_, err := w.Request()
+	assert.Error(t, err)
+
+	// a malformed message is never delivered again
+	assert.Empty(t, runs)
+	waiting, inflight := backend.counts()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 0, inflight)
+}
+
+func TestWorkerShutdown(t *testing.T) {
+	backend := newFakeBackend()
+	var runs []string
+	w := newTestWorker(backend, &runs)
+
+	assert.NoError(t, w.Queue(newTestJob("hello")))
+	m, err := w.Request()
+	assert.NoError(t, err)
+
+	assert.NoError(t, w.Shutdown())
+	assert.True(t, backend.closed)
+	assert.Equal(t, queue.ErrQueueShutdown, w.Shutdown())
+	assert.Equal(t, queue.ErrQueueShutdown, w.Queue(newTestJob("world")))
+	_, err = w.Request()
+	assert.Equal(t, queue.ErrQueueHasBeenClosed, err)
+
+	// the message dequeued before the shutdown isn't run nor acked, it's
+	// delivered again on restart
+	assert.Equal(t, queue.ErrQueueShutdown, w.Run(m))
+	assert.Empty(t, runs)
+	_, inflight := backend.counts()
+	assert.Equal(t, 1, inflight)
+}
+
+func TestWorkerTouchRunning(t *testing.T) {
+	backend := newFakeBackend()
+	w := NewWorker(backend, func(context.Context, core.QueuedMessage) error {
+		// a send outlasting the timeout of the backend
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}, queue.NewEmptyLogger())
+	w.touchInterval = 20 * time.Millisecond
+
+	assert.NoError(t, w.Queue(newTestJob("hello")))
+	m, err := w.Request()
+	assert.NoError(t, err)
+	assert.NoError(t, w.Run(m))
+
+	backend.mu.Lock()
+	touches := backend.touches
+	backend.mu.Unlock()
+	assert.GreaterOrEqual(t, touches, 3)
+
+	// the message isn't touched anymore once acked
+	time.Sleep(50 * time.Millisecond)
+	backend.mu.Lock()
+	assert.Equal(t, touches, backend.touches)
+	backend.mu.Unlock()
+}
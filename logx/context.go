@@ -0,0 +1,50 @@
+package logx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID, which is
+// recorded by the loggers of AccessEntry and ErrorEntry.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AccessEntry returns the access logger recording the request ID of ctx.
+func AccessEntry(ctx context.Context) *logrus.Entry {
+	return requestEntry(LogAccess, RequestID(ctx))
+}
+
+// ErrorEntry returns the error logger recording the request ID of ctx.
+func ErrorEntry(ctx context.Context) *logrus.Entry {
+	return requestEntry(LogError, RequestID(ctx))
+}
+
+func requestEntry(log *logrus.Logger, id string) *logrus.Entry {
+	if id == "" {
+		return logrus.NewEntry(log)
+	}
+	return log.WithField("request_id", id)
+}
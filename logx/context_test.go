@@ -0,0 +1,36 @@
+package logx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestIDContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", RequestID(ctx))
+	assert.Equal(t, ctx, WithRequestID(ctx, ""))
+	assert.Equal(t, "abc", RequestID(WithRequestID(ctx, "abc")))
+
+	assert.Len(t, NewRequestID(), 16)
+	assert.NotEqual(t, NewRequestID(), NewRequestID())
+}
+
+func TestRequestEntry(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+
+	origin := LogError
+	LogError = log
+	defer func() { LogError = origin }()
+
+	ErrorEntry(context.Background()).Error("no id")
+	assert.NotContains(t, buf.String(), "request_id")
+
+	ErrorEntry(WithRequestID(context.Background(), "abc")).Error("with id")
+	assert.Contains(t, buf.String(), "request_id=abc")
+}
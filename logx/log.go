@@ -1,7 +1,6 @@
 package logx
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -29,6 +28,19 @@ type LogPushEntry struct {
 	Token    string `json:"token"`
 	Message  string `json:"message"`
 	Error    string `json:"error"`
+
+	MessageID string         `json:"message_id,omitempty"`
+	ErrorType string         `json:"error_type,omitempty"`
+	ErrorCode core.ErrorCode `json:"error_code,omitempty"`
+	Index     *int           `json:"index,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+
+	RequestID string `json:"request_id,omitempty"`
+
+	// raw error of the provider, only filled in on request
+	RawError      string `json:"raw_error,omitempty"`
+	RawErrorCode  string `json:"raw_error_code,omitempty"`
+	RawHTTPStatus int    `json:"raw_http_status,omitempty"`
 }
 
 var isTerm bool
@@ -105,6 +117,14 @@ func SetLogOut(log *logrus.Logger, outString string) error {
 	return nil
 }
 
+// SetLogFormat switches the log to JSON lines when the format is json, the
+// default text format is kept otherwise.
+func SetLogFormat(log *logrus.Logger, format string) {
+	if format == "json" {
+		log.SetFormatter(&logrus.JSONFormatter{})
+	}
+}
+
 // SetLogLevel is define log level what you want
 // log level: panic, fatal, error, warn, info and debug
 func SetLogLevel(log *logrus.Logger, levelString string) error {
@@ -189,6 +209,13 @@ func GetLogPushEntry(input *InputLog) LogPushEntry {
 		Token:    token,
 		Message:  message,
 		Error:    errMsg,
+
+		MessageID: input.MessageID,
+		ErrorType: input.ErrorType,
+		ErrorCode: input.ErrorCode,
+		Index:     input.Index,
+
+		RequestID: input.RequestID,
 	}
 }
 
@@ -203,6 +230,56 @@ type InputLog struct {
 	HideToken   bool
 	HideMessage bool
 	Format      string
+	MessageID   string
+	ErrorType   string
+	ErrorCode   core.ErrorCode
+	Index       *int
+	RequestID   string
+}
+
+// logPushFields records the push log with structured fields.
+func logPushFields(log LogPushEntry) {
+	fields := logrus.Fields{
+		"status":   log.Type,
+		"platform": log.Platform,
+		"token":    log.Token,
+		"message":  log.Message,
+	}
+
+	if log.ID != "" {
+		fields["notif_id"] = log.ID
+	}
+
+	if log.MessageID != "" {
+		fields["message_id"] = log.MessageID
+	}
+
+	if log.Error != "" {
+		fields["error"] = log.Error
+	}
+
+	if log.ErrorType != "" {
+		fields["error_type"] = log.ErrorType
+	}
+
+	if log.ErrorCode != "" {
+		fields["error_code"] = log.ErrorCode
+	}
+
+	if log.Index != nil {
+		fields["index"] = *log.Index
+	}
+
+	if log.RequestID != "" {
+		fields["request_id"] = log.RequestID
+	}
+
+	switch log.Type {
+	case core.SucceededPush:
+		LogAccess.WithFields(fields).Info("push notification")
+	case core.FailedPush:
+		LogError.WithFields(fields).Error("push notification")
+	}
 }
 
 // LogPush record user push request and server response.
@@ -217,43 +294,42 @@ func LogPush(input *InputLog) LogPushEntry {
 	log := GetLogPushEntry(input)
 
 	if input.Format == "json" {
-		logJSON, _ := json.Marshal(log)
+		logPushFields(log)
+		return log
+	}
 
-		output = string(logJSON)
-	} else {
-		var typeColor string
-		switch input.Status {
-		case core.SucceededPush:
-			if isTerm {
-				typeColor = green
-			}
-
-			output = fmt.Sprintf("|%s %s %s| %s%s%s [%s] %s",
-				typeColor, log.Type, resetColor,
-				platColor, log.Platform, resetColor,
-				log.Token,
-				log.Message,
-			)
-		case core.FailedPush:
-			if isTerm {
-				typeColor = red
-			}
-
-			output = fmt.Sprintf("|%s %s %s| %s%s%s [%s] | %s | Error Message: %s",
-				typeColor, log.Type, resetColor,
-				platColor, log.Platform, resetColor,
-				log.Token,
-				log.Message,
-				log.Error,
-			)
+	var typeColor string
+	switch input.Status {
+	case core.SucceededPush:
+		if isTerm {
+			typeColor = green
 		}
+
+		output = fmt.Sprintf("|%s %s %s| %s%s%s [%s] %s",
+			typeColor, log.Type, resetColor,
+			platColor, log.Platform, resetColor,
+			log.Token,
+			log.Message,
+		)
+	case core.FailedPush:
+		if isTerm {
+			typeColor = red
+		}
+
+		output = fmt.Sprintf("|%s %s %s| %s%s%s [%s] | %s | Error Message: %s",
+			typeColor, log.Type, resetColor,
+			platColor, log.Platform, resetColor,
+			log.Token,
+			log.Message,
+			log.Error,
+		)
 	}
 
 	switch input.Status {
 	case core.SucceededPush:
-		LogAccess.Info(output)
+		requestEntry(LogAccess, log.RequestID).Info(output)
 	case core.FailedPush:
-		LogError.Error(output)
+		requestEntry(LogError, log.RequestID).Error(output)
 	}
 
 	return log
@@ -1,6 +1,8 @@
 package logx
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"testing"
 
@@ -158,6 +160,14 @@ func TestLogPushEntry(t *testing.T) {
 	in.Message = "hellothisisamessage"
 	in.HideMessage = true
 	assert.Equal(t, "(message redacted)", GetLogPushEntry(&in).Message)
+
+	in.MessageID = "projects/foo-123/messages/1"
+	assert.Equal(t, "projects/foo-123/messages/1", GetLogPushEntry(&in).MessageID)
+
+	assert.Nil(t, GetLogPushEntry(&in).Index)
+	index := 3
+	in.Index = &index
+	assert.Equal(t, 3, *GetLogPushEntry(&in).Index)
 }
 
 func TestLogPush(t *testing.T) {
@@ -176,3 +186,34 @@ func TestLogPush(t *testing.T) {
 	in.Message = "failed"
 	assert.Equal(t, "failed", LogPush(&in).Message)
 }
+
+func TestLogPushJSONFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	SetLogFormat(log, "json")
+
+	origin := LogAccess
+	LogAccess = log
+	defer func() { LogAccess = origin }()
+
+	index := 2
+
+	LogPush(&InputLog{
+		Status:    core.SucceededPush,
+		Token:     "1234567890",
+		Platform:  core.PlatFormAndroid,
+		Message:   "hello",
+		MessageID: "projects/foo-123/messages/1",
+		Format:    "json",
+		Index:     &index,
+	})
+
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &fields))
+	assert.Equal(t, "1234567890", fields["token"])
+	assert.Equal(t, core.SucceededPush, fields["status"])
+	assert.Equal(t, "android", fields["platform"])
+	assert.Equal(t, "projects/foo-123/messages/1", fields["message_id"])
+	assert.Equal(t, float64(2), fields["index"])
+}
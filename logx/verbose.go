@@ -0,0 +1,44 @@
+package logx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// verboseLock serializes the verbose writes, they bypass the logger lock.
+var verboseLock sync.Mutex
+
+// LogVerbose records the message and fields in the access log whatever its
+// level, for the requests asking for verbose logs. The requestID correlates
+// the records of a single request.
+func LogVerbose(requestID, message string, fields map[string]interface{}) {
+	entry := LogAccess.WithFields(fields).WithField("request_id", requestID)
+	entry.Time = time.Now()
+	entry.Level = logrus.DebugLevel
+	entry.Message = message
+
+	b, err := LogAccess.Formatter.Format(entry)
+	if err != nil {
+		LogError.Error("verbose log error: " + err.Error())
+		return
+	}
+
+	verboseLock.Lock()
+	defer verboseLock.Unlock()
+	_, _ = LogAccess.Out.Write(b)
+}
+
+// HashToken returns a short hash of the token, which identifies the token in
+// the verbose logs without disclosing it.
+func HashToken(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,41 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogVerbose(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	log.Level = logrus.ErrorLevel
+	SetLogFormat(log, "json")
+
+	origin := LogAccess
+	LogAccess = log
+	defer func() { LogAccess = origin }()
+
+	// the debug logs are dropped by the error level
+	log.Debug("dropped")
+	assert.Empty(t, buf.String())
+
+	LogVerbose("abc", "fcm request", map[string]interface{}{"tokens": []string{"sha256:1"}})
+
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &fields))
+	assert.Equal(t, "abc", fields["request_id"])
+	assert.Equal(t, "fcm request", fields["msg"])
+	assert.Equal(t, "debug", fields["level"])
+	assert.Equal(t, []interface{}{"sha256:1"}, fields["tokens"])
+}
+
+func TestHashToken(t *testing.T) {
+	assert.Equal(t, "", HashToken(""))
+	assert.Equal(t, "sha256:2c26b46b68ffc68f", HashToken("foo"))
+	assert.NotEqual(t, HashToken("foo"), HashToken("bar"))
+}
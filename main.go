@@ -2,19 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/appleboy/gorush/config"
 	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/durable"
 	"github.com/appleboy/gorush/logx"
+	"github.com/appleboy/gorush/metric"
 	"github.com/appleboy/gorush/notify"
 	"github.com/appleboy/gorush/router"
 	"github.com/appleboy/gorush/rpc"
@@ -34,6 +40,9 @@ func main() {
 
 	var (
 		ping        bool
+		replay      bool
+		androidTest bool
+		dryRun      bool
 		showVersion bool
 		configFile  string
 		topic       string
@@ -78,6 +87,9 @@ func main() {
 	flag.StringVar(&topic, "topic", "", "apns topic in iOS")
 	flag.StringVar(&opts.Core.HTTPProxy, "proxy", "", "http proxy url")
 	flag.BoolVar(&ping, "ping", false, "ping server")
+	flag.BoolVar(&replay, "replay", false, "replay the notifications of the dead letter store")
+	flag.BoolVar(&androidTest, "android-test", false, "send a test notification to the android token")
+	flag.BoolVar(&dryRun, "dry-run", false, "validate the android test notification without sending it")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -101,6 +113,7 @@ func main() {
 
 	// Initialize push slots for concurrent iOS pushes
 	notify.MaxConcurrentIOSPushes = make(chan struct{}, cfg.Ios.MaxConcurrentPushes)
+	notify.InitPushLimiter(cfg)
 
 	if opts.Ios.KeyPath != "" {
 		cfg.Ios.KeyPath = opts.Ios.KeyPath
@@ -158,6 +171,8 @@ func main() {
 	); err != nil {
 		log.Fatalf("can't load log module, error: %v", err)
 	}
+	logx.SetLogFormat(logx.LogAccess, cfg.Log.Format)
+	logx.SetLogFormat(logx.LogError, cfg.Log.Format)
 
 	if opts.Core.HTTPProxy != "" {
 		cfg.Core.HTTPProxy = opts.Core.HTTPProxy
@@ -177,6 +192,20 @@ func main() {
 		return
 	}
 
+	if replay {
+		if err := replayDeadLetter(cfg); err != nil {
+			logx.LogError.Fatal(err)
+		}
+		return
+	}
+
+	if androidTest {
+		if err := sendAndroidTest(cfg, token, title, message, dryRun); err != nil {
+			logx.LogError.Fatal(err)
+		}
+		return
+	}
+
 	// send android notification
 	if opts.Android.Enabled {
 		cfg.Android.Enabled = opts.Android.Enabled
@@ -193,7 +222,7 @@ func main() {
 
 		// send topic message
 		if topic != "" {
-			req.To = topic
+			req.Topic = topic
 		}
 
 		err := notify.CheckMessage(req)
@@ -308,6 +337,34 @@ func main() {
 		logx.LogError.Fatal(err)
 	}
 
+	notify.InitDeadLetter(cfg)
+
+	if err = notify.InitIdempotency(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	if err = notify.InitDelivery(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	if err = notify.InitTokenBatch(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	if err = notify.InitResult(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	if err = notify.InitTransformer(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	notify.InitTokenFeedback(cfg)
+
+	if err = notify.InitScheduler(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
 	var w qcore.Worker
 	switch core.Queue(cfg.Queue.Engine) {
 	case core.LocalQueue:
@@ -317,6 +374,10 @@ func main() {
 			queue.WithLogger(logx.QueueLogger()),
 		)
 	case core.NSQ:
+		if cfg.Queue.Durable {
+			w = newDurableWorker(cfg)
+			break
+		}
 		w = nsq.NewWorker(
 			nsq.WithAddr(cfg.Queue.NSQ.Addr),
 			nsq.WithTopic(cfg.Queue.NSQ.Topic),
@@ -334,8 +395,15 @@ func main() {
 			nats.WithLogger(logx.QueueLogger()),
 		)
 	case core.Redis:
+		if cfg.Queue.Durable {
+			w = newDurableWorker(cfg)
+			break
+		}
 		w = redisdb.NewWorker(
 			redisdb.WithAddr(cfg.Queue.Redis.Addr),
+			redisdb.WithPassword(cfg.Queue.Redis.Password),
+			redisdb.WithDB(cfg.Queue.Redis.DB),
+			redisdb.WithConnectionString(redisQueueURL(cfg)),
 			redisdb.WithStreamName(cfg.Queue.Redis.StreamName),
 			redisdb.WithGroup(cfg.Queue.Redis.Group),
 			redisdb.WithConsumer(cfg.Queue.Redis.Consumer),
@@ -348,7 +416,7 @@ func main() {
 
 	q := queue.NewPool(
 		int(cfg.Core.WorkerNum),
-		queue.WithWorker(w),
+		queue.WithWorker(metric.NewQueueWorker(w)),
 		queue.WithLogger(logx.QueueLogger()),
 	)
 
@@ -358,6 +426,12 @@ func main() {
 
 	g.AddShutdownJob(func() error {
 		// logx.LogAccess.Info("close the queue system, current queue usage: ", q.Usage())
+		// stop sending the scheduled notifications
+		if notify.PushScheduler != nil {
+			if err := notify.PushScheduler.Close(); err != nil {
+				logx.LogError.Error("can't close the schedule store: ", err.Error())
+			}
+		}
 		// stop queue system and wait job completed
 		q.Release()
 		// close the connection with storage
@@ -365,6 +439,18 @@ func main() {
 		if err := status.StatStorage.Close(); err != nil {
 			logx.LogError.Fatal("can't close the storage connection: ", err.Error())
 		}
+		// write the queued delivery receipts
+		if notify.DeliveryRecorder != nil {
+			if err := notify.DeliveryRecorder.Close(); err != nil {
+				logx.LogError.Error("can't close the delivery store: ", err.Error())
+			}
+		}
+		// post the queued invalid tokens
+		if notify.TokenFeedbackRecorder != nil {
+			if err := notify.TokenFeedbackRecorder.Close(); err != nil {
+				logx.LogError.Error("can't close the token feedback: ", err.Error())
+			}
+		}
 		return nil
 	})
 
@@ -375,9 +461,29 @@ func main() {
 	}
 
 	if cfg.Android.Enabled {
-		if _, err = notify.InitFCMV1Client(g.ShutdownContext(), cfg); err != nil {
+		if _, err = notify.InitFCMV1Client(g.ShutdownContext(), cfg, cfg.Android.ProjectID); err != nil {
 			logx.LogError.Fatal(err)
 		}
+
+		// reload the FCM client on SIGHUP after rotating the credential
+		g.AddRunningJob(func(ctx context.Context) error {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-hup:
+					if _, err := notify.ReloadFCMV1Client(ctx, cfg); err != nil {
+						logx.LogError.Error("reload fcm client error: ", err)
+						continue
+					}
+					logx.LogAccess.Info("reload fcm client")
+				}
+			}
+		})
 	}
 
 	if cfg.Huawei.Enabled {
@@ -425,6 +531,7 @@ Server Options:
     --pid <pid path>                 Process identifier path
     --redis-addr <redis addr>        Redis addr (default: localhost:6379)
     --ping                           healthy check command for container
+    --replay                         replay the notifications of the dead letter store
 iOS Options:
     -i, --key <file>                 certificate key file path
     -P, --password <password>        certificate key password
@@ -433,6 +540,8 @@ iOS Options:
 Android Options:
     -k, --apikey <api_key>           Android API Key
     --android                        enabled android (default: false)
+    --android-test                   send a test notification to the token and print the response
+    --dry-run                        validate the test notification without sending it (default: false)
 Huawei Options:
     -hk, --hmskey <hms_key>          HMS App Secret
     -hid, --hmsid <hms_id>			 HMS App ID
@@ -478,6 +587,107 @@ func pinger(cfg *config.ConfYaml) error {
 	return nil
 }
 
+// replayDeadLetter sends again the notifications of the dead letter store.
+func replayDeadLetter(cfg *config.ConfYaml) error {
+	if !cfg.DeadLetter.Enabled {
+		return fmt.Errorf("dead letter is disabled")
+	}
+
+	if err := notify.CheckPushConf(cfg); err != nil {
+		return err
+	}
+
+	if err := status.InitAppStatus(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Ios.Enabled {
+		if err := notify.InitAPNSClient(cfg); err != nil {
+			return err
+		}
+	}
+
+	notify.InitDeadLetter(cfg)
+	count, err := notify.ReplayDeadLetter(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+
+	logx.LogAccess.Infof("replayed %d dead letter notifications", count)
+	return nil
+}
+
+// sendAndroidTest sends a test notification to the token and prints the
+// response, the FCM config is checked end to end.
+func sendAndroidTest(cfg *config.ConfYaml, token, title, message string, dryRun bool) error {
+	cfg.Android.Enabled = true
+	if err := notify.CheckPushConf(cfg); err != nil {
+		return err
+	}
+
+	if err := status.InitAppStatus(cfg); err != nil {
+		return err
+	}
+
+	resp, err := notify.SendAndroidTest(context.Background(), cfg, token, title, message, dryRun)
+	if resp != nil {
+		b, _ := json.MarshalIndent(resp, "", "  ")
+		fmt.Println(string(b))
+	}
+	return err
+}
+
+// newDurableWorker returns the worker of queue.durable, the messages are
+// acked once sent.
+func newDurableWorker(cfg *config.ConfYaml) qcore.Worker {
+	var backend durable.Backend
+	var err error
+	switch core.Queue(cfg.Queue.Engine) {
+	case core.NSQ:
+		backend, err = durable.NewNSQBackend(
+			cfg.Queue.NSQ.Addr,
+			cfg.Queue.NSQ.Topic,
+			cfg.Queue.NSQ.Channel,
+			int(cfg.Core.WorkerNum),
+		)
+	case core.Redis:
+		backend, err = durable.NewRedisBackend(durable.RedisOptions{
+			Addr:     cfg.Queue.Redis.Addr,
+			Password: cfg.Queue.Redis.Password,
+			DB:       cfg.Queue.Redis.DB,
+			TLS:      cfg.Queue.Redis.TLS,
+			Stream:   cfg.Queue.Redis.StreamName,
+			Group:    cfg.Queue.Redis.Group,
+			Consumer: cfg.Queue.Redis.Consumer,
+		})
+	default:
+		err = fmt.Errorf("the durable queue doesn't support the queue engine: %s", cfg.Queue.Engine)
+	}
+	if err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	return durable.NewWorker(backend, notify.Run(cfg), logx.QueueLogger())
+}
+
+// redisQueueURL returns the rediss:// connection string of queue.redis with
+// tls, the redis queue connects to addr otherwise.
+func redisQueueURL(cfg *config.ConfYaml) string {
+	if !cfg.Queue.Redis.TLS {
+		return ""
+	}
+
+	u := url.URL{
+		Scheme: "rediss",
+		Host:   cfg.Queue.Redis.Addr,
+		Path:   "/" + strconv.Itoa(cfg.Queue.Redis.DB),
+	}
+	if cfg.Queue.Redis.Password != "" {
+		u.User = url.UserPassword("", cfg.Queue.Redis.Password)
+	}
+	return u.String()
+}
+
 func createPIDFile(cfg *config.ConfYaml) error {
 	if !cfg.Core.PID.Enabled {
 		return nil
@@ -1,9 +1,13 @@
 package metric
 
 import (
+	"sync"
+
+	"github.com/appleboy/gorush/notify"
 	"github.com/appleboy/gorush/status"
 
 	"github.com/golang-queue/queue"
+	qcore "github.com/golang-queue/queue/core"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -12,18 +16,25 @@ const namespace = "gorush_"
 // Metrics implements the prometheus.Metrics interface and
 // exposes gorush metrics for prometheus
 type Metrics struct {
-	TotalPushCount *prometheus.Desc
-	IosSuccess     *prometheus.Desc
-	IosError       *prometheus.Desc
-	AndroidSuccess *prometheus.Desc
-	AndroidError   *prometheus.Desc
-	HuaweiSuccess  *prometheus.Desc
-	HuaweiError    *prometheus.Desc
-	BusyWorkers    *prometheus.Desc
-	SuccessTasks   *prometheus.Desc
-	FailureTasks   *prometheus.Desc
-	SubmittedTasks *prometheus.Desc
-	q              *queue.Queue
+	TotalPushCount     *prometheus.Desc
+	IosSuccess         *prometheus.Desc
+	IosError           *prometheus.Desc
+	AndroidSuccess     *prometheus.Desc
+	AndroidError       *prometheus.Desc
+	AndroidErrorByType *prometheus.Desc
+	HuaweiSuccess      *prometheus.Desc
+	HuaweiError        *prometheus.Desc
+	BusyWorkers        *prometheus.Desc
+	SuccessTasks       *prometheus.Desc
+	FailureTasks       *prometheus.Desc
+	SubmittedTasks     *prometheus.Desc
+	ConcurrentPushes   *prometheus.Desc
+	QueueDepth         *prometheus.Desc
+	InFlightPushes     *prometheus.Desc
+	TenantPushCount    *prometheus.Desc
+	SendLatency        *prometheus.Desc
+	RetryCount         *prometheus.Desc
+	q                  *queue.Queue
 }
 
 // NewMetrics returns a new Metrics with all prometheus.Desc initialized
@@ -54,6 +65,11 @@ func NewMetrics(q *queue.Queue) Metrics {
 			"Number of android fail count",
 			nil, nil,
 		),
+		AndroidErrorByType: prometheus.NewDesc(
+			namespace+"android_fail_by_type",
+			"Number of android fail count by FCM error type",
+			[]string{"type"}, nil,
+		),
 		HuaweiSuccess: prometheus.NewDesc(
 			namespace+"huawei_success",
 			"Number of huawei success count",
@@ -84,12 +100,93 @@ func NewMetrics(q *queue.Queue) Metrics {
 			"Length of Submitted Tasks",
 			nil, nil,
 		),
+		ConcurrentPushes: prometheus.NewDesc(
+			namespace+"concurrent_pushes",
+			"Number of sends in flight to the providers",
+			nil, nil,
+		),
+		QueueDepth: prometheus.NewDesc(
+			namespace+"queue_depth",
+			"Number of notifications waiting for a worker",
+			nil, nil,
+		),
+		InFlightPushes: prometheus.NewDesc(
+			namespace+"in_flight_pushes",
+			"Number of notifications being sent",
+			nil, nil,
+		),
+		TenantPushCount: prometheus.NewDesc(
+			namespace+"tenant_push_count",
+			"Number of push count by tenant, platform and status",
+			[]string{"tenant", "platform", "status"}, nil,
+		),
+		SendLatency: prometheus.NewDesc(
+			namespace+"send_latency_seconds",
+			"Duration of the sends to the providers by platform and outcome",
+			[]string{"platform", "outcome"}, nil,
+		),
+		RetryCount: prometheus.NewDesc(
+			namespace+"retry_count",
+			"Number of the tokens resent to the providers by platform and result",
+			[]string{"platform", "result"}, nil,
+		),
 		q: q,
 	}
 
 	return m
 }
 
+// QueueDepth returns the number of notifications submitted to the queue but
+// neither done nor taken by a worker.
+func QueueDepth(q *queue.Queue) int64 {
+	depth := q.SubmittedTasks() - q.SuccessTasks() - q.FailureTasks() - q.BusyWorkers()
+	if depth < 0 {
+		return 0
+	}
+	return int64(depth)
+}
+
+// QueueWorker wraps the worker of the queue and records the queue depth each
+// time a notification is queued or requested by a worker.
+type QueueWorker struct {
+	qcore.Worker
+	sync.Mutex
+	depth int64
+}
+
+// NewQueueWorker returns the worker of the queue, set with queue.WithWorker.
+func NewQueueWorker(w qcore.Worker) *QueueWorker {
+	return &QueueWorker{Worker: w}
+}
+
+// Queue sends the notification to the queue.
+func (w *QueueWorker) Queue(task qcore.QueuedMessage) error {
+	if err := w.Worker.Queue(task); err != nil {
+		return err
+	}
+	w.add(1)
+	return nil
+}
+
+// Request takes the next notification for a worker.
+func (w *QueueWorker) Request() (qcore.QueuedMessage, error) {
+	task, err := w.Worker.Request()
+	if task != nil {
+		w.add(-1)
+	}
+	return task, err
+}
+
+func (w *QueueWorker) add(delta int64) {
+	w.Lock()
+	defer w.Unlock()
+	// the consumers of a shared queue take the notifications of other instances
+	w.depth = max(w.depth+delta, 0)
+	if status.StatStorage != nil {
+		status.StatStorage.SetQueueDepth(w.depth)
+	}
+}
+
 // Describe returns all possible prometheus.Desc
 func (c Metrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.TotalPushCount
@@ -97,12 +194,19 @@ func (c Metrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.IosError
 	ch <- c.AndroidSuccess
 	ch <- c.AndroidError
+	ch <- c.AndroidErrorByType
 	ch <- c.HuaweiSuccess
 	ch <- c.HuaweiError
 	ch <- c.BusyWorkers
 	ch <- c.SuccessTasks
 	ch <- c.FailureTasks
 	ch <- c.SubmittedTasks
+	ch <- c.ConcurrentPushes
+	ch <- c.QueueDepth
+	ch <- c.InFlightPushes
+	ch <- c.TenantPushCount
+	ch <- c.SendLatency
+	ch <- c.RetryCount
 }
 
 // Collect returns the metrics with values
@@ -132,6 +236,14 @@ func (c Metrics) Collect(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(status.StatStorage.GetAndroidError()),
 	)
+	for _, kind := range status.AndroidErrorTypes {
+		ch <- prometheus.MustNewConstMetric(
+			c.AndroidErrorByType,
+			prometheus.CounterValue,
+			float64(status.StatStorage.GetAndroidErrorByType(kind)),
+			kind,
+		)
+	}
 	ch <- prometheus.MustNewConstMetric(
 		c.HuaweiSuccess,
 		prometheus.CounterValue,
@@ -162,4 +274,59 @@ func (c Metrics) Collect(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(c.q.SubmittedTasks()),
 	)
+	ch <- prometheus.MustNewConstMetric(
+		c.ConcurrentPushes,
+		prometheus.GaugeValue,
+		float64(notify.ActivePushes()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.QueueDepth,
+		prometheus.GaugeValue,
+		float64(status.StatStorage.GetQueueDepth()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.InFlightPushes,
+		prometheus.GaugeValue,
+		float64(status.StatStorage.GetInFlight()),
+	)
+	for _, tenant := range status.StatStorage.Tenants() {
+		counts := []struct {
+			platform, status string
+			value            int64
+		}{
+			{"ios", "success", status.StatStorage.GetIosSuccessByTenant(tenant)},
+			{"ios", "error", status.StatStorage.GetIosErrorByTenant(tenant)},
+			{"android", "success", status.StatStorage.GetAndroidSuccessByTenant(tenant)},
+			{"android", "error", status.StatStorage.GetAndroidErrorByTenant(tenant)},
+			{"huawei", "success", status.StatStorage.GetHuaweiSuccessByTenant(tenant)},
+			{"huawei", "error", status.StatStorage.GetHuaweiErrorByTenant(tenant)},
+		}
+		for _, count := range counts {
+			ch <- prometheus.MustNewConstMetric(
+				c.TenantPushCount,
+				prometheus.CounterValue,
+				float64(count.value),
+				tenant, count.platform, count.status,
+			)
+		}
+	}
+	for key, latency := range status.StatStorage.SendLatencies() {
+		ch <- prometheus.MustNewConstHistogram(
+			c.SendLatency,
+			latency.Count,
+			latency.Sum,
+			latency.Buckets,
+			key.Platform, key.Outcome,
+		)
+	}
+	for _, platform := range status.RetryPlatforms {
+		for _, result := range status.RetryResults {
+			ch <- prometheus.MustNewConstMetric(
+				c.RetryCount,
+				prometheus.CounterValue,
+				float64(status.StatStorage.GetRetry(platform, result)),
+				platform, result,
+			)
+		}
+	}
 }
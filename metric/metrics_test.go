@@ -2,10 +2,15 @@ package metric
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/status"
+
 	"github.com/golang-queue/queue"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +26,164 @@ func TestNewMetrics(t *testing.T) {
 	assert.Equal(t, 2, m.q.SubmittedTasks())
 	assert.Equal(t, 2, m.q.SuccessTasks())
 }
+
+func TestTenantMetrics(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, status.InitAppStatus(cfg))
+
+	status.StatStorage.AddAndroidSuccessByTenant("alpha", 2)
+	status.StatStorage.AddIosErrorByTenant("beta", 1)
+
+	q := queue.NewPool(1)
+	defer q.Release()
+
+	expected := `
+# HELP gorush_tenant_push_count Number of push count by tenant, platform and status
+# TYPE gorush_tenant_push_count counter
+gorush_tenant_push_count{platform="android",status="error",tenant="alpha"} 0
+gorush_tenant_push_count{platform="android",status="error",tenant="beta"} 0
+gorush_tenant_push_count{platform="android",status="success",tenant="alpha"} 2
+gorush_tenant_push_count{platform="android",status="success",tenant="beta"} 0
+gorush_tenant_push_count{platform="huawei",status="error",tenant="alpha"} 0
+gorush_tenant_push_count{platform="huawei",status="error",tenant="beta"} 0
+gorush_tenant_push_count{platform="huawei",status="success",tenant="alpha"} 0
+gorush_tenant_push_count{platform="huawei",status="success",tenant="beta"} 0
+gorush_tenant_push_count{platform="ios",status="error",tenant="alpha"} 0
+gorush_tenant_push_count{platform="ios",status="error",tenant="beta"} 1
+gorush_tenant_push_count{platform="ios",status="success",tenant="alpha"} 0
+gorush_tenant_push_count{platform="ios",status="success",tenant="beta"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_tenant_push_count"))
+}
+
+func TestQueueDepth(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, status.InitAppStatus(cfg))
+
+	status.StatStorage.SetQueueDepth(0)
+	q := queue.NewPool(1, queue.WithWorker(NewQueueWorker(queue.NewConsumer())))
+	defer q.Release()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.NoError(t, q.QueueTask(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	<-started
+
+	// the busy worker leaves the next tasks waiting
+	assert.NoError(t, q.QueueTask(noTask))
+	assert.NoError(t, q.QueueTask(noTask))
+	assert.Equal(t, int64(2), QueueDepth(q))
+	// the queue records the depth before any scrape
+	assert.Equal(t, int64(2), status.StatStorage.GetQueueDepth())
+
+	expected := `
+# HELP gorush_queue_depth Number of notifications waiting for a worker
+# TYPE gorush_queue_depth gauge
+gorush_queue_depth 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_queue_depth"))
+	assert.Equal(t, int64(2), status.StatStorage.GetQueueDepth())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return QueueDepth(q) == 0 && status.StatStorage.GetQueueDepth() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInFlightMetrics(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, status.InitAppStatus(cfg))
+
+	q := queue.NewPool(1)
+	defer q.Release()
+
+	status.StatStorage.IncInFlight()
+	expected := `
+# HELP gorush_in_flight_pushes Number of notifications being sent
+# TYPE gorush_in_flight_pushes gauge
+gorush_in_flight_pushes 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_in_flight_pushes"))
+
+	status.StatStorage.DecInFlight()
+	expected = strings.Replace(expected, "gorush_in_flight_pushes 1", "gorush_in_flight_pushes 0", 1)
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_in_flight_pushes"))
+}
+
+func TestSendLatencyMetrics(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, status.InitAppStatus(cfg))
+
+	status.StatStorage.ObserveSendLatency("android", status.SendSuccess, 200*time.Millisecond)
+	status.StatStorage.ObserveSendLatency("ios", status.SendError, 3*time.Second)
+
+	q := queue.NewPool(1)
+	defer q.Release()
+
+	expected := `
+# HELP gorush_send_latency_seconds Duration of the sends to the providers by platform and outcome
+# TYPE gorush_send_latency_seconds histogram
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.005"} 0
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.01"} 0
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.025"} 0
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.05"} 0
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.1"} 0
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.25"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="0.5"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="1"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="2.5"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="5"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="10"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="30"} 1
+gorush_send_latency_seconds_bucket{outcome="success",platform="android",le="+Inf"} 1
+gorush_send_latency_seconds_sum{outcome="success",platform="android"} 0.2
+gorush_send_latency_seconds_count{outcome="success",platform="android"} 1
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.005"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.01"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.025"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.05"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.1"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.25"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="0.5"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="1"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="2.5"} 0
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="5"} 1
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="10"} 1
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="30"} 1
+gorush_send_latency_seconds_bucket{outcome="error",platform="ios",le="+Inf"} 1
+gorush_send_latency_seconds_sum{outcome="error",platform="ios"} 3
+gorush_send_latency_seconds_count{outcome="error",platform="ios"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_send_latency_seconds"))
+}
+
+func TestRetryMetrics(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, status.InitAppStatus(cfg))
+
+	status.StatStorage.AddRetry("android", status.RetryAttempt, 3)
+	status.StatStorage.AddRetry("android", status.RetrySuccess, 2)
+	status.StatStorage.AddRetry("android", status.RetryFailure, 1)
+
+	q := queue.NewPool(1)
+	defer q.Release()
+
+	expected := `
+# HELP gorush_retry_count Number of the tokens resent to the providers by platform and result
+# TYPE gorush_retry_count counter
+gorush_retry_count{platform="android",result="attempt"} 3
+gorush_retry_count{platform="android",result="failure"} 1
+gorush_retry_count{platform="android",result="success"} 2
+gorush_retry_count{platform="huawei",result="attempt"} 0
+gorush_retry_count{platform="huawei",result="failure"} 0
+gorush_retry_count{platform="huawei",result="success"} 0
+gorush_retry_count{platform="ios",result="attempt"} 0
+gorush_retry_count{platform="ios",result="failure"} 0
+gorush_retry_count{platform="ios",result="success"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewMetrics(q), strings.NewReader(expected), "gorush_retry_count"))
+}
@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/appleboy/gorush/config"
+)
+
+var (
+	// ErrBatchNotFound is the error of the unknown token batch IDs.
+	ErrBatchNotFound = errors.New("token batch not found")
+	// ErrBatchStoreDisabled is the error of the token batches without
+	// core.batch_store.
+	ErrBatchStoreDisabled = errors.New("token batch store is disabled")
+)
+
+// batchIDPattern is the format of the IDs returned by NewBatchID.
+var batchIDPattern = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// TokenBatchStore keeps the token batches uploaded ahead of the sends, a
+// notification with a BatchID is sent to the tokens of the batch.
+type TokenBatchStore interface {
+	// Put stores the tokens of the batch.
+	Put(id string, tokens []string) error
+	// Tokens returns the tokens of the batch, ErrBatchNotFound if unknown.
+	Tokens(id string) ([]string, error)
+	// Delete removes the batch.
+	Delete(id string) error
+}
+
+// NewBatchID returns a random token batch ID.
+func NewBatchID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MemoryTokenBatchStore keeps the token batches in memory, they are lost on
+// restart.
+type MemoryTokenBatchStore struct {
+	mu      sync.RWMutex
+	batches map[string][]string
+}
+
+// NewMemoryTokenBatchStore returns an empty memory store.
+func NewMemoryTokenBatchStore() *MemoryTokenBatchStore {
+	return &MemoryTokenBatchStore{batches: make(map[string][]string)}
+}
+
+// Put implements TokenBatchStore.
+func (s *MemoryTokenBatchStore) Put(id string, tokens []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches[id] = append([]string(nil), tokens...)
+	return nil
+}
+
+// Tokens implements TokenBatchStore.
+func (s *MemoryTokenBatchStore) Tokens(id string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens, ok := s.batches[id]
+	if !ok {
+		return nil, ErrBatchNotFound
+	}
+	return append([]string(nil), tokens...), nil
+}
+
+// Delete implements TokenBatchStore.
+func (s *MemoryTokenBatchStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.batches, id)
+	return nil
+}
+
+// FileTokenBatchStore keeps every token batch in a file of the directory,
+// one token per line.
+type FileTokenBatchStore struct {
+	dir string
+}
+
+// NewFileTokenBatchStore creates the directory of the store.
+func NewFileTokenBatchStore(dir string) (*FileTokenBatchStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileTokenBatchStore{dir: dir}, nil
+}
+
+func (s *FileTokenBatchStore) path(id string) (string, error) {
+	// the IDs are file names, they can't leave the directory
+	if !batchIDPattern.MatchString(id) {
+		return "", ErrBatchNotFound
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// Put implements TokenBatchStore.
+func (s *FileTokenBatchStore) Put(id string, tokens []string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	// the batch is renamed once written, a send never reads half of it
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(tokens, "\n")+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Tokens implements TokenBatchStore.
+func (s *FileTokenBatchStore) Tokens(id string) ([]string, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if token := scanner.Text(); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// Delete implements TokenBatchStore.
+func (s *FileTokenBatchStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// NewTokenBatchStore returns the store of core.batch_store, nil if disabled.
+func NewTokenBatchStore(cfg *config.ConfYaml) (TokenBatchStore, error) {
+	switch cfg.Core.BatchStore {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemoryTokenBatchStore(), nil
+	case "file":
+		return NewFileTokenBatchStore(cfg.Core.BatchPath)
+	default:
+		return nil, errors.New("batch store must be none, memory or file")
+	}
+}
+
+// InitTokenBatch initializes TokenBatches when the batch store is enabled.
+func InitTokenBatch(cfg *config.ConfYaml) error {
+	TokenBatches = nil
+	store, err := NewTokenBatchStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	TokenBatches = store
+	return nil
+}
+
+// UploadTokenBatch stores the tokens as a new batch and returns its ID.
+func UploadTokenBatch(tokens []string) (string, error) {
+	if TokenBatches == nil {
+		return "", ErrBatchStoreDisabled
+	}
+
+	id := NewBatchID()
+	if err := TokenBatches.Put(id, tokens); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// DeleteTokenBatch removes the batch.
+func DeleteTokenBatch(id string) error {
+	if TokenBatches == nil {
+		return ErrBatchStoreDisabled
+	}
+	return TokenBatches.Delete(id)
+}
+
+// loadTokenBatch returns the tokens of the batch of the notification.
+func loadTokenBatch(req *PushNotification) ([]string, error) {
+	if TokenBatches == nil {
+		return nil, ErrBatchStoreDisabled
+	}
+	return TokenBatches.Tokens(req.BatchID)
+}
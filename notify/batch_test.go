@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBatchStores(t *testing.T) {
+	file, err := NewFileTokenBatchStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for name, store := range map[string]TokenBatchStore{
+		"memory": NewMemoryTokenBatchStore(),
+		"file":   file,
+	} {
+		t.Run(name, func(t *testing.T) {
+			id := NewBatchID()
+			_, err := store.Tokens(id)
+			assert.ErrorIs(t, err, ErrBatchNotFound)
+
+			assert.NoError(t, store.Put(id, []string{"aaaaaaaaa", "bbbbbbbbb"}))
+			tokens, err := store.Tokens(id)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb"}, tokens)
+
+			assert.NoError(t, store.Delete(id))
+			_, err = store.Tokens(id)
+			assert.ErrorIs(t, err, ErrBatchNotFound)
+		})
+	}
+
+	// the IDs can't leave the directory of the file store
+	_, err = file.Tokens("../config")
+	assert.ErrorIs(t, err, ErrBatchNotFound)
+}
+
+func TestInitTokenBatch(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	defer func() { TokenBatches = nil }()
+
+	assert.NoError(t, InitTokenBatch(cfg))
+	assert.Nil(t, TokenBatches)
+	_, err := UploadTokenBatch([]string{"aaaaaaaaa"})
+	assert.ErrorIs(t, err, ErrBatchStoreDisabled)
+
+	cfg.Core.BatchStore = "file"
+	cfg.Core.BatchPath = t.TempDir()
+	assert.NoError(t, InitTokenBatch(cfg))
+	assert.IsType(t, &FileTokenBatchStore{}, TokenBatches)
+
+	cfg.Core.BatchStore = "redis"
+	assert.EqualError(t, InitTokenBatch(cfg), "batch store must be none, memory or file")
+}
+
+func TestAndroidPushByBatchID(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	TokenBatches = NewMemoryTokenBatchStore()
+	defer func() { TokenBatches = nil }()
+
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := make([]string, 0, 1200)
+	for i := 0; i < 1200; i++ {
+		tokens = append(tokens, fmt.Sprintf("token%04d", i))
+	}
+	id, err := UploadTokenBatch(tokens)
+	assert.NoError(t, err)
+
+	req := &PushNotification{
+		Message:  "Welcome",
+		Platform: core.PlatFormAndroid,
+		BatchID:  id,
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200, resp.Total)
+	assert.Equal(t, 1200, resp.Success)
+	// the tokens are sent by the chunks of 500
+	assert.Equal(t, 3, len(client.batches))
+	// the request still refers to the batch
+	assert.Equal(t, id, req.BatchID)
+	assert.Nil(t, req.Tokens)
+
+	req.BatchID = NewBatchID()
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrBatchNotFound)
+
+	req.Tokens = []string{"aaaaaaaaa"}
+	assert.EqualError(t, CheckMessage(req), "the message can't specify both a batch ID and registration IDs, a topic or condition")
+
+	req.Tokens = nil
+	req.Platform = core.PlatFormIos
+	assert.EqualError(t, CheckMessage(req), "the batch is only supported by Android")
+}
+
+func TestAndroidBatchTokenFields(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Ios.Enabled = true
+	TokenBatches = NewMemoryTokenBatchStore()
+	defer func() { TokenBatches = nil }()
+
+	setAPNSTestClient(t, func(string) (int, string) { return http.StatusOK, "" })
+	_, unregistered := newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")).
+		Send(context.Background(), &messaging.Message{Token: "XXXXXXXXX"})
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{onSend: rejectTokens(unregistered, "gone")})
+
+	id, err := UploadTokenBatch([]string{"alive", "gone"})
+	assert.NoError(t, err)
+
+	// the per token fields are checked against the tokens of the batch
+	req := &PushNotification{
+		Message:        "Welcome",
+		Platform:       core.PlatFormAndroid,
+		BatchID:        id,
+		UserIDs:        []string{"alice", "bob"},
+		Fallback:       true,
+		FallbackTokens: []string{"apns_alice", "apns_bob"},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, []FallbackResult{{Index: 1, Channel: "ios"}}, resp.Fallbacks)
+	assert.Equal(t, "bob", resp.Logs[1].UserID)
+
+	req.FallbackTokens = []string{"apns_alice"}
+	assert.NoError(t, CheckMessage(req))
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.EqualError(t, err, "the message must specify one fallback token per token, got 1 fallback tokens for 2 tokens")
+
+	// the templates of a batch are rendered with the tokens of the batch
+	client := &personalizedFCMClient{notifications: map[string][2]*messaging.Notification{}}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+	req = &PushNotification{
+		Platform: core.PlatFormAndroid,
+		BatchID:  id,
+		Template: &NotificationTemplate{Title: "Hi {{.name}}", Body: "Welcome"},
+		Vars:     []map[string]string{{"name": "Alice"}, {"name": "Bob"}},
+	}
+	assert.NoError(t, CheckMessage(req))
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, "Hi Bob", client.notifications["gone"][0].Title)
+
+	// the batch counts against core.max_tokens_per_request
+	cfg.Core.MaxTokensPerRequest = 1
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrTooManyTokens)
+}
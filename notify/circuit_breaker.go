@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+)
+
+// ErrFCMCircuitOpen is the error of the sends which fail fast while the
+// circuit of the project is open.
+var ErrFCMCircuitOpen = errors.New("circuit open")
+
+// States of a circuit breaker.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+var (
+	// fcmV1Breakers are the circuit breakers per project, they're shared by
+	// all the workers.
+	fcmV1Breakers     = make(map[string]*CircuitBreaker)
+	fcmV1BreakersLock sync.Mutex
+)
+
+// CircuitBreaker fails the sends fast once threshold consecutive sends
+// failed. After timeout a single probe send is let through, the circuit is
+// closed again when it succeeds.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// NewCircuitBreaker returns a closed circuit breaker, name identifies it in
+// the logs.
+func NewCircuitBreaker(name string, threshold int, timeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		timeout:   timeout,
+		state:     CircuitClosed,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a send may be done, it fails with ErrFCMCircuitOpen
+// while the circuit is open. A nil breaker allows every send.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitClosed {
+		return nil
+	}
+
+	// a probe is let through once per timeout, a lost probe doesn't keep
+	// the circuit open forever
+	if b.now().Sub(b.openedAt) < b.timeout {
+		return ErrFCMCircuitOpen
+	}
+
+	b.state = CircuitHalfOpen
+	b.openedAt = b.now()
+	logx.LogAccess.Infof("probe the FCM circuit of %q", b.name)
+	return nil
+}
+
+// Record counts the result of a send, failed is true when FCM is
+// unavailable.
+func (b *CircuitBreaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state != CircuitClosed {
+			logx.LogAccess.Infof("close the FCM circuit of %q", b.name)
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.state == CircuitClosed && b.failures >= b.threshold) {
+		logx.LogError.Errorf("open the FCM circuit of %q after %d failed sends", b.name, b.failures)
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State returns the state of the circuit.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// fcmV1Breaker returns the circuit breaker of the project of the request, the
+// breaker is created on first use. Nil is returned when
+// android.circuit_breaker_threshold isn't set.
+func fcmV1Breaker(cfg *config.ConfYaml, req *PushNotification) *CircuitBreaker {
+	if cfg.Android.BreakerThreshold <= 0 {
+		return nil
+	}
+
+	projectID := fcmV1ProjectID(cfg, req)
+
+	fcmV1BreakersLock.Lock()
+	defer fcmV1BreakersLock.Unlock()
+
+	breaker, ok := fcmV1Breakers[projectID]
+	if !ok {
+		breaker = NewCircuitBreaker(projectID, cfg.Android.BreakerThreshold,
+			time.Duration(cfg.Android.BreakerTimeout)*time.Second)
+		fcmV1Breakers[projectID] = breaker
+	}
+
+	return breaker
+}
+
+// isFCMOutage reports whether the send failed because FCM is unavailable,
+// either the whole batch or every message of it.
+func isFCMOutage(res *messaging.BatchResponse, err error) bool {
+	if err != nil {
+		return isRetryableFCMError(err)
+	}
+
+	if res == nil || res.SuccessCount > 0 || len(res.Responses) == 0 {
+		return false
+	}
+
+	for _, result := range res.Responses {
+		if !isRetryableFCMError(result.Error) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/status"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.NoError(t, b.Allow())
+	b.Record(true)
+	assert.Equal(t, CircuitClosed, b.State())
+
+	// a success resets the consecutive failures
+	b.Record(false)
+	b.Record(true)
+	assert.Equal(t, CircuitClosed, b.State())
+
+	b.Record(true)
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.Equal(t, ErrFCMCircuitOpen, b.Allow())
+
+	// a single probe is let through after the timeout
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.Equal(t, ErrFCMCircuitOpen, b.Allow())
+
+	// the failed probe opens the circuit again
+	b.Record(true)
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.Equal(t, ErrFCMCircuitOpen, b.Allow())
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.Allow())
+	b.Record(false)
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.NoError(t, b.Allow())
+
+	// the nil breaker allows every send
+	var disabled *CircuitBreaker
+	assert.NoError(t, disabled.Allow())
+	disabled.Record(true)
+}
+
+func TestIsFCMOutage(t *testing.T) {
+	assert.True(t, isFCMOutage(nil, context.DeadlineExceeded))
+	assert.False(t, isFCMOutage(nil, errors.New("invalid message")))
+
+	assert.True(t, isFCMOutage(&messaging.BatchResponse{
+		FailureCount: 2,
+		Responses: []*messaging.SendResponse{
+			{Error: context.DeadlineExceeded},
+			{Error: context.DeadlineExceeded},
+		},
+	}, nil))
+
+	// FCM answered for some of the tokens
+	assert.False(t, isFCMOutage(&messaging.BatchResponse{
+		SuccessCount: 1,
+		FailureCount: 1,
+		Responses: []*messaging.SendResponse{
+			{Success: true},
+			{Error: context.DeadlineExceeded},
+		},
+	}, nil))
+	assert.False(t, isFCMOutage(&messaging.BatchResponse{}, nil))
+}
+
+func TestAndroidCircuitBreaker(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "breaker-project"
+	cfg.Android.BreakerThreshold = 3
+	cfg.Android.BreakerTimeout = 1
+	t.Cleanup(func() {
+		fcmV1BreakersLock.Lock()
+		delete(fcmV1Breakers, "breaker-project")
+		fcmV1BreakersLock.Unlock()
+	})
+
+	// FCM times out every send while down
+	var down atomic.Bool
+	down.Store(true)
+	client := &recordingFCMClient{onSend: func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+		if down.Load() {
+			return nil, context.DeadlineExceeded
+		}
+		return successResponse(m.Tokens), nil
+	}}
+	setFCMTestClient(t, "breaker-project", cfg, client)
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	status.StatStorage.Reset()
+
+	// the consecutive failures trip the breaker
+	for i := 0; i < 3; i++ {
+		_, err := PushToAndroidV1(context.Background(), req, cfg)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	assert.Equal(t, 3, len(client.messages))
+
+	// the sends fail fast without calling FCM
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrFCMCircuitOpen)
+	assert.Equal(t, 1, resp.Failure)
+	assert.Equal(t, ErrorTypeCircuitOpen, resp.Logs[0].ErrorType)
+	assert.Equal(t, core.ErrorCodeServerError, resp.Logs[0].ErrorCode)
+	assert.Equal(t, 3, len(client.messages))
+	assert.Equal(t, int64(1), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorCircuitOpen))
+
+	// the probe finds FCM recovered and closes the circuit
+	down.Store(false)
+	time.Sleep(time.Second)
+
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 4, len(client.messages))
+	assert.Equal(t, CircuitClosed, fcmV1Breaker(cfg, req).State())
+}
+
+func TestAndroidTopicCircuitBreakerCanceled(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "breaker-topic-project"
+	cfg.Android.BreakerThreshold = 1
+	cfg.Android.BreakerTimeout = 60
+	t.Cleanup(func() {
+		fcmV1BreakersLock.Lock()
+		delete(fcmV1Breakers, "breaker-topic-project")
+		fcmV1BreakersLock.Unlock()
+	})
+
+	setFCMTestClient(t, "breaker-topic-project", cfg, blockingFCMClient{})
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Topic:    "news",
+	}
+
+	// the send canceled by the caller doesn't trip the breaker
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := PushToAndroidV1(ctx, req, cfg)
+	assert.Error(t, err)
+	assert.Equal(t, CircuitClosed, fcmV1Breaker(cfg, req).State())
+}
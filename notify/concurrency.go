@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/status"
+)
+
+// ErrMaxConcurrentPushes is the error of the sends which waited for a free
+// slot longer than core.queue_timeout.
+var ErrMaxConcurrentPushes = errors.New("max concurrent pushes reached")
+
+// activePushes counts the sends in flight to the providers.
+var activePushes atomic.Int64
+
+// ActivePushes returns the number of sends in flight to the providers.
+func ActivePushes() int64 {
+	return activePushes.Load()
+}
+
+// PushLimiter caps the concurrent sends to the providers of all the
+// platforms.
+type PushLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// NewPushLimiter returns a limiter of size concurrent sends, a send waits up
+// to timeout for a free slot.
+func NewPushLimiter(size int, timeout time.Duration) *PushLimiter {
+	return &PushLimiter{
+		slots:   make(chan struct{}, size),
+		timeout: timeout,
+	}
+}
+
+// Acquire waits for a free slot, it fails once the timeout is elapsed or ctx
+// is done.
+func (l *PushLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrMaxConcurrentPushes
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by Acquire.
+func (l *PushLimiter) Release() {
+	<-l.slots
+}
+
+// InitPushLimiter initializes PushLimit when core.max_concurrent_pushes is
+// set.
+func InitPushLimiter(cfg *config.ConfYaml) {
+	PushLimit = nil
+	if cfg.Core.MaxConcurrentPushes <= 0 {
+		return
+	}
+
+	PushLimit = NewPushLimiter(int(cfg.Core.MaxConcurrentPushes),
+		time.Duration(cfg.Core.QueueTimeout)*time.Second)
+}
+
+// acquirePush takes a slot of PushLimit before a send to a provider, the
+// returned func releases it once the send is done.
+func acquirePush(ctx context.Context) (func(), error) {
+	limit := PushLimit
+	if limit != nil {
+		if err := limit.Acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	activePushes.Add(1)
+	return func() {
+		activePushes.Add(-1)
+		if limit != nil {
+			limit.Release()
+		}
+	}, nil
+}
+
+// recordRetry counts the tokens resent by the retry number retryCount of the
+// platform and how many of them were delivered, the first send isn't a
+// retry.
+func recordRetry(platform string, retryCount, sent, delivered int) {
+	if retryCount == 0 || sent == 0 {
+		return
+	}
+
+	status.StatStorage.AddRetry(platform, status.RetryAttempt, int64(sent))
+	status.StatStorage.AddRetry(platform, status.RetrySuccess, int64(delivered))
+	status.StatStorage.AddRetry(platform, status.RetryFailure, int64(sent-delivered))
+}
+
+// observeSendLatency records the duration of a send to the provider of the
+// platform since start, failed is true when nothing was delivered.
+func observeSendLatency(platform string, start time.Time, failed bool) {
+	outcome := status.SendSuccess
+	if failed {
+		outcome = status.SendError
+	}
+
+	status.StatStorage.ObserveSendLatency(platform, outcome, time.Since(start))
+}
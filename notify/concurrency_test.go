@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushLimiter(t *testing.T) {
+	l := NewPushLimiter(1, 10*time.Millisecond)
+	assert.NoError(t, l.Acquire(context.Background()))
+
+	// the saturated limiter fails after the timeout
+	assert.Equal(t, ErrMaxConcurrentPushes, l.Acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.timeout = time.Minute
+	assert.Equal(t, context.Canceled, l.Acquire(ctx))
+
+	// the waiting send takes the released slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Release()
+	}()
+	assert.NoError(t, l.Acquire(context.Background()))
+}
+
+func TestInitPushLimiter(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	defer func() { PushLimit = nil }()
+
+	InitPushLimiter(cfg)
+	assert.Nil(t, PushLimit)
+
+	cfg.Core.MaxConcurrentPushes = 2
+	InitPushLimiter(cfg)
+	assert.Equal(t, 2, cap(PushLimit.slots))
+	assert.Equal(t, 10*time.Second, PushLimit.timeout)
+}
+
+func TestAndroidMaxConcurrentPushes(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{})
+
+	PushLimit = NewPushLimiter(1, 10*time.Millisecond)
+	defer func() { PushLimit = nil }()
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	// every slot is taken by another send
+	release, err := acquirePush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), ActivePushes())
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.Equal(t, ErrMaxConcurrentPushes, err)
+	assert.Equal(t, 0, resp.Success)
+	assert.Equal(t, ErrMaxConcurrentPushes.Error(), resp.Logs[0].Error)
+
+	release()
+	assert.Equal(t, int64(0), ActivePushes())
+
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, int64(0), ActivePushes())
+}
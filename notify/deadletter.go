@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+)
+
+// DeadLetterEntry is a notification failed on an unrecoverable error.
+type DeadLetterEntry struct {
+	Notification *PushNotification `json:"notification"`
+	Reason       string            `json:"reason"`
+	Time         int64             `json:"time"`
+}
+
+// DeadLetter stores the failed notifications so they can be replayed.
+type DeadLetter interface {
+	// Put appends the entry to the store.
+	Put(entry *DeadLetterEntry) error
+	// Drain returns all the entries and removes them from the store.
+	Drain() ([]*DeadLetterEntry, error)
+}
+
+// FileDeadLetter stores the entries as JSON lines in a file.
+type FileDeadLetter struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileDeadLetter returns a dead letter store backed by the file at path.
+func NewFileDeadLetter(path string) *FileDeadLetter {
+	return &FileDeadLetter{path: path}
+}
+
+// Put implements DeadLetter.
+func (f *FileDeadLetter) Put(entry *DeadLetterEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Drain implements DeadLetter.
+func (f *FileDeadLetter) Drain() ([]*DeadLetterEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*DeadLetterEntry
+	scanner := bufio.NewScanner(file)
+	// a notification may be larger than the default 64KB token size
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := &DeadLetterEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, os.Truncate(f.path, 0)
+}
+
+// InitDeadLetter initializes DeadLetterStore when dead_letter is enabled.
+func InitDeadLetter(cfg *config.ConfYaml) {
+	DeadLetterStore = nil
+	if cfg.DeadLetter.Enabled {
+		DeadLetterStore = NewFileDeadLetter(cfg.DeadLetter.Path)
+	}
+}
+
+// putDeadLetter stores the notification when the dead letter store is enabled.
+func putDeadLetter(notification *PushNotification, reason error) {
+	if DeadLetterStore == nil {
+		return
+	}
+
+	if err := DeadLetterStore.Put(&DeadLetterEntry{
+		Notification: notification,
+		Reason:       reason.Error(),
+		Time:         time.Now().Unix(),
+	}); err != nil {
+		logx.LogError.Error("dead letter error: " + err.Error())
+	}
+}
+
+// ReplayDeadLetter sends again the notifications of the dead letter store
+// and returns the number of replayed entries. Entries failed again are put
+// back in the store.
+func ReplayDeadLetter(ctx context.Context, cfg *config.ConfYaml) (int, error) {
+	if DeadLetterStore == nil {
+		return 0, nil
+	}
+
+	entries, err := DeadLetterStore.Drain()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		logx.LogAccess.Infof("replay the notification failed at %s: %s",
+			time.Unix(entry.Time, 0).Format(time.RFC3339), entry.Reason)
+
+		if _, err := SendNotification(ctx, entry.Notification, cfg); err != nil {
+			logx.LogError.Error("replay error: " + err.Error())
+		}
+	}
+
+	return len(entries), nil
+}
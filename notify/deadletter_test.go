@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingFCMClient fails every batch entirely.
+type failingFCMClient struct {
+	blockingFCMClient
+	err error
+}
+
+func (c *failingFCMClient) SendEachForMulticast(
+	_ context.Context,
+	_ *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	return nil, c.err
+}
+
+func TestFileDeadLetter(t *testing.T) {
+	store := NewFileDeadLetter(filepath.Join(t.TempDir(), "dead_letter.jsonl"))
+
+	// nothing stored yet
+	entries, err := store.Drain()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.NoError(t, store.Put(&DeadLetterEntry{
+		Notification: &PushNotification{Platform: core.PlatFormAndroid, Tokens: []string{"a"}, Message: "Test"},
+		Reason:       "boom",
+		Time:         1,
+	}))
+	assert.NoError(t, store.Put(&DeadLetterEntry{
+		Notification: &PushNotification{Platform: core.PlatFormAndroid, Tokens: []string{"b"}, Message: "Test"},
+		Reason:       "bang",
+		Time:         2,
+	}))
+
+	entries, err = store.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, []string{"a"}, entries[0].Notification.Tokens)
+	assert.Equal(t, "boom", entries[0].Reason)
+	assert.Equal(t, int64(2), entries[1].Time)
+
+	// the drained entries are removed
+	entries, err = store.Drain()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestAndroidDeadLetter(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.DeadLetter.Enabled = true
+	cfg.DeadLetter.Path = filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	cfg.Android.MaxRetry = 1
+	cfg.Android.RetryInterval = 0
+	InitDeadLetter(cfg)
+	defer func() { DeadLetterStore = nil }()
+
+	client := &failingFCMClient{err: errors.New("boom")}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	_, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.Error(t, err)
+
+	// the retryable errors are stored once the retries are exhausted
+	client.err = context.DeadlineExceeded
+	req.Tokens = []string{"ccccccccc"}
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.Error(t, err)
+
+	entries, err := DeadLetterStore.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb"}, entries[0].Notification.Tokens)
+	assert.Equal(t, "Test", entries[0].Notification.Message)
+	assert.Equal(t, "boom", entries[0].Reason)
+	assert.Equal(t, []string{"ccccccccc"}, entries[1].Notification.Tokens)
+
+	// the replayed notifications failed again are stored again
+	client.err = errors.New("boom")
+	for _, entry := range entries {
+		assert.NoError(t, DeadLetterStore.Put(entry))
+	}
+
+	count, err := ReplayDeadLetter(context.Background(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	entries, err = DeadLetterStore.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	// the replayed notifications are sent
+	for _, entry := range entries {
+		assert.NoError(t, DeadLetterStore.Put(entry))
+	}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &slowFCMClient{})
+
+	count, err = ReplayDeadLetter(context.Background(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	entries, err = DeadLetterStore.Drain()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestAndroidDeadLetterCanceledRetry(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.DeadLetter.Enabled = true
+	cfg.DeadLetter.Path = filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	cfg.Android.MaxRetry = 1
+	cfg.Android.RetryInterval = 60
+	cfg.Android.DedupTokens = true
+	InitDeadLetter(cfg)
+	defer func() { DeadLetterStore = nil }()
+
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &failingFCMClient{err: context.DeadlineExceeded})
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb", "aaaaaaaaa"},
+	}
+
+	// the retry canceled while waiting completes the send
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	resp, err := PushToAndroidV1(ctx, req, cfg)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, resp.Failure)
+	// the duplicate token has its log
+	assert.Equal(t, 3, len(resp.Logs))
+
+	entries, err := DeadLetterStore.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb"}, entries[0].Notification.Tokens)
+}
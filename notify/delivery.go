@@ -0,0 +1,297 @@
+package notify
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+
+	// register the sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// deliveryBatchSize is the max number of receipts written at once.
+	deliveryBatchSize = 100
+	// deliveryBufferSize is the max number of receipts waiting to be written.
+	deliveryBufferSize = 8192
+)
+
+// DeliveryReceipt is a message accepted by FCM for the token.
+type DeliveryReceipt struct {
+	MessageID string `json:"message_id"`
+	Token     string `json:"token"`
+	SentAt    int64  `json:"sent_at"`
+}
+
+// DeliveryStore records the successful sends for the delivery analytics.
+type DeliveryStore interface {
+	// Record stores the receipts.
+	Record(receipts []*DeliveryReceipt) error
+	// Receipts returns the receipts of the token, the newest first.
+	Receipts(token string) ([]*DeliveryReceipt, error)
+	// Purge removes the receipts sent before the time.
+	Purge(before time.Time) error
+	// Close releases the store.
+	Close() error
+}
+
+// NoopDeliveryStore drops all the receipts.
+type NoopDeliveryStore struct{}
+
+// Record implements DeliveryStore.
+func (NoopDeliveryStore) Record([]*DeliveryReceipt) error { return nil }
+
+// Receipts implements DeliveryStore.
+func (NoopDeliveryStore) Receipts(string) ([]*DeliveryReceipt, error) { return nil, nil }
+
+// Purge implements DeliveryStore.
+func (NoopDeliveryStore) Purge(time.Time) error { return nil }
+
+// Close implements DeliveryStore.
+func (NoopDeliveryStore) Close() error { return nil }
+
+// SQLiteDeliveryStore stores the receipts in a sqlite database.
+type SQLiteDeliveryStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteDeliveryStore opens the sqlite database at path and creates the
+// receipts table if needed.
+func NewSQLiteDeliveryStore(path string) (*SQLiteDeliveryStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS deliveries (
+  message_id TEXT NOT NULL,
+  token TEXT NOT NULL,
+  sent_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deliveries_token ON deliveries (token);
+CREATE INDEX IF NOT EXISTS deliveries_sent_at ON deliveries (sent_at);
+`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteDeliveryStore{db: db}, nil
+}
+
+// Record implements DeliveryStore.
+func (s *SQLiteDeliveryStore) Record(receipts []*DeliveryReceipt) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO deliveries (message_id, token, sent_at) VALUES (?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, receipt := range receipts {
+		if _, err := stmt.Exec(receipt.MessageID, receipt.Token, receipt.SentAt); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Receipts implements DeliveryStore.
+func (s *SQLiteDeliveryStore) Receipts(token string) ([]*DeliveryReceipt, error) {
+	rows, err := s.db.Query(
+		"SELECT message_id, token, sent_at FROM deliveries WHERE token = ? ORDER BY sent_at DESC, rowid DESC",
+		token,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []*DeliveryReceipt
+	for rows.Next() {
+		receipt := &DeliveryReceipt{}
+		if err := rows.Scan(&receipt.MessageID, &receipt.Token, &receipt.SentAt); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, rows.Err()
+}
+
+// Purge implements DeliveryStore.
+func (s *SQLiteDeliveryStore) Purge(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM deliveries WHERE sent_at < ?", before.Unix())
+	return err
+}
+
+// Close implements DeliveryStore.
+func (s *SQLiteDeliveryStore) Close() error {
+	return s.db.Close()
+}
+
+// DeliveryWriter records the receipts to the store in the background, so the
+// store never slows the send path. Receipts are dropped when the buffer is
+// full.
+type DeliveryWriter struct {
+	store     DeliveryStore
+	retention time.Duration
+	// requestedOnly records only the receipts requested by the message.
+	requestedOnly bool
+	receipts      chan *DeliveryReceipt
+	done          chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewDeliveryWriter starts writing the receipts to the store, the receipts
+// older than retention are purged every hour unless retention is zero.
+func NewDeliveryWriter(store DeliveryStore, retention time.Duration, size int) *DeliveryWriter {
+	w := &DeliveryWriter{
+		store:     store,
+		retention: retention,
+		receipts:  make(chan *DeliveryReceipt, size),
+		done:      make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+// Record queues the receipt without blocking.
+func (w *DeliveryWriter) Record(messageID, token string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.receipts <- &DeliveryReceipt{MessageID: messageID, Token: token, SentAt: time.Now().Unix()}:
+	default:
+		logx.LogError.Error("delivery store buffer is full, drop the receipt of message " + messageID)
+	}
+}
+
+// Close writes the queued receipts and closes the store.
+func (w *DeliveryWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.receipts)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	return w.store.Close()
+}
+
+func (w *DeliveryWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	w.purge()
+
+	for {
+		select {
+		case receipt, ok := <-w.receipts:
+			if !ok {
+				return
+			}
+			w.write(receipt)
+		case <-ticker.C:
+			w.purge()
+		}
+	}
+}
+
+// write stores the receipt along with the other queued ones.
+func (w *DeliveryWriter) write(receipt *DeliveryReceipt) {
+	batch := []*DeliveryReceipt{receipt}
+loop:
+	for len(batch) < deliveryBatchSize {
+		select {
+		case receipt, ok := <-w.receipts:
+			if !ok {
+				break loop
+			}
+			batch = append(batch, receipt)
+		default:
+			break loop
+		}
+	}
+
+	if err := w.store.Record(batch); err != nil {
+		logx.LogError.Error("delivery store error: " + err.Error())
+	}
+}
+
+func (w *DeliveryWriter) purge() {
+	if w.retention <= 0 {
+		return
+	}
+
+	if err := w.store.Purge(time.Now().Add(-w.retention)); err != nil {
+		logx.LogError.Error("delivery store purge error: " + err.Error())
+	}
+}
+
+// NewDeliveryStore returns the store of core.delivery_store.
+func NewDeliveryStore(cfg *config.ConfYaml) (DeliveryStore, error) {
+	switch cfg.Core.DeliveryStore {
+	case "", "none":
+		return NoopDeliveryStore{}, nil
+	case "sqlite":
+		return NewSQLiteDeliveryStore(cfg.Core.DeliveryPath)
+	default:
+		return nil, errors.New("delivery store must be none or sqlite")
+	}
+}
+
+// InitDelivery initializes DeliveryRecorder when the delivery store is enabled.
+func InitDelivery(cfg *config.ConfYaml) error {
+	DeliveryRecorder = nil
+	store, err := NewDeliveryStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := store.(NoopDeliveryStore); ok {
+		return nil
+	}
+
+	DeliveryRecorder = NewDeliveryWriter(store,
+		time.Duration(cfg.Core.DeliveryRetention)*24*time.Hour, deliveryBufferSize)
+	DeliveryRecorder.requestedOnly = cfg.Core.DeliveryRequestedOnly
+	return nil
+}
+
+// recordDelivery records the successful send when the delivery store is
+// enabled, dry run messages aren't delivered.
+func recordDelivery(req *PushNotification, messageID, token string) {
+	if DeliveryRecorder == nil || req.DryRun {
+		return
+	}
+	if DeliveryRecorder.requestedOnly && !req.DeliveryReceiptRequested {
+		return
+	}
+
+	DeliveryRecorder.Record(messageID, token)
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteDeliveryStore(t *testing.T) {
+	store, err := NewSQLiteDeliveryStore(filepath.Join(t.TempDir(), "delivery.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	receipts, err := store.Receipts("aaaaaaaaa")
+	assert.NoError(t, err)
+	assert.Empty(t, receipts)
+
+	assert.NoError(t, store.Record([]*DeliveryReceipt{
+		{MessageID: "1", Token: "aaaaaaaaa", SentAt: 100},
+		{MessageID: "2", Token: "bbbbbbbbb", SentAt: 100},
+		{MessageID: "3", Token: "aaaaaaaaa", SentAt: 200},
+	}))
+
+	// the newest first
+	receipts, err = store.Receipts("aaaaaaaaa")
+	assert.NoError(t, err)
+	assert.Equal(t, []*DeliveryReceipt{
+		{MessageID: "3", Token: "aaaaaaaaa", SentAt: 200},
+		{MessageID: "1", Token: "aaaaaaaaa", SentAt: 100},
+	}, receipts)
+
+	// the receipts sent before the time are purged
+	assert.NoError(t, store.Purge(time.Unix(200, 0)))
+	receipts, err = store.Receipts("aaaaaaaaa")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(receipts))
+	assert.Equal(t, "3", receipts[0].MessageID)
+
+	receipts, err = store.Receipts("bbbbbbbbb")
+	assert.NoError(t, err)
+	assert.Empty(t, receipts)
+}
+
+func TestDeliveryWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delivery.db")
+	store, err := NewSQLiteDeliveryStore(path)
+	assert.NoError(t, err)
+
+	w := NewDeliveryWriter(store, 0, 10)
+	for i := 0; i < 5; i++ {
+		w.Record("message", "aaaaaaaaa")
+	}
+
+	// the queued receipts are written on close
+	assert.NoError(t, w.Close())
+	w.Record("closed", "aaaaaaaaa")
+
+	store, err = NewSQLiteDeliveryStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	receipts, err := store.Receipts("aaaaaaaaa")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(receipts))
+}
+
+func TestDeliveryWriterFullBuffer(t *testing.T) {
+	w := &DeliveryWriter{
+		store:    NoopDeliveryStore{},
+		receipts: make(chan *DeliveryReceipt, 1),
+		done:     make(chan struct{}),
+	}
+
+	// the receipts are dropped instead of blocking the send path
+	w.Record("1", "aaaaaaaaa")
+	w.Record("2", "aaaaaaaaa")
+	assert.Equal(t, 1, len(w.receipts))
+}
+
+func TestAndroidDeliveryReceipts(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Core.DeliveryStore = "sqlite"
+	cfg.Core.DeliveryPath = filepath.Join(t.TempDir(), "delivery.db")
+	assert.NoError(t, InitDelivery(cfg))
+	defer func() { DeliveryRecorder = nil }()
+
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{})
+
+	_, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, DeliveryRecorder.Close())
+
+	store, err := NewSQLiteDeliveryStore(cfg.Core.DeliveryPath)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	receipts, err := store.Receipts("bbbbbbbbb")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(receipts))
+	assert.Equal(t, "bbbbbbbbb", receipts[0].MessageID)
+	assert.InDelta(t, time.Now().Unix(), receipts[0].SentAt, 5)
+}
+
+func TestAndroidDeliveryReceiptRequested(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Core.DeliveryStore = "sqlite"
+	cfg.Core.DeliveryPath = filepath.Join(t.TempDir(), "delivery.db")
+	cfg.Core.DeliveryRequestedOnly = true
+	assert.NoError(t, InitDelivery(cfg))
+	defer func() { DeliveryRecorder = nil }()
+
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{})
+
+	for _, token := range []string{"aaaaaaaaa", "bbbbbbbbb"} {
+		req := &PushNotification{
+			Message:  "Test",
+			Platform: core.PlatFormAndroid,
+			Priority: "normal",
+			Tokens:   []string{token},
+			// only the second message requests the receipt
+			DeliveryReceiptRequested: token == "bbbbbbbbb",
+		}
+		assert.NoError(t, CheckMessage(req))
+
+		_, err := PushToAndroidV1(context.Background(), req, cfg)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, DeliveryRecorder.Close())
+
+	store, err := NewSQLiteDeliveryStore(cfg.Core.DeliveryPath)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	receipts, err := store.Receipts("aaaaaaaaa")
+	assert.NoError(t, err)
+	assert.Empty(t, receipts)
+
+	// the message ID returned by FCM
+	receipts, err = store.Receipts("bbbbbbbbb")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(receipts))
+	assert.Equal(t, "bbbbbbbbb", receipts[0].MessageID)
+
+	assert.EqualError(t, CheckMessage(&PushNotification{
+		Message:                  "Test",
+		Platform:                 core.PlatFormIos,
+		Tokens:                   []string{"aaaaaaaaa"},
+		DeliveryReceiptRequested: true,
+	}), "the delivery receipt is only supported by Android")
+}
+
+func TestInitDelivery(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	defer func() { DeliveryRecorder = nil }()
+
+	assert.NoError(t, InitDelivery(cfg))
+	assert.Nil(t, DeliveryRecorder)
+
+	cfg.Core.DeliveryStore = "bolt"
+	assert.EqualError(t, InitDelivery(cfg), "delivery store must be none or sqlite")
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/logx"
+)
+
+// FallbackResult tells which channel delivered the notification of a token
+// rejected by its provider and sent again to its fallback token.
+type FallbackResult struct {
+	// Index is the position of the token in the request.
+	Index int `json:"index"`
+	// Channel is the platform which delivered the notification, ios or
+	// android, it's empty if the fallback failed as well.
+	Channel string `json:"channel,omitempty"`
+}
+
+// fallbackChannels are the channel names of the fallback platforms.
+var fallbackChannels = map[int]string{
+	core.PlatFormIos:     "ios",
+	core.PlatFormAndroid: "android",
+}
+
+// fallbackPlatform returns the platform of the fallback tokens.
+func fallbackPlatform(platform int) int {
+	if platform == core.PlatFormIos {
+		return core.PlatFormAndroid
+	}
+	return core.PlatFormIos
+}
+
+// fallbackIndexes returns the positions of the failed tokens in tokens.
+func fallbackIndexes(tokens, failed []string) []int {
+	positions := make(map[string][]int, len(tokens))
+	for k, token := range tokens {
+		positions[token] = append(positions[token], k)
+	}
+
+	indexes := make([]int, 0, len(failed))
+	for _, token := range failed {
+		if len(positions[token]) == 0 {
+			continue
+		}
+		indexes = append(indexes, positions[token][0])
+		positions[token] = positions[token][1:]
+	}
+	return indexes
+}
+
+// pushFallback sends the notification to the fallback tokens of the tokens
+// at indexes on the other platform when req.Fallback is set. The logs of the
+// fallback sends are appended to resp along with the delivering channel of
+// every index.
+func pushFallback(ctx context.Context, req *PushNotification, cfg *config.ConfYaml, indexes []int, resp *ResponsePush) {
+	if !req.Fallback || len(indexes) == 0 {
+		return
+	}
+
+	platform := fallbackPlatform(req.Platform)
+	if (platform == core.PlatFormIos && !cfg.Ios.Enabled) || (platform == core.PlatFormAndroid && !cfg.Android.Enabled) {
+		logx.ErrorEntry(ctx).Errorf("skip the fallback of %d tokens, the %s platform isn't enabled",
+			len(indexes), fallbackChannels[platform])
+		return
+	}
+
+	for _, k := range indexes {
+		if req.FallbackTokens[k] == "" {
+			continue
+		}
+
+		notification := fallbackNotification(req, platform, k)
+
+		logx.AccessEntry(ctx).Debugf("send the notification of token %d to its %s fallback token", k, fallbackChannels[platform])
+
+		var (
+			fallback *ResponsePush
+			err      error
+		)
+		if platform == core.PlatFormIos {
+			fallback, err = PushToIOS(notification, cfg)
+		} else {
+			// the fallback results are reported with the request, not as
+			// batches of their own
+			fallback, err = PushToAndroidV1(context.WithValue(ctx, batchHandlerKey{}, BatchHandler(nil)), notification, cfg)
+		}
+
+		result := FallbackResult{Index: k}
+		if fallback != nil {
+			// the logs refer to the position of the token in the request
+			for _, l := range fallback.Logs {
+				index := k
+				l.Index = &index
+				resp.Logs = append(resp.Logs, l)
+			}
+			if err == nil && !hasFailedLog(fallback) {
+				result.Channel = fallbackChannels[platform]
+			}
+		}
+		resp.Fallbacks = append(resp.Fallbacks, result)
+	}
+}
+
+// fallbackNotification returns the notification of the token at index k for
+// its fallback token on the platform. Only the common fields are copied, the
+// fields of the platform of the request don't apply to the other one, e.g. the
+// APNs topic would be sent as a FCM topic.
+func fallbackNotification(req *PushNotification, platform, k int) *PushNotification {
+	title, message := req.Title, req.Message
+	if len(req.Titles) > 0 && req.Titles[k] != "" {
+		title = req.Titles[k]
+	}
+	if len(req.Bodies) > 0 && req.Bodies[k] != "" {
+		message = req.Bodies[k]
+	}
+
+	return &PushNotification{
+		ID:               req.ID,
+		Tokens:           []string{req.FallbackTokens[k]},
+		Platform:         platform,
+		Message:          message,
+		Title:            title,
+		Image:            req.Image,
+		Priority:         req.Priority,
+		ContentAvailable: req.ContentAvailable,
+		MutableContent:   req.MutableContent,
+		Sound:            req.Sound,
+		Data:             req.Data,
+		Retry:            req.Retry,
+		Debug:            req.Debug,
+		IncludeRawError:  req.IncludeRawError,
+		RequestID:        req.RequestID,
+		TenantID:         req.TenantID,
+		Critical:         req.Critical,
+		Badge:            req.Badge,
+	}
+}
+
+// hasFailedLog reports whether a token of the response failed.
+func hasFailedLog(resp *ResponsePush) bool {
+	for _, l := range resp.Logs {
+		if l.Type == core.FailedPush {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/sideshow/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+// apnsRoundTripper answers the APNs requests with the status and reason of
+// the device token.
+type apnsRoundTripper func(token string) (int, string)
+
+func (f apnsRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	code, reason := f(path.Base(r.URL.Path))
+
+	body := ""
+	if reason != "" {
+		body = `{"reason": "` + reason + `"}`
+	}
+
+	return &http.Response{
+		StatusCode: code,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// setAPNSTestClient replaces the APNs client until the test ends.
+func setAPNSTestClient(t *testing.T, rt apnsRoundTripper) {
+	t.Helper()
+
+	client, pushes := ApnsClient, MaxConcurrentIOSPushes
+	ApnsClient = &apns2.Client{
+		Host:       apns2.HostDevelopment,
+		HTTPClient: &http.Client{Transport: rt},
+	}
+	MaxConcurrentIOSPushes = make(chan struct{}, 10)
+
+	t.Cleanup(func() {
+		ApnsClient, MaxConcurrentIOSPushes = client, pushes
+	})
+}
+
+func TestFallbackIndexes(t *testing.T) {
+	tokens := []string{"a", "b", "a", "c"}
+	assert.Equal(t, []int{0, 3, 2}, fallbackIndexes(tokens, []string{"a", "c", "a", "d"}))
+	assert.Empty(t, fallbackIndexes(tokens, nil))
+}
+
+func TestIOSFallbackToAndroid(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Ios.Enabled = true
+	cfg.Log.HideToken = false
+
+	setAPNSTestClient(t, func(token string) (int, string) {
+		if token == "bad" {
+			return http.StatusBadRequest, apns2.ReasonBadDeviceToken
+		}
+		return http.StatusOK, ""
+	})
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Message:        "Welcome",
+		Platform:       core.PlatFormIos,
+		Tokens:         []string{"good", "bad"},
+		Fallback:       true,
+		FallbackTokens: []string{"fcm_good", "fcm_bad"},
+	}
+
+	resp, err := PushToIOS(req, cfg)
+	assert.NoError(t, err)
+	// only the bad token is sent to its fallback token
+	assert.Equal(t, [][]string{{"fcm_bad"}}, client.batches)
+	assert.Equal(t, []FallbackResult{{Index: 1, Channel: "android"}}, resp.Fallbacks)
+	assert.Equal(t, 2, len(resp.Logs))
+	assert.Equal(t, "ios", resp.Logs[0].Platform)
+	assert.Equal(t, core.FailedPush, resp.Logs[0].Type)
+	assert.Equal(t, "android", resp.Logs[1].Platform)
+	assert.Equal(t, core.SucceededPush, resp.Logs[1].Type)
+	assert.Equal(t, 1, *resp.Logs[1].Index)
+
+	// the APNs topic of the request isn't sent as a FCM topic
+	client.batches = nil
+	req.Topic = "com.example.app"
+	resp, err = PushToIOS(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"fcm_bad"}}, client.batches)
+	assert.Equal(t, []FallbackResult{{Index: 1, Channel: "android"}}, resp.Fallbacks)
+
+	// the fallback is opt-in
+	client.batches = nil
+	req = &PushNotification{
+		Message:        "Welcome",
+		Platform:       core.PlatFormIos,
+		Tokens:         []string{"bad"},
+		FallbackTokens: []string{"fcm_bad"},
+	}
+	resp, err = PushToIOS(req, cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, client.batches)
+	assert.Empty(t, resp.Fallbacks)
+}
+
+func TestAndroidFallbackToIOS(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Ios.Enabled = true
+
+	setAPNSTestClient(t, func(token string) (int, string) {
+		if token == "apns_bad" {
+			return http.StatusBadRequest, apns2.ReasonBadDeviceToken
+		}
+		return http.StatusOK, ""
+	})
+
+	_, unregistered := newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")).
+		Send(context.Background(), &messaging.Message{Token: "XXXXXXXXX"})
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{onSend: rejectTokens(unregistered, "gone_a", "gone_b")})
+
+	req := &PushNotification{
+		Message:        "Welcome",
+		Platform:       core.PlatFormAndroid,
+		Tokens:         []string{"gone_a", "alive", "gone_b"},
+		Fallback:       true,
+		FallbackTokens: []string{"apns_good", "apns_alive", "apns_bad"},
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, []FallbackResult{{Index: 0, Channel: "ios"}, {Index: 2}}, resp.Fallbacks)
+
+	// the fallback isn't sent when the other platform is disabled
+	cfg.Ios.Enabled = false
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Fallbacks)
+}
+
+func TestCheckMessageFallback(t *testing.T) {
+	req := &PushNotification{
+		Message:        "Welcome",
+		Platform:       core.PlatFormIos,
+		Tokens:         []string{"a", "b"},
+		Fallback:       true,
+		FallbackTokens: []string{"c", ""},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	req.FallbackTokens = []string{"c"}
+	assert.EqualError(t, CheckMessage(req), "the message must specify one fallback token per token, got 1 fallback tokens for 2 tokens")
+
+	req.Platform = core.PlatFormHuawei
+	assert.EqualError(t, CheckMessage(req), "the fallback is only supported by iOS and Android")
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/logx"
 )
 
@@ -23,17 +26,36 @@ func extractHeaders(headers []string) map[string]string {
 	return result
 }
 
+// feedbackRetryInterval is the wait before resending a failed feedback, it's
+// doubled on each retry.
+var feedbackRetryInterval = time.Second
+
 // DispatchFeedback sends a feedback to the configured gateway.
 func DispatchFeedback(ctx context.Context, log logx.LogPushEntry, url string, timeout int64, header []string) error {
+	return postFeedback(ctx, log, url, timeout, header)
+}
+
+// DispatchFeedbackResult sends the aggregated result of a push to the configured gateway.
+func DispatchFeedbackResult(ctx context.Context, resp *ResponsePush, url string, timeout int64, header []string) error {
+	return postFeedback(ctx, resp, url, timeout, header)
+}
+
+func postFeedback(ctx context.Context, v interface{}, url string, timeout int64, header []string) error {
 	if url == "" {
 		return errors.New("url can't be empty")
 	}
 
-	payload, err := json.Marshal(log)
+	payload, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
 	if err != nil {
 		return err
@@ -46,7 +68,6 @@ func DispatchFeedback(ctx context.Context, log logx.LogPushEntry, url string, ti
 
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 
-	feedbackClient.Timeout = time.Duration(timeout) * time.Second
 	resp, err := feedbackClient.Do(req)
 
 	if resp != nil {
@@ -57,5 +78,49 @@ func DispatchFeedback(ctx context.Context, log logx.LogPushEntry, url string, ti
 		return err
 	}
 
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("feedback hook returned status %d", resp.StatusCode)
+	}
+
 	return nil
 }
+
+// dispatchFeedback posts the push result to cfg.Core.FeedbackURL in the
+// background, either as a whole or as one request per failed log. Failures
+// are retried up to cfg.Core.FeedbackMaxRetry times, then logged.
+func dispatchFeedback(ctx context.Context, resp *ResponsePush, cfg *config.ConfYaml) {
+	if cfg.Core.FeedbackURL == "" || resp == nil {
+		return
+	}
+
+	// the push is done, the feedback must not be canceled with it.
+	ctx = context.WithoutCancel(ctx)
+
+	send := func(v interface{}) {
+		var err error
+		for attempt := 0; attempt <= cfg.Core.FeedbackMaxRetry; attempt++ {
+			if attempt > 0 {
+				time.Sleep(feedbackRetryInterval << (attempt - 1))
+			}
+
+			if err = postFeedback(ctx, v, cfg.Core.FeedbackURL, cfg.Core.FeedbackTimeout, cfg.Core.FeedbackHeader); err == nil {
+				return
+			}
+		}
+		logx.LogError.Error("feedback error: ", err)
+	}
+
+	if cfg.Core.FeedbackResult {
+		go send(resp)
+		return
+	}
+
+	for _, l := range resp.Logs {
+		// only failures are reported to the feedback hook
+		if l.Type != core.FailedPush {
+			continue
+		}
+
+		go send(l)
+	}
+}
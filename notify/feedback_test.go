@@ -5,9 +5,12 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/logx"
 
 	"github.com/stretchr/testify/assert"
@@ -100,3 +103,95 @@ func TestSuccessfulFeedbackCall(t *testing.T) {
 	)
 	assert.Nil(t, err)
 }
+
+func TestFeedbackStatusError(t *testing.T) {
+	httpMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer httpMock.Close()
+
+	err := DispatchFeedback(context.Background(), logx.LogPushEntry{}, httpMock.URL, 10, nil)
+	assert.EqualError(t, err, "feedback hook returned status 500")
+}
+
+func TestDispatchFeedbackResult(t *testing.T) {
+	origin := feedbackRetryInterval
+	feedbackRetryInterval = time.Millisecond
+	defer func() { feedbackRetryInterval = origin }()
+
+	var calls int32
+	bodies := make(chan ResponsePush, 1)
+	httpMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var resp ResponsePush
+		_ = json.NewDecoder(r.Body).Decode(&resp)
+		bodies <- resp
+	}))
+	defer httpMock.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Core.FeedbackURL = httpMock.URL
+	cfg.Core.FeedbackMaxRetry = 1
+	cfg.Core.FeedbackResult = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatchFeedback(ctx, &ResponsePush{
+		Logs: []logx.LogPushEntry{
+			{Type: core.SucceededPush, Token: "aaaaaaaaa"},
+			{Type: core.FailedPush, Token: "bbbbbbbbb"},
+		},
+		InvalidTokens: []string{"bbbbbbbbb"},
+		Success:       1,
+		Failure:       1,
+		Total:         2,
+	}, cfg)
+	// the feedback outlives the push context
+	cancel()
+
+	select {
+	case resp := <-bodies:
+		assert.Equal(t, 2, resp.Total)
+		assert.Equal(t, 1, resp.Failure)
+		assert.Equal(t, []string{"bbbbbbbbb"}, resp.InvalidTokens)
+		assert.Equal(t, 2, len(resp.Logs))
+	case <-time.After(5 * time.Second):
+		t.Fatal("feedback is not received")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestDispatchFeedbackFailedLogs(t *testing.T) {
+	tokens := make(chan string, 2)
+	httpMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var l logx.LogPushEntry
+		_ = json.NewDecoder(r.Body).Decode(&l)
+		tokens <- l.Token
+	}))
+	defer httpMock.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Core.FeedbackURL = httpMock.URL
+
+	dispatchFeedback(context.Background(), &ResponsePush{
+		Logs: []logx.LogPushEntry{
+			{Type: core.SucceededPush, Token: "aaaaaaaaa"},
+			{Type: core.FailedPush, Token: "bbbbbbbbb"},
+		},
+	}, cfg)
+
+	select {
+	case token := <-tokens:
+		assert.Equal(t, "bbbbbbbbb", token)
+	case <-time.After(5 * time.Second):
+		t.Fatal("feedback is not received")
+	}
+
+	// a missing result is ignored
+	dispatchFeedback(context.Background(), nil, cfg)
+	assert.Empty(t, tokens)
+}
@@ -16,6 +16,24 @@ var (
 	HMSClient *core.HMSClient
 	// MaxConcurrentIOSPushes pool to limit the number of concurrent iOS pushes
 	MaxConcurrentIOSPushes chan struct{}
+	// DeadLetterStore keeps the notifications failed on unrecoverable errors, nil if disabled
+	DeadLetterStore DeadLetter
+	// IdempotencyStore keeps the results of the notifications sent with an idempotency key, nil if disabled
+	IdempotencyStore IdempotencyCache
+	// DeliveryRecorder records the successful Android sends, nil if disabled
+	DeliveryRecorder *DeliveryWriter
+	// PushLimit caps the concurrent sends of all the platforms, nil if unlimited
+	PushLimit *PushLimiter
+	// PushScheduler sends the notifications with a future send_at, nil if not running
+	PushScheduler *Scheduler
+	// AndroidTransformer modifies the Android notifications before the sends
+	AndroidTransformer Transformer = NoopTransformer{}
+	// TokenBatches keeps the token batches uploaded ahead of the sends, nil if disabled
+	TokenBatches TokenBatchStore
+	// RequestResults keeps the results of the requests by request ID, nil if disabled
+	RequestResults ResultStore
+	// TokenFeedbackRecorder posts the invalid Android tokens to the token removal webhook, nil if disabled
+	TokenFeedbackRecorder *TokenFeedbackWriter
 
 	transport = &http.Transport{
 		Dial: (&net.Dialer{
@@ -0,0 +1,204 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const idempotencyKeyPrefix = "gorush-idempotency-"
+
+// idempotencyReserved is the redis value of the keys reserved by a send in
+// flight.
+const idempotencyReserved = "reserved"
+
+// ErrIdempotencyKeyInUse is the error of the notifications whose idempotency
+// key is reserved by a send in flight.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+// IdempotencyCache keeps the results of the recently sent notifications by
+// their idempotency key.
+type IdempotencyCache interface {
+	// Get returns the result of the notification sent with the key.
+	Get(ctx context.Context, key string) (*ResponsePush, bool)
+	// Reserve reserves the key for ttl before the send, it returns false if
+	// the key is reserved or sent already.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release releases the key reserved by a failed send.
+	Release(ctx context.Context, key string) error
+	// Set stores the result of the notification sent with the key for ttl.
+	Set(ctx context.Context, key string, resp *ResponsePush, ttl time.Duration) error
+}
+
+// idempotencyEntry is the result sent with a key, resp is nil while the key
+// is reserved.
+type idempotencyEntry struct {
+	resp    *ResponsePush
+	expires time.Time
+}
+
+// MemoryIdempotencyCache keeps the results in memory.
+type MemoryIdempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]idempotencyEntry
+	now     func() time.Time
+}
+
+// NewMemoryIdempotencyCache returns an empty in memory cache.
+func NewMemoryIdempotencyCache() *MemoryIdempotencyCache {
+	return &MemoryIdempotencyCache{
+		entries: make(map[string]idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Get implements IdempotencyCache.
+func (c *MemoryIdempotencyCache) Get(_ context.Context, key string) (*ResponsePush, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.resp == nil || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+
+	return entry.resp, true
+}
+
+// Reserve implements IdempotencyCache.
+func (c *MemoryIdempotencyCache) Reserve(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.dropExpired(now)
+
+	if _, ok := c.entries[key]; ok {
+		return false, nil
+	}
+
+	c.entries[key] = idempotencyEntry{expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Release implements IdempotencyCache.
+func (c *MemoryIdempotencyCache) Release(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.resp == nil {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// Set implements IdempotencyCache.
+func (c *MemoryIdempotencyCache) Set(_ context.Context, key string, resp *ResponsePush, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.dropExpired(now)
+
+	c.entries[key] = idempotencyEntry{resp: resp, expires: now.Add(ttl)}
+	return nil
+}
+
+// dropExpired drops the expired entries, c.mu must be held.
+func (c *MemoryIdempotencyCache) dropExpired(now time.Time) {
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// RedisIdempotencyCache keeps the results in redis, it's shared by all the
+// gorush instances using the same redis.
+type RedisIdempotencyCache struct {
+	client redis.Cmdable
+}
+
+// NewRedisIdempotencyCache returns a cache using the stat.redis settings.
+func NewRedisIdempotencyCache(cfg *config.ConfYaml) *RedisIdempotencyCache {
+	if cfg.Stat.Redis.Cluster {
+		return &RedisIdempotencyCache{client: redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    strings.Split(cfg.Stat.Redis.Addr, ","),
+			Password: cfg.Stat.Redis.Password,
+		})}
+	}
+
+	return &RedisIdempotencyCache{client: redis.NewClient(&redis.Options{
+		Addr:     cfg.Stat.Redis.Addr,
+		Password: cfg.Stat.Redis.Password,
+		DB:       cfg.Stat.Redis.DB,
+	})}
+}
+
+// Get implements IdempotencyCache.
+func (c *RedisIdempotencyCache) Get(ctx context.Context, key string) (*ResponsePush, bool) {
+	b, err := c.client.Get(ctx, idempotencyKeyPrefix+key).Bytes()
+	if err != nil || string(b) == idempotencyReserved {
+		return nil, false
+	}
+
+	resp := &ResponsePush{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// Set implements IdempotencyCache.
+func (c *RedisIdempotencyCache) Set(ctx context.Context, key string, resp *ResponsePush, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Set(ctx, idempotencyKeyPrefix+key, b, ttl).Err()
+}
+
+// Reserve implements IdempotencyCache.
+func (c *RedisIdempotencyCache) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, idempotencyKeyPrefix+key, idempotencyReserved, ttl).Result()
+}
+
+// releaseReservedScript deletes the key only while it's still reserved.
+var releaseReservedScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Release implements IdempotencyCache.
+func (c *RedisIdempotencyCache) Release(ctx context.Context, key string) error {
+	return releaseReservedScript.Run(ctx, c.client, []string{idempotencyKeyPrefix + key}, idempotencyReserved).Err()
+}
+
+// InitIdempotency initializes IdempotencyStore when idempotency is enabled.
+func InitIdempotency(cfg *config.ConfYaml) error {
+	IdempotencyStore = nil
+	if !cfg.Idempotency.Enabled {
+		return nil
+	}
+
+	switch cfg.Idempotency.Engine {
+	case "memory":
+		IdempotencyStore = NewMemoryIdempotencyCache()
+	case "redis":
+		IdempotencyStore = NewRedisIdempotencyCache(cfg)
+	default:
+		return errors.New("idempotency engine must be memory or redis")
+	}
+
+	return nil
+}
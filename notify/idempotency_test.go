@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryIdempotencyCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	cache := NewMemoryIdempotencyCache()
+	cache.now = func() time.Time { return now }
+
+	_, ok := cache.Get(ctx, "key")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Set(ctx, "key", &ResponsePush{Success: 1}, time.Minute))
+
+	now = now.Add(59 * time.Second)
+	resp, ok := cache.Get(ctx, "key")
+	assert.True(t, ok)
+	assert.Equal(t, 1, resp.Success)
+
+	// expired after the ttl
+	now = now.Add(time.Second)
+	_, ok = cache.Get(ctx, "key")
+	assert.False(t, ok)
+
+	// the expired entries are dropped on set
+	assert.NoError(t, cache.Set(ctx, "other", &ResponsePush{}, time.Minute))
+	assert.Equal(t, 1, len(cache.entries))
+}
+
+func TestMemoryIdempotencyCacheReserve(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	cache := NewMemoryIdempotencyCache()
+	cache.now = func() time.Time { return now }
+
+	ok, err := cache.Reserve(ctx, "key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// the reserved key isn't sent already but can't be reserved again
+	_, ok = cache.Get(ctx, "key")
+	assert.False(t, ok)
+	ok, err = cache.Reserve(ctx, "key", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// the key released by a failed send is reserved again
+	assert.NoError(t, cache.Release(ctx, "key"))
+	ok, _ = cache.Reserve(ctx, "key", time.Minute)
+	assert.True(t, ok)
+
+	// the results sent aren't released
+	assert.NoError(t, cache.Set(ctx, "key", &ResponsePush{Success: 1}, time.Minute))
+	assert.NoError(t, cache.Release(ctx, "key"))
+	resp, ok := cache.Get(ctx, "key")
+	assert.True(t, ok)
+	assert.Equal(t, 1, resp.Success)
+	ok, _ = cache.Reserve(ctx, "key", time.Minute)
+	assert.False(t, ok)
+
+	// the reservations expire after the ttl
+	ok, _ = cache.Reserve(ctx, "other", time.Minute)
+	assert.True(t, ok)
+	now = now.Add(time.Minute)
+	ok, _ = cache.Reserve(ctx, "other", time.Minute)
+	assert.True(t, ok)
+}
+
+func TestSendNotificationIdempotencyKey(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Idempotency.Enabled = true
+	assert.NoError(t, InitIdempotency(cfg))
+	defer func() { IdempotencyStore = nil }()
+
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	newReq := func(key string) *PushNotification {
+		return &PushNotification{
+			Message:        "Test",
+			Platform:       core.PlatFormAndroid,
+			Tokens:         []string{"aaaaaaaaa", "bbbbbbbbb"},
+			IdempotencyKey: key,
+		}
+	}
+
+	resp, err := SendNotification(context.Background(), newReq("order-1"), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 1, len(client.batches))
+
+	// the retried submission returns the cached result without sending
+	cached, err := SendNotification(context.Background(), newReq("order-1"), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, resp, cached)
+	assert.Equal(t, 1, len(client.batches))
+
+	// other keys and notifications without key are sent
+	_, err = SendNotification(context.Background(), newReq("order-2"), cfg)
+	assert.NoError(t, err)
+	_, err = SendNotification(context.Background(), newReq(""), cfg)
+	assert.NoError(t, err)
+	_, err = SendNotification(context.Background(), newReq(""), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(client.batches))
+
+	// the key reserved by a send in flight isn't sent twice
+	ok, err := IdempotencyStore.Reserve(context.Background(), "order-3", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = SendNotification(context.Background(), newReq("order-3"), cfg)
+	assert.Equal(t, ErrIdempotencyKeyInUse, err)
+	assert.Equal(t, 4, len(client.batches))
+
+	// the key of a failed send is released for the retries
+	invalid := newReq("order-4")
+	invalid.Tokens = nil
+	_, err = SendNotification(context.Background(), invalid, cfg)
+	assert.Error(t, err)
+	_, err = SendNotification(context.Background(), newReq("order-4"), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(client.batches))
+
+	// the key is sent again once expired
+	IdempotencyStore.(*MemoryIdempotencyCache).now = func() time.Time {
+		return time.Now().Add(time.Duration(cfg.Idempotency.TTL) * time.Second)
+	}
+	_, err = SendNotification(context.Background(), newReq("order-1"), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(client.batches))
+}
+
+func TestInitIdempotency(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	defer func() { IdempotencyStore = nil }()
+
+	assert.NoError(t, InitIdempotency(cfg))
+	assert.Nil(t, IdempotencyStore)
+
+	cfg.Idempotency.Enabled = true
+	cfg.Idempotency.Engine = "redis"
+	assert.NoError(t, InitIdempotency(cfg))
+	assert.IsType(t, &RedisIdempotencyCache{}, IdempotencyStore)
+
+	cfg.Idempotency.Engine = "bolt"
+	assert.EqualError(t, InitIdempotency(cfg), "idempotency engine must be memory or redis")
+}
@@ -1,20 +1,80 @@
 package notify
 
 import (
+	"context"
+	"sync/atomic"
+
 	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/logx"
 )
 
+// successLogCount counts the succeeded pushes for the log sampling.
+var successLogCount atomic.Uint64
+
 func logPush(cfg *config.ConfYaml, status, token string, req *PushNotification, err error) logx.LogPushEntry {
-	return logx.LogPush(&logx.InputLog{
-		ID:          req.ID,
-		Status:      status,
-		Token:       token,
-		Message:     req.Message,
-		Platform:    req.Platform,
-		Error:       err,
-		HideToken:   cfg.Log.HideToken,
-		HideMessage: cfg.Log.HideMessages,
-		Format:      cfg.Log.Format,
+	return logPushInput(cfg, req, &logx.InputLog{
+		Status: status,
+		Token:  token,
+		Error:  err,
 	})
 }
+
+// logPushInput fills the input with the request and log settings and records it.
+func logPushInput(cfg *config.ConfYaml, req *PushNotification, input *logx.InputLog) logx.LogPushEntry {
+	input.ID = req.ID
+	input.RequestID = req.RequestID
+	input.Message = req.Message
+	input.Platform = req.Platform
+	input.HideToken = cfg.Log.HideToken
+	input.HideMessage = cfg.Log.HideMessages
+	input.Format = cfg.Log.Format
+
+	if input.Status == core.SucceededPush && !sampleSuccessLog(cfg.Log.SuccessSampleRate) {
+		return logx.GetLogPushEntry(input)
+	}
+
+	return logx.LogPush(input)
+}
+
+// sampleSuccessLog reports whether the succeeded push is logged, one in
+// 1/rate of them is. The pushes are counted instead of drawn at random so
+// the logged ones are evenly spread.
+func sampleSuccessLog(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	n := successLogCount.Add(1)
+	return uint64(float64(n)*rate) != uint64(float64(n-1)*rate)
+}
+
+// requestContext assigns a request ID to the request without one and returns
+// a copy of ctx carrying it for the logs.
+func requestContext(ctx context.Context, req *PushNotification) context.Context {
+	if req.RequestID == "" {
+		req.RequestID = logx.NewRequestID()
+	}
+	return logx.WithRequestID(ctx, req.RequestID)
+}
+
+// logDebug records the verbose log when the request asks for it.
+func logDebug(req *PushNotification, message string, fields map[string]interface{}) {
+	if !req.Debug {
+		return
+	}
+
+	logx.LogVerbose(req.RequestID, message, fields)
+}
+
+// hashTokens returns the hashes of the tokens for the verbose logs.
+func hashTokens(tokens []string) []string {
+	hashes := make([]string, len(tokens))
+	for k, token := range tokens {
+		hashes[k] = logx.HashToken(token)
+	}
+	return hashes
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/logx"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessLogSampling(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.Format = "json"
+	cfg.Log.SuccessSampleRate = 0
+
+	var access, failure bytes.Buffer
+	newLog := func(buf *bytes.Buffer) *logrus.Logger {
+		log := logrus.New()
+		log.Out = buf
+		logx.SetLogFormat(log, "json")
+		return log
+	}
+
+	originAccess, originError := logx.LogAccess, logx.LogError
+	logx.LogAccess, logx.LogError = newLog(&access), newLog(&failure)
+	defer func() { logx.LogAccess, logx.LogError = originAccess, originError }()
+
+	req := &PushNotification{
+		Platform: core.PlatFormAndroid,
+		Message:  "Test",
+	}
+
+	// the succeeded pushes aren't logged but are still returned
+	entry := logPush(cfg, core.SucceededPush, "token", req, nil)
+	assert.Equal(t, core.SucceededPush, entry.Type)
+	assert.Empty(t, access.String())
+
+	// the failed pushes are always logged
+	entry = logPush(cfg, core.FailedPush, "token", req, errors.New("failed"))
+	assert.Equal(t, "failed", entry.Error)
+	assert.Contains(t, failure.String(), core.FailedPush)
+
+	cfg.Log.SuccessSampleRate = 1
+	logPush(cfg, core.SucceededPush, "token", req, nil)
+	assert.Contains(t, access.String(), core.SucceededPush)
+}
+
+func TestSampleSuccessLog(t *testing.T) {
+	successLogCount.Store(0)
+	t.Cleanup(func() { successLogCount.Store(0) })
+
+	logged := 0
+	for i := 0; i < 1000; i++ {
+		if sampleSuccessLog(0.01) {
+			logged++
+		}
+	}
+	assert.Equal(t, 10, logged)
+
+	assert.True(t, sampleSuccessLog(1))
+	assert.False(t, sampleSuccessLog(0))
+}
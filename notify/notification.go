@@ -3,11 +3,15 @@ package notify
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appleboy/gorush/config"
 	"github.com/appleboy/gorush/core"
@@ -20,6 +24,33 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// ErrTooManyTokens is the error of the notifications with more tokens than
+// core.max_tokens_per_request.
+var ErrTooManyTokens = errors.New("too many tokens")
+
+// ValidationError is the error of a notification rejected before the send,
+// Status is the HTTP status suggested to the API handlers.
+type ValidationError struct {
+	Status int
+	Err    error
+}
+
+// Error implements error.
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the rejection error.
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// ValidationStatus returns the HTTP status suggested by the error of a
+// rejected notification, 400 when it suggests none.
+func ValidationStatus(err error) int {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && validationErr.Status != 0 {
+		return validationErr.Status
+	}
+	return http.StatusBadRequest
+}
+
 // D provide string array
 type D map[string]interface{}
 
@@ -53,6 +84,20 @@ type Alert struct {
 	SummaryArgCount int      `json:"summary-arg-count,omitempty"`
 }
 
+// LiveActivity is the APNs payload of a Live Activity update.
+// ref: https://developer.apple.com/documentation/activitykit/starting-and-updating-live-activities-with-activitykit-push-notifications
+type LiveActivity struct {
+	// Event is start, update or end.
+	Event string `json:"event"`
+	// Timestamp in unix seconds, default is the send time.
+	Timestamp      int64  `json:"timestamp,omitempty"`
+	ContentState   D      `json:"content-state,omitempty"`
+	StaleDate      int64  `json:"stale-date,omitempty"`
+	DismissalDate  int64  `json:"dismissal-date,omitempty"`
+	AttributesType string `json:"attributes-type,omitempty"`
+	Attributes     D      `json:"attributes,omitempty"`
+}
+
 // RequestPush support multiple notification request.
 type RequestPush struct {
 	Notifications []PushNotification `json:"notifications" binding:"required"`
@@ -61,6 +106,35 @@ type RequestPush struct {
 // ResponsePush response of notification request.
 type ResponsePush struct {
 	Logs []logx.LogPushEntry `json:"logs"`
+	// InvalidTokens lists the tokens rejected permanently by the provider,
+	// they should be removed from the device store.
+	InvalidTokens []string `json:"invalid_tokens,omitempty"`
+	// Success, Failure and Total count the tokens of the request, only
+	// filled in for Android.
+	Success int `json:"success_count"`
+	Failure int `json:"failure_count"`
+	Total   int `json:"total_count"`
+	// Fallbacks tells the channel which delivered the tokens sent again to
+	// their fallback tokens.
+	Fallbacks []FallbackResult `json:"fallbacks,omitempty"`
+	// RequestID is the request ID of the notification.
+	RequestID string `json:"request_id,omitempty"`
+	// Error is the first failed token of a FailFast notification.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchHandler receives the final result of a batch of tokens, err is the
+// error of the whole batch. The handler must not modify resp.
+type BatchHandler func(resp *ResponsePush, err error)
+
+type batchHandlerKey struct{}
+
+// WithBatchHandler returns a copy of ctx which reports the result of every
+// batch of 500 Android tokens to handler, in the token order once the
+// notification is sent. The results include the retries, the duplicates and
+// the fallbacks of the tokens of the batch, so they can be summed.
+func WithBatchHandler(ctx context.Context, handler BatchHandler) context.Context {
+	return context.WithValue(ctx, batchHandlerKey{}, handler)
 }
 
 // PushNotification is single notification request
@@ -78,6 +152,30 @@ type PushNotification struct {
 	Sound            interface{} `json:"sound,omitempty"`
 	Data             D           `json:"data,omitempty"`
 	Retry            int         `json:"retry,omitempty"`
+	IdempotencyKey   string      `json:"idempotency_key,omitempty"`
+	// FallbackTokens are the tokens of the other platform, aligned with
+	// Tokens. With Fallback the notification of an iOS token rejected as bad
+	// or an Android token unregistered is sent again to its fallback token.
+	Fallback       bool     `json:"fallback,omitempty"`
+	FallbackTokens []string `json:"fallback_tokens,omitempty"`
+	// Debug records the payload and the provider responses of the request
+	// whatever the log level, with the tokens hashed.
+	Debug bool `json:"debug,omitempty"`
+	// IncludeRawError attaches the error string, code and HTTP status
+	// returned by FCM to the failed logs of the response.
+	IncludeRawError bool `json:"include_raw_error,omitempty"`
+	// RequestID correlates the logs of the request, it's generated when
+	// missing.
+	RequestID string `json:"request_id,omitempty"`
+	// TenantID attributes the sends to a tenant in the stats and metrics.
+	TenantID string `json:"tenant_id,omitempty"`
+	// SendAt delays the notification until the RFC3339 time, a past time
+	// is sent right away.
+	SendAt string `json:"send_at,omitempty"`
+	// Critical keeps the high priority of the Android message while
+	// android.downgrade_priority sends the others as normal under quota
+	// pressure.
+	Critical bool `json:"critical,omitempty"`
 
 	// Android
 	APIKey                string           `json:"api_key,omitempty"`
@@ -87,7 +185,67 @@ type PushNotification struct {
 	RestrictedPackageName string           `json:"restricted_package_name,omitempty"`
 	DryRun                bool             `json:"dry_run,omitempty"`
 	Condition             string           `json:"condition,omitempty"`
+	ProjectID             string           `json:"project_id,omitempty"`
+	DataOnly              bool             `json:"data_only,omitempty"`
+	DirectBootOK          bool             `json:"direct_boot_ok,omitempty"`
+	AnalyticsLabel        string           `json:"analytics_label,omitempty"`
 	Notification          *FCMNotification `json:"notification,omitempty"`
+	WebPush               *WebPushConfig   `json:"webpush,omitempty"`
+	FCMApns               *FCMApnsConfig   `json:"fcm_apns,omitempty"`
+	FCMOptions            *FCMOptions      `json:"fcm_options,omitempty"`
+	// AndroidImage overrides Image in the Android notification, e.g. with an
+	// image optimized for Android.
+	AndroidImage string `json:"android_image,omitempty"`
+	// SuppressNotification sends the title, body and image of the
+	// notification as data keys, for the app to render it.
+	SuppressNotification bool `json:"suppress_notification,omitempty"`
+	// RichContent sends the image of the notification as data keys too, for
+	// the app to build a rich notification. The notification keeps the image
+	// for the default rendering.
+	RichContent bool `json:"rich_content,omitempty"`
+	// ExpiresAt is the RFC3339 expiration of the message kept on FCM
+	// storage, the time left is sent as the TTL. It replaces TimeToLive.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// DisplayUntil is the RFC3339 time the app stops showing the
+	// notification, sent as the display_until data key in unix seconds. The
+	// time left is the TTL unless the message sets its own.
+	DisplayUntil string `json:"display_until,omitempty"`
+	// FailFast fails the whole notification when any of its tokens failed,
+	// instead of the partial success. All the tokens are still sent.
+	FailFast bool `json:"fail_fast,omitempty"`
+	// DeliveryReceiptRequested records the message ID returned by FCM for
+	// the tokens in the delivery store, the only sends recorded with
+	// core.delivery_requested_only.
+	DeliveryReceiptRequested bool `json:"delivery_receipt_requested,omitempty"`
+	// AutoCollapse derives the CollapseKey from the values of the data keys
+	// of android.auto_collapse_fields when it's empty, so the repeated
+	// status updates replace each other on the device.
+	AutoCollapse bool `json:"auto_collapse,omitempty"`
+	// BatchID sends the notification to the tokens of the batch uploaded
+	// to api.batch_uri instead of Tokens, they are loaded at the send.
+	BatchID string `json:"batch_id,omitempty"`
+
+	// AnalyticsLabels are the analytics labels of the tokens, aligned with
+	// Tokens. An empty label falls back to AnalyticsLabel. The messages with
+	// labels are sent one by one instead of multicast, a request per token is
+	// much slower for large token lists.
+	AnalyticsLabels []string `json:"analytics_labels,omitempty"`
+
+	// UserIDs are the user IDs of the tokens, aligned with Tokens. The logs
+	// of the response echo the user ID of their token.
+	UserIDs []string `json:"user_ids,omitempty"`
+
+	// Titles and Bodies personalize the notification of the tokens, aligned
+	// with Tokens. An empty value falls back to Title or Message. They are
+	// sent one by one like AnalyticsLabels.
+	Titles []string `json:"titles,omitempty"`
+	Bodies []string `json:"bodies,omitempty"`
+
+	// Template renders the title and body of the notification of every token
+	// with its Vars, aligned with Tokens. The tokens are sent one by one like
+	// Titles and Bodies.
+	Template *NotificationTemplate `json:"template,omitempty"`
+	Vars     []map[string]string   `json:"vars,omitempty"`
 
 	// Huawei
 	AppID              string                     `json:"app_id,omitempty"`
@@ -116,6 +274,8 @@ type PushNotification struct {
 	SoundVolume float32  `json:"volume,omitempty"`
 	Apns        D        `json:"apns,omitempty"`
 
+	LiveActivity *LiveActivity `json:"live_activity,omitempty"`
+
 	// ref: https://github.com/sideshow/apns2/blob/54928d6193dfe300b6b88dad72b7e2ae138d4f0a/payload/builder.go#L7-L24
 	InterruptionLevel string `json:"interruption_level,omitempty"`
 }
@@ -133,7 +293,7 @@ func (p *PushNotification) Bytes() []byte {
 // ref: https://firebase.google.com/docs/cloud-messaging/send-message#topic-http-post-request
 func (p *PushNotification) IsTopic() bool {
 	if p.Platform == core.PlatFormAndroid {
-		return p.To != "" && strings.HasPrefix(p.To, "/topics/") || p.Condition != ""
+		return p.Topic != "" || p.To != "" && strings.HasPrefix(p.To, "/topics/") || p.Condition != ""
 	}
 
 	if p.Platform == core.PlatFormHuawei {
@@ -143,6 +303,12 @@ func (p *PushNotification) IsTopic() bool {
 	return false
 }
 
+// IsDeviceGroup reports whether the Android notification is sent to the
+// device group notification key of To.
+func (p *PushNotification) IsDeviceGroup() bool {
+	return p.Platform == core.PlatFormAndroid && androidDeviceGroup(p) != ""
+}
+
 // FCMNotification specifies the predefined, user-visible key-value pairs of the
 // notification payload.
 // Copied as is from go-fcm (old FCM API) to keep backward compatibility in external contracts
@@ -162,6 +328,116 @@ type FCMNotification struct {
 	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
 	TitleLocKey  string   `json:"title_loc_key,omitempty"`
 	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+
+	// NotificationPriority is one of min, low, default, high or max.
+	NotificationPriority string `json:"notification_priority,omitempty"`
+	// ChannelGroupID and Importance are hints for the app creating the
+	// channel on Android O+, FCM doesn't create channels. Importance is one
+	// of min, low, default or high and sets NotificationPriority when empty.
+	ChannelGroupID string `json:"android_channel_group_id,omitempty"`
+	Importance     string `json:"importance,omitempty"`
+	// GroupKey and GroupSummary are hints for the app bundling the
+	// notifications of the group, Android groups them on the device. The
+	// summary replaces the previous one with the group key as the Tag.
+	GroupKey     string `json:"group_key,omitempty"`
+	GroupSummary bool   `json:"group_summary,omitempty"`
+	// DeepLink is the URI opened on tap, sent as the ClickAction for the
+	// older apps and as the deep_link data key for the newer ones.
+	DeepLink string `json:"deep_link,omitempty"`
+	// Visibility is one of private, public or secret.
+	Visibility string `json:"visibility,omitempty"`
+	Ticker     string `json:"ticker,omitempty"`
+	Sticky     bool   `json:"sticky,omitempty"`
+	// LocalOnly notifications aren't bridged to other devices like wearables.
+	LocalOnly bool `json:"local_only,omitempty"`
+
+	VibrateTimings []int64           `json:"vibrate_timing_millis,omitempty"`
+	LightSettings  *FCMLightSettings `json:"light_settings,omitempty"`
+
+	// DefaultSound and DefaultVibrate use the sound and vibration pattern of
+	// the system, instead of Sound and VibrateTimings.
+	DefaultSound   bool `json:"default_sound,omitempty"`
+	DefaultVibrate bool `json:"default_vibrate,omitempty"`
+
+	// EventTime is the time of the event shown by the notification, a RFC3339
+	// string or unix seconds.
+	EventTime interface{} `json:"event_time,omitempty"`
+}
+
+// FCMOptions are the options of the outer FCM message, they are distinct from
+// the Android FCM options set by AnalyticsLabel.
+type FCMOptions struct {
+	AnalyticsLabel string `json:"analytics_label,omitempty"`
+	// Link to open when the user clicks on the web push notification, must be
+	// https. The link of WebPush is used first.
+	Link string `json:"link,omitempty"`
+}
+
+// WebPushConfig is the web push payload delivered through FCM.
+type WebPushConfig struct {
+	// Headers as defined in the webpush protocol, e.g. TTL or Urgency.
+	Headers      map[string]string    `json:"headers,omitempty"`
+	Data         map[string]string    `json:"data,omitempty"`
+	Notification *WebPushNotification `json:"notification,omitempty"`
+	// Link to open when the user clicks on the notification, must be https.
+	Link string `json:"link,omitempty"`
+}
+
+// WebPushNotification is the notification shown by the browser.
+type WebPushNotification struct {
+	Title              string          `json:"title,omitempty"`
+	Body               string          `json:"body,omitempty"`
+	Icon               string          `json:"icon,omitempty"`
+	Badge              string          `json:"badge,omitempty"`
+	Image              string          `json:"image,omitempty"`
+	Tag                string          `json:"tag,omitempty"`
+	RequireInteraction bool            `json:"require_interaction,omitempty"`
+	Silent             bool            `json:"silent,omitempty"`
+	Actions            []WebPushAction `json:"actions,omitempty"`
+}
+
+// WebPushAction is an action button of the web push notification.
+type WebPushAction struct {
+	Action string `json:"action,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Icon   string `json:"icon,omitempty"`
+}
+
+// FCMApnsConfig is the APNs payload FCM uses when the message is delivered
+// to iOS devices.
+type FCMApnsConfig struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Aps     *FCMAps           `json:"aps,omitempty"`
+	// CustomData is added next to the aps dictionary.
+	CustomData D `json:"custom_data,omitempty"`
+}
+
+// FCMAps is the aps dictionary of the FCM APNs payload. The alert is sent as
+// a dictionary with the alert body when a title, subtitle or loc key is set,
+// and as a string otherwise.
+type FCMAps struct {
+	Alert            string `json:"alert,omitempty"`
+	Badge            *int   `json:"badge,omitempty"`
+	Sound            string `json:"sound,omitempty"`
+	ContentAvailable bool   `json:"content_available,omitempty"`
+	MutableContent   bool   `json:"mutable_content,omitempty"`
+	Category         string `json:"category,omitempty"`
+	ThreadID         string `json:"thread_id,omitempty"`
+
+	Title        string   `json:"title,omitempty"`
+	Subtitle     string   `json:"subtitle,omitempty"`
+	LocKey       string   `json:"loc_key,omitempty"`
+	LocArgs      []string `json:"loc_args,omitempty"`
+	TitleLocKey  string   `json:"title_loc_key,omitempty"`
+	TitleLocArgs []string `json:"title_loc_args,omitempty"`
+}
+
+// FCMLightSettings controls the notification LED.
+type FCMLightSettings struct {
+	// Color in #RRGGBB or #RRGGBBAA format
+	Color            string `json:"color"`
+	LightOnDuration  int64  `json:"light_on_duration_millis"`
+	LightOffDuration int64  `json:"light_off_duration_millis"`
 }
 
 func (f FCMNotification) NotificationCount() (*int, error) {
@@ -174,21 +450,83 @@ func (f FCMNotification) NotificationCount() (*int, error) {
 		return nil, err
 	}
 
+	if v < 0 {
+		return nil, fmt.Errorf("negative badge value %d", v)
+	}
+
 	return &v, nil
 }
 
+// EventTimestamp parses the event time of the notification.
+func (f FCMNotification) EventTimestamp() (*time.Time, error) {
+	var sec int64
+	switch v := f.EventTime.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return &t, nil
+		}
+
+		var err error
+		if sec, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("event time %q is neither RFC3339 nor unix seconds", v)
+		}
+	case float64:
+		sec = int64(v)
+	case int:
+		sec = int64(v)
+	case int64:
+		sec = v
+	default:
+		return nil, fmt.Errorf("unsupported event time value %#v", f.EventTime)
+	}
+
+	t := time.Unix(sec, 0)
+	return &t, nil
+}
+
 // CheckMessage for check request message
 func CheckMessage(req *PushNotification) error {
 	var msg string
 
-	if req.Platform == core.PlatFormAndroid && req.IsTopic() {
-		msg = "android topics not supported yet"
+	if _, err := scheduledTime(req); err != nil {
+		logx.LogAccess.Debug(err.Error())
+		return err
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.IsTopic() && len(req.Tokens) > 0 {
+		msg = "the message can't specify both registration IDs and a topic or condition"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.Condition != "" && androidTopic(req) != "" {
+		msg = "the message can't specify both a topic and a condition"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && androidDeviceGroup(req) != "" && (req.IsTopic() || len(req.Tokens) > 0) {
+		msg = "the message can't specify both a device group notification key and registration IDs, a topic or condition"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.BatchID != "" && req.Platform != core.PlatFormAndroid {
+		msg = "the batch is only supported by Android"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.BatchID != "" && (req.IsTopic() || len(req.Tokens) > 0 || androidDeviceGroup(req) != "") {
+		msg = "the message can't specify both a batch ID and registration IDs, a topic or condition"
 		logx.LogAccess.Debug(msg)
 		return errors.New(msg)
 	}
 
 	// ignore send topic mesaage from FCM
-	if !req.IsTopic() && len(req.Tokens) == 0 && req.To == "" {
+	if !req.IsTopic() && len(req.Tokens) == 0 && req.To == "" && req.BatchID == "" {
 		msg = "the message must specify at least one registration ID"
 		logx.LogAccess.Debug(msg)
 		return errors.New(msg)
@@ -200,22 +538,202 @@ func CheckMessage(req *PushNotification) error {
 		return errors.New(msg)
 	}
 
-	if req.Platform == core.PlatFormAndroid && len(req.Tokens) > 500 {
-		msg = "the message may specify at most 500 registration IDs"
+	if req.Platform == core.PlatFormAndroid && req.Notification != nil {
+		if err := checkAndroidNotification(req.Notification); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.Priority != "" && !androidPriorities[req.Priority] {
+		msg = fmt.Sprintf("the message's priority must be normal or high, got %q", req.Priority)
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.AnalyticsLabel != "" && !analyticsLabelPattern.MatchString(req.AnalyticsLabel) {
+		msg = "the analytics label must match " + analyticsLabelPattern.String()
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.FCMOptions != nil {
+		if req.FCMOptions.AnalyticsLabel != "" && !analyticsLabelPattern.MatchString(req.FCMOptions.AnalyticsLabel) {
+			msg = "the analytics label must match " + analyticsLabelPattern.String()
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+
+		if link := req.FCMOptions.Link; link != "" && !isHTTPSURL(link) {
+			msg = "the fcm_options link must be a valid https URL, got " + link
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+	}
+
+	if req.Platform == core.PlatFormAndroid {
+		if req.Image != "" && !isHTTPSURL(req.Image) {
+			msg = "the image must be a valid https URL, got " + req.Image
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+
+		if req.AndroidImage != "" && !isHTTPSURL(req.AndroidImage) {
+			msg = "the android image must be a valid https URL, got " + req.AndroidImage
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+
+		if req.RichContent {
+			if image := androidImage(req); !isHTTPSURL(image) {
+				msg = "the rich content image must be a valid https URL, got " + strconv.Quote(image)
+				logx.LogAccess.Debug(msg)
+				return errors.New(msg)
+			}
+		}
+	}
+
+	if req.Platform == core.PlatFormAndroid && len(req.AnalyticsLabels) > 0 {
+		for _, label := range req.AnalyticsLabels {
+			if label != "" && !analyticsLabelPattern.MatchString(label) {
+				msg = "the analytics label must match " + analyticsLabelPattern.String()
+				logx.LogAccess.Debug(msg)
+				return errors.New(msg)
+			}
+		}
+	}
+
+	if req.FailFast && req.Platform != core.PlatFormAndroid {
+		msg = "the fail fast is only supported by Android"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.AutoCollapse && req.Platform != core.PlatFormAndroid {
+		msg = "the auto collapse is only supported by Android"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.DeliveryReceiptRequested && req.Platform != core.PlatFormAndroid {
+		msg = "the delivery receipt is only supported by Android"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Fallback && req.Platform != core.PlatFormIos && req.Platform != core.PlatFormAndroid {
+		msg = "the fallback is only supported by iOS and Android"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Template != nil {
+		switch {
+		case req.Platform != core.PlatFormAndroid:
+			msg = "the template is only supported by Android"
+		case len(req.Titles) > 0 || len(req.Bodies) > 0:
+			msg = "the message can't specify both a template and titles or bodies"
+		}
+		if msg != "" {
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+
+		if _, _, err := req.Template.parse(); err != nil {
+			msg = "invalid template: " + err.Error()
+			logx.LogAccess.Debug(msg)
+			return errors.New(msg)
+		}
+	}
+
+	// the tokens of a batch are checked once loaded at the send
+	if req.BatchID == "" {
+		if err := checkTokenFields(req); err != nil {
+			return err
+		}
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.DirectBootOK && (!req.DataOnly || req.Notification != nil) {
+		msg = "the direct boot message must be data only, set data_only and remove the notification"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.WebPush != nil {
+		if err := checkWebPush(req.WebPush); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
+	if req.Platform == core.PlatFormIos {
+		if err := checkIOSPushType(req); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
+	if sound, ok := soundObject(req.Sound); ok && (sound.Volume < 0 || sound.Volume > 1) {
+		msg = fmt.Sprintf("the sound volume must be between 0 and 1, got %v", sound.Volume)
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.SoundVolume < 0 || req.SoundVolume > 1 {
+		msg = fmt.Sprintf("the sound volume must be between 0 and 1, got %v", req.SoundVolume)
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.FCMApns != nil && len(req.Apns) > 0 {
+		msg = "the message can't specify both apns and fcm_apns"
 		logx.LogAccess.Debug(msg)
 		return errors.New(msg)
 	}
 
+	if req.FCMApns != nil {
+		if err := checkFCMApnsHeaders(req.FCMApns.Headers); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+
+		if err := checkFCMApsAlert(req.FCMApns.Aps); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
 	if req.Platform == core.PlatFormHuawei && len(req.Tokens) > 500 {
 		msg = "the message may specify at most 500 registration IDs for Huawei"
 		logx.LogAccess.Debug(msg)
+		return &ValidationError{Status: http.StatusRequestEntityTooLarge, Err: errors.New(msg)}
+	}
+
+	if req.Platform == core.PlatFormAndroid && len(req.CollapseKey) > fcmMaxCollapseKeyLength {
+		msg = fmt.Sprintf("the message's collapse key must be at most %d characters, got %d",
+			fcmMaxCollapseKeyLength, len(req.CollapseKey))
+		logx.LogAccess.Debug(msg)
 		return errors.New(msg)
 	}
 
+	if req.Platform == core.PlatFormAndroid && req.ExpiresAt != "" {
+		if err := checkExpiresAt(req); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
+	if req.Platform == core.PlatFormAndroid && req.DisplayUntil != "" {
+		if _, err := parseDisplayUntil(req.DisplayUntil); err != nil {
+			logx.LogAccess.Debug(err.Error())
+			return err
+		}
+	}
+
 	// ref: https://firebase.google.com/docs/cloud-messaging/http-server-ref
-	if req.Platform == core.PlatFormAndroid && req.TimeToLive != nil && *req.TimeToLive > uint(2419200) {
-		msg = "the message's TimeToLive field must be an integer " +
-			"between 0 and 2419200 (4 weeks)"
+	if req.Platform == core.PlatFormAndroid && req.TimeToLive != nil && *req.TimeToLive > fcmMaxTTL {
+		msg = fmt.Sprintf("the message's TimeToLive field must be an integer "+
+			"between 0 and %d (4 weeks), got %d", fcmMaxTTL, *req.TimeToLive)
 		logx.LogAccess.Debug(msg)
 		return errors.New(msg)
 	}
@@ -223,6 +741,12 @@ func CheckMessage(req *PushNotification) error {
 	return nil
 }
 
+// isHTTPSURL reports whether the link is an absolute https URL.
+func isHTTPSURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
 // SetProxy only working for FCM server.
 func SetProxy(proxy string) error {
 	proxyURL, err := url.ParseRequestURI(proxy)
@@ -236,6 +760,126 @@ func SetProxy(proxy string) error {
 	return nil
 }
 
+// checkTokenFields checks the per token fields of the notification match its
+// tokens.
+func checkTokenFields(req *PushNotification) error {
+	var msg string
+
+	if req.Platform == core.PlatFormAndroid && len(req.AnalyticsLabels) > 0 && len(req.AnalyticsLabels) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify one analytics label per token, got %d labels for %d tokens",
+			len(req.AnalyticsLabels), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && len(req.UserIDs) > 0 && len(req.UserIDs) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify one user ID per token, got %d user IDs for %d tokens",
+			len(req.UserIDs), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Fallback && len(req.FallbackTokens) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify one fallback token per token, got %d fallback tokens for %d tokens",
+			len(req.FallbackTokens), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && len(req.Titles) > 0 && len(req.Titles) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify one title per token, got %d titles for %d tokens",
+			len(req.Titles), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Platform == core.PlatFormAndroid && len(req.Bodies) > 0 && len(req.Bodies) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify one body per token, got %d bodies for %d tokens",
+			len(req.Bodies), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if req.Template != nil && len(req.Tokens) == 0 {
+		msg = "the template requires tokens"
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if len(req.Vars) > 0 && len(req.Vars) != len(req.Tokens) {
+		msg = fmt.Sprintf("the message must specify the vars of every token, got %d vars for %d tokens",
+			len(req.Vars), len(req.Tokens))
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// CheckTokenCount checks the notification has at most
+// core.max_tokens_per_request tokens, zero is unlimited.
+func CheckTokenCount(req *PushNotification, cfg *config.ConfYaml) error {
+	if limit := cfg.Core.MaxTokensPerRequest; limit > 0 && int64(len(req.Tokens)) > limit {
+		return &ValidationError{
+			Status: http.StatusRequestEntityTooLarge,
+			Err:    fmt.Errorf("%w: the message has %d tokens, over the limit of %d", ErrTooManyTokens, len(req.Tokens), limit),
+		}
+	}
+	return nil
+}
+
+// tenantIDPattern bounds the tenant IDs, they label the metrics and name the
+// stat keys.
+var tenantIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// CheckTenant checks the tenant_id of the notification has the valid format
+// and is listed in core.tenants, an empty list allows any tenant_id.
+func CheckTenant(req *PushNotification, cfg *config.ConfYaml) error {
+	var msg string
+
+	if req.TenantID == "" {
+		return nil
+	}
+
+	if !tenantIDPattern.MatchString(req.TenantID) {
+		msg = fmt.Sprintf("the tenant_id %q must be 1 to 64 letters, digits, '_', '.' or '-'", req.TenantID)
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if len(cfg.Core.Tenants) > 0 && !slices.Contains(cfg.Core.Tenants, req.TenantID) {
+		msg = fmt.Sprintf("the tenant_id %q is not listed in core.tenants", req.TenantID)
+		logx.LogAccess.Debug(msg)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// ValidateNotification checks the notification like the sends do without
+// sending it, the FCM message of the Android notifications is built as well.
+// No provider client is needed.
+func ValidateNotification(ctx context.Context, req *PushNotification, cfg *config.ConfYaml) error {
+	if err := CheckTokenCount(req, cfg); err != nil {
+		return err
+	}
+
+	if err := CheckTenant(req, cfg); err != nil {
+		return err
+	}
+
+	if req.Platform != core.PlatFormAndroid {
+		return CheckMessage(req)
+	}
+
+	if err := checkAndroidMessageV1(ctx, req, cfg); err != nil {
+		return err
+	}
+
+	_, err := getAndroidNotificationV1(req, cfg)
+	return err
+}
+
 // CheckPushConf provide check your yml config.
 func CheckPushConf(cfg *config.ConfYaml) error {
 	if !cfg.Ios.Enabled && !cfg.Android.Enabled && !cfg.Huawei.Enabled {
@@ -256,12 +900,45 @@ func CheckPushConf(cfg *config.ConfYaml) error {
 	}
 
 	if cfg.Android.Enabled {
-		if cfg.Android.ServiceAccountKey == "" {
+		if cfg.Android.ServiceAccountKey == "" && cfg.Android.Credential == "" {
 			return errors.New("missing service account key")
 		}
 
+		if cfg.Android.Credential != "" && !json.Valid([]byte(cfg.Android.Credential)) {
+			return errors.New("android credential is not a valid JSON")
+		}
+
+		if cfg.Android.DefaultPriority != "" && !androidPriorities[cfg.Android.DefaultPriority] {
+			return errors.New("android default priority must be normal or high")
+		}
+
+		if t := cfg.Android.HTTPTransport; t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 ||
+			t.IdleConnTimeout < 0 || t.KeepAlive < 0 {
+			return errors.New("android http_transport values must not be negative")
+		}
+
+		if cfg.Android.Proxy != "" {
+			if _, err := parseProxyURL(cfg.Android.Proxy); err != nil {
+				return fmt.Errorf("invalid android proxy: %w", err)
+			}
+		}
+
+		if cfg.Android.CACertFile != "" {
+			if _, err := fcmV1CertPool(cfg.Android.CACertFile); err != nil {
+				return fmt.Errorf("invalid android ca_cert_file: %w", err)
+			}
+		}
+
+		// use the project of the service account key by default
 		if cfg.Android.ProjectID == "" {
-			return errors.New("missing project id")
+			projectID, err := serviceAccountProjectID(cfg)
+			if err != nil {
+				return fmt.Errorf("invalid android service account key: %w", err)
+			}
+			if projectID == "" {
+				return errors.New("missing project id")
+			}
+			cfg.Android.ProjectID = projectID
 		}
 	}
 
@@ -290,6 +967,75 @@ func SendNotification(
 			return nil, err
 		}
 	}
+	ctx = requestContext(ctx, v)
+
+	// the result is recorded for the polls by request ID, the scheduled
+	// notifications once sent
+	scheduled := false
+	defer func() {
+		if scheduled {
+			return
+		}
+		result := resp
+		if result == nil {
+			result = &ResponsePush{RequestID: v.RequestID}
+		}
+		if err != nil && result.Error == "" {
+			result.Error = err.Error()
+		}
+		CompleteResult(ctx, cfg, v.RequestID, result)
+	}()
+
+	if err = CheckTokenCount(v, cfg); err != nil {
+		logx.ErrorEntry(ctx).Error("request error: " + err.Error())
+		return nil, err
+	}
+
+	if err = CheckTenant(v, cfg); err != nil {
+		logx.ErrorEntry(ctx).Error("request error: " + err.Error())
+		return nil, err
+	}
+
+	// the notification was sent already, e.g. a submission retried by the client
+	if v.IdempotencyKey != "" && IdempotencyStore != nil {
+		if cached, ok := IdempotencyStore.Get(ctx, v.IdempotencyKey); ok {
+			logx.AccessEntry(ctx).Debugf("skip the notification with the idempotency key %q sent already", v.IdempotencyKey)
+			return cached, nil
+		}
+	}
+
+	at, err := scheduledTime(v)
+	if err != nil {
+		return nil, err
+	}
+	if at.After(time.Now()) {
+		resp, err = scheduleNotification(ctx, v, at)
+		scheduled = err == nil
+		return resp, err
+	}
+
+	// reserve the key so that the concurrent submissions aren't sent twice
+	reserved := false
+	if v.IdempotencyKey != "" && IdempotencyStore != nil && cfg.Idempotency.TTL > 0 {
+		ttl := time.Duration(cfg.Idempotency.TTL) * time.Second
+		ok, err := IdempotencyStore.Reserve(ctx, v.IdempotencyKey, ttl)
+		if err != nil {
+			logx.ErrorEntry(ctx).Error("idempotency cache error: " + err.Error())
+		} else if !ok {
+			if cached, ok := IdempotencyStore.Get(ctx, v.IdempotencyKey); ok {
+				return cached, nil
+			}
+			return nil, ErrIdempotencyKeyInUse
+		}
+		reserved = ok
+	}
+	defer func() {
+		if reserved && (err != nil || resp == nil) {
+			if err := IdempotencyStore.Release(ctx, v.IdempotencyKey); err != nil {
+				logx.ErrorEntry(ctx).Error("idempotency cache error: " + err.Error())
+			}
+		}
+	}()
 
 	switch v.Platform {
 	case core.PlatFormIos:
@@ -300,15 +1046,15 @@ func SendNotification(
 		resp, err = PushToHuawei(v, cfg)
 	}
 
-	if cfg.Core.FeedbackURL != "" {
-		for _, l := range resp.Logs {
-			err := DispatchFeedback(ctx, l, cfg.Core.FeedbackURL, cfg.Core.FeedbackTimeout, cfg.Core.FeedbackHeader)
-			if err != nil {
-				logx.LogError.Error(err)
-			}
+	if err == nil && resp != nil && reserved {
+		ttl := time.Duration(cfg.Idempotency.TTL) * time.Second
+		if err := IdempotencyStore.Set(ctx, v.IdempotencyKey, resp, ttl); err != nil {
+			logx.ErrorEntry(ctx).Error("idempotency cache error: " + err.Error())
 		}
 	}
 
+	dispatchFeedback(ctx, resp, cfg)
+
 	return resp, err
 }
 
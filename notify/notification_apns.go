@@ -1,13 +1,17 @@
 package notify
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,6 +42,26 @@ const (
 
 var doOnce sync.Once
 
+// apns-push-type values not defined by apns2.
+const (
+	// PushTypeLiveActivity updates a Live Activity, the topic must end with .push-type.liveactivity
+	PushTypeLiveActivity apns2.EPushType = "liveactivity"
+	// PushTypePushToTalk wakes a Push to Talk app, the topic must end with .voip-ptt
+	PushTypePushToTalk apns2.EPushType = "pushtotalk"
+)
+
+// apnsTopicSuffixes are the topic suffixes required by the push types.
+var apnsTopicSuffixes = map[apns2.EPushType]string{
+	PushTypeLiveActivity: ".push-type.liveactivity",
+	PushTypePushToTalk:   ".voip-ptt",
+}
+
+var liveActivityEvents = map[string]bool{
+	"start":  true,
+	"update": true,
+	"end":    true,
+}
+
 // DialTLS is the default dial function for creating TLS connections for
 // non-proxied HTTPS requests.
 var DialTLS = func(cfg *tls.Config) func(network, addr string) (net.Conn, error) {
@@ -57,6 +81,24 @@ type Sound struct {
 	Volume   float32 `json:"volume,omitempty"`
 }
 
+// soundObject returns the sound of the request when it's a sound dictionary
+// instead of a sound name.
+func soundObject(sound interface{}) (*Sound, bool) {
+	switch v := sound.(type) {
+	// from http request binding
+	case map[string]interface{}:
+		result := &Sound{}
+		_ = mapstructure.Decode(v, result)
+		return result, true
+	case Sound:
+		return &v, true
+	case *Sound:
+		return v, v != nil
+	}
+
+	return nil, false
+}
+
 // InitAPNSClient use for initialize APNs Client.
 func InitAPNSClient(cfg *config.ConfYaml) error {
 	if cfg.Ios.Enabled {
@@ -321,6 +363,18 @@ func GetIOSNotification(req *PushNotification) *apns2.Notification {
 
 	if len(req.PushType) > 0 {
 		notification.PushType = apns2.EPushType(req.PushType)
+	} else if isBackgroundIOSNotification(req) {
+		notification.PushType = apns2.PushTypeBackground
+	}
+
+	// APNs requires the low priority for background pushes.
+	if notification.PushType == apns2.PushTypeBackground {
+		notification.Priority = apns2.PriorityLow
+	}
+
+	if suffix, ok := apnsTopicSuffixes[notification.PushType]; ok && notification.Topic != "" &&
+		!strings.HasSuffix(notification.Topic, suffix) {
+		notification.Topic += suffix
 	}
 
 	payload := payload.NewPayload()
@@ -339,17 +393,11 @@ func GetIOSNotification(req *PushNotification) *apns2.Notification {
 		payload.MutableContent()
 	}
 
-	switch req.Sound.(type) {
-	// from http request binding
-	case map[string]interface{}:
-		result := &Sound{}
-		_ = mapstructure.Decode(req.Sound, &result)
-		payload.Sound(result)
 	// from http request binding for non critical alerts
-	case string:
-		payload.Sound(&req.Sound)
-	case Sound:
-		payload.Sound(&req.Sound)
+	if v, ok := req.Sound.(string); ok {
+		payload.Sound(v)
+	} else if v, ok := soundObject(req.Sound); ok {
+		payload.Sound(v)
 	}
 
 	if len(req.SoundName) > 0 {
@@ -380,9 +428,97 @@ func GetIOSNotification(req *PushNotification) *apns2.Notification {
 
 	notification.Payload = payload
 
+	if notification.PushType == PushTypeLiveActivity && req.LiveActivity != nil {
+		notification.Payload = liveActivityPayload(payload, req.LiveActivity)
+	}
+
 	return notification
 }
 
+// isBackgroundIOSNotification reports whether the request only wakes the app
+// with content-available and doesn't show an alert.
+func isBackgroundIOSNotification(req *PushNotification) bool {
+	return req.ContentAvailable &&
+		req.Message == "" &&
+		req.Title == "" &&
+		req.Alert.Title == "" &&
+		req.Alert.Subtitle == "" &&
+		req.Alert.Body == "" &&
+		req.Alert.LocKey == "" &&
+		req.Alert.TitleLocKey == ""
+}
+
+// liveActivityPayload adds the Live Activity keys to the aps dictionary,
+// the payload builder of apns2 doesn't support them.
+func liveActivityPayload(p *payload.Payload, la *LiveActivity) map[string]interface{} {
+	content := map[string]interface{}{}
+	b, _ := json.Marshal(p)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	_ = d.Decode(&content)
+
+	aps, _ := content["aps"].(map[string]interface{})
+	if aps == nil {
+		aps = map[string]interface{}{}
+	}
+
+	aps["event"] = la.Event
+	aps["timestamp"] = la.Timestamp
+	if la.Timestamp == 0 {
+		aps["timestamp"] = time.Now().Unix()
+	}
+
+	if len(la.ContentState) > 0 {
+		aps["content-state"] = la.ContentState
+	}
+
+	if la.StaleDate > 0 {
+		aps["stale-date"] = la.StaleDate
+	}
+
+	if la.DismissalDate > 0 {
+		aps["dismissal-date"] = la.DismissalDate
+	}
+
+	if la.AttributesType != "" {
+		aps["attributes-type"] = la.AttributesType
+		aps["attributes"] = la.Attributes
+	}
+
+	content["aps"] = aps
+
+	return content
+}
+
+// checkIOSPushType validates the payload required by the push type.
+func checkIOSPushType(req *PushNotification) error {
+	switch apns2.EPushType(req.PushType) {
+	case PushTypeLiveActivity:
+		la := req.LiveActivity
+		if la == nil {
+			return errors.New("the liveactivity push type requires the live_activity payload")
+		}
+
+		if !liveActivityEvents[la.Event] {
+			return fmt.Errorf("unknown live activity event: %q, must be start, update or end", la.Event)
+		}
+
+		if la.Event != "end" && len(la.ContentState) == 0 {
+			return fmt.Errorf("the live activity %s event requires the content-state", la.Event)
+		}
+
+		if la.Event == "start" && (la.AttributesType == "" || len(la.Attributes) == 0) {
+			return errors.New("the live activity start event requires the attributes-type and attributes")
+		}
+	case PushTypePushToTalk:
+		if req.Priority == NORMAL {
+			return errors.New("the pushtotalk push type must be sent with high priority")
+		}
+	}
+
+	return nil
+}
+
 func getApnsClient(cfg *config.ConfYaml, req *PushNotification) (client *apns2.Client) {
 	switch {
 	case req.Production:
@@ -402,7 +538,30 @@ func getApnsClient(cfg *config.ConfYaml, req *PushNotification) (client *apns2.C
 
 // PushToIOS provide send notification to APNs server.
 func PushToIOS(req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePush, err error) {
-	logx.LogAccess.Debug("Start push notification for iOS")
+	ctx := requestContext(context.Background(), req)
+	logx.AccessEntry(ctx).Debug("Start push notification for iOS")
+
+	// check message
+	err = CheckMessage(req)
+	if err != nil {
+		logx.ErrorEntry(ctx).Error("request error: " + err.Error())
+		return nil, err
+	}
+
+	// the sends fail fast while iOS is disabled at runtime
+	if !PlatformEnabled(core.PlatFormIos) {
+		logx.ErrorEntry(ctx).Error("APNs send skipped: " + ErrPlatformDisabled.Error())
+		resp = &ResponsePush{RequestID: req.RequestID}
+		for _, token := range req.Tokens {
+			resp.Logs = append(resp.Logs, logPush(cfg, core.FailedPush, token, req, ErrPlatformDisabled))
+		}
+		status.StatStorage.AddIosErrorByTenant(req.TenantID, int64(len(req.Tokens)))
+		return resp, ErrPlatformDisabled
+	}
+
+	// the valid notifications are in flight until they are sent
+	status.StatStorage.IncInFlight()
+	defer status.StatStorage.DecInFlight()
 
 	var (
 		retryCount = 0
@@ -413,15 +572,24 @@ func PushToIOS(req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePush,
 		maxRetry = req.Retry
 	}
 
-	resp = &ResponsePush{}
+	resp = &ResponsePush{RequestID: req.RequestID}
+
+	// the tokens of the original request, kept across retries
+	tokens := req.Tokens
+	var badTokens []string
 
 Retry:
 	var newTokens []string
 
 	notification := GetIOSNotification(req)
 	client := getApnsClient(cfg, req)
+	logDebugAPNSRequest(req, notification)
 
-	var wg sync.WaitGroup
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed int
+	)
 	for _, token := range req.Tokens {
 		// occupy push slot
 		MaxConcurrentIOSPushes <- struct{}{}
@@ -430,7 +598,15 @@ Retry:
 			notification.DeviceToken = token
 
 			// send ios notification
-			res, err := client.Push(&notification)
+			var res *apns2.Response
+			release, err := acquirePush(ctx)
+			if err == nil {
+				start := time.Now()
+				res, err = client.Push(&notification)
+				observeSendLatency("ios", start, err != nil || res.StatusCode != http.StatusOK)
+				release()
+			}
+			logDebugAPNSResponse(req, token, res, err)
 			if err != nil || (res != nil && res.StatusCode != http.StatusOK) {
 				if err == nil {
 					// error message:
@@ -440,19 +616,25 @@ Retry:
 
 				// apns server error
 				errLog := logPush(cfg, core.FailedPush, token, req, err)
+				lock.Lock()
 				resp.Logs = append(resp.Logs, errLog)
+				failed++
 
-				status.StatStorage.AddIosError(1)
+				status.StatStorage.AddIosErrorByTenant(req.TenantID, 1)
 				// We should retry only "retryable" statuses. More info about response:
 				// See https://apple.co/3AdNane (Handling Notification Responses from APNs)
 				if res != nil && res.StatusCode >= http.StatusInternalServerError {
 					newTokens = append(newTokens, token)
 				}
+				if res != nil && res.Reason == apns2.ReasonBadDeviceToken {
+					badTokens = append(badTokens, token)
+				}
+				lock.Unlock()
 			}
 
 			if res != nil && res.Sent() {
 				logPush(cfg, core.SucceededPush, token, req, nil)
-				status.StatStorage.AddIosSuccess(1)
+				status.StatStorage.AddIosSuccessByTenant(req.TenantID, 1)
 			}
 
 			// free push slot
@@ -462,6 +644,7 @@ Retry:
 	}
 
 	wg.Wait()
+	recordRetry("ios", retryCount, len(req.Tokens), len(req.Tokens)-failed)
 
 	if len(newTokens) > 0 && retryCount < maxRetry {
 		retryCount++
@@ -471,5 +654,53 @@ Retry:
 		goto Retry
 	}
 
+	pushFallback(ctx, req, cfg, fallbackIndexes(tokens, badTokens), resp)
+
 	return resp, nil
 }
+
+// logDebugAPNSRequest records the notification sent to APNs when the request
+// asks for verbose logs.
+func logDebugAPNSRequest(req *PushNotification, notification *apns2.Notification) {
+	if !req.Debug {
+		return
+	}
+
+	payload, err := json.Marshal(notification.Payload)
+	if err != nil {
+		logx.LogError.Error("APNs payload error: " + err.Error())
+		return
+	}
+
+	logDebug(req, "apns request", map[string]interface{}{
+		"tokens":    hashTokens(req.Tokens),
+		"topic":     notification.Topic,
+		"push_type": string(notification.PushType),
+		"priority":  notification.Priority,
+		"payload":   string(payload),
+	})
+}
+
+// logDebugAPNSResponse records the APNs response of the token when the
+// request asks for verbose logs.
+func logDebugAPNSResponse(req *PushNotification, token string, res *apns2.Response, err error) {
+	if !req.Debug {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"token": logx.HashToken(token),
+	}
+	if res != nil {
+		fields["status_code"] = res.StatusCode
+		fields["apns_id"] = res.ApnsID
+		if res.Reason != "" {
+			fields["reason"] = res.Reason
+		}
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+
+	logDebug(req, "apns response", fields)
+}
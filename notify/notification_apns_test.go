@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/status"
 
 	"github.com/buger/jsonparser"
@@ -232,6 +233,33 @@ func TestIOSSoundAndVolume(t *testing.T) {
 	assert.Equal(t, "default", soundName)
 }
 
+func TestIOSSoundVolumeRange(t *testing.T) {
+	req := &PushNotification{
+		Platform: core.PlatFormIos,
+		Tokens:   []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+		Message:  "Welcome",
+		Sound: map[string]interface{}{
+			"critical": 1,
+			"name":     "default",
+			"volume":   1.0,
+		},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	req.Sound = map[string]interface{}{
+		"name":   "default",
+		"volume": 1.5,
+	}
+	assert.EqualError(t, CheckMessage(req), "the sound volume must be between 0 and 1, got 1.5")
+
+	req.Sound = Sound{Name: "default", Volume: -0.5}
+	assert.EqualError(t, CheckMessage(req), "the sound volume must be between 0 and 1, got -0.5")
+
+	req.Sound = "default"
+	req.SoundVolume = 2
+	assert.EqualError(t, CheckMessage(req), "the sound volume must be between 0 and 1, got 2")
+}
+
 func TestIOSSummaryArg(t *testing.T) {
 	var dat map[string]interface{}
 
@@ -777,3 +805,135 @@ func TestApnsHostFromRequest(t *testing.T) {
 	client = getApnsClient(cfg, req)
 	assert.Equal(t, apns2.HostDevelopment, client.Host)
 }
+
+func TestIOSLiveActivity(t *testing.T) {
+	req := &PushNotification{
+		Platform: core.PlatFormIos,
+		Tokens:   []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+		Topic:    "com.example.app",
+		PushType: "liveactivity",
+		Message:  "Delivery is on the way",
+		Data:     D{"order": 12345678901},
+	}
+
+	assert.EqualError(t, CheckMessage(req), "the liveactivity push type requires the live_activity payload")
+
+	req.LiveActivity = &LiveActivity{Event: "pause"}
+	assert.EqualError(t, CheckMessage(req), `unknown live activity event: "pause", must be start, update or end`)
+
+	req.LiveActivity = &LiveActivity{Event: "update"}
+	assert.EqualError(t, CheckMessage(req), "the live activity update event requires the content-state")
+
+	req.LiveActivity = &LiveActivity{Event: "start", ContentState: D{"eta": 10}}
+	assert.EqualError(t, CheckMessage(req), "the live activity start event requires the attributes-type and attributes")
+
+	// the end event doesn't need a content state
+	req.LiveActivity = &LiveActivity{Event: "end", DismissalDate: 1700000600}
+	assert.NoError(t, CheckMessage(req))
+
+	req.LiveActivity = &LiveActivity{
+		Event:          "update",
+		Timestamp:      1700000000,
+		ContentState:   D{"eta": 10},
+		StaleDate:      1700000300,
+		AttributesType: "DeliveryAttributes",
+		Attributes:     D{"order": "A1"},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	notification := GetIOSNotification(req)
+	assert.Equal(t, PushTypeLiveActivity, notification.PushType)
+	assert.Equal(t, "com.example.app.push-type.liveactivity", notification.Topic)
+
+	data, err := json.Marshal(notification.Payload)
+	assert.NoError(t, err)
+
+	alert, _ := jsonparser.GetString(data, "aps", "alert")
+	event, _ := jsonparser.GetString(data, "aps", "event")
+	timestamp, _ := jsonparser.GetInt(data, "aps", "timestamp")
+	eta, _ := jsonparser.GetInt(data, "aps", "content-state", "eta")
+	staleDate, _ := jsonparser.GetInt(data, "aps", "stale-date")
+	attributesType, _ := jsonparser.GetString(data, "aps", "attributes-type")
+	order, _ := jsonparser.GetString(data, "aps", "attributes", "order")
+	custom, _ := jsonparser.GetInt(data, "order")
+	_, _, _, err = jsonparser.Get(data, "aps", "dismissal-date")
+
+	assert.Equal(t, "Delivery is on the way", alert)
+	assert.Equal(t, "update", event)
+	assert.Equal(t, int64(1700000000), timestamp)
+	assert.Equal(t, int64(10), eta)
+	assert.Equal(t, int64(1700000300), staleDate)
+	assert.Equal(t, "DeliveryAttributes", attributesType)
+	assert.Equal(t, "A1", order)
+	assert.Equal(t, int64(12345678901), custom)
+	assert.Error(t, err)
+
+	// the topic suffix isn't added twice and the timestamp defaults to now
+	req.Topic = "com.example.app.push-type.liveactivity"
+	req.LiveActivity.Timestamp = 0
+	notification = GetIOSNotification(req)
+	assert.Equal(t, "com.example.app.push-type.liveactivity", notification.Topic)
+
+	data, _ = json.Marshal(notification.Payload)
+	timestamp, _ = jsonparser.GetInt(data, "aps", "timestamp")
+	assert.InDelta(t, time.Now().Unix(), timestamp, 5)
+}
+
+func TestIOSPushToTalk(t *testing.T) {
+	req := &PushNotification{
+		Platform: core.PlatFormIos,
+		Tokens:   []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+		Topic:    "com.example.app",
+		PushType: "pushtotalk",
+		Priority: "normal",
+	}
+
+	assert.EqualError(t, CheckMessage(req), "the pushtotalk push type must be sent with high priority")
+
+	req.Priority = HIGH
+	assert.NoError(t, CheckMessage(req))
+
+	notification := GetIOSNotification(req)
+	assert.Equal(t, PushTypePushToTalk, notification.PushType)
+	assert.Equal(t, "com.example.app.voip-ptt", notification.Topic)
+	assert.Equal(t, apns2.PriorityHigh, notification.Priority)
+}
+
+func TestIOSBackgroundNotification(t *testing.T) {
+	req := &PushNotification{
+		Platform:         core.PlatFormIos,
+		Tokens:           []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+		Priority:         HIGH,
+		ContentAvailable: true,
+		MutableContent:   true,
+		Data:             D{"sync": true},
+	}
+
+	notification := GetIOSNotification(req)
+	assert.Equal(t, apns2.PushTypeBackground, notification.PushType)
+	assert.Equal(t, apns2.PriorityLow, notification.Priority)
+
+	data, err := json.Marshal(notification.Payload)
+	assert.NoError(t, err)
+
+	contentAvailable, _ := jsonparser.GetInt(data, "aps", "content-available")
+	mutableContent, _ := jsonparser.GetInt(data, "aps", "mutable-content")
+	_, _, _, alertErr := jsonparser.Get(data, "aps", "alert")
+	assert.Equal(t, int64(1), contentAvailable)
+	assert.Equal(t, int64(1), mutableContent)
+	assert.Error(t, alertErr)
+
+	// an alert is sent as is
+	req.Alert = Alert{Body: "new mail"}
+	notification = GetIOSNotification(req)
+	assert.Equal(t, apns2.EPushType(""), notification.PushType)
+	assert.Equal(t, apns2.PriorityHigh, notification.Priority)
+
+	data, _ = json.Marshal(notification.Payload)
+	contentAvailable, _ = jsonparser.GetInt(data, "aps", "content-available")
+	mutableContent, _ = jsonparser.GetInt(data, "aps", "mutable-content")
+	body, _ := jsonparser.GetString(data, "aps", "alert", "body")
+	assert.Equal(t, int64(1), contentAvailable)
+	assert.Equal(t, int64(1), mutableContent)
+	assert.Equal(t, "new mail", body)
+}
@@ -1,117 +1,2141 @@
 package notify
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/errorutils"
 	"firebase.google.com/go/v4/messaging"
 	"github.com/appleboy/gorush/config"
 	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/logx"
 	"github.com/appleboy/gorush/status"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/option"
+	gtransport "google.golang.org/api/transport"
+	htransport "google.golang.org/api/transport/http"
 )
 
 // Send messages and manage messaging subscriptions for your Firebase
 // applications
 const firebaseMessagingScope = "https://www.googleapis.com/auth/firebase.messaging"
 
-var fcmV1Client *messaging.Client
+// FCMClient sends messages to FCM, it's implemented by *messaging.Client.
+type FCMClient interface {
+	Send(ctx context.Context, message *messaging.Message) (string, error)
+	SendDryRun(ctx context.Context, message *messaging.Message) (string, error)
+	SendEachForMulticast(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error)
+	SendEachForMulticastDryRun(ctx context.Context, message *messaging.MulticastMessage) (*messaging.BatchResponse, error)
+}
+
+var (
+	// fcmV1Clients caches the FCM clients per project and service account.
+	fcmV1Clients     = make(map[string]FCMClient)
+	fcmV1ClientsLock sync.RWMutex
+
+	// fcmV1Limiters limits the sending rate per project, they're shared by
+	// all the workers.
+	fcmV1Limiters     = make(map[string]*rate.Limiter)
+	fcmV1LimitersLock sync.Mutex
+
+	// fcmHealthChecks caches the results of CheckFCMV1Health per project and
+	// service account for android.health_check_interval.
+	fcmHealthChecks     = make(map[string]fcmHealthCheck)
+	fcmHealthChecksLock sync.Mutex
+)
+
+// fcmHealthCheck is the result of a health check.
+type fcmHealthCheck struct {
+	at  time.Time
+	err error
+}
+
+// fcmHealthCheckToken is a dummy token validated by CheckFCMV1Health, FCM
+// rejects it as an invalid argument once the request is authorized.
+const fcmHealthCheckToken = "gorush-health-check"
+
+// ErrFCMRateLimited is returned when android.rate_limit is hit for longer
+// than android.rate_limit_wait.
+var ErrFCMRateLimited = errors.New("rate limited")
+
+// ErrFCMTokenFormat is the error of the malformed tokens, they aren't sent
+// to FCM.
+var ErrFCMTokenFormat = errors.New("invalid token format")
+
+// ErrFailFast is the error of the FailFast notifications with a failed token.
+var ErrFailFast = errors.New("fail fast")
+
+// fcmMulticastLimit is the max number of tokens of a FCM multicast message.
+const fcmMulticastLimit = 500
+
+// fcmMaxTTL is the max time to live of a FCM message in seconds (4 weeks).
+const fcmMaxTTL uint = 2419200
+
+// fcmMaxTokenLength is the max length of a FCM registration token. The
+// tokens are about 160 bytes, the bound only rejects the obviously broken
+// ones.
+const fcmMaxTokenLength = 4096
+
+// Error types of failed FCM pushes.
+const (
+	// ErrorTypeInvalidToken the token is no longer valid and should be removed
+	ErrorTypeInvalidToken = "invalid_token"
+	// ErrorTypeQuota the sending quota is exceeded
+	ErrorTypeQuota = "quota"
+	// ErrorTypeServer the FCM server is unavailable or failed internally
+	ErrorTypeServer = "server"
+	// ErrorTypeAuth the credential is not allowed to send to the token
+	ErrorTypeAuth = "auth"
+	// ErrorTypeTimeout the request didn't finish before android.timeout
+	ErrorTypeTimeout = "timeout"
+	// ErrorTypeRateLimited the message isn't sent because of android.rate_limit
+	ErrorTypeRateLimited = "rate_limited"
+	// ErrorTypeCircuitOpen the message isn't sent because FCM is unavailable
+	ErrorTypeCircuitOpen = "circuit_open"
+	// ErrorTypeInvalidFormat the token is malformed and isn't sent to FCM
+	ErrorTypeInvalidFormat = "invalid_format"
+	// ErrorTypePlatformDisabled the message isn't sent because Android is
+	// disabled at runtime
+	ErrorTypePlatformDisabled = "platform_disabled"
+	// ErrorTypeThrottled the token isn't sent because of
+	// android.per_token_rate_limit
+	ErrorTypeThrottled = "throttled"
+)
+
+// androidPriorities are the message priorities accepted by FCM.
+var androidPriorities = map[string]bool{
+	"normal": true,
+	"high":   true,
+}
+
+var androidNotificationPriorities = map[string]messaging.AndroidNotificationPriority{
+	"min":     messaging.PriorityMin,
+	"low":     messaging.PriorityLow,
+	"default": messaging.PriorityDefault,
+	"high":    messaging.PriorityHigh,
+	"max":     messaging.PriorityMax,
+}
+
+// androidNotificationImportances maps the channel importances to the closest
+// notification priority, as Android does for the apps before O.
+var androidNotificationImportances = map[string]messaging.AndroidNotificationPriority{
+	"min":     messaging.PriorityMin,
+	"low":     messaging.PriorityLow,
+	"default": messaging.PriorityDefault,
+	"high":    messaging.PriorityHigh,
+}
+
+// the data keys of the channel hints, the app reads them to create the
+// channel of the notification.
+const (
+	androidChannelGroupIDKey = "android_channel_group_id"
+	androidImportanceKey     = "android_channel_importance"
+)
+
+// the data keys of the group hints, the app reads them to bundle the
+// notifications of the group.
+const (
+	androidGroupKey        = "android_group_key"
+	androidGroupSummaryKey = "android_group_summary"
+)
+
+// androidDeepLinkKey is the data key of the deep link of the notification.
+const androidDeepLinkKey = "deep_link"
+
+// the data keys of the rich notifications, the notification extension of the
+// app fetches the image and renders it with the style.
+const (
+	androidRichImageURLKey = "image_url"
+	androidRichStyleKey    = "style"
+
+	androidRichStyleBigPicture = "big_picture"
+)
+
+// androidDisplayUntilKey is the data key of the unix time the app stops
+// showing the notification.
+const androidDisplayUntilKey = "display_until"
+
+// androidMaxTagLength is the max length of the notification tags.
+const androidMaxTagLength = 64
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}([0-9a-fA-F]{2})?$`)
+
+// analyticsLabelPattern is the format of FCM analytics labels.
+var analyticsLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]{1,50}$`)
+
+// fcmTokenPattern matches the characters of the FCM registration tokens.
+var fcmTokenPattern = regexp.MustCompile(`^[a-zA-Z0-9_:.-]+$`)
+
+var androidNotificationVisibilities = map[string]messaging.AndroidNotificationVisibility{
+	"private": messaging.VisibilityPrivate,
+	"public":  messaging.VisibilityPublic,
+	"secret":  messaging.VisibilitySecret,
+}
+
+// InitFCMV1Client returns the FCM client of the given project, the client is
+// created on first use. An empty projectID falls back to cfg.Android.ProjectID,
+// then to the project of the service account key.
+func InitFCMV1Client(ctx context.Context, cfg *config.ConfYaml, projectID string) (FCMClient, error) {
+	if projectID == "" {
+		projectID = cfg.Android.ProjectID
+	}
+
+	if projectID == "" {
+		keyProjectID, err := serviceAccountProjectID(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("InitFCMV1Client: unable to read the project id of the service account key %w", err)
+		}
+		if keyProjectID == "" {
+			return nil, errors.New("InitFCMV1Client: missing android project_id, it isn't found in the service account key either")
+		}
+		projectID = keyProjectID
+	}
+
+	key := fcmV1ClientKey(projectID, cfg.Android.ServiceAccountKey)
+
+	fcmV1ClientsLock.RLock()
+	client, ok := fcmV1Clients[key]
+	fcmV1ClientsLock.RUnlock()
+	if ok {
+		return client, nil
+	}
+
+	fcmV1ClientsLock.Lock()
+	defer fcmV1ClientsLock.Unlock()
+
+	if client, ok := fcmV1Clients[key]; ok {
+		return client, nil
+	}
+
+	logx.AccessEntry(ctx).Debugf("init FCM client of project %q", projectID)
+
+	client, err := newFCMV1Client(ctx, cfg, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	fcmV1Clients[key] = client
+	return client, err
+}
+
+// serviceAccountProjectID returns the project_id field of the service account
+// key, it's empty if the key doesn't have one.
+func serviceAccountProjectID(cfg *config.ConfYaml) (string, error) {
+	content := []byte(cfg.Android.Credential)
+	if cfg.Android.Credential == "" {
+		var err error
+		if content, err = os.ReadFile(cfg.Android.ServiceAccountKey); err != nil {
+			return "", err
+		}
+	}
+
+	var key struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(content, &key); err != nil {
+		return "", err
+	}
+
+	return key.ProjectID, nil
+}
+
+// ReloadFCMV1Client drops the cached FCM clients and recreates the client of
+// cfg.Android.ProjectID, so a rotated credential is loaded without restart.
+// Sends already in flight complete against the old client. The cache is kept
+// untouched if the new client can't be created.
+func ReloadFCMV1Client(ctx context.Context, cfg *config.ConfYaml) (FCMClient, error) {
+	client, err := newFCMV1Client(ctx, cfg, cfg.Android.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	fcmV1ClientsLock.Lock()
+	defer fcmV1ClientsLock.Unlock()
+
+	fcmV1Clients = map[string]FCMClient{
+		fcmV1ClientKey(cfg.Android.ProjectID, cfg.Android.ServiceAccountKey): client,
+	}
+
+	return client, nil
+}
+
+// newFCMV1Client creates the FCM client of the given project.
+func newFCMV1Client(ctx context.Context, cfg *config.ConfYaml, projectID string) (FCMClient, error) {
+	opts := fcmV1ClientOptions(cfg)
+	base, err := fcmV1Transport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("InitFCMV1Client: %w", err)
+	}
+	if base != nil {
+		if cfg.Android.Proxy != "" || cfg.Android.CACertFile != "" {
+			// the access tokens are fetched through the proxy and with the
+			// CAs as well
+			ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: base})
+		}
+
+		// the http client replaces the authenticated default one, wrap the
+		// tuned transport with the credential of the options.
+		trans, err := htransport.NewTransport(ctx, base, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("InitFCMV1Client: unable to create http transport %w", err)
+		}
+		opts = append(opts, option.WithHTTPClient(&http.Client{Transport: trans}))
+	}
+
+	f, err := firebase.NewApp(ctx,
+		&firebase.Config{
+			ProjectID: projectID,
+		},
+		opts...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("InitFCMV1Client: unable to create firebase app %w", err)
+	}
+
+	client, err := f.Messaging(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("InitFCMV1Client: unable to create messaging client %w", err)
+	}
+
+	return client, nil
+}
+
+// CheckFCMV1Health fetches an access token with the credential and validates
+// a dry run message to a dummy token with the FCM client of
+// cfg.Android.ProjectID. An error is returned if the client can't be created
+// or the credential isn't authorized, other FCM errors like the invalid dummy
+// token are ignored. The result is reused for android.health_check_interval
+// so the probes don't fetch a token each time.
+func CheckFCMV1Health(ctx context.Context, cfg *config.ConfYaml) error {
+	client, err := InitFCMV1Client(ctx, cfg, cfg.Android.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	key := fcmV1ClientKey(cfg.Android.ProjectID, cfg.Android.ServiceAccountKey)
+	interval := time.Duration(cfg.Android.HealthCheckInterval) * time.Second
+
+	fcmHealthChecksLock.Lock()
+	last, ok := fcmHealthChecks[key]
+	fcmHealthChecksLock.Unlock()
+	if ok && time.Since(last.at) < interval {
+		return last.err
+	}
+
+	err = checkFCMV1Health(ctx, cfg, client)
+	// the result of a canceled probe says nothing about the credential
+	if ctx.Err() == nil {
+		fcmHealthChecksLock.Lock()
+		fcmHealthChecks[key] = fcmHealthCheck{at: time.Now(), err: err}
+		fcmHealthChecksLock.Unlock()
+	}
+	return err
+}
+
+func checkFCMV1Health(ctx context.Context, cfg *config.ConfYaml, client FCMClient) error {
+	ctx, cancel := fcmV1Context(ctx, cfg)
+	defer cancel()
+
+	// the messaging client hides why the token can't be fetched
+	creds, err := gtransport.Creds(ctx, fcmV1ClientOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("fcm health check: %w", err)
+	}
+
+	if _, err := creds.TokenSource.Token(); err != nil {
+		return fmt.Errorf("fcm health check: %w", err)
+	}
+
+	_, err = client.SendDryRun(ctx, &messaging.Message{Token: fcmHealthCheckToken})
+	if err == nil || !isFCMAuthError(err) {
+		return nil
+	}
+
+	return fmt.Errorf("fcm health check: %w", err)
+}
+
+// isFCMAuthError reports whether the credential isn't allowed to send.
+func isFCMAuthError(err error) bool {
+	return fcmErrorType(err) == ErrorTypeAuth ||
+		errorutils.IsUnauthenticated(err) ||
+		errorutils.IsPermissionDenied(err)
+}
+
+// fcmV1ClientOptions returns the options of the firebase app.
+func fcmV1ClientOptions(cfg *config.ConfYaml) []option.ClientOption {
+	opts := []option.ClientOption{
+		option.WithScopes(firebaseMessagingScope),
+	}
+
+	switch {
+	case cfg.Android.Credential != "":
+		if cfg.Android.ServiceAccountKey != "" {
+			logx.LogAccess.Warn("both android credential and service_account_key are set, the credential is used")
+		}
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.Android.Credential)))
+	default:
+		opts = append(opts, option.WithCredentialsFile(cfg.Android.ServiceAccountKey))
+	}
+
+	if cfg.Android.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Android.Endpoint))
+	}
+
+	return opts
+}
+
+// fcmV1Transport returns the transport tuned with android.http_transport and
+// routed through android.proxy, nil is returned when nothing is set so the
+// default transport is used. Both honor the HTTPS_PROXY environment variable
+// without android.proxy.
+func fcmV1Transport(cfg *config.ConfYaml) (*http.Transport, error) {
+	t := cfg.Android.HTTPTransport
+	if t == (config.SectionHTTPTransport{}) && cfg.Android.Proxy == "" && cfg.Android.CACertFile == "" {
+		return nil, nil
+	}
+
+	// same as the default transport of the google api client
+	trans := http.DefaultTransport.(*http.Transport).Clone()
+	trans.MaxIdleConnsPerHost = 100
+
+	if t.MaxIdleConns > 0 {
+		trans.MaxIdleConns = t.MaxIdleConns
+	}
+
+	if t.MaxIdleConnsPerHost > 0 {
+		trans.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+
+	if t.IdleConnTimeout > 0 {
+		trans.IdleConnTimeout = time.Duration(t.IdleConnTimeout) * time.Second
+	}
+
+	if t.KeepAlive > 0 {
+		trans.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: time.Duration(t.KeepAlive) * time.Second,
+		}).DialContext
+	}
+
+	if cfg.Android.Proxy != "" {
+		proxyURL, err := parseProxyURL(cfg.Android.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		trans.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.Android.CACertFile != "" {
+		pool, err := fcmV1CertPool(cfg.Android.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		trans.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return trans, nil
+}
+
+// fcmV1CertPool returns the system cert pool with the CAs of the PEM bundle
+// at path.
+func fcmV1CertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// parseProxyURL parses the URL of an http, https or socks5 proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.ParseRequestURI(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("missing proxy host in %q", proxy)
+	}
+
+	return proxyURL, nil
+}
+
+func fcmV1ClientKey(projectID, serviceAccountKey string) string {
+	return projectID + ":" + serviceAccountKey
+}
+
+func PushToAndroidV1(ctx context.Context, req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePush, err error) {
+	ctx = requestContext(ctx, req)
+	logx.AccessEntry(ctx).Debug("Start push notification for Android V1")
+
+	// check message
+	err = checkAndroidMessageV1(ctx, req, cfg)
+	if err != nil {
+		logx.ErrorEntry(ctx).Error("request error: " + err.Error())
+		return nil, err
+	}
+	defer func() { echoUserIDs(req, resp) }()
+
+	// the tokens of a batch are loaded at the send, the queue only carries
+	// its ID. The failed tokens are kept inline by the dead letter store.
+	if batchID := req.BatchID; batchID != "" {
+		tokens, err := loadTokenBatch(req)
+		if err != nil {
+			logx.ErrorEntry(ctx).Errorf("token batch %s error: %s", batchID, err.Error())
+			return nil, err
+		}
+		req.BatchID, req.Tokens = "", tokens
+		defer func() { req.BatchID, req.Tokens = batchID, nil }()
+
+		// the per token fields and the token count are checked against the
+		// tokens of the batch
+		if err = checkTokenFields(req); err == nil {
+			err = CheckTokenCount(req, cfg)
+		}
+		if err != nil {
+			logx.ErrorEntry(ctx).Error("request error: " + err.Error())
+			return nil, err
+		}
+	}
+
+	// the sends fail fast while Android is disabled at runtime
+	if !PlatformEnabled(core.PlatFormAndroid) {
+		logx.ErrorEntry(ctx).Error("FCM V1 send skipped: " + ErrPlatformDisabled.Error())
+		return disabledAndroidResponse(req, cfg), ErrPlatformDisabled
+	}
+
+	if err = AndroidTransformer.Transform(cfg, req); err != nil {
+		logx.ErrorEntry(ctx).Error("transformer error: " + err.Error())
+		return nil, err
+	}
+
+	// the valid notifications are in flight until they are sent
+	status.StatStorage.IncInFlight()
+	defer status.StatStorage.DecInFlight()
+
+	resp = &ResponsePush{RequestID: req.RequestID}
+
+	notification, err := getAndroidNotificationV1(req, cfg)
+	if err != nil {
+		// FCM server error
+		logx.ErrorEntry(ctx).Error("FCM V1 server error: " + err.Error())
+		return resp, err
+	}
+	logDebugFCMRequest(req, notification)
+
+	client, err := InitFCMV1Client(ctx, cfg, req.ProjectID)
+	if err != nil {
+		// FCM server error
+		logx.ErrorEntry(ctx).Error("FCM V1 server error: " + err.Error())
+		return resp, err
+	}
+
+	// a device group is sent like a topic, with a single message
+	if req.IsTopic() || androidDeviceGroup(req) != "" {
+		topicCtx, cancel := fcmV1Context(ctx, cfg)
+		defer cancel()
+
+		return pushTopicToAndroidV1(topicCtx, client, req, cfg, notification)
+	}
+
+	var (
+		retryCount = 0
+		maxRetry   = cfg.Android.MaxRetry
+	)
+
+	if req.Retry > 0 && req.Retry < maxRetry {
+		maxRetry = req.Retry
+	}
+
+	resp.Total = len(req.Tokens)
+
+	// the templates are rendered ahead of the dedup, the tokens which failed
+	// to render aren't sent
+	var renderErrs map[int]error
+	if req.Template != nil {
+		titles, bodies := req.Titles, req.Bodies
+		req.Titles, req.Bodies, renderErrs = renderAndroidTemplate(req)
+		defer func() { req.Titles, req.Bodies = titles, bodies }()
+	}
+
+	// the position of every token in the original request, kept across retries.
+	// The skipped and the retried tokens are selected in req.Tokens, restored
+	// once sent.
+	tokens := req.Tokens
+	indexes := make([]int, len(tokens))
+	for k := range indexes {
+		indexes[k] = k
+	}
+	defer func() { req.Tokens = tokens }()
+
+	// the duplicate tokens are sent once, the positions of the duplicates by
+	// position of the token sent
+	var duplicates map[int][]int
+	if cfg.Android.DedupTokens {
+		if indexes, duplicates = dedupAndroidTokens(req); len(duplicates) > 0 {
+			logx.AccessEntry(ctx).Debugf("skip %d duplicate tokens", len(tokens)-len(indexes))
+			req.Tokens = selectTokens(tokens, indexes)
+		}
+	}
+
+	// the malformed tokens fail without a FCM round trip
+	if valid := skipMalformedAndroidTokens(ctx, req, cfg, tokens, indexes, resp); len(valid) < len(indexes) {
+		indexes = valid
+		req.Tokens = selectTokens(tokens, indexes)
+	}
+
+	if valid := skipUnrenderedAndroidTokens(ctx, req, cfg, tokens, indexes, renderErrs, resp); len(valid) < len(indexes) {
+		indexes = valid
+		req.Tokens = selectTokens(tokens, indexes)
+	}
+
+	// the tokens over their rate limit aren't sent, nor retried
+	if valid := skipThrottledAndroidTokens(ctx, req, cfg, tokens, indexes, resp); len(valid) < len(indexes) {
+		indexes = valid
+		req.Tokens = selectTokens(tokens, indexes)
+	}
+
+	// the last error of the batch of every token, for the batch handler
+	batchErrs := make(map[int]error, len(indexes))
+
+Retry:
+	var (
+		newIndexes []int
+		sendErr    error
+	)
+
+	delivered := resp.Success
+	results := pushBatchesToAndroidV1(ctx, client, req, cfg, notification, indexes)
+	for _, result := range results {
+		resp.Logs = append(resp.Logs, result.resp.Logs...)
+		resp.InvalidTokens = append(resp.InvalidTokens, result.resp.InvalidTokens...)
+		resp.Success += result.resp.Success
+
+		if result.err != nil {
+			sendErr = result.err
+			if !isRetryableFCMError(result.err) {
+				putAndroidDeadLetter(req, result)
+			}
+		}
+		newIndexes = append(newIndexes, result.retryIndexes...)
+		for _, k := range result.indexes {
+			batchErrs[k] = result.err
+		}
+	}
+	recordRetry("android", retryCount, len(indexes), resp.Success-delivered)
+
+	if len(newIndexes) > 0 && retryCount < maxRetry {
+		retryCount++
+
+		wait := fcmV1RetryBackoff(time.Duration(cfg.Android.RetryInterval)*time.Second, retryCount)
+		logx.AccessEntry(ctx).Infof("FCM V1 retry %d/%d for %d tokens in %s", retryCount, maxRetry, len(newIndexes), wait)
+
+		// the canceled retries complete like the exhausted ones
+		select {
+		case <-ctx.Done():
+			sendErr = ctx.Err()
+		case <-time.After(wait):
+			// resend fail token
+			indexes = newIndexes
+			req.Tokens = selectTokens(tokens, indexes)
+			goto Retry
+		}
+	}
+
+	// the batches failed entirely aren't resent anymore
+	for _, result := range results {
+		if result.err != nil && isRetryableFCMError(result.err) {
+			putAndroidDeadLetter(req, result)
+		}
+	}
+
+	fanOutDuplicates(resp, tokens, duplicates)
+	resp.Failure = resp.Total - resp.Success
+
+	if req.Fallback {
+		var unregistered []int
+		for _, l := range resp.Logs {
+			if l.ErrorType == ErrorTypeInvalidToken && l.Index != nil {
+				unregistered = append(unregistered, *l.Index)
+			}
+		}
+		pushFallback(ctx, req, cfg, unregistered, resp)
+	}
+
+	// a single failed token fails the whole notification
+	if req.FailFast {
+		if err := failFastError(resp); err != nil {
+			resp.Error = err.Error()
+			if sendErr == nil {
+				sendErr = err
+			}
+		}
+	}
+
+	if handler, _ := ctx.Value(batchHandlerKey{}).(BatchHandler); handler != nil {
+		handleAndroidBatches(handler, resp, tokens, batchErrs, duplicates)
+	}
+
+	return resp, sendErr
+}
+
+// handleAndroidBatches reports the final result of every batch of 500 tokens
+// of the request to the handler, in the token order. The retries, the
+// duplicates and the fallbacks of a token are reported with its batch, err is
+// the last error of the batch sent.
+func handleAndroidBatches(
+	handler BatchHandler,
+	resp *ResponsePush,
+	tokens []string,
+	errs map[int]error,
+	duplicates map[int][]int,
+) {
+	for k, positions := range duplicates {
+		for _, d := range positions {
+			errs[d] = errs[k]
+		}
+	}
+
+	batches := make([]ResponsePush, (len(tokens)+fcmMulticastLimit-1)/fcmMulticastLimit)
+	for i := range batches {
+		batches[i] = ResponsePush{
+			RequestID: resp.RequestID,
+			Total:     min(fcmMulticastLimit, len(tokens)-i*fcmMulticastLimit),
+		}
+	}
+
+	for _, l := range resp.Logs {
+		if l.Index == nil {
+			continue
+		}
+
+		batch := &batches[*l.Index/fcmMulticastLimit]
+		batch.Logs = append(batch.Logs, l)
+		// the logs of the fallback tokens aren't counted
+		if l.Platform != fallbackChannels[core.PlatFormAndroid] {
+			continue
+		}
+		switch {
+		case l.Type == core.SucceededPush:
+			batch.Success++
+		case l.ErrorType == ErrorTypeInvalidToken:
+			batch.InvalidTokens = append(batch.InvalidTokens, tokens[*l.Index])
+		}
+	}
+	for _, f := range resp.Fallbacks {
+		batch := &batches[f.Index/fcmMulticastLimit]
+		batch.Fallbacks = append(batch.Fallbacks, f)
+	}
+
+	for i := range batches {
+		batch := &batches[i]
+		batch.Failure = batch.Total - batch.Success
+
+		var err error
+		for k := i * fcmMulticastLimit; k < i*fcmMulticastLimit+batch.Total && err == nil; k++ {
+			err = errs[k]
+		}
+		handler(batch, err)
+	}
+}
+
+// failFastError returns the ErrFailFast error of the failed token with the
+// lowest index of the response, nil when every token succeeded. The failures
+// of the tokens which succeeded on a retry are ignored.
+func failFastError(resp *ResponsePush) error {
+	succeeded := make(map[int]bool)
+	for _, l := range resp.Logs {
+		if l.Type == core.SucceededPush && l.Index != nil {
+			succeeded[*l.Index] = true
+		}
+	}
+
+	var first *logx.LogPushEntry
+	for k := range resp.Logs {
+		l := &resp.Logs[k]
+		if l.Type != core.FailedPush || (l.Index != nil && succeeded[*l.Index]) {
+			continue
+		}
+		if first == nil || (l.Index != nil && (first.Index == nil || *l.Index < *first.Index)) {
+			first = l
+		}
+	}
+
+	switch {
+	case first == nil:
+		return nil
+	case first.Index == nil:
+		return fmt.Errorf("%w: %s", ErrFailFast, first.Error)
+	default:
+		return fmt.Errorf("%w: the token at index %d failed: %s", ErrFailFast, *first.Index, first.Error)
+	}
+}
+
+// checkAndroidMessageV1 checks the message with CheckMessage and the
+// android settings, time_to_live is clamped with android.clamp_ttl.
+func checkAndroidMessageV1(ctx context.Context, req *PushNotification, cfg *config.ConfYaml) error {
+	if cfg.Android.ClampTTL && req.TimeToLive != nil && *req.TimeToLive > fcmMaxTTL {
+		logx.AccessEntry(ctx).Debugf("clamp the message's TimeToLive from %d to %d", *req.TimeToLive, fcmMaxTTL)
+		ttl := fcmMaxTTL
+		req.TimeToLive = &ttl
+	}
+
+	if err := CheckMessage(req); err != nil {
+		return err
+	}
+
+	if req.AutoCollapse && req.CollapseKey == "" {
+		key, err := autoCollapseKey(req, cfg.Android.AutoCollapseFields)
+		if err != nil {
+			logx.AccessEntry(ctx).Debug(err.Error())
+			return err
+		}
+		req.CollapseKey = key
+	}
+
+	if cfg.Android.StrictTokenValidation {
+		return checkAndroidTokens(req)
+	}
+	return nil
+}
+
+// fcmMaxCollapseKeyLength is the max length of the FCM collapse keys.
+const fcmMaxCollapseKeyLength = 32
+
+// autoCollapseKey joins the values of the data keys of fields with ":", the
+// same values always give the same collapse key.
+func autoCollapseKey(req *PushNotification, fields []string) (string, error) {
+	if len(fields) == 0 {
+		// the server config is missing, not the request
+		return "", &ValidationError{
+			Status: http.StatusInternalServerError,
+			Err:    errors.New("the auto collapse requires android.auto_collapse_fields"),
+		}
+	}
+
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		v, ok := req.Data[field]
+		if !ok || v == nil {
+			return "", fmt.Errorf("the auto collapse requires the data key %s", field)
+		}
+		values = append(values, fmt.Sprint(v))
+	}
+
+	key := strings.Join(values, ":")
+	if len(key) > fcmMaxCollapseKeyLength {
+		return "", fmt.Errorf("the auto collapse key must be at most %d characters, got %d",
+			fcmMaxCollapseKeyLength, len(key))
+	}
+	return key, nil
+}
+
+// checkFCMToken checks the token is non-empty and within the length and
+// the charset of the FCM registration tokens.
+func checkFCMToken(token string) error {
+	switch {
+	case strings.TrimSpace(token) == "":
+		return fmt.Errorf("%w: the token must not be empty", ErrFCMTokenFormat)
+	case len(token) > fcmMaxTokenLength:
+		return fmt.Errorf("%w: the token must be at most %d bytes, got %d", ErrFCMTokenFormat, fcmMaxTokenLength, len(token))
+	case !fcmTokenPattern.MatchString(token):
+		return fmt.Errorf("%w: the token must match %s", ErrFCMTokenFormat, fcmTokenPattern.String())
+	}
+	return nil
+}
+
+// checkAndroidTokens rejects the request if one of its tokens is malformed,
+// it's used with android.strict_token_validation.
+func checkAndroidTokens(req *PushNotification) error {
+	for k, token := range req.Tokens {
+		if err := checkFCMToken(token); err != nil {
+			return fmt.Errorf("the token at index %d is invalid: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// disabledAndroidResponse fails the tokens, or the topic, of the request with
+// ErrPlatformDisabled.
+func disabledAndroidResponse(req *PushNotification, cfg *config.ConfYaml) *ResponsePush {
+	resp := &ResponsePush{RequestID: req.RequestID}
+
+	if req.IsTopic() || androidDeviceGroup(req) != "" {
+		resp.Logs = append(resp.Logs, logPushFCMError(cfg, androidTopicTarget(req), req, ErrPlatformDisabled))
+	}
+	for k, token := range req.Tokens {
+		index := k
+		errLog := logPushFCMError(cfg, token, req, ErrPlatformDisabled)
+		errLog.Index = &index
+		resp.Logs = append(resp.Logs, errLog)
+	}
+
+	resp.Total = len(resp.Logs)
+	resp.Failure = len(resp.Logs)
+	status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(len(resp.Logs)))
+	return resp
+}
+
+// skipMalformedAndroidTokens records a failed log with the invalid_format
+// error type for the malformed tokens at indexes and returns the indexes of
+// the others.
+func skipMalformedAndroidTokens(
+	ctx context.Context,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	tokens []string,
+	indexes []int,
+	resp *ResponsePush,
+) []int {
+	valid := make([]int, 0, len(indexes))
+	for _, k := range indexes {
+		err := checkFCMToken(tokens[k])
+		if err == nil {
+			valid = append(valid, k)
+			continue
+		}
+
+		logx.AccessEntry(ctx).Debugf("skip the token at index %d: %s", k, err.Error())
+		index := k
+		errLog := logPushFCMError(cfg, tokens[k], req, err)
+		errLog.Index = &index
+		resp.Logs = append(resp.Logs, errLog)
+	}
+
+	if skipped := len(indexes) - len(valid); skipped > 0 {
+		status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(skipped))
+	}
+	return valid
+}
+
+// dedupAndroidTokens returns the positions of the first occurrence of every
+// token and the positions of its duplicates. The tokens with distinct
+// analytics labels, titles or bodies aren't duplicates.
+func dedupAndroidTokens(req *PushNotification) ([]int, map[int][]int) {
+	value := func(values []string, k int) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[k]
+	}
+
+	first := make(map[string]int, len(req.Tokens))
+	indexes := make([]int, 0, len(req.Tokens))
+	duplicates := make(map[int][]int)
+	for k, token := range req.Tokens {
+		key := strings.Join([]string{
+			token, value(req.AnalyticsLabels, k), value(req.Titles, k), value(req.Bodies, k),
+		}, "\x00")
+
+		if j, ok := first[key]; ok {
+			duplicates[j] = append(duplicates[j], k)
+			continue
+		}
+		first[key] = k
+		indexes = append(indexes, k)
+	}
+
+	return indexes, duplicates
+}
+
+// fanOutDuplicates copies the logs of the tokens sent to the positions of
+// their duplicates, which count as sent the same way.
+func fanOutDuplicates(resp *ResponsePush, tokens []string, duplicates map[int][]int) {
+	if len(duplicates) == 0 {
+		return
+	}
+
+	logs := resp.Logs
+	for _, l := range logs {
+		if l.Index == nil {
+			continue
+		}
+
+		for _, k := range duplicates[*l.Index] {
+			index := k
+			l.Index = &index
+			resp.Logs = append(resp.Logs, l)
+
+			switch {
+			case l.Type == core.SucceededPush:
+				resp.Success++
+			case l.ErrorType == ErrorTypeInvalidToken:
+				resp.InvalidTokens = append(resp.InvalidTokens, tokens[k])
+			}
+		}
+	}
+}
+
+// putAndroidDeadLetter stores the notification of a batch failed entirely.
+func putAndroidDeadLetter(req *PushNotification, result fcmV1BatchResult) {
+	notification := *req
+	notification.Tokens = result.tokens
+	notification.AnalyticsLabels = pickIndexes(req.AnalyticsLabels, result.indexes)
+	notification.Titles = pickIndexes(req.Titles, result.indexes)
+	notification.Bodies = pickIndexes(req.Bodies, result.indexes)
+	notification.UserIDs = pickIndexes(req.UserIDs, result.indexes)
+	putDeadLetter(&notification, result.err)
+}
+
+// selectTokens returns the tokens at the indexes.
+func selectTokens(tokens []string, indexes []int) []string {
+	selected := make([]string, 0, len(indexes))
+	for _, k := range indexes {
+		selected = append(selected, tokens[k])
+	}
+	return selected
+}
+
+// pickIndexes returns the values at the indexes, nil for empty values.
+func pickIndexes(values []string, indexes []int) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	picked := make([]string, 0, len(indexes))
+	for _, k := range indexes {
+		picked = append(picked, values[k])
+	}
+	return picked
+}
+
+// echoUserIDs sets the user ID of the token of the logs, by their index in
+// the request.
+func echoUserIDs(req *PushNotification, resp *ResponsePush) {
+	if resp == nil || len(req.UserIDs) == 0 {
+		return
+	}
+
+	for k := range resp.Logs {
+		if l := &resp.Logs[k]; l.Index != nil && *l.Index < len(req.UserIDs) {
+			l.UserID = req.UserIDs[*l.Index]
+		}
+	}
+}
+
+// fcmV1BatchResult is the result of a batch of at most 500 tokens.
+type fcmV1BatchResult struct {
+	tokens       []string
+	indexes      []int
+	resp         *ResponsePush
+	retryIndexes []int
+	err          error
+}
+
+// pushBatchesToAndroidV1 splits the tokens into batches of at most 500 tokens
+// and sends android.concurrency batches at the same time. The results are in
+// the order of the tokens, indexes are the positions of the tokens in the
+// original request.
+func pushBatchesToAndroidV1(
+	ctx context.Context,
+	client FCMClient,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	notification *messaging.MulticastMessage,
+	indexes []int,
+) []fcmV1BatchResult {
+	concurrency := cfg.Android.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]fcmV1BatchResult, (len(req.Tokens)+fcmMulticastLimit-1)/fcmMulticastLimit)
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range results {
+		start := i * fcmMulticastLimit
+		end := min(start+fcmMulticastLimit, len(req.Tokens))
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(result *fcmV1BatchResult, tokens []string, indexes []int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			result.tokens = tokens
+			result.indexes = indexes
+			result.resp = &ResponsePush{Total: len(tokens)}
+			result.retryIndexes, result.err = pushBatchToAndroidV1(
+				ctx, client, req, cfg, notification, tokens, indexes, result.resp,
+			)
+			result.resp.Failure = result.resp.Total - result.resp.Success
+		}(&results[i], req.Tokens[start:end], indexes[start:end])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// pushBatchToAndroidV1 sends the message to a batch of at most 500 tokens and
+// records the results into resp. The indexes of the tokens which may be
+// retried are returned.
+func pushBatchToAndroidV1(
+	ctx context.Context,
+	client FCMClient,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	notification *messaging.MulticastMessage,
+	tokens []string,
+	indexes []int,
+	resp *ResponsePush,
+) ([]int, error) {
+	var newIndexes []int
+
+	batch := *downgradeAndroidPriority(ctx, cfg, req, notification, len(tokens))
+	batch.Tokens = tokens
+
+	var (
+		res     *messaging.BatchResponse
+		release func()
+	)
+	breaker := fcmV1Breaker(cfg, req)
+	err := waitFCMV1RateLimit(ctx, cfg, req, len(tokens))
+	if err == nil {
+		err = breaker.Allow()
+	}
+	if err == nil {
+		release, err = acquirePush(ctx)
+	}
+	if err == nil {
+		sendCtx, cancel := fcmV1Context(ctx, cfg)
+		defer cancel()
+
+		start := time.Now()
+		if len(req.AnalyticsLabels) > 0 || len(req.Titles) > 0 || len(req.Bodies) > 0 {
+			res, err = sendEachV1(sendCtx, client, req, &batch, indexes)
+		} else {
+			res, err = sendEachForMulticastV1(sendCtx, client, req, &batch)
+		}
+		observeSendLatency("android", start, err != nil || res.SuccessCount == 0)
+		release()
+
+		// the canceled requests say nothing about FCM
+		if ctx.Err() == nil {
+			breaker.Record(isFCMOutage(res, err))
+		}
+		recordFCMQuotaError(cfg, req, res, err)
+	}
+	if err != nil {
+		// Send Message error
+		logx.ErrorEntry(ctx).Error("FCM server send message error: " + err.Error())
+		logDebug(req, "fcm response", map[string]interface{}{
+			"tokens": hashTokens(tokens),
+			"error":  err.Error(),
+		})
+
+		for k, token := range tokens {
+			errLog := logPushFCMError(cfg, token, req, err)
+			errLog.Index = &indexes[k]
+			resp.Logs = append(resp.Logs, errLog)
+		}
+
+		status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(len(tokens)))
+
+		if isRetryableFCMError(err) {
+			newIndexes = indexes
+		}
+
+		return newIndexes, err
+	}
+
+	status.StatStorage.AddAndroidSuccessByTenant(req.TenantID, int64(res.SuccessCount))
+	status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(res.FailureCount))
+	resp.Success += res.SuccessCount
+
+	// result from Send messages to specific devices
+	for k, result := range res.Responses {
+		if k >= len(tokens) {
+			break
+		}
+		to := tokens[k]
+		logDebugFCMResponse(req, to, indexes[k], result)
+
+		if result.Error != nil {
+			errLog := logPushFCMError(cfg, to, req, result.Error)
+			errLog.Index = &indexes[k]
+			resp.Logs = append(resp.Logs, errLog)
+			if errLog.ErrorType == ErrorTypeInvalidToken {
+				resp.InvalidTokens = append(resp.InvalidTokens, to)
+				recordInvalidToken(to, fcmStatErrorType(result.Error))
+			}
+			if isRetryableFCMError(result.Error) {
+				newIndexes = append(newIndexes, indexes[k])
+			}
+			continue
+		}
+
+		recordDelivery(req, result.MessageID, to)
+		resp.Logs = append(resp.Logs, logPushInput(cfg, req, &logx.InputLog{
+			Status:    core.SucceededPush,
+			Token:     to,
+			MessageID: result.MessageID,
+			Index:     &indexes[k],
+		}))
+	}
+
+	return newIndexes, nil
+}
+
+// sendEachForMulticastV1 sends the message to every token, dry run messages
+// are only validated by FCM.
+func sendEachForMulticastV1(
+	ctx context.Context,
+	client FCMClient,
+	req *PushNotification,
+	m *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	if req.DryRun {
+		return client.SendEachForMulticastDryRun(ctx, m)
+	}
+
+	return client.SendEachForMulticast(ctx, m)
+}
+
+// sendEachV1 sends the message to the tokens one by one with the
+// analytics label, title and body of each token, indexes are the positions of
+// the tokens in the request. The results are collected like SendEachForMulticast.
+func sendEachV1(
+	ctx context.Context,
+	client FCMClient,
+	req *PushNotification,
+	m *messaging.MulticastMessage,
+	indexes []int,
+) (*messaging.BatchResponse, error) {
+	res := &messaging.BatchResponse{}
+	for k, token := range m.Tokens {
+		label := req.AnalyticsLabel
+		if len(req.AnalyticsLabels) > 0 && req.AnalyticsLabels[indexes[k]] != "" {
+			label = req.AnalyticsLabels[indexes[k]]
+		}
+
+		msg := &messaging.Message{
+			Data:         m.Data,
+			Notification: m.Notification,
+			Android:      m.Android,
+			Webpush:      m.Webpush,
+			APNS:         m.APNS,
+			FCMOptions:   m.FCMOptions,
+			Token:        token,
+		}
+
+		if label != "" {
+			msg.FCMOptions = &messaging.FCMOptions{AnalyticsLabel: label}
+			if m.Android != nil {
+				android := *m.Android
+				android.FCMOptions = &messaging.AndroidFCMOptions{AnalyticsLabel: label}
+				msg.Android = &android
+			}
+		}
+
+		personalizeV1(msg, req, indexes[k])
+
+		messageID, err := sendV1(ctx, client, req, msg)
+		if err != nil {
+			res.FailureCount++
+		} else {
+			res.SuccessCount++
+		}
+		res.Responses = append(res.Responses, &messaging.SendResponse{
+			Success:   err == nil,
+			MessageID: messageID,
+			Error:     err,
+		})
+	}
+
+	return res, nil
+}
+
+// personalizeV1 overrides the notification title and body of the message
+// with the ones of the token at index k, data only messages are unchanged.
+func personalizeV1(msg *messaging.Message, req *PushNotification, k int) {
+	var title, body string
+	if len(req.Titles) > 0 {
+		title = req.Titles[k]
+	}
+	if len(req.Bodies) > 0 {
+		body = req.Bodies[k]
+	}
+	if title == "" && body == "" {
+		return
+	}
+
+	if msg.Notification != nil {
+		notification := *msg.Notification
+		if title != "" {
+			notification.Title = title
+		}
+		if body != "" {
+			notification.Body = body
+		}
+		msg.Notification = &notification
+	}
+
+	if msg.Android != nil && msg.Android.Notification != nil {
+		android := *msg.Android
+		notification := *android.Notification
+		if title != "" {
+			notification.Title = title
+		}
+		if body != "" {
+			notification.Body = body
+		}
+		android.Notification = &notification
+		msg.Android = &android
+	}
+}
+
+// sendV1 sends a single message, dry run messages are only validated by FCM.
+func sendV1(ctx context.Context, client FCMClient, req *PushNotification, m *messaging.Message) (string, error) {
+	if req.DryRun {
+		return client.SendDryRun(ctx, m)
+	}
+
+	return client.Send(ctx, m)
+}
+
+// fcmV1Limiter returns the rate limiter of the project, the limiter is
+// created on first use.
+func fcmV1Limiter(cfg *config.ConfYaml, projectID string) *rate.Limiter {
+	fcmV1LimitersLock.Lock()
+	defer fcmV1LimitersLock.Unlock()
+
+	limiter, ok := fcmV1Limiters[projectID]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(cfg.Android.RateLimit), cfg.Android.RateLimit)
+		fcmV1Limiters[projectID] = limiter
+	}
+
+	return limiter
+}
+
+// fcmV1ProjectID returns the project the request is sent to.
+func fcmV1ProjectID(cfg *config.ConfYaml, req *PushNotification) string {
+	if req.ProjectID != "" {
+		return req.ProjectID
+	}
+
+	return cfg.Android.ProjectID
+}
+
+// waitFCMV1RateLimit blocks until n messages may be sent to the project of
+// the request, at most android.rate_limit_wait seconds.
+func waitFCMV1RateLimit(ctx context.Context, cfg *config.ConfYaml, req *PushNotification, n int) error {
+	if cfg.Android.RateLimit <= 0 {
+		return nil
+	}
+
+	limiter := fcmV1Limiter(cfg, fcmV1ProjectID(cfg, req))
+
+	wait := cfg.Android.RateLimitWait > 0
+	if wait {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Android.RateLimitWait)*time.Second)
+		defer cancel()
+	}
+
+	// a batch may be larger than the bucket
+	for n > 0 {
+		k := min(n, limiter.Burst())
+		if wait {
+			if err := limiter.WaitN(ctx, k); err != nil {
+				return fmt.Errorf("%w: %v", ErrFCMRateLimited, err)
+			}
+		} else if !limiter.AllowN(time.Now(), k) {
+			return ErrFCMRateLimited
+		}
+		n -= k
+	}
+
+	return nil
+}
+
+// isRetryableFCMError reports whether the push may succeed when it's sent again.
+func isRetryableFCMError(err error) bool {
+	switch fcmErrorType(err) {
+	case ErrorTypeServer, ErrorTypeTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// fcmV1RetryBackoff doubles the interval on each attempt and picks a random
+// wait between half and the full backoff.
+func fcmV1RetryBackoff(interval time.Duration, attempt int) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	backoff := interval << (attempt - 1)
+	/* #nosec */
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// pushTopicToAndroidV1 sends a single FCM message to a topic, condition or
+// device group.
+func pushTopicToAndroidV1(
+	ctx context.Context,
+	client FCMClient,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	notification *messaging.MulticastMessage,
+) (*ResponsePush, error) {
+	resp := &ResponsePush{Total: 1, RequestID: req.RequestID}
+
+	to := androidTopicTarget(req)
+
+	var (
+		messageID string
+		release   func()
+	)
+	notification = downgradeAndroidPriority(ctx, cfg, req, notification, 1)
+	breaker := fcmV1Breaker(cfg, req)
+	err := waitFCMV1RateLimit(ctx, cfg, req, 1)
+	if err == nil {
+		err = breaker.Allow()
+	}
+	if err == nil {
+		release, err = acquirePush(ctx)
+	}
+	if err == nil {
+		start := time.Now()
+		messageID, err = sendV1(ctx, client, req, getAndroidTopicMessageV1(req, notification))
+		observeSendLatency("android", start, err != nil)
+		release()
+
+		// the canceled requests say nothing about FCM
+		if ctx.Err() == nil {
+			breaker.Record(isFCMOutage(nil, err))
+		}
+		recordFCMQuotaError(cfg, req, nil, err)
+	}
+	logDebugFCMResponse(req, to, -1, &messaging.SendResponse{
+		Success:   err == nil,
+		MessageID: messageID,
+		Error:     err,
+	})
+	if err != nil {
+		logx.ErrorEntry(ctx).Error("FCM server send message error: " + err.Error())
+
+		errLog := logPushFCMError(cfg, to, req, err)
+		resp.Logs = append(resp.Logs, errLog)
+
+		status.StatStorage.AddAndroidErrorByTenant(req.TenantID, 1)
+		resp.Failure = 1
+		putDeadLetter(req, err)
+		return resp, err
+	}
+
+	status.StatStorage.AddAndroidSuccessByTenant(req.TenantID, 1)
+	resp.Success = 1
+	recordDelivery(req, messageID, to)
+	resp.Logs = append(resp.Logs, logPushInput(cfg, req, &logx.InputLog{
+		Status:    core.SucceededPush,
+		Token:     to,
+		MessageID: messageID,
+	}))
+
+	return resp, nil
+}
+
+// logDebugFCMRequest records the message sent to FCM when the request asks
+// for verbose logs.
+func logDebugFCMRequest(req *PushNotification, notification *messaging.MulticastMessage) {
+	if !req.Debug {
+		return
+	}
+
+	payload := *notification
+	payload.Tokens = hashTokens(notification.Tokens)
+	b, err := json.Marshal(&payload)
+	if err != nil {
+		logx.LogError.Error("FCM V1 payload error: " + err.Error())
+		return
+	}
+
+	logDebug(req, "fcm request", map[string]interface{}{
+		"payload": string(b),
+	})
+}
+
+// logDebugFCMResponse records the FCM response of the token when the request
+// asks for verbose logs, the index is negative for the topic messages.
+func logDebugFCMResponse(req *PushNotification, token string, index int, res *messaging.SendResponse) {
+	if !req.Debug {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"token":   logx.HashToken(token),
+		"success": res.Success,
+	}
+	if req.IsTopic() {
+		// the topics and conditions aren't sensitive
+		fields["token"] = token
+	}
+	if index >= 0 {
+		fields["index"] = index
+	}
+	if res.MessageID != "" {
+		fields["message_id"] = res.MessageID
+	}
+	if res.Error != nil {
+		fields["error"] = res.Error.Error()
+		fields["error_type"] = fcmErrorType(res.Error)
+	}
+
+	logDebug(req, "fcm response", fields)
+}
+
+// fcmV1Context limits the sending time to android.timeout seconds.
+func fcmV1Context(ctx context.Context, cfg *config.ConfYaml) (context.Context, context.CancelFunc) {
+	if cfg.Android.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(cfg.Android.Timeout)*time.Second)
+}
+
+// logPushFCMError records the failed push along with the FCM error type, the
+// failure is counted by error type in the stat storage.
+func logPushFCMError(cfg *config.ConfYaml, token string, req *PushNotification, err error) logx.LogPushEntry {
+	status.StatStorage.AddAndroidErrorByType(fcmStatErrorType(err))
+
+	errLog := logPushInput(cfg, req, &logx.InputLog{
+		Status:    core.FailedPush,
+		Token:     token,
+		Error:     err,
+		ErrorType: fcmErrorType(err),
+		ErrorCode: fcmErrorCode(err),
+	})
+
+	if req.IncludeRawError {
+		errLog.RawError = err.Error()
+		errLog.RawErrorCode, errLog.RawHTTPStatus = fcmRawError(err)
+	}
+
+	return errLog
+}
+
+// fcmRawError returns the error code and the HTTP status of the FCM
+// response, the code is the FCM error code if any or else the status of the
+// error, e.g. UNREGISTERED or NOT_FOUND.
+func fcmRawError(err error) (string, int) {
+	res := errorutils.HTTPResponse(err)
+	if res == nil || res.Body == nil {
+		return "", 0
+	}
+
+	body, readErr := io.ReadAll(res.Body)
+	// keep the body readable for the other callers
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return "", res.StatusCode
+	}
+
+	var googleError struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &googleError); err != nil {
+		return "", res.StatusCode
+	}
+
+	for _, detail := range googleError.Error.Details {
+		if detail.ErrorCode != "" {
+			return detail.ErrorCode, res.StatusCode
+		}
+	}
+	return googleError.Error.Status, res.StatusCode
+}
 
-func InitFCMV1Client(ctx context.Context, cfg *config.ConfYaml) (*messaging.Client, error) {
-	if fcmV1Client != nil {
-		return fcmV1Client, nil
+// fcmErrorType classifies the error returned by FCM, an empty string is
+// returned for errors without a known type.
+func fcmErrorType(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrFCMRateLimited):
+		return ErrorTypeRateLimited
+	case errors.Is(err, ErrFCMCircuitOpen):
+		return ErrorTypeCircuitOpen
+	case errors.Is(err, ErrFCMTokenFormat):
+		return ErrorTypeInvalidFormat
+	case errors.Is(err, ErrPlatformDisabled):
+		return ErrorTypePlatformDisabled
+	case errors.Is(err, ErrFCMTokenThrottled):
+		return ErrorTypeThrottled
+	case errors.Is(err, context.DeadlineExceeded), errorutils.IsDeadlineExceeded(err):
+		return ErrorTypeTimeout
+	case messaging.IsUnregistered(err):
+		return ErrorTypeInvalidToken
+	case messaging.IsQuotaExceeded(err):
+		return ErrorTypeQuota
+	case messaging.IsUnavailable(err), messaging.IsInternal(err):
+		return ErrorTypeServer
+	case messaging.IsSenderIDMismatch(err), messaging.IsThirdPartyAuthError(err):
+		return ErrorTypeAuth
+	default:
+		return ""
 	}
+}
 
-	fmt.Printf("InitFCMV1Client ProjectID: '%s'\n", cfg.Android.ProjectID)
+// fcmErrorCode maps the error returned by FCM to the error codes of the
+// response, an empty code is returned for errors without a known code.
+func fcmErrorCode(err error) core.ErrorCode {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrFCMRateLimited), errors.Is(err, ErrFCMTokenThrottled), messaging.IsQuotaExceeded(err):
+		return core.ErrorCodeQuotaExceeded
+	case errors.Is(err, context.DeadlineExceeded), errorutils.IsDeadlineExceeded(err):
+		return core.ErrorCodeTimeout
+	case errors.Is(err, ErrFCMTokenFormat), messaging.IsUnregistered(err):
+		return core.ErrorCodeInvalidToken
+	case errors.Is(err, ErrTemplateExecution), messaging.IsInvalidArgument(err):
+		return core.ErrorCodeInvalidPayload
+	case errors.Is(err, ErrFCMCircuitOpen), errors.Is(err, ErrPlatformDisabled),
+		messaging.IsUnavailable(err), messaging.IsInternal(err):
+		return core.ErrorCodeServerError
+	case messaging.IsSenderIDMismatch(err), messaging.IsThirdPartyAuthError(err):
+		return core.ErrorCodeAuthError
+	default:
+		return ""
+	}
+}
 
-	f, err := firebase.NewApp(ctx,
-		&firebase.Config{
-			ProjectID: cfg.Android.ProjectID,
-		},
-		option.WithCredentialsFile(cfg.Android.ServiceAccountKey),
-		option.WithScopes(firebaseMessagingScope),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("InitFCMV1Client: unable to create firebase app %w", err)
+// fcmStatErrorType maps the error returned by FCM to the error types of the
+// stat storage.
+func fcmStatErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrFCMRateLimited):
+		return status.AndroidErrorRateLimited
+	case errors.Is(err, ErrFCMCircuitOpen):
+		return status.AndroidErrorCircuitOpen
+	case errors.Is(err, ErrFCMTokenFormat):
+		return status.AndroidErrorInvalidFormat
+	case errors.Is(err, ErrPlatformDisabled):
+		return status.AndroidErrorDisabled
+	case errors.Is(err, ErrFCMTokenThrottled):
+		return status.AndroidErrorThrottled
+	case errors.Is(err, context.DeadlineExceeded), errorutils.IsDeadlineExceeded(err):
+		return status.AndroidErrorTimeout
+	case messaging.IsUnregistered(err):
+		return status.AndroidErrorUnregistered
+	case errors.Is(err, ErrTemplateExecution), messaging.IsInvalidArgument(err):
+		return status.AndroidErrorInvalidArgument
+	case messaging.IsQuotaExceeded(err):
+		return status.AndroidErrorQuotaExceeded
+	case messaging.IsUnavailable(err):
+		return status.AndroidErrorUnavailable
+	case messaging.IsInternal(err):
+		return status.AndroidErrorInternal
+	case messaging.IsSenderIDMismatch(err):
+		return status.AndroidErrorSenderIDMismatch
+	case messaging.IsThirdPartyAuthError(err):
+		return status.AndroidErrorThirdPartyAuth
+	default:
+		return status.AndroidErrorUnknown
 	}
+}
 
-	client, err := f.Messaging(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("InitFCMV1Client: unable to create messaging client %w", err)
+// androidTopicTarget returns the topic, condition or device group the
+// request is sent to as a single message.
+func androidTopicTarget(req *PushNotification) string {
+	if to := androidTopic(req); to != "" {
+		return to
 	}
+	if req.Condition != "" {
+		return req.Condition
+	}
+	return androidDeviceGroup(req)
+}
 
-	fcmV1Client = client
-	return client, err
+// androidTopic returns the FCM topic name of the request. The legacy
+// "/topics/" prefixed "to" field is supported as well.
+func androidTopic(req *PushNotification) string {
+	if req.Topic != "" {
+		return req.Topic
+	}
+
+	if strings.HasPrefix(req.To, "/topics/") {
+		return req.To
+	}
+
+	return ""
 }
 
-func PushToAndroidV1(ctx context.Context, req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePush, err error) {
-	logx.LogAccess.Debug("Start push notification for Android V1")
+// androidDeviceGroup returns the device group notification key of the
+// request, the "to" field which isn't a topic.
+func androidDeviceGroup(req *PushNotification) string {
+	if req.To == "" || strings.HasPrefix(req.To, "/topics/") {
+		return ""
+	}
 
-	// check message
-	err = CheckMessage(req)
-	if err != nil {
-		logx.LogError.Error("request error: " + err.Error())
-		return nil, err
+	return req.To
+}
+
+// getAndroidTopicMessageV1 converts the multicast message into a single
+// message addressed to the topic, condition or device group of the request.
+func getAndroidTopicMessageV1(req *PushNotification, m *messaging.MulticastMessage) *messaging.Message {
+	return &messaging.Message{
+		Data:         m.Data,
+		Notification: m.Notification,
+		Android:      m.Android,
+		Webpush:      m.Webpush,
+		APNS:         m.APNS,
+		FCMOptions:   m.FCMOptions,
+		Topic:        androidTopic(req),
+		Condition:    req.Condition,
+		Token:        androidDeviceGroup(req),
+	}
+}
+
+// androidChannelData adds the channel, group and deep link hints of the
+// notification to the Android data, the keys of the request data are kept.
+func androidChannelData(n *FCMNotification, data map[string]string) map[string]string {
+	if n == nil || (n.ChannelGroupID == "" && n.Importance == "" && n.GroupKey == "" && n.DeepLink == "") {
+		return data
 	}
 
-	resp = &ResponsePush{}
+	hints := make(map[string]string, 5)
+	if n.ChannelGroupID != "" {
+		hints[androidChannelGroupIDKey] = n.ChannelGroupID
+	}
+	if n.Importance != "" {
+		hints[androidImportanceKey] = n.Importance
+	}
+	if n.GroupKey != "" {
+		hints[androidGroupKey] = n.GroupKey
+		hints[androidGroupSummaryKey] = strconv.FormatBool(n.GroupSummary)
+	}
+	if n.DeepLink != "" {
+		hints[androidDeepLinkKey] = n.DeepLink
+	}
+	return dataWithDefaults(data, hints)
+}
 
-	notification, err := getAndroidNotificationV1(req)
-	if err != nil {
-		// FCM server error
-		logx.LogError.Error("FCM V1 server error: " + err.Error())
-		return resp, err
+// androidImage returns the image of the Android notification, the one of the
+// notification payload first.
+func androidImage(req *PushNotification) string {
+	switch {
+	case req.Notification != nil && req.Notification.Image != "":
+		return req.Notification.Image
+	case req.AndroidImage != "":
+		return req.AndroidImage
+	default:
+		return req.Image
 	}
+}
 
-	client, err := InitFCMV1Client(ctx, cfg)
-	if err != nil {
-		// FCM server error
-		logx.LogError.Error("FCM V1 server error: " + err.Error())
-		return resp, err
+// androidClickAction returns the click action of the notification, the deep
+// link for the apps handling the tap with an intent filter.
+func androidClickAction(n *FCMNotification) string {
+	if n.ClickAction == "" {
+		return n.DeepLink
 	}
+	return n.ClickAction
+}
 
-	res, err := client.SendEachForMulticast(ctx, notification)
-	if err != nil {
-		// Send Message error
-		logx.LogError.Error("FCM server send message error: " + err.Error())
+// checkDeepLink validates the deep link is an absolute URI, e.g. an https
+// app link or a custom scheme like myapp://orders/42.
+func checkDeepLink(link string) error {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" || (u.Host == "" && u.Opaque == "" && u.Path == "") {
+		return fmt.Errorf("the deep link must be an absolute URI: %q", link)
+	}
+	return nil
+}
 
-		for _, token := range req.Tokens {
-			errLog := logPush(cfg, core.FailedPush, token, req, err)
-			resp.Logs = append(resp.Logs, errLog)
+// androidMessagePriority returns the priority of the message, the
+// android.default_priority when the request doesn't set one.
+func androidMessagePriority(req *PushNotification, cfg *config.ConfYaml) string {
+	if req.Priority == "" {
+		return cfg.Android.DefaultPriority
+	}
+	return req.Priority
+}
+
+// isAndroidHeadsUp reports whether the notification priority shows the
+// notification as a heads-up one.
+func isAndroidHeadsUp(priority messaging.AndroidNotificationPriority) bool {
+	return priority == messaging.PriorityHigh || priority == messaging.PriorityMax
+}
+
+// androidNotificationTag returns the tag of the notification, the group key
+// for the group summaries without a tag.
+func androidNotificationTag(n *FCMNotification) string {
+	if n.Tag == "" && n.GroupSummary {
+		return n.GroupKey
+	}
+	return n.Tag
+}
+
+// dataWithDefaults returns a copy of data with the defaults of the missing
+// keys.
+func dataWithDefaults(data, defaults map[string]string) map[string]string {
+	merged := make(map[string]string, len(data)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return merged
+}
+
+// checkAndroidNotification validates the enum values of the FCM notification payload.
+func checkAndroidNotification(n *FCMNotification) error {
+	if _, ok := androidNotificationPriorities[n.NotificationPriority]; n.NotificationPriority != "" && !ok {
+		return fmt.Errorf("unknown notification priority: %q", n.NotificationPriority)
+	}
+
+	if _, ok := androidNotificationImportances[n.Importance]; n.Importance != "" && !ok {
+		return fmt.Errorf("unknown notification importance: %q", n.Importance)
+	}
+
+	if _, ok := androidNotificationVisibilities[n.Visibility]; n.Visibility != "" && !ok {
+		return fmt.Errorf("unknown notification visibility: %q", n.Visibility)
+	}
+
+	for _, v := range n.VibrateTimings {
+		if v < 0 {
+			return errors.New("vibrate timings must not be negative")
 		}
+	}
 
-		status.StatStorage.AddAndroidError(int64(len(req.Tokens)))
-		return resp, err
+	if n.DefaultSound && n.Sound != "" {
+		return errors.New("the notification can't specify both sound and default_sound")
 	}
 
-	status.StatStorage.AddAndroidSuccess(int64(res.SuccessCount))
-	status.StatStorage.AddAndroidError(int64(res.FailureCount))
+	if n.GroupSummary && n.GroupKey == "" {
+		return errors.New("the group summary requires a group_key")
+	}
 
-	// result from Send messages to specific devices
-	for k, result := range res.Responses {
-		to := req.To
-		if k < len(req.Tokens) {
-			to = req.Tokens[k]
+	if n.DeepLink != "" {
+		if n.ClickAction != "" {
+			return errors.New("the notification can't specify both click_action and deep_link")
 		}
+		if err := checkDeepLink(n.DeepLink); err != nil {
+			return err
+		}
+	}
 
-		if result.Error != nil {
-			errLog := logPush(cfg, core.FailedPush, to, req, result.Error)
-			resp.Logs = append(resp.Logs, errLog)
-			continue
+	if tag := androidNotificationTag(n); len(tag) > androidMaxTagLength {
+		return fmt.Errorf("the notification tag must be at most %d characters, got %d", androidMaxTagLength, len(tag))
+	}
+
+	if n.DefaultVibrate && len(n.VibrateTimings) > 0 {
+		return errors.New("the notification can't specify both vibrate_timing_millis and default_vibrate")
+	}
+
+	// the loc args are the arguments of the format specifiers of the
+	// localized string of the loc key
+	if len(n.TitleLocArgs) > 0 && n.TitleLocKey == "" {
+		return errors.New("the title_loc_args require a title_loc_key")
+	}
+
+	if len(n.BodyLocArgs) > 0 && n.BodyLocKey == "" {
+		return errors.New("the body_loc_args require a body_loc_key")
+	}
+
+	if n.Title != "" && n.TitleLocKey != "" {
+		return errors.New("the notification can't specify both title and title_loc_key")
+	}
+
+	if n.Body != "" && n.BodyLocKey != "" {
+		return errors.New("the notification can't specify both body and body_loc_key")
+	}
+
+	if _, err := n.EventTimestamp(); err != nil {
+		return err
+	}
+
+	if n.LightSettings != nil {
+		if !hexColorPattern.MatchString(n.LightSettings.Color) {
+			return fmt.Errorf("invalid light settings color: %q", n.LightSettings.Color)
 		}
 
-		logPush(cfg, core.SucceededPush, to, req, nil)
+		if n.LightSettings.LightOnDuration < 0 || n.LightSettings.LightOffDuration < 0 {
+			return errors.New("light settings durations must not be negative")
+		}
 	}
 
-	return resp, nil
+	return nil
+}
+
+// checkWebPush validates the web push payload.
+func checkWebPush(w *WebPushConfig) error {
+	if w.Link == "" {
+		return nil
+	}
+
+	u, err := url.Parse(w.Link)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webpush link must be a valid https URL: %q", w.Link)
+	}
+
+	return nil
+}
+
+// getWebpushConfigV1 converts the web push payload into FCM webpush config.
+func getWebpushConfigV1(w *WebPushConfig) *messaging.WebpushConfig {
+	if w == nil {
+		return nil
+	}
+
+	webpush := &messaging.WebpushConfig{
+		Headers: w.Headers,
+		Data:    w.Data,
+	}
+
+	if w.Notification != nil {
+		webpush.Notification = &messaging.WebpushNotification{
+			Title:              w.Notification.Title,
+			Body:               w.Notification.Body,
+			Icon:               w.Notification.Icon,
+			Badge:              w.Notification.Badge,
+			Image:              w.Notification.Image,
+			Tag:                w.Notification.Tag,
+			RequireInteraction: w.Notification.RequireInteraction,
+			Silent:             w.Notification.Silent,
+		}
+
+		for _, action := range w.Notification.Actions {
+			webpush.Notification.Actions = append(webpush.Notification.Actions, &messaging.WebpushNotificationAction{
+				Action: action.Action,
+				Title:  action.Title,
+				Icon:   action.Icon,
+			})
+		}
+	}
+
+	if w.Link != "" {
+		webpush.FCMOptions = &messaging.WebpushFCMOptions{
+			Link: w.Link,
+		}
+	}
+
+	return webpush
+}
+
+// SendAndroidTest sends a test notification to the token with the FCM client
+// of the config, validated only by FCM with dryRun. It smoke tests the FCM
+// config of a deployment.
+func SendAndroidTest(ctx context.Context, cfg *config.ConfYaml, token, title, message string, dryRun bool) (*ResponsePush, error) {
+	if token == "" {
+		return nil, errors.New("the android test notification requires a token")
+	}
+
+	req := &PushNotification{
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{token},
+		Title:    title,
+		Message:  message,
+		DryRun:   dryRun,
+	}
+	if err := CheckMessage(req); err != nil {
+		return nil, err
+	}
+
+	return PushToAndroidV1(ctx, req, cfg)
+}
+
+// getAPNSConfigV1 converts the APNs override into FCM APNs config.
+func getAPNSConfigV1(a *FCMApnsConfig) *messaging.APNSConfig {
+	if a == nil {
+		return nil
+	}
+
+	apns := &messaging.APNSConfig{
+		Headers: a.Headers,
+		Payload: &messaging.APNSPayload{
+			Aps:        &messaging.Aps{},
+			CustomData: a.CustomData,
+		},
+	}
+
+	if a.Aps != nil {
+		apns.Payload.Aps = &messaging.Aps{
+			Badge:            a.Aps.Badge,
+			Sound:            a.Aps.Sound,
+			ContentAvailable: a.Aps.ContentAvailable,
+			MutableContent:   a.Aps.MutableContent,
+			Category:         a.Aps.Category,
+			ThreadID:         a.Aps.ThreadID,
+		}
+
+		if isFCMApsAlertDict(a.Aps) {
+			apns.Payload.Aps.Alert = &messaging.ApsAlert{
+				Title:        a.Aps.Title,
+				SubTitle:     a.Aps.Subtitle,
+				Body:         a.Aps.Alert,
+				LocKey:       a.Aps.LocKey,
+				LocArgs:      a.Aps.LocArgs,
+				TitleLocKey:  a.Aps.TitleLocKey,
+				TitleLocArgs: a.Aps.TitleLocArgs,
+			}
+		} else {
+			apns.Payload.Aps.AlertString = a.Aps.Alert
+		}
+	}
+
+	return apns
+}
+
+// isFCMApsAlertDict reports whether the alert is sent as a dictionary, the
+// plain alert string can't carry the title, subtitle nor the loc keys.
+func isFCMApsAlertDict(aps *FCMAps) bool {
+	return aps.Title != "" || aps.Subtitle != "" || aps.LocKey != "" || aps.TitleLocKey != ""
+}
+
+// checkFCMApsAlert validates the loc args of the alert are sent with the loc
+// key they format.
+func checkFCMApsAlert(aps *FCMAps) error {
+	if aps == nil {
+		return nil
+	}
+
+	if len(aps.LocArgs) > 0 && aps.LocKey == "" {
+		return errors.New("the fcm_apns alert loc_args require a loc_key")
+	}
+
+	if len(aps.TitleLocArgs) > 0 && aps.TitleLocKey == "" {
+		return errors.New("the fcm_apns alert title_loc_args require a title_loc_key")
+	}
+
+	return nil
+}
+
+// checkExpiresAt validates the expiration of the message, it can't be
+// combined with the time to live and must not be reached yet.
+func checkExpiresAt(req *PushNotification) error {
+	if req.TimeToLive != nil {
+		return errors.New("the message can't specify both time_to_live and expires_at")
+	}
+
+	at, err := time.Parse(time.RFC3339, req.ExpiresAt)
+	if err != nil {
+		return errors.New("the expires_at must be a RFC3339 time, got " + req.ExpiresAt)
+	}
+
+	if !time.Now().Before(at) {
+		return errors.New("the message is expired since " + req.ExpiresAt)
+	}
+
+	return nil
+}
+
+// parseDisplayUntil parses the display until time of the notification, it
+// must not be reached yet.
+func parseDisplayUntil(value string) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("the display_until must be a RFC3339 time, got " + value)
+	}
+
+	if !time.Now().Before(at) {
+		return time.Time{}, errors.New("the notification isn't displayed since " + value)
+	}
+
+	return at, nil
+}
+
+// expiresAtTTL returns the time left until the expiration of the message in
+// whole seconds, it's clamped to 4 weeks with android.clamp_ttl.
+func expiresAtTTL(req *PushNotification, cfg *config.ConfYaml) (time.Duration, error) {
+	at, err := time.Parse(time.RFC3339, req.ExpiresAt)
+	if err != nil {
+		return 0, errors.New("the expires_at must be a RFC3339 time, got " + req.ExpiresAt)
+	}
+
+	ttl := time.Until(at).Truncate(time.Second)
+	maxTTL := time.Duration(fcmMaxTTL) * time.Second
+	switch {
+	case ttl <= 0:
+		return 0, errors.New("the message is expired since " + req.ExpiresAt)
+	case ttl > maxTTL && cfg.Android.ClampTTL:
+		ttl = maxTTL
+	case ttl > maxTTL:
+		return 0, fmt.Errorf("the message's expires_at must be at most %d seconds (4 weeks) away, got %s",
+			fcmMaxTTL, req.ExpiresAt)
+	}
+
+	return ttl, nil
+}
+
+// apnsCollapseIDLimit is the max size of the apns-collapse-id header in bytes.
+const apnsCollapseIDLimit = 64
+
+// checkFCMApnsHeaders validates the APNs headers FCM forwards to APNs, the
+// header names are case insensitive.
+func checkFCMApnsHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		switch strings.ToLower(name) {
+		case "apns-priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil || (priority != ApnsPriorityLow && priority != ApnsPriorityHigh) {
+				return fmt.Errorf("the apns-priority header must be %d or %d, got %q",
+					ApnsPriorityLow, ApnsPriorityHigh, value)
+			}
+		case "apns-expiration":
+			if expiration, err := strconv.ParseInt(value, 10, 64); err != nil || expiration < 0 {
+				return fmt.Errorf("the apns-expiration header must be a UNIX epoch in seconds, got %q", value)
+			}
+		case "apns-collapse-id":
+			if len(value) > apnsCollapseIDLimit {
+				return fmt.Errorf("the apns-collapse-id header must be at most %d bytes, got %d",
+					apnsCollapseIDLimit, len(value))
+			}
+		}
+	}
+
+	return nil
 }
 
-func getAndroidNotificationV1(req *PushNotification) (*messaging.MulticastMessage, error) {
+func getAndroidNotificationV1(req *PushNotification, cfg *config.ConfYaml) (*messaging.MulticastMessage, error) {
 	androidNotification := &messaging.AndroidNotification{}
 	if req.Notification != nil {
 		notificationCount, err := req.Notification.NotificationCount()
@@ -120,57 +2144,136 @@ func getAndroidNotificationV1(req *PushNotification) (*messaging.MulticastMessag
 			return nil, errors.New("invalid badge format")
 		}
 
+		eventTime, err := req.Notification.EventTimestamp()
+		if err != nil {
+			logx.LogError.Error("FCM unsupported event time value", err)
+			return nil, errors.New("invalid event time format")
+		}
+
 		androidNotification = &messaging.AndroidNotification{
-			Title:             req.Notification.Title,
-			Body:              req.Notification.Body,
-			ChannelID:         req.Notification.ChannelID,
-			Icon:              req.Notification.Icon,
-			ImageURL:          req.Notification.Image,
-			Sound:             req.Notification.Sound,
-			NotificationCount: notificationCount,
-			Tag:               req.Notification.Tag,
-			Color:             req.Notification.Color,
-			ClickAction:       req.Notification.ClickAction,
-			BodyLocKey:        req.Notification.BodyLocKey,
-			BodyLocArgs:       req.Notification.BodyLocArgs,
-			TitleLocKey:       req.Notification.TitleLocKey,
-			TitleLocArgs:      req.Notification.TitleLocArgs,
-			// Ticker:                "",
-			// Sticky:                false,
-			// EventTimestamp:        nil,
-			// LocalOnly:             false,
-			// Priority:              0,
-			// VibrateTimingMillis:   nil,
-			// DefaultVibrateTimings: false,
-			// DefaultSound:          false,
-			// LightSettings:         nil,
+			Title:               req.Notification.Title,
+			Body:                req.Notification.Body,
+			ChannelID:           req.Notification.ChannelID,
+			Icon:                req.Notification.Icon,
+			ImageURL:            req.Notification.Image,
+			Sound:               req.Notification.Sound,
+			NotificationCount:   notificationCount,
+			Tag:                 androidNotificationTag(req.Notification),
+			Color:               req.Notification.Color,
+			ClickAction:         androidClickAction(req.Notification),
+			BodyLocKey:          req.Notification.BodyLocKey,
+			BodyLocArgs:         req.Notification.BodyLocArgs,
+			TitleLocKey:         req.Notification.TitleLocKey,
+			TitleLocArgs:        req.Notification.TitleLocArgs,
+			Ticker:              req.Notification.Ticker,
+			Sticky:              req.Notification.Sticky,
+			LocalOnly:           req.Notification.LocalOnly,
+			Priority:            androidNotificationPriorities[req.Notification.NotificationPriority],
+			Visibility:          androidNotificationVisibilities[req.Notification.Visibility],
+			VibrateTimingMillis: req.Notification.VibrateTimings,
+			EventTimestamp:      eventTime,
 			// DefaultLightSettings:  false,
-			// Visibility:            0,
-			// NotificationCount:     nil,
 		}
+
+		androidNotification.DefaultSound = req.Notification.DefaultSound
+		androidNotification.DefaultVibrateTimings = req.Notification.DefaultVibrate
+
+		if req.Notification.NotificationPriority == "" {
+			androidNotification.Priority = androidNotificationImportances[req.Notification.Importance]
+		}
+
+		if req.Notification.LightSettings != nil {
+			androidNotification.LightSettings = &messaging.LightSettings{
+				Color:                  req.Notification.LightSettings.Color,
+				LightOnDurationMillis:  req.Notification.LightSettings.LightOnDuration,
+				LightOffDurationMillis: req.Notification.LightSettings.LightOffDuration,
+			}
+		}
+	}
+
+	// the top level badge is shared with iOS. An explicit zero clears the
+	// badge while an absent badge leaves the notification count unset.
+	if androidNotification.NotificationCount == nil && req.Badge != nil {
+		if *req.Badge < 0 {
+			logx.LogError.Errorf("FCM unsupported badge value: %d", *req.Badge)
+			return nil, errors.New("invalid badge format")
+		}
+
+		badge := *req.Badge
+		androidNotification.NotificationCount = &badge
 	}
 
-	if androidNotification.Title == "" {
+	// the localized title and body aren't mixed with the plain ones
+	if androidNotification.Title == "" && androidNotification.TitleLocKey == "" {
 		androidNotification.Title = req.Title
 	}
 
-	if androidNotification.Body == "" {
+	if androidNotification.Body == "" && androidNotification.BodyLocKey == "" {
 		androidNotification.Body = req.Message
 	}
 
 	if androidNotification.ImageURL == "" {
-		androidNotification.ImageURL = req.Image
+		androidNotification.ImageURL = androidImage(req)
+	}
+
+	// the notifications of the high priority messages are shown as heads-up
+	// ones as well, unless they set their own priority
+	if cfg.Android.InheritNotificationPriority && androidNotification.Priority == 0 &&
+		androidMessagePriority(req, cfg) == "high" {
+		androidNotification.Priority = messaging.PriorityHigh
+	}
+
+	// the heads-up notifications play the sound and vibration of the system,
+	// unless the request sets its own
+	if cfg.Android.AutoDefaultsForHighPriority && isAndroidHeadsUp(androidNotification.Priority) {
+		if androidNotification.Sound == "" && req.Sound == nil {
+			androidNotification.DefaultSound = true
+		}
+		if len(androidNotification.VibrateTimingMillis) == 0 {
+			androidNotification.DefaultVibrateTimings = true
+		}
 	}
 
-	if androidNotification.Sound == "" && req.Sound != nil {
+	// the request and config sounds don't override the default sound
+	if androidNotification.Sound == "" && req.Sound != nil && !androidNotification.DefaultSound {
 		v, ok := req.Sound.(string)
 		if !ok {
-			logx.LogError.Errorf("FCM unsupported sound value: %#v", req.Sound)
-			return nil, errors.New("invalid sound format")
+			sound, ok := soundObject(req.Sound)
+			if !ok {
+				logx.LogError.Errorf("FCM unsupported sound value: %#v", req.Sound)
+				return nil, errors.New("invalid sound format")
+			}
+			if sound.Critical != 0 || sound.Volume != 0 {
+				logx.LogAccess.Debug("FCM ignores the sound critical and volume, they are only supported on iOS")
+			}
+			v = sound.Name
 		}
 		androidNotification.Sound = v
 	}
 
+	if androidNotification.Icon == "" {
+		androidNotification.Icon = cfg.Android.DefaultIcon
+	}
+
+	if androidNotification.Color == "" {
+		androidNotification.Color = cfg.Android.DefaultColor
+	}
+
+	if androidNotification.Sound == "" && !androidNotification.DefaultSound {
+		androidNotification.Sound = cfg.Android.DefaultSound
+	}
+
+	if androidNotification.ChannelID == "" {
+		androidNotification.ChannelID = cfg.Android.DefaultChannelID
+	}
+
+	// the sound is played by the devices before Android 8.0 only, the newer
+	// ones play the sound of the channel
+	if androidNotification.Sound != "" && androidNotification.ChannelID == "" && !req.DataOnly && !req.SuppressNotification {
+		logx.LogAccess.Warnf("FCM sound %q without a channel is ignored on Android 8.0+, "+
+			"set android_channel_id or android.default_channel_id", androidNotification.Sound)
+	}
+
 	data := make(map[string]string, len(req.Data))
 	for k, val := range req.Data {
 		switch v := val.(type) {
@@ -211,19 +2314,56 @@ func getAndroidNotificationV1(req *PushNotification) (*messaging.MulticastMessag
 			data[k] = strconv.FormatFloat(float64(v), 'f', -1, 64)
 
 		default:
+			// nested objects and arrays are sent as JSON strings
+			if cfg.Android.StringifyData {
+				if b, err := json.Marshal(v); err == nil {
+					data[k] = string(b)
+					break
+				}
+			}
+
 			logx.LogError.Errorf("FCM unsupported data value for key %s. value: %#v of type %T", k, val, val)
 			return nil, errors.New("invalid data format")
 		}
 	}
 
+	var displayUntil time.Time
+	if req.DisplayUntil != "" {
+		at, err := parseDisplayUntil(req.DisplayUntil)
+		if err != nil {
+			return nil, err
+		}
+
+		displayUntil = at
+		if _, ok := data[androidDisplayUntilKey]; !ok {
+			data[androidDisplayUntilKey] = strconv.FormatInt(at.Unix(), 10)
+		}
+	}
+
 	android := &messaging.AndroidConfig{
-		CollapseKey: req.CollapseKey,
-		Priority:    req.Priority,
-		TTL:         nil,
-		// RestrictedPackageName: "",
-		Data:         data,
-		Notification: androidNotification,
-		FCMOptions:   nil,
+		CollapseKey:           req.CollapseKey,
+		Priority:              androidMessagePriority(req, cfg),
+		TTL:                   nil,
+		RestrictedPackageName: req.RestrictedPackageName,
+		Data:                  androidChannelData(req.Notification, data),
+		Notification:          androidNotification,
+		FCMOptions:            nil,
+		DirectBootOK:          req.DirectBootOK,
+	}
+
+	if android.RestrictedPackageName == "" {
+		android.RestrictedPackageName = cfg.Android.RestrictedPackageName
+	}
+
+	var fcmOptions *messaging.FCMOptions
+	if req.AnalyticsLabel != "" {
+		fcmOptions = &messaging.FCMOptions{AnalyticsLabel: req.AnalyticsLabel}
+		android.FCMOptions = &messaging.AndroidFCMOptions{AnalyticsLabel: req.AnalyticsLabel}
+	}
+
+	// the message level options only apply to the outer message
+	if req.FCMOptions != nil && req.FCMOptions.AnalyticsLabel != "" {
+		fcmOptions = &messaging.FCMOptions{AnalyticsLabel: req.FCMOptions.AnalyticsLabel}
 	}
 
 	if req.TimeToLive != nil {
@@ -231,6 +2371,21 @@ func getAndroidNotificationV1(req *PushNotification) (*messaging.MulticastMessag
 		android.TTL = &ttl
 	}
 
+	if req.ExpiresAt != "" {
+		ttl, err := expiresAtTTL(req, cfg)
+		if err != nil {
+			return nil, err
+		}
+		android.TTL = &ttl
+	}
+
+	// the notification isn't worth delivering once the app stops showing it,
+	// FCM keeps the messages for 4 weeks at most
+	if android.TTL == nil && !displayUntil.IsZero() {
+		ttl := min(time.Until(displayUntil).Truncate(time.Second), time.Duration(fcmMaxTTL)*time.Second)
+		android.TTL = &ttl
+	}
+
 	m := &messaging.MulticastMessage{
 		Data: data,
 		Notification: &messaging.Notification{
@@ -239,11 +2394,95 @@ func getAndroidNotificationV1(req *PushNotification) (*messaging.MulticastMessag
 			ImageURL: req.Image,
 		},
 		Android:    android,
-		Webpush:    nil,
-		APNS:       nil,
-		FCMOptions: nil,
+		Webpush:    getWebpushConfigV1(req.WebPush),
+		APNS:       getAPNSConfigV1(req.FCMApns),
+		FCMOptions: fcmOptions,
 		Tokens:     req.Tokens,
 	}
 
+	if req.FCMOptions != nil && req.FCMOptions.Link != "" {
+		if m.Webpush == nil {
+			m.Webpush = &messaging.WebpushConfig{}
+		}
+		if m.Webpush.FCMOptions == nil {
+			m.Webpush.FCMOptions = &messaging.WebpushFCMOptions{Link: req.FCMOptions.Link}
+		}
+	}
+
+	// data only messages are handled by the app, they never show up in the
+	// system tray.
+	if req.DataOnly {
+		m.Notification = nil
+		android.Notification = nil
+	}
+
+	// the rich notifications are built by the app from the image, the
+	// notification keeps it for the default rendering.
+	if req.RichContent && androidNotification.ImageURL != "" {
+		rich := map[string]string{
+			androidRichImageURLKey: androidNotification.ImageURL,
+			androidRichStyleKey:    androidRichStyleBigPicture,
+		}
+
+		m.Data = dataWithDefaults(m.Data, rich)
+		android.Data = dataWithDefaults(android.Data, rich)
+	}
+
+	// the suppressed notifications are rendered by the app, the content is
+	// kept in the data without overwriting the keys of the request.
+	if req.SuppressNotification {
+		content := map[string]string{
+			"title": androidNotification.Title,
+			"body":  androidNotification.Body,
+		}
+		if androidNotification.ImageURL != "" {
+			content["image"] = androidNotification.ImageURL
+		}
+
+		m.Data = dataWithDefaults(m.Data, content)
+		android.Data = dataWithDefaults(android.Data, content)
+		m.Notification = nil
+		android.Notification = nil
+	}
+
+	if err := checkAndroidPayloadSize(m, cfg); err != nil {
+		logx.LogAccess.Debug(err.Error())
+		return nil, err
+	}
+
 	return m, nil
 }
+
+// checkAndroidPayloadSize rejects the messages larger than the payload limits
+// before sending them, FCM allows at most 4KB. The size is approximated by the
+// JSON encoding of the data and notification payloads.
+func checkAndroidPayloadSize(m *messaging.MulticastMessage, cfg *config.ConfYaml) error {
+	kind, limit := "data", cfg.Android.MaxDataSize
+	payloads := []interface{}{m.Data}
+	if m.Notification != nil {
+		kind, limit = "notification", cfg.Android.MaxNotificationSize
+		payloads = append(payloads, m.Notification, m.Android.Notification)
+	}
+
+	if limit <= 0 {
+		return nil
+	}
+
+	size := 0
+	for _, payload := range payloads {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		size += len(b)
+	}
+
+	if size > limit {
+		return &ValidationError{
+			Status: http.StatusRequestEntityTooLarge,
+			Err:    fmt.Errorf("the %s message payload must be at most %d bytes, got %d bytes", kind, limit, size),
+		}
+	}
+
+	return nil
+}
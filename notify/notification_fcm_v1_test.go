@@ -1,10 +1,37 @@
 package notify
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/messaging"
+	"github.com/appleboy/gorush/config"
 	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/logx"
+	"github.com/appleboy/gorush/status"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
 )
 
 func TestFCMMessage(t *testing.T) {
@@ -28,27 +55,29 @@ func TestFCMMessage(t *testing.T) {
 	err = CheckMessage(req)
 	assert.Error(t, err)
 
-	// android topics not supported yet
+	// the message can't specify both registration IDs and a topic
 	req = &PushNotification{
 		Message:  "Test",
 		Platform: core.PlatFormAndroid,
-		To:       "/topics/foo-bar",
+		Tokens:   []string{"XXXXXXXXX"},
+		Topic:    "news",
 	}
 
 	err = CheckMessage(req)
 	assert.Error(t, err)
 
-	// android topics not supported yet
+	// the message can't specify both a topic and a condition
 	req = &PushNotification{
 		Message:   "Test",
 		Platform:  core.PlatFormAndroid,
+		To:        "/topics/foo-bar",
 		Condition: "'dogs' in topics || 'cats' in topics",
 	}
 
 	err = CheckMessage(req)
 	assert.Error(t, err)
 
-	// the message may specify at most 501 registration IDs
+	// more than 500 registration IDs are sent in batches
 	req = &PushNotification{
 		Message:  "Test",
 		Platform: core.PlatFormAndroid,
@@ -56,7 +85,7 @@ func TestFCMMessage(t *testing.T) {
 	}
 
 	err = CheckMessage(req)
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	// the message's TimeToLive field must be an integer
 	// between 0 and 2419200 (4 weeks)
@@ -83,3 +112,3411 @@ func TestFCMMessage(t *testing.T) {
 	err = CheckMessage(req)
 	assert.NoError(t, err)
 }
+
+func TestAndroidNotificationPriorityAndVisibility(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{
+			NotificationPriority: "max",
+			Visibility:           "secret",
+			Ticker:               "ticker",
+			Sticky:               true,
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, messaging.PriorityMax, msg.Android.Notification.Priority)
+	assert.Equal(t, messaging.VisibilitySecret, msg.Android.Notification.Visibility)
+	assert.Equal(t, "ticker", msg.Android.Notification.Ticker)
+	assert.True(t, msg.Android.Notification.Sticky)
+
+	// unknown notification priority
+	req.Notification.NotificationPriority = "urgent"
+	assert.Error(t, CheckMessage(req))
+
+	// unknown notification visibility
+	req.Notification.NotificationPriority = "high"
+	req.Notification.Visibility = "hidden"
+	assert.Error(t, CheckMessage(req))
+}
+
+func TestAndroidLocalizedNotification(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Title:    "Welcome",
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{
+			TitleLocKey:  "welcome_title",
+			TitleLocArgs: []string{"Jane"},
+			BodyLocKey:   "welcome_body",
+			BodyLocArgs:  []string{"3", "new"},
+		},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	// the plain title and body don't fall back to the message
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg.Android.Notification.Title)
+	assert.Equal(t, "", msg.Android.Notification.Body)
+	assert.Equal(t, "welcome_title", msg.Android.Notification.TitleLocKey)
+	assert.Equal(t, []string{"Jane"}, msg.Android.Notification.TitleLocArgs)
+	assert.Equal(t, "welcome_body", msg.Android.Notification.BodyLocKey)
+	assert.Equal(t, []string{"3", "new"}, msg.Android.Notification.BodyLocArgs)
+
+	// the loc args without a loc key
+	req.Notification.TitleLocKey = ""
+	assert.EqualError(t, CheckMessage(req), "the title_loc_args require a title_loc_key")
+
+	req.Notification.TitleLocKey = "welcome_title"
+	req.Notification.BodyLocKey = ""
+	assert.EqualError(t, CheckMessage(req), "the body_loc_args require a body_loc_key")
+
+	// a plain and a localized title or body
+	req.Notification.BodyLocKey = "welcome_body"
+	req.Notification.Title = "Hello"
+	assert.EqualError(t, CheckMessage(req), "the notification can't specify both title and title_loc_key")
+
+	req.Notification.Title = ""
+	req.Notification.Body = "Hello"
+	assert.EqualError(t, CheckMessage(req), "the notification can't specify both body and body_loc_key")
+
+	// a loc key without args is fine
+	req.Notification = &FCMNotification{BodyLocKey: "welcome_body"}
+	assert.NoError(t, CheckMessage(req))
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "Welcome", msg.Android.Notification.Title)
+	assert.Equal(t, "", msg.Android.Notification.Body)
+}
+
+func TestAndroidDefaultSound(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.DefaultSound = "config.wav"
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Sound:    "request.wav",
+		Notification: &FCMNotification{
+			DefaultSound:   true,
+			DefaultVibrate: true,
+		},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	// the system sound is used instead of the request and config ones
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.True(t, msg.Android.Notification.DefaultSound)
+	assert.True(t, msg.Android.Notification.DefaultVibrateTimings)
+	assert.Equal(t, "", msg.Android.Notification.Sound)
+
+	data, err := json.Marshal(msg.Android.Notification)
+	assert.NoError(t, err)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &body))
+	assert.Equal(t, true, body["default_sound"])
+	assert.Equal(t, true, body["default_vibrate_timings"])
+
+	req.Notification.Sound = "custom.wav"
+	assert.EqualError(t, CheckMessage(req), "the notification can't specify both sound and default_sound")
+
+	req.Notification.Sound = ""
+	req.Notification.VibrateTimings = []int64{100, 200}
+	assert.EqualError(t, CheckMessage(req),
+		"the notification can't specify both vibrate_timing_millis and default_vibrate")
+
+	// the request sound is used without the default sound
+	req.Notification = &FCMNotification{}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.False(t, msg.Android.Notification.DefaultSound)
+	assert.Equal(t, "request.wav", msg.Android.Notification.Sound)
+}
+
+func TestAndroidSoundChannel(t *testing.T) {
+	hook := &test.Hook{}
+	hooks := logx.LogAccess.ReplaceHooks(logrus.LevelHooks{})
+	logx.LogAccess.AddHook(hook)
+	t.Cleanup(func() { logx.LogAccess.ReplaceHooks(hooks) })
+
+	warnings := func() int {
+		n := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "without a channel") {
+				n++
+			}
+		}
+		hook.Reset()
+		return n
+	}
+
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:      "Test",
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"XXXXXXXXX"},
+		Sound:        "bell.wav",
+		Notification: &FCMNotification{ChannelID: "alerts"},
+	}
+
+	// the sound is sent with the channel for the devices before Android 8.0
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "bell.wav", msg.Android.Notification.Sound)
+	assert.Equal(t, "alerts", msg.Android.Notification.ChannelID)
+	assert.Equal(t, 0, warnings())
+
+	// the sound without a channel is only warned about
+	req.Notification = nil
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "bell.wav", msg.Android.Notification.Sound)
+	assert.Equal(t, "", msg.Android.Notification.ChannelID)
+	assert.Equal(t, 1, warnings())
+
+	// the config channel is used when the request doesn't set one
+	cfg.Android.DefaultChannelID = "default"
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "bell.wav", msg.Android.Notification.Sound)
+	assert.Equal(t, "default", msg.Android.Notification.ChannelID)
+	assert.Equal(t, 0, warnings())
+
+	req.Notification = &FCMNotification{ChannelID: "alerts"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "alerts", msg.Android.Notification.ChannelID)
+
+	// the channel alone needs no sound
+	req.Sound = nil
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg.Android.Notification.Sound)
+	assert.Equal(t, "alerts", msg.Android.Notification.ChannelID)
+
+	// the data only messages show no notification
+	cfg.Android.DefaultChannelID = ""
+	req.Sound = "bell.wav"
+	req.Notification = nil
+	req.DataOnly = true
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, warnings())
+}
+
+func TestAndroidChannelHints(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Data:     D{"android_channel_importance": "custom", "foo": "bar"},
+		Notification: &FCMNotification{
+			ChannelID:      "news",
+			ChannelGroupID: "updates",
+			Importance:     "low",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "news", msg.Android.Notification.ChannelID)
+	assert.Equal(t, messaging.PriorityLow, msg.Android.Notification.Priority)
+	assert.Equal(t, map[string]string{
+		"android_channel_group_id":   "updates",
+		"android_channel_importance": "custom",
+		"foo":                        "bar",
+	}, msg.Android.Data)
+	// the hints are only sent to Android
+	assert.Equal(t, map[string]string{"android_channel_importance": "custom", "foo": "bar"}, msg.Data)
+
+	// the notification priority is used first
+	req.Notification.NotificationPriority = "max"
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, messaging.PriorityMax, msg.Android.Notification.Priority)
+
+	// unknown importance
+	req.Notification.Importance = "max"
+	assert.EqualError(t, CheckMessage(req), `unknown notification importance: "max"`)
+}
+
+func TestAndroidGroupHints(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Data:     D{"foo": "bar"},
+		Notification: &FCMNotification{
+			Tag:      "message-42",
+			GroupKey: "chat-1",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "message-42", msg.Android.Notification.Tag)
+	assert.Equal(t, map[string]string{
+		"android_group_key":     "chat-1",
+		"android_group_summary": "false",
+		"foo":                   "bar",
+	}, msg.Android.Data)
+
+	// the summary is tagged with the group key, so it replaces the previous
+	// summary of the group
+	req.Notification.Tag = ""
+	req.Notification.GroupSummary = true
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "chat-1", msg.Android.Notification.Tag)
+	assert.Equal(t, "true", msg.Android.Data["android_group_summary"])
+
+	req.Notification.GroupKey = ""
+	assert.EqualError(t, CheckMessage(req), "the group summary requires a group_key")
+
+	req.Notification.GroupSummary = false
+	req.Notification.Tag = strings.Repeat("a", 65)
+	assert.EqualError(t, CheckMessage(req), "the notification tag must be at most 64 characters, got 65")
+}
+
+func TestAndroidDeepLink(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Data:     D{"foo": "bar"},
+		Notification: &FCMNotification{
+			DeepLink: "myapp://orders/42",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "myapp://orders/42", msg.Android.Notification.ClickAction)
+	assert.Equal(t, map[string]string{
+		"deep_link": "myapp://orders/42",
+		"foo":       "bar",
+	}, msg.Android.Data)
+
+	req.Notification.DeepLink = "https://example.com/orders/42"
+	assert.NoError(t, CheckMessage(req))
+
+	for _, link := range []string{"orders/42", "myapp://", "://orders"} {
+		req.Notification.DeepLink = link
+		assert.EqualError(t, CheckMessage(req), "the deep link must be an absolute URI: "+strconv.Quote(link))
+	}
+
+	req.Notification.DeepLink = "myapp://orders/42"
+	req.Notification.ClickAction = "OPEN_ORDER"
+	assert.EqualError(t, CheckMessage(req), "the notification can't specify both click_action and deep_link")
+}
+
+func TestAndroidAutoDefaultsForHighPriority(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.AutoDefaultsForHighPriority = true
+	cfg.Android.DefaultSound = "config.mp3"
+
+	tests := []struct {
+		name           string
+		notification   *FCMNotification
+		sound          interface{}
+		defaultSound   bool
+		defaultVibrate bool
+		wantSound      string
+	}{
+		{
+			name:           "high priority",
+			notification:   &FCMNotification{NotificationPriority: "high"},
+			defaultSound:   true,
+			defaultVibrate: true,
+		},
+		{
+			name:           "max priority",
+			notification:   &FCMNotification{NotificationPriority: "max"},
+			defaultSound:   true,
+			defaultVibrate: true,
+		},
+		{
+			name:           "high importance",
+			notification:   &FCMNotification{Importance: "high"},
+			defaultSound:   true,
+			defaultVibrate: true,
+		},
+		{
+			name:         "normal priority",
+			notification: &FCMNotification{NotificationPriority: "default"},
+			wantSound:    "config.mp3",
+		},
+		{
+			name: "explicit overrides",
+			notification: &FCMNotification{
+				NotificationPriority: "high",
+				Sound:                "ping.mp3",
+				VibrateTimings:       []int64{0, 100},
+			},
+			wantSound: "ping.mp3",
+		},
+		{
+			name:           "request sound",
+			notification:   &FCMNotification{NotificationPriority: "high"},
+			sound:          "alert.mp3",
+			defaultVibrate: true,
+			wantSound:      "alert.mp3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &PushNotification{
+				Message:      "Test",
+				Platform:     core.PlatFormAndroid,
+				Tokens:       []string{"XXXXXXXXX"},
+				Notification: tt.notification,
+				Sound:        tt.sound,
+			}
+			assert.NoError(t, CheckMessage(req))
+
+			msg, err := getAndroidNotificationV1(req, cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.defaultSound, msg.Android.Notification.DefaultSound)
+			assert.Equal(t, tt.defaultVibrate, msg.Android.Notification.DefaultVibrateTimings)
+			assert.Equal(t, tt.wantSound, msg.Android.Notification.Sound)
+		})
+	}
+
+	// the priority doesn't change the defaults when disabled
+	cfg.Android.AutoDefaultsForHighPriority = false
+	msg, err := getAndroidNotificationV1(&PushNotification{
+		Message:      "Test",
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{NotificationPriority: "high"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.False(t, msg.Android.Notification.DefaultSound)
+	assert.False(t, msg.Android.Notification.DefaultVibrateTimings)
+	assert.Equal(t, "config.mp3", msg.Android.Notification.Sound)
+}
+
+func TestAndroidInheritNotificationPriority(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	tests := []struct {
+		name         string
+		priority     string
+		notification *FCMNotification
+		want         messaging.AndroidNotificationPriority
+	}{
+		{
+			name:     "high message",
+			priority: "high",
+			want:     messaging.PriorityHigh,
+		},
+		{
+			name:     "normal message",
+			priority: "normal",
+		},
+		{
+			name: "no priority",
+		},
+		{
+			name:         "notification priority",
+			priority:     "high",
+			notification: &FCMNotification{NotificationPriority: "low"},
+			want:         messaging.PriorityLow,
+		},
+		{
+			name:         "notification importance",
+			priority:     "high",
+			notification: &FCMNotification{Importance: "default"},
+			want:         messaging.PriorityDefault,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &PushNotification{
+				Message:      "Test",
+				Platform:     core.PlatFormAndroid,
+				Tokens:       []string{"XXXXXXXXX"},
+				Priority:     tt.priority,
+				Notification: tt.notification,
+			}
+			assert.NoError(t, CheckMessage(req))
+
+			msg, err := getAndroidNotificationV1(req, cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, msg.Android.Notification.Priority)
+		})
+	}
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+	}
+
+	// the default priority of the messages is inherited too
+	cfg.Android.DefaultPriority = "high"
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "high", msg.Android.Priority)
+	assert.Equal(t, messaging.PriorityHigh, msg.Android.Notification.Priority)
+
+	// the inherited priority is a heads-up one
+	cfg.Android.AutoDefaultsForHighPriority = true
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.True(t, msg.Android.Notification.DefaultSound)
+	assert.True(t, msg.Android.Notification.DefaultVibrateTimings)
+
+	cfg.Android.AutoDefaultsForHighPriority = false
+	cfg.Android.InheritNotificationPriority = false
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "high", msg.Android.Priority)
+	assert.Equal(t, messaging.AndroidNotificationPriority(0), msg.Android.Notification.Priority)
+}
+
+func TestAndroidPriority(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+	}
+
+	for _, priority := range []string{"high", "normal", ""} {
+		req.Priority = priority
+		assert.NoError(t, CheckMessage(req))
+
+		msg, err := getAndroidNotificationV1(req, cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, priority, msg.Android.Priority)
+	}
+
+	req.Priority = "urgent"
+	assert.EqualError(t, CheckMessage(req), `the message's priority must be normal or high, got "urgent"`)
+
+	// the default priority is used when the request doesn't set one
+	cfg.Android.DefaultPriority = "high"
+	req.Priority = ""
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "high", msg.Android.Priority)
+
+	req.Priority = "normal"
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "normal", msg.Android.Priority)
+}
+
+func TestAndroidEventTime(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{
+			EventTime: "2024-03-01T09:30:00+01:00",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1709281800), msg.Android.Notification.EventTimestamp.Unix())
+
+	// unix seconds as JSON number or string
+	for _, v := range []interface{}{float64(1709281800), "1709281800"} {
+		req.Notification.EventTime = v
+		assert.NoError(t, CheckMessage(req))
+
+		msg, err = getAndroidNotificationV1(req, cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1709281800), msg.Android.Notification.EventTimestamp.Unix())
+	}
+
+	req.Notification.EventTime = nil
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Android.Notification.EventTimestamp)
+
+	req.Notification.EventTime = "tomorrow"
+	assert.EqualError(t, CheckMessage(req), `event time "tomorrow" is neither RFC3339 nor unix seconds`)
+
+	req.Notification.EventTime = true
+	assert.Error(t, CheckMessage(req))
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid event time format")
+}
+
+func TestAndroidStickyAndLocalOnly(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Now playing",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{
+			Sticky:    true,
+			LocalOnly: true,
+		},
+	}
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.True(t, msg.Android.Notification.Sticky)
+	assert.True(t, msg.Android.Notification.LocalOnly)
+
+	req.Notification = &FCMNotification{}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.False(t, msg.Android.Notification.Sticky)
+	assert.False(t, msg.Android.Notification.LocalOnly)
+}
+
+func TestAndroidTopicMessage(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Topic:    "news",
+	}
+
+	assert.True(t, req.IsTopic())
+	assert.NoError(t, CheckMessage(req))
+
+	notification, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+
+	msg := getAndroidTopicMessageV1(req, notification)
+	assert.Equal(t, "news", msg.Topic)
+	assert.Empty(t, msg.Condition)
+	assert.Empty(t, msg.Token)
+	assert.Equal(t, "Test", msg.Notification.Body)
+
+	// legacy topic format
+	req = &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		To:       "/topics/foo-bar",
+	}
+
+	assert.True(t, req.IsTopic())
+	assert.False(t, req.IsDeviceGroup())
+	assert.NoError(t, CheckMessage(req))
+	assert.Equal(t, "/topics/foo-bar", getAndroidTopicMessageV1(req, notification).Topic)
+
+	req = &PushNotification{
+		Message:   "Test",
+		Platform:  core.PlatFormAndroid,
+		Condition: "'stock' in topics && 'tech' in topics",
+	}
+
+	assert.True(t, req.IsTopic())
+	assert.NoError(t, CheckMessage(req))
+
+	msg = getAndroidTopicMessageV1(req, notification)
+	assert.Empty(t, msg.Topic)
+	assert.Equal(t, "'stock' in topics && 'tech' in topics", msg.Condition)
+}
+
+// groupFCMClient records the single messages sent.
+type groupFCMClient struct {
+	blockingFCMClient
+	messages []*messaging.Message
+}
+
+func (c *groupFCMClient) Send(_ context.Context, m *messaging.Message) (string, error) {
+	c.messages = append(c.messages, m)
+	return "projects/foo-123/messages/1", nil
+}
+
+func TestAndroidDeviceGroupMessage(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &groupFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		To:       "aUniqueKey",
+	}
+
+	assert.False(t, req.IsTopic())
+	assert.True(t, req.IsDeviceGroup())
+	assert.NoError(t, CheckMessage(req))
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Total)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 1, len(resp.Logs))
+	assert.Equal(t, "aUniqueKey", resp.Logs[0].Token)
+	assert.Equal(t, "projects/foo-123/messages/1", resp.Logs[0].MessageID)
+
+	assert.Equal(t, 1, len(client.messages))
+	assert.Equal(t, "aUniqueKey", client.messages[0].Token)
+	assert.Empty(t, client.messages[0].Topic)
+	assert.Empty(t, client.messages[0].Condition)
+	assert.Equal(t, "Test", client.messages[0].Notification.Body)
+
+	// the device group excludes the other targets
+	msg := "the message can't specify both a device group notification key and registration IDs, a topic or condition"
+	req.Tokens = []string{"aaaaaaaaa"}
+	assert.EqualError(t, CheckMessage(req), msg)
+
+	req.Tokens = nil
+	req.Topic = "news"
+	assert.EqualError(t, CheckMessage(req), msg)
+
+	req.Topic = ""
+	req.Condition = "'stock' in topics"
+	assert.EqualError(t, CheckMessage(req), msg)
+}
+
+func TestAndroidVibrateTimingsAndLightSettings(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Notification: &FCMNotification{
+			VibrateTimings: []int64{100, 200, 300},
+			LightSettings: &FCMLightSettings{
+				Color:            "#00FF00",
+				LightOnDuration:  500,
+				LightOffDuration: 1000,
+			},
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{100, 200, 300}, msg.Android.Notification.VibrateTimingMillis)
+	assert.Equal(t, &messaging.LightSettings{
+		Color:                  "#00FF00",
+		LightOnDurationMillis:  500,
+		LightOffDurationMillis: 1000,
+	}, msg.Android.Notification.LightSettings)
+
+	// invalid light settings color
+	req.Notification.LightSettings.Color = "green"
+	assert.Error(t, CheckMessage(req))
+
+	req.Notification.LightSettings.Color = "#00FF0"
+	assert.Error(t, CheckMessage(req))
+
+	// negative vibrate timings
+	req.Notification.LightSettings.Color = "#00FF00AA"
+	assert.NoError(t, CheckMessage(req))
+	req.Notification.VibrateTimings = []int64{100, -1}
+	assert.Error(t, CheckMessage(req))
+}
+
+func TestAndroidWebPush(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		WebPush: &WebPushConfig{
+			Headers: map[string]string{
+				"TTL":     "3600",
+				"Urgency": "high",
+			},
+			Notification: &WebPushNotification{
+				Title: "Web Title",
+				Body:  "Web Body",
+				Icon:  "https://example.com/icon.png",
+				Actions: []WebPushAction{
+					{Action: "open", Title: "Open"},
+				},
+			},
+			Link: "https://example.com/landing",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "3600", msg.Webpush.Headers["TTL"])
+	assert.Equal(t, "high", msg.Webpush.Headers["Urgency"])
+	assert.Equal(t, "Web Title", msg.Webpush.Notification.Title)
+	assert.Equal(t, "Web Body", msg.Webpush.Notification.Body)
+	assert.Equal(t, "https://example.com/icon.png", msg.Webpush.Notification.Icon)
+	assert.Equal(t, 1, len(msg.Webpush.Notification.Actions))
+	assert.Equal(t, "open", msg.Webpush.Notification.Actions[0].Action)
+	assert.Equal(t, "https://example.com/landing", msg.Webpush.FCMOptions.Link)
+
+	// webpush link must be a valid https URL
+	req.WebPush.Link = "http://example.com/landing"
+	assert.Error(t, CheckMessage(req))
+
+	req.WebPush.Link = "landing"
+	assert.Error(t, CheckMessage(req))
+
+	// no webpush payload
+	req.WebPush = nil
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Webpush)
+}
+
+func TestAndroidFCMOptions(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:        "Test",
+		Platform:       core.PlatFormAndroid,
+		Tokens:         []string{"XXXXXXXXX"},
+		AnalyticsLabel: "android",
+		FCMOptions: &FCMOptions{
+			AnalyticsLabel: "campaign",
+			Link:           "https://example.com/campaign",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	// the message level label only applies to the outer message
+	assert.Equal(t, "campaign", msg.FCMOptions.AnalyticsLabel)
+	assert.Equal(t, "android", msg.Android.FCMOptions.AnalyticsLabel)
+	assert.Equal(t, "https://example.com/campaign", msg.Webpush.FCMOptions.Link)
+
+	// the webpush link is used first
+	req.WebPush = &WebPushConfig{Link: "https://example.com/landing"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/landing", msg.Webpush.FCMOptions.Link)
+
+	req.FCMOptions.Link = "http://example.com/campaign"
+	assert.EqualError(t, CheckMessage(req), "the fcm_options link must be a valid https URL, got http://example.com/campaign")
+
+	req.FCMOptions.Link = "campaign"
+	assert.Error(t, CheckMessage(req))
+
+	req.FCMOptions.Link = ""
+	req.FCMOptions.AnalyticsLabel = "invalid label!"
+	assert.Error(t, CheckMessage(req))
+}
+
+func TestAndroidAPNSOverride(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	badge := 3
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		FCMApns: &FCMApnsConfig{
+			Headers: map[string]string{
+				"apns-priority": "10",
+			},
+			Aps: &FCMAps{
+				Alert:    "Hello iOS",
+				Badge:    &badge,
+				Sound:    "default",
+				Category: "news",
+			},
+			CustomData: D{
+				"campaign": "spring",
+			},
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", msg.APNS.Headers["apns-priority"])
+	assert.Equal(t, "Hello iOS", msg.APNS.Payload.Aps.AlertString)
+	assert.Equal(t, 3, *msg.APNS.Payload.Aps.Badge)
+	assert.Equal(t, "default", msg.APNS.Payload.Aps.Sound)
+	assert.Equal(t, "news", msg.APNS.Payload.Aps.Category)
+	assert.Equal(t, "spring", msg.APNS.Payload.CustomData["campaign"])
+
+	// the message can't specify both apns and fcm_apns
+	req.Apns = D{"aps": D{"alert": "Hello"}}
+	assert.Error(t, CheckMessage(req))
+}
+
+func TestAndroidAPNSAlert(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		FCMApns: &FCMApnsConfig{
+			Aps: &FCMAps{Alert: "Hello iOS"},
+		},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	// the alert is a string without a title, subtitle nor loc keys
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	data, err := json.Marshal(msg.APNS.Payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps": {"alert": "Hello iOS"}}`, string(data))
+
+	req.FCMApns.Aps = &FCMAps{
+		Alert:        "Hello iOS",
+		Subtitle:     "Spring",
+		LocKey:       "GAME_PLAY_REQUEST_FORMAT",
+		LocArgs:      []string{"Jenna", "Frank"},
+		TitleLocKey:  "GAME_TITLE",
+		TitleLocArgs: []string{"Chess"},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	data, err = json.Marshal(msg.APNS.Payload)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"aps": {"alert": {
+		"subtitle": "Spring",
+		"body": "Hello iOS",
+		"loc-key": "GAME_PLAY_REQUEST_FORMAT",
+		"loc-args": ["Jenna", "Frank"],
+		"title-loc-key": "GAME_TITLE",
+		"title-loc-args": ["Chess"]
+	}}}`, string(data))
+
+	// the loc args are sent with their loc key
+	req.FCMApns.Aps.LocKey = ""
+	assert.EqualError(t, CheckMessage(req), "the fcm_apns alert loc_args require a loc_key")
+
+	req.FCMApns.Aps.LocKey = "GAME_PLAY_REQUEST_FORMAT"
+	req.FCMApns.Aps.TitleLocKey = ""
+	assert.EqualError(t, CheckMessage(req), "the fcm_apns alert title_loc_args require a title_loc_key")
+}
+
+func TestAndroidAPNSHeaders(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		FCMApns: &FCMApnsConfig{
+			Headers: map[string]string{
+				"apns-collapse-id": "score",
+				"apns-expiration":  "1704067200",
+				"apns-priority":    "5",
+				"apns-push-type":   "alert",
+			},
+		},
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+
+	// every header is sent to FCM
+	data, err := json.Marshal(msg.APNS)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"headers": {
+			"apns-collapse-id": "score",
+			"apns-expiration": "1704067200",
+			"apns-priority": "5",
+			"apns-push-type": "alert"
+		},
+		"payload": {"aps": {}}
+	}`, string(data))
+}
+
+func TestCheckFCMApnsHeaders(t *testing.T) {
+	assert.NoError(t, checkFCMApnsHeaders(nil))
+	assert.NoError(t, checkFCMApnsHeaders(map[string]string{"Apns-Priority": "10", "apns-expiration": "0"}))
+
+	assert.EqualError(t, checkFCMApnsHeaders(map[string]string{"Apns-Priority": "1"}),
+		`the apns-priority header must be 5 or 10, got "1"`)
+	assert.EqualError(t, checkFCMApnsHeaders(map[string]string{"apns-priority": "high"}),
+		`the apns-priority header must be 5 or 10, got "high"`)
+	assert.EqualError(t, checkFCMApnsHeaders(map[string]string{"apns-expiration": "2024-01-01"}),
+		`the apns-expiration header must be a UNIX epoch in seconds, got "2024-01-01"`)
+	assert.EqualError(t, checkFCMApnsHeaders(map[string]string{"apns-expiration": "-1"}),
+		`the apns-expiration header must be a UNIX epoch in seconds, got "-1"`)
+	assert.EqualError(t, checkFCMApnsHeaders(map[string]string{"apns-collapse-id": strings.Repeat("a", 65)}),
+		"the apns-collapse-id header must be at most 64 bytes, got 65")
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		FCMApns:  &FCMApnsConfig{Headers: map[string]string{"apns-priority": "1"}},
+	}
+	assert.Error(t, CheckMessage(req))
+}
+
+// newFCMTestClient returns a messaging client talking to a local server
+// which always replies with the given status code and body.
+func newFCMTestClient(t *testing.T, code int, body string) *messaging.Client {
+	t.Helper()
+
+	return newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body))
+	})
+}
+
+// newFCMTestHandlerClient returns a messaging client talking to a local server
+// using the given handler.
+func newFCMTestHandlerClient(t *testing.T, handler http.HandlerFunc) *messaging.Client {
+	t.Helper()
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	ctx := context.Background()
+	app, err := firebase.NewApp(ctx,
+		&firebase.Config{ProjectID: "test-project"},
+		option.WithEndpoint(ts.URL),
+		option.WithoutAuthentication(),
+	)
+	assert.NoError(t, err)
+
+	client, err := app.Messaging(ctx)
+	assert.NoError(t, err)
+
+	return client
+}
+
+// setFCMTestClient registers the client for the project until the test ends.
+func setFCMTestClient(t *testing.T, projectID string, cfg *config.ConfYaml, client FCMClient) {
+	t.Helper()
+
+	key := fcmV1ClientKey(projectID, cfg.Android.ServiceAccountKey)
+	fcmV1ClientsLock.Lock()
+	fcmV1Clients[key] = client
+	fcmV1ClientsLock.Unlock()
+
+	t.Cleanup(func() {
+		fcmV1ClientsLock.Lock()
+		delete(fcmV1Clients, key)
+		fcmV1ClientsLock.Unlock()
+	})
+}
+
+func fcmErrorBody(status, code string) string {
+	return `{"error": {"status": "` + status + `", "message": "test error", "details": [` +
+		`{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "` + code + `"}]}}`
+}
+
+func TestFCMErrorType(t *testing.T) {
+	assert.Equal(t, "", fcmErrorType(nil))
+	assert.Equal(t, "", fcmErrorType(errors.New("unknown")))
+
+	assert.Equal(t, status.AndroidErrorUnknown, fcmStatErrorType(errors.New("unknown")))
+
+	assert.Equal(t, core.ErrorCode(""), fcmErrorCode(errors.New("unknown")))
+	assert.Equal(t, core.ErrorCodeTimeout, fcmErrorCode(context.DeadlineExceeded))
+	assert.Equal(t, core.ErrorCodeQuotaExceeded, fcmErrorCode(ErrFCMRateLimited))
+
+	tests := []struct {
+		code      int
+		body      string
+		expected  string
+		statType  string
+		errorCode core.ErrorCode
+	}{
+		{
+			http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED"),
+			ErrorTypeInvalidToken, status.AndroidErrorUnregistered, core.ErrorCodeInvalidToken,
+		},
+		{
+			http.StatusTooManyRequests, fcmErrorBody("RESOURCE_EXHAUSTED", "QUOTA_EXCEEDED"),
+			ErrorTypeQuota, status.AndroidErrorQuotaExceeded, core.ErrorCodeQuotaExceeded,
+		},
+		{
+			http.StatusBadGateway, fcmErrorBody("UNAVAILABLE", "UNAVAILABLE"),
+			ErrorTypeServer, status.AndroidErrorUnavailable, core.ErrorCodeServerError,
+		},
+		{
+			http.StatusInternalServerError, fcmErrorBody("INTERNAL", "INTERNAL"),
+			ErrorTypeServer, status.AndroidErrorInternal, core.ErrorCodeServerError,
+		},
+		{
+			http.StatusForbidden, fcmErrorBody("PERMISSION_DENIED", "SENDER_ID_MISMATCH"),
+			ErrorTypeAuth, status.AndroidErrorSenderIDMismatch, core.ErrorCodeAuthError,
+		},
+		{
+			http.StatusUnauthorized, fcmErrorBody("UNAUTHENTICATED", "THIRD_PARTY_AUTH_ERROR"),
+			ErrorTypeAuth, status.AndroidErrorThirdPartyAuth, core.ErrorCodeAuthError,
+		},
+		{
+			http.StatusBadRequest, fcmErrorBody("INVALID_ARGUMENT", "INVALID_ARGUMENT"),
+			"", status.AndroidErrorInvalidArgument, core.ErrorCodeInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		client := newFCMTestClient(t, tt.code, tt.body)
+		_, err := client.Send(context.Background(), &messaging.Message{Token: "XXXXXXXXX"})
+		assert.Error(t, err)
+		assert.Equal(t, tt.expected, fcmErrorType(err), tt.body)
+		assert.Equal(t, tt.statType, fcmStatErrorType(err), tt.body)
+		assert.Equal(t, tt.errorCode, fcmErrorCode(err), tt.body)
+	}
+}
+
+func TestAndroidInvalidTokens(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resp.Logs))
+	assert.Equal(t, core.FailedPush, resp.Logs[0].Type)
+	assert.Equal(t, ErrorTypeInvalidToken, resp.Logs[0].ErrorType)
+	assert.Equal(t, core.ErrorCodeInvalidToken, resp.Logs[0].ErrorCode)
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb"}, resp.InvalidTokens)
+
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidError())
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorUnregistered))
+}
+
+func TestAndroidDedupTokens(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := []string{"aaaaaaaaa", "bbbbbbbbb", "aaaaaaaaa", "ccccccccc", "bbbbbbbbb"}
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}
+
+	// the duplicates are sent without the flag
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{tokens}, client.batches)
+	assert.Equal(t, 5, resp.Success)
+
+	client.batches = nil
+	cfg.Android.DedupTokens = true
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"}}, client.batches)
+	assert.Equal(t, tokens, req.Tokens)
+
+	// the result of a token is copied to the positions of its duplicates
+	assert.Equal(t, 5, resp.Total)
+	assert.Equal(t, 5, resp.Success)
+	assert.Equal(t, 0, resp.Failure)
+	assert.Equal(t, 5, len(resp.Logs))
+	for _, l := range resp.Logs {
+		assert.Equal(t, core.SucceededPush, l.Type)
+		assert.Equal(t, tokens[*l.Index], l.MessageID)
+	}
+}
+
+func TestCheckFCMToken(t *testing.T) {
+	assert.NoError(t, checkFCMToken("dGVzdA:APA91bH-abc_123.x"))
+
+	for _, token := range []string{"", "   ", "aaaa bbbb", "aaaa\n", "aaaa/bbbb", strings.Repeat("a", 4097)} {
+		err := checkFCMToken(token)
+		assert.ErrorIs(t, err, ErrFCMTokenFormat, token)
+	}
+	assert.EqualError(t, checkFCMToken(" "), "invalid token format: the token must not be empty")
+	assert.EqualError(t, checkFCMToken(strings.Repeat("a", 4097)),
+		"invalid token format: the token must be at most 4096 bytes, got 4097")
+}
+
+func TestAndroidTokenFormat(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := []string{"aaaaaaaaa", "", "bbb bbbbb", strings.Repeat("c", 4097), "ddddddddd"}
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}
+
+	status.StatStorage.Reset()
+
+	// the malformed tokens fail without being sent
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"aaaaaaaaa", "ddddddddd"}}, client.batches)
+	assert.Equal(t, tokens, req.Tokens)
+	assert.Equal(t, 5, resp.Total)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 3, resp.Failure)
+	assert.Empty(t, resp.InvalidTokens)
+
+	var failed []int
+	for _, l := range resp.Logs {
+		if l.Type == core.FailedPush {
+			failed = append(failed, *l.Index)
+			assert.Equal(t, ErrorTypeInvalidFormat, l.ErrorType)
+			assert.Equal(t, core.ErrorCodeInvalidToken, l.ErrorCode)
+			assert.Equal(t, tokens[*l.Index], l.Token)
+		}
+	}
+	assert.Equal(t, []int{1, 2, 3}, failed)
+	assert.Equal(t, int64(3), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorInvalidFormat))
+	assert.Equal(t, int64(3), status.StatStorage.GetAndroidError())
+
+	// the strict validation rejects the whole request
+	client.batches = nil
+	cfg.Android.StrictTokenValidation = true
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "the token at index 1 is invalid: invalid token format: the token must not be empty")
+	assert.Empty(t, client.batches)
+}
+
+func TestAndroidFailFast(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{
+		onSend: rejectTokens(errors.New("requested entity was not found"), "ggggggggg"),
+	})
+
+	tokens := []string{
+		"aaaaaaaaa", "bbbbbbbbb", "ccccccccc", "ddddddddd", "eeeeeeeee",
+		"fffffffff", "ggggggggg", "hhhhhhhhh", "iiiiiiiii", "jjjjjjjjj",
+	}
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+		FailFast: true,
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	// the tokens are all sent and logged, the failed one fails the notification
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrFailFast)
+	assert.EqualError(t, err, "fail fast: the token at index 6 failed: requested entity was not found")
+	assert.Equal(t, err.Error(), resp.Error)
+	assert.Equal(t, 9, resp.Success)
+	assert.Equal(t, 1, resp.Failure)
+	assert.Equal(t, len(tokens), len(resp.Logs))
+
+	// the partial success is the default
+	req.FailFast = false
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Error)
+	assert.Equal(t, 9, resp.Success)
+
+	req.FailFast = true
+	req.Tokens = []string{"aaaaaaaaa", "bbbbbbbbb"}
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+
+	req.Platform = core.PlatFormIos
+	assert.EqualError(t, CheckMessage(req), "the fail fast is only supported by Android")
+}
+
+func TestAndroidFailFastRetry(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.MaxRetry = 1
+	cfg.Android.RetryInterval = 0
+
+	var lock sync.Mutex
+	calls := map[string]int{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		calls[body.Message.Token]++
+		count := calls[body.Message.Token]
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if body.Message.Token == "bbbbbbbbb" && count == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(fcmErrorBody("UNAVAILABLE", "UNAVAILABLE")))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/1"}`))
+	}))
+
+	// the token succeeded on the retry, its first failure doesn't count
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+		FailFast: true,
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Error)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 0, resp.Failure)
+	assert.Equal(t, 2, calls["bbbbbbbbb"])
+}
+
+func TestMaxTokensPerRequest(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Core.MaxTokensPerRequest = 2
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"},
+	}
+
+	// the notification is rejected before any send
+	resp, err := SendNotification(context.Background(), req, cfg)
+	assert.Nil(t, resp)
+	assert.ErrorIs(t, err, ErrTooManyTokens)
+	assert.EqualError(t, err, "too many tokens: the message has 3 tokens, over the limit of 2")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, ValidationStatus(err))
+	assert.Empty(t, client.batches)
+	assert.ErrorIs(t, ValidateNotification(context.Background(), req, cfg), ErrTooManyTokens)
+
+	req.Tokens = req.Tokens[:2]
+	resp, err = SendNotification(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+
+	// zero is unlimited
+	cfg.Core.MaxTokensPerRequest = 0
+	assert.NoError(t, CheckTokenCount(&PushNotification{Tokens: make([]string, 1000)}, cfg))
+}
+
+func TestCheckTenant(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	assert.NoError(t, CheckTenant(&PushNotification{}, cfg))
+	assert.NoError(t, CheckTenant(&PushNotification{TenantID: "acme-eu.prod_1"}, cfg))
+	assert.Error(t, CheckTenant(&PushNotification{TenantID: "acme eu"}, cfg))
+	assert.Error(t, CheckTenant(&PushNotification{TenantID: strings.Repeat("a", 65)}, cfg))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		TenantID: "globex",
+	}
+
+	// the tenants not listed are rejected before any send
+	cfg.Core.Tenants = []string{"acme"}
+	resp, err := SendNotification(context.Background(), req, cfg)
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, `the tenant_id "globex" is not listed in core.tenants`)
+	assert.Empty(t, client.batches)
+	assert.Error(t, ValidateNotification(context.Background(), req, cfg))
+
+	req.TenantID = "acme"
+	resp, err = SendNotification(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+}
+
+func TestAndroidDedupInvalidTokens(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.DedupTokens = true
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "aaaaaaaaa", "bbbbbbbbb"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 3, resp.Failure)
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb", "aaaaaaaaa"}, resp.InvalidTokens)
+
+	indexes := make([]int, 0, len(resp.Logs))
+	for _, l := range resp.Logs {
+		assert.Equal(t, ErrorTypeInvalidToken, l.ErrorType)
+		indexes = append(indexes, *l.Index)
+	}
+	assert.ElementsMatch(t, []int{0, 1, 2}, indexes)
+}
+
+func TestDedupAndroidTokens(t *testing.T) {
+	// the tokens with distinct titles aren't duplicates
+	indexes, duplicates := dedupAndroidTokens(&PushNotification{
+		Tokens: []string{"a", "a", "b", "a"},
+		Titles: []string{"x", "y", "x", "x"},
+	})
+	assert.Equal(t, []int{0, 1, 2}, indexes)
+	assert.Equal(t, map[int][]int{0: {3}}, duplicates)
+}
+
+func TestAndroidRawError(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	// the raw error is left out by default
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Logs))
+	assert.Empty(t, resp.Logs[0].RawError)
+	assert.Empty(t, resp.Logs[0].RawErrorCode)
+	assert.Equal(t, 0, resp.Logs[0].RawHTTPStatus)
+
+	req.IncludeRawError = true
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Logs))
+	assert.Equal(t, "test error", resp.Logs[0].RawError)
+	assert.Equal(t, "UNREGISTERED", resp.Logs[0].RawErrorCode)
+	assert.Equal(t, http.StatusNotFound, resp.Logs[0].RawHTTPStatus)
+}
+
+func TestFCMRawError(t *testing.T) {
+	code, httpStatus := fcmRawError(errors.New("unknown"))
+	assert.Empty(t, code)
+	assert.Equal(t, 0, httpStatus)
+
+	// the status is returned without a FCM error code
+	client := newFCMTestClient(t, http.StatusBadRequest, `{"error": {"status": "INVALID_ARGUMENT", "message": "test error"}}`)
+	_, err := client.Send(context.Background(), &messaging.Message{Token: "XXXXXXXXX"})
+	code, httpStatus = fcmRawError(err)
+	assert.Equal(t, "INVALID_ARGUMENT", code)
+	assert.Equal(t, http.StatusBadRequest, httpStatus)
+}
+
+func TestAndroidStatByTenant(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{})
+
+	status.StatStorage.Reset()
+
+	for tenant, tokens := range map[string][]string{
+		"alpha": {"aaaaaaaaa", "bbbbbbbbb"},
+		"beta":  {"ccccccccc"},
+	} {
+		_, err := PushToAndroidV1(context.Background(), &PushNotification{
+			Message:  "Test",
+			Platform: core.PlatFormAndroid,
+			Tokens:   tokens,
+			TenantID: tenant,
+		}, cfg)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(3), status.StatStorage.GetAndroidSuccess())
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidSuccessByTenant("alpha"))
+	assert.Equal(t, int64(1), status.StatStorage.GetAndroidSuccessByTenant("beta"))
+	assert.Equal(t, int64(0), status.StatStorage.GetAndroidErrorByTenant("beta"))
+}
+
+func TestAndroidProjectOverride(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "default-project"
+
+	setFCMTestClient(t, "default-project", cfg,
+		newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+	setFCMTestClient(t, "other-project", cfg,
+		newFCMTestClient(t, http.StatusOK, `{"name": "projects/other-project/messages/1"}`))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	// fallback to the project of the config
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.FailedPush, resp.Logs[0].Type)
+
+	req.ProjectID = "other-project"
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+	assert.Equal(t, "projects/other-project/messages/1", resp.Logs[0].MessageID)
+}
+
+// blockingFCMClient never answers until the context is done.
+type blockingFCMClient struct{}
+
+func (blockingFCMClient) Send(ctx context.Context, _ *messaging.Message) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (blockingFCMClient) SendEachForMulticast(
+	ctx context.Context,
+	_ *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c blockingFCMClient) SendDryRun(ctx context.Context, m *messaging.Message) (string, error) {
+	return c.Send(ctx, m)
+}
+
+func (c blockingFCMClient) SendEachForMulticastDryRun(
+	ctx context.Context,
+	m *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	return c.SendEachForMulticast(ctx, m)
+}
+
+func TestAndroidInFlight(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, blockingFCMClient{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = PushToAndroidV1(ctx, &PushNotification{
+			Message:  "Test",
+			Platform: core.PlatFormAndroid,
+			Tokens:   []string{"aaaaaaaaa"},
+		}, cfg)
+	}()
+
+	// the send is in flight until FCM answers
+	assert.Eventually(t, func() bool {
+		return status.StatStorage.GetInFlight() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	assert.Equal(t, int64(0), status.StatStorage.GetInFlight())
+}
+
+func TestAndroidSendLatency(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/fake_message_id"}`))
+	}))
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+
+	latencies := status.StatStorage.SendLatencies()
+	assert.Equal(t, 1, len(latencies))
+
+	latency := latencies[status.LatencyKey{Platform: "android", Outcome: status.SendSuccess}]
+	assert.Equal(t, uint64(1), latency.Count)
+	assert.GreaterOrEqual(t, latency.Sum, 0.05)
+	assert.Equal(t, uint64(0), latency.Buckets[0.05])
+	assert.Equal(t, uint64(1), latency.Buckets[30])
+}
+
+func TestAndroidSendTimeout(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.Timeout = 1
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, blockingFCMClient{})
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	start := time.Now()
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 2, len(resp.Logs))
+	for _, l := range resp.Logs {
+		assert.Equal(t, core.FailedPush, l.Type)
+		assert.Equal(t, ErrorTypeTimeout, l.ErrorType)
+	}
+	assert.Equal(t, 2, resp.Total)
+	assert.Equal(t, 0, resp.Success)
+	assert.Equal(t, 2, resp.Failure)
+}
+
+func TestAndroidRetry(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.MaxRetry = 2
+	cfg.Android.RetryInterval = 0
+
+	var lock sync.Mutex
+	calls := map[string]int{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		calls[body.Message.Token]++
+		count := calls[body.Message.Token]
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case body.Message.Token == "aaaaaaaaa":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+		case body.Message.Token == "bbbbbbbbb" && count == 1:
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(fcmErrorBody("UNAVAILABLE", "UNAVAILABLE")))
+		case body.Message.Token == "ccccccccc":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fcmErrorBody("INTERNAL", "INTERNAL")))
+		default:
+			_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/1"}`))
+		}
+	}))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"},
+	}
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+
+	// the tokens of the request are kept
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"}, req.Tokens)
+
+	// invalid tokens are never retried
+	assert.Equal(t, 1, calls["aaaaaaaaa"])
+	// retried once and succeeded
+	assert.Equal(t, 2, calls["bbbbbbbbb"])
+	// stop after max retry
+	assert.Equal(t, 3, calls["ccccccccc"])
+
+	// one log per token and attempt
+	assert.Equal(t, 6, len(resp.Logs))
+	// retried tokens keep their index in the request
+	attempts := map[int]int{}
+	for _, l := range resp.Logs {
+		attempts[*l.Index]++
+	}
+	assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 3}, attempts)
+	assert.Equal(t, []string{"aaaaaaaaa"}, resp.InvalidTokens)
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 2, resp.Failure)
+
+	// the first retry resent both tokens, the second only the failed one
+	assert.Equal(t, int64(3), status.StatStorage.GetRetry("android", status.RetryAttempt))
+	assert.Equal(t, int64(1), status.StatStorage.GetRetry("android", status.RetrySuccess))
+	assert.Equal(t, int64(2), status.StatStorage.GetRetry("android", status.RetryFailure))
+	assert.Equal(t, int64(0), status.StatStorage.GetRetry("ios", status.RetryAttempt))
+}
+
+func TestFCMV1RetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), fcmV1RetryBackoff(0, 1))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		backoff := time.Second << (attempt - 1)
+		wait := fcmV1RetryBackoff(time.Second, attempt)
+		assert.GreaterOrEqual(t, wait, backoff/2)
+		assert.LessOrEqual(t, wait, backoff)
+	}
+}
+
+func TestAndroidSuppressNotification(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:              "Welcome",
+		Title:                "Hello",
+		Image:                "https://example.com/a.png",
+		Platform:             core.PlatFormAndroid,
+		Tokens:               []string{"XXXXXXXXX"},
+		SuppressNotification: true,
+		Data: D{
+			"a":    "1",
+			"body": "custom",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Notification)
+	assert.Nil(t, msg.Android.Notification)
+
+	// the content is kept in the data, the keys of the request first
+	expected := map[string]string{
+		"a":     "1",
+		"title": "Hello",
+		"body":  "custom",
+		"image": "https://example.com/a.png",
+	}
+	assert.Equal(t, expected, msg.Data)
+	assert.Equal(t, expected, msg.Android.Data)
+
+	// the notification payload is used first
+	req.Notification = &FCMNotification{Title: "Android title"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "Android title", msg.Data["title"])
+}
+
+func TestAndroidRichContent(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:      "Welcome",
+		Image:        "https://example.com/a.png",
+		AndroidImage: "https://example.com/android.png",
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"XXXXXXXXX"},
+		RichContent:  true,
+		Data: D{
+			"a": "1",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+
+	// the notification keeps the image for the default rendering
+	assert.Equal(t, "https://example.com/android.png", msg.Android.Notification.ImageURL)
+	expected := map[string]string{
+		"a":         "1",
+		"image_url": "https://example.com/android.png",
+		"style":     "big_picture",
+	}
+	assert.Equal(t, expected, msg.Data)
+	assert.Equal(t, expected, msg.Android.Data)
+
+	// the image of the notification payload is used first and the keys of
+	// the request are kept
+	req.Notification = &FCMNotification{Image: "https://example.com/n.png"}
+	req.Data = D{"style": "custom"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/n.png", msg.Android.Data["image_url"])
+	assert.Equal(t, "custom", msg.Android.Data["style"])
+
+	// the rich content requires a https image
+	req.Notification.Image = "http://example.com/n.png"
+	assert.Error(t, CheckMessage(req))
+
+	req.Notification = nil
+	req.Image = ""
+	req.AndroidImage = ""
+	assert.EqualError(t, CheckMessage(req), `the rich content image must be a valid https URL, got ""`)
+
+	// no data key is added without the flag
+	req.RichContent = false
+	req.Image = "https://example.com/a.png"
+	req.Data = nil
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Android.Data)
+}
+
+func TestAndroidDataOnlyAndAnalyticsLabel(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:        "Test",
+		Platform:       core.PlatFormAndroid,
+		Tokens:         []string{"XXXXXXXXX"},
+		DataOnly:       true,
+		AnalyticsLabel: "spring_campaign-2024",
+		Data: D{
+			"a": "1",
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Notification)
+	assert.Nil(t, msg.Android.Notification)
+	assert.Equal(t, "1", msg.Data["a"])
+	assert.Equal(t, "spring_campaign-2024", msg.FCMOptions.AnalyticsLabel)
+	assert.Equal(t, "spring_campaign-2024", msg.Android.FCMOptions.AnalyticsLabel)
+
+	req.AnalyticsLabel = "invalid label!"
+	assert.Error(t, CheckMessage(req))
+
+	req.AnalyticsLabel = strings.Repeat("a", 51)
+	assert.Error(t, CheckMessage(req))
+}
+
+// labelFCMClient records the analytics labels of the messages sent one by one.
+type labelFCMClient struct {
+	blockingFCMClient
+	labels map[string][2]string
+}
+
+func (c *labelFCMClient) Send(_ context.Context, m *messaging.Message) (string, error) {
+	if m.Token == "ccccccccc" {
+		return "", errors.New("boom")
+	}
+
+	c.labels[m.Token] = [2]string{m.FCMOptions.AnalyticsLabel, m.Android.FCMOptions.AnalyticsLabel}
+	return "projects/foo-123/messages/" + m.Token, nil
+}
+
+func (c *labelFCMClient) SendEachForMulticast(
+	_ context.Context,
+	_ *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	return nil, errors.New("multicast must not be used with per token labels")
+}
+
+func TestAndroidAnalyticsLabels(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &labelFCMClient{labels: map[string][2]string{}}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Message:         "Test",
+		Platform:        core.PlatFormAndroid,
+		Tokens:          []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"},
+		AnalyticsLabel:  "control",
+		AnalyticsLabels: []string{"variant_a", "", "variant_c"},
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 1, resp.Failure)
+	assert.Equal(t, 3, len(resp.Logs))
+	assert.Equal(t, "projects/foo-123/messages/aaaaaaaaa", resp.Logs[0].MessageID)
+	assert.Equal(t, "boom", resp.Logs[2].Error)
+
+	assert.Equal(t, [2]string{"variant_a", "variant_a"}, client.labels["aaaaaaaaa"])
+	// an empty label falls back to the analytics label of the message
+	assert.Equal(t, [2]string{"control", "control"}, client.labels["bbbbbbbbb"])
+
+	req.AnalyticsLabels = []string{"variant_a"}
+	assert.EqualError(t, CheckMessage(req), "the message must specify one analytics label per token, got 1 labels for 3 tokens")
+
+	req.AnalyticsLabels = []string{"variant_a", "invalid label!", ""}
+	assert.Error(t, CheckMessage(req))
+}
+
+// personalizedFCMClient records the notifications of the messages sent one by one.
+type personalizedFCMClient struct {
+	blockingFCMClient
+	notifications map[string][2]*messaging.Notification
+}
+
+func (c *personalizedFCMClient) Send(_ context.Context, m *messaging.Message) (string, error) {
+	c.notifications[m.Token] = [2]*messaging.Notification{
+		m.Notification,
+		{Title: m.Android.Notification.Title, Body: m.Android.Notification.Body},
+	}
+	return "projects/foo-123/messages/" + m.Token, nil
+}
+
+func (c *personalizedFCMClient) SendEachForMulticast(
+	_ context.Context,
+	_ *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	return nil, errors.New("multicast must not be used with per token notifications")
+}
+
+func TestAndroidPersonalizedNotifications(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &personalizedFCMClient{notifications: map[string][2]*messaging.Notification{}}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Title:    "Hello",
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+		Titles:   []string{"Hi Alice", ""},
+		Bodies:   []string{"", "Welcome back Bob"},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 2, len(resp.Logs))
+
+	for _, n := range client.notifications["aaaaaaaaa"] {
+		assert.Equal(t, "Hi Alice", n.Title)
+		assert.Equal(t, "Test", n.Body)
+	}
+	// the empty values fall back to the title and message
+	for _, n := range client.notifications["bbbbbbbbb"] {
+		assert.Equal(t, "Hello", n.Title)
+		assert.Equal(t, "Welcome back Bob", n.Body)
+	}
+
+	req.Titles = []string{"Hi Alice"}
+	assert.EqualError(t, CheckMessage(req), "the message must specify one title per token, got 1 titles for 2 tokens")
+
+	req.Titles = nil
+	req.Bodies = []string{"Welcome", "Welcome", "Welcome"}
+	assert.EqualError(t, CheckMessage(req), "the message must specify one body per token, got 3 bodies for 2 tokens")
+}
+
+func TestAndroidPayloadSize(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	// 5KB of data
+	data := D{}
+	for i := 0; i < 5; i++ {
+		data["key"+strconv.Itoa(i)] = strings.Repeat("a", 1024)
+	}
+
+	req := &PushNotification{
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		DataOnly: true,
+		Data:     data,
+	}
+
+	_, err := getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "the data message payload must be at most 4096 bytes, got 5171 bytes")
+
+	// the oversized payload is rejected before sending
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.EqualError(t, err, "the data message payload must be at most 4096 bytes, got 5171 bytes")
+
+	// the notification messages have their own limit
+	req.DataOnly = false
+	req.Message = "Test"
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the notification message payload must be at most 4096 bytes")
+
+	cfg.Android.MaxNotificationSize = 8192
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+
+	// zero disables the check
+	req.DataOnly = true
+	cfg.Android.MaxDataSize = 0
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+}
+
+func TestAndroidDirectBoot(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"XXXXXXXXX"},
+		DirectBootOK: true,
+		Data: D{
+			"command": "lock",
+		},
+	}
+
+	assert.EqualError(t, CheckMessage(req), "the direct boot message must be data only, set data_only and remove the notification")
+
+	req.DataOnly = true
+	req.Notification = &FCMNotification{Title: "Locked"}
+	assert.EqualError(t, CheckMessage(req), "the direct boot message must be data only, set data_only and remove the notification")
+
+	req.Notification = nil
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.True(t, msg.Android.DirectBootOK)
+	assert.Nil(t, msg.Android.Notification)
+	assert.Equal(t, "lock", msg.Data["command"])
+}
+
+func TestAndroidImage(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"XXXXXXXXX"},
+		Message:      "Welcome",
+		Image:        "https://example.com/wide.png",
+		AndroidImage: "https://example.com/android.png",
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wide.png", msg.Notification.ImageURL)
+	assert.Equal(t, "https://example.com/android.png", msg.Android.Notification.ImageURL)
+
+	// the android image falls back to the image
+	req.AndroidImage = ""
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/wide.png", msg.Android.Notification.ImageURL)
+
+	req.Image = "http://example.com/wide.png"
+	assert.EqualError(t, CheckMessage(req), "the image must be a valid https URL, got http://example.com/wide.png")
+
+	req.Image = ""
+	req.AndroidImage = "android.png"
+	assert.EqualError(t, CheckMessage(req), "the android image must be a valid https URL, got android.png")
+}
+
+func TestAndroidBadge(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	badge := 5
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Badge:    &badge,
+	}
+
+	// top level badge
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, *msg.Android.Notification.NotificationCount)
+
+	// android notification badge is used first
+	req.Notification = &FCMNotification{Badge: "3"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *msg.Android.Notification.NotificationCount)
+
+	req.Badge = nil
+	req.Notification.Badge = "5"
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, *msg.Android.Notification.NotificationCount)
+
+	req.Notification.Badge = "-1"
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid badge format")
+
+	req.Notification.Badge = "five"
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid badge format")
+
+	negative := -1
+	req.Notification = nil
+	req.Badge = &negative
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid badge format")
+}
+
+func TestAndroidBadgeClear(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+	}
+
+	// an absent badge leaves the count unset
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Android.Notification.NotificationCount)
+	b, err := json.Marshal(msg.Android.Notification)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "notification_count")
+
+	// an explicit zero clears the badge
+	zero := 0
+	req.Badge = &zero
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.NotNil(t, msg.Android.Notification.NotificationCount)
+	assert.Equal(t, 0, *msg.Android.Notification.NotificationCount)
+	b, err = json.Marshal(msg.Android.Notification)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"notification_count":0`)
+
+	req.Badge = nil
+	req.Notification = &FCMNotification{Badge: "0"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *msg.Android.Notification.NotificationCount)
+}
+
+func TestAndroidDryRun(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	var lock sync.Mutex
+	validateOnly := []bool{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ValidateOnly bool `json:"validate_only"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		validateOnly = append(validateOnly, body.ValidateOnly)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/fake_message_id"}`))
+	}))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+		DryRun:   true,
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(resp.Logs))
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+	assert.Equal(t, []bool{true, true}, validateOnly)
+
+	// topic messages
+	validateOnly = nil
+	req.Tokens = nil
+	req.Topic = "news"
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.Logs))
+	assert.Equal(t, []bool{true}, validateOnly)
+	assert.Equal(t, 1, resp.Total)
+	assert.Equal(t, 1, resp.Success)
+}
+
+func TestAndroidTimeToLive(t *testing.T) {
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	for _, ttl := range []uint{0, fcmMaxTTL} {
+		ttl := ttl
+		req.TimeToLive = &ttl
+		assert.NoError(t, CheckMessage(req))
+	}
+
+	over := fcmMaxTTL + 1
+	req.TimeToLive = &over
+	assert.EqualError(t, CheckMessage(req), "the message's TimeToLive field must be an integer "+
+		"between 0 and 2419200 (4 weeks), got 2419201")
+
+	// negative values are rejected when decoding the request
+	assert.Error(t, json.Unmarshal([]byte(`{"time_to_live": -1}`), &PushNotification{}))
+
+	cfg, _ := config.LoadConf()
+
+	var lock sync.Mutex
+	ttls := []string{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message struct {
+				Android struct {
+					TTL string `json:"ttl"`
+				} `json:"android"`
+			} `json:"message"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		ttls = append(ttls, body.Message.Android.TTL)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/fake_message_id"}`))
+	}))
+
+	_, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.Error(t, err)
+	assert.Empty(t, ttls)
+
+	cfg.Android.ClampTTL = true
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, fcmMaxTTL, *req.TimeToLive)
+	assert.Equal(t, []string{"2419200s"}, ttls)
+}
+
+func TestAndroidExpiresAt(t *testing.T) {
+	ttl := uint(60)
+	req := &PushNotification{
+		Message:    "Test",
+		Platform:   core.PlatFormAndroid,
+		Tokens:     []string{"aaaaaaaaa"},
+		TimeToLive: &ttl,
+		ExpiresAt:  time.Now().Add(time.Hour).Format(time.RFC3339),
+	}
+	assert.EqualError(t, CheckMessage(req), "the message can't specify both time_to_live and expires_at")
+
+	req.TimeToLive = nil
+	assert.NoError(t, CheckMessage(req))
+
+	req.ExpiresAt = "tomorrow"
+	assert.EqualError(t, CheckMessage(req), "the expires_at must be a RFC3339 time, got tomorrow")
+
+	// the expired messages are rejected before they are sent
+	req.ExpiresAt = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	assert.EqualError(t, CheckMessage(req), "the message is expired since "+req.ExpiresAt)
+
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Empty(t, client.batches)
+
+	// the time left is sent as the TTL
+	req.ExpiresAt = time.Now().Add(time.Hour).Format(time.RFC3339)
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), msg.Android.TTL.Seconds(), 2)
+	assert.Equal(t, time.Duration(0), *msg.Android.TTL%time.Second)
+
+	// more than 4 weeks away
+	req.ExpiresAt = time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339)
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "the message's expires_at must be at most 2419200 seconds (4 weeks) away, got "+
+		req.ExpiresAt)
+
+	cfg.Android.ClampTTL = true
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(fcmMaxTTL)*time.Second, *msg.Android.TTL)
+}
+
+func TestAndroidDisplayUntil(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	req := &PushNotification{
+		Message:      "Test",
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"aaaaaaaaa"},
+		DisplayUntil: until.Format(time.RFC3339),
+	}
+	assert.NoError(t, CheckMessage(req))
+
+	// the time is sent in unix seconds and the time left is the TTL
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(until.Unix(), 10), msg.Data["display_until"])
+	assert.Equal(t, strconv.FormatInt(until.Unix(), 10), msg.Android.Data["display_until"])
+	assert.InDelta(t, time.Hour.Seconds(), msg.Android.TTL.Seconds(), 2)
+
+	// the TTL of the message is kept
+	ttl := uint(60)
+	req.TimeToLive = &ttl
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, *msg.Android.TTL)
+	req.TimeToLive = nil
+
+	// the TTL is at most 4 weeks
+	req.DisplayUntil = time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339)
+	assert.NoError(t, CheckMessage(req))
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(fcmMaxTTL)*time.Second, *msg.Android.TTL)
+
+	req.DisplayUntil = "tomorrow"
+	assert.EqualError(t, CheckMessage(req), "the display_until must be a RFC3339 time, got tomorrow")
+
+	req.DisplayUntil = time.Now().Add(-time.Minute).Format(time.RFC3339)
+	assert.EqualError(t, CheckMessage(req), "the notification isn't displayed since "+req.DisplayUntil)
+}
+
+func TestAndroidStringifyData(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Data: D{
+			"user":  map[string]interface{}{"id": 1, "name": "gorush"},
+			"ids":   []int{1, 2, 3},
+			"empty": nil,
+		},
+	}
+
+	// strict by default
+	_, err := getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid data format")
+
+	cfg.Android.StringifyData = true
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"name":"gorush"}`, msg.Data["user"])
+	assert.Equal(t, "[1,2,3]", msg.Data["ids"])
+	_, ok := msg.Data["empty"]
+	assert.False(t, ok)
+}
+
+// recordingFCMClient records the multicast messages sent and their tokens,
+// every token succeeds with its own name as message ID unless onSend answers.
+type recordingFCMClient struct {
+	blockingFCMClient
+	onSend   func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error)
+	lock     sync.Mutex
+	messages []*messaging.MulticastMessage
+	batches  [][]string
+}
+
+func (c *recordingFCMClient) SendEachForMulticast(
+	_ context.Context,
+	m *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	c.lock.Lock()
+	c.messages = append(c.messages, m)
+	c.batches = append(c.batches, m.Tokens)
+	c.lock.Unlock()
+
+	if c.onSend != nil {
+		return c.onSend(m)
+	}
+	return successResponse(m.Tokens), nil
+}
+
+// successResponse answers every token with its own name as message ID.
+func successResponse(tokens []string) *messaging.BatchResponse {
+	res := &messaging.BatchResponse{}
+	for _, token := range tokens {
+		res.SuccessCount++
+		res.Responses = append(res.Responses, &messaging.SendResponse{Success: true, MessageID: token})
+	}
+	return res
+}
+
+// failBatch fails the whole batch containing the token.
+func failBatch(token string) func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	return func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+		if slices.Contains(m.Tokens, token) {
+			return nil, errors.New("batch error")
+		}
+		return successResponse(m.Tokens), nil
+	}
+}
+
+// rejectTokens rejects the gone tokens with err, the others succeed.
+func rejectTokens(err error, gone ...string) func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+	return func(m *messaging.MulticastMessage) (*messaging.BatchResponse, error) {
+		res := successResponse(m.Tokens)
+		for i, token := range m.Tokens {
+			if slices.Contains(gone, token) {
+				res.SuccessCount--
+				res.FailureCount++
+				res.Responses[i] = &messaging.SendResponse{Error: err}
+			}
+		}
+		return res, nil
+	}
+}
+
+// dryRunFCMClient records the tokens of the dry runs apart.
+type dryRunFCMClient struct {
+	recordingFCMClient
+	dryRuns [][]string
+}
+
+func (c *dryRunFCMClient) SendEachForMulticastDryRun(
+	ctx context.Context,
+	m *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	c.dryRuns = append(c.dryRuns, m.Tokens)
+	return c.recordingFCMClient.SendEachForMulticast(ctx, m)
+}
+
+func TestSendAndroidTest(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &dryRunFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	resp, err := SendAndroidTest(context.Background(), cfg, "token", "Hello", "Test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"token"}}, client.batches)
+	assert.Empty(t, client.dryRuns)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 1, resp.Total)
+	assert.Equal(t, "token", resp.Logs[0].Token)
+
+	// the dry run is only validated by FCM
+	client.batches = nil
+	resp, err = SendAndroidTest(context.Background(), cfg, "token", "Hello", "Test", true)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"token"}}, client.dryRuns)
+	assert.Equal(t, 1, resp.Success)
+
+	_, err = SendAndroidTest(context.Background(), cfg, "", "Hello", "Test", false)
+	assert.EqualError(t, err, "the android test notification requires a token")
+}
+
+func TestAndroidUserIDs(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.DedupTokens = true
+	cfg.DeadLetter.Enabled = true
+	cfg.DeadLetter.Path = filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	InitDeadLetter(cfg)
+	defer func() { DeadLetterStore = nil }()
+	client := &recordingFCMClient{onSend: failBatch("fail")}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := make([]string, 1200)
+	userIDs := make([]string, len(tokens))
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+		userIDs[i] = "user-" + strconv.Itoa(i)
+	}
+	tokens[700] = "fail"
+	tokens[1100] = tokens[5]
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+		UserIDs:  userIDs,
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.EqualError(t, err, "batch error")
+	assert.Equal(t, 3, len(client.batches))
+
+	// every log has the user ID of its token across the batches, the failed
+	// and the duplicate tokens included
+	assert.Equal(t, 1200, len(resp.Logs))
+	for _, l := range resp.Logs {
+		assert.Equal(t, "user-"+strconv.Itoa(*l.Index), l.UserID)
+	}
+
+	// the dead letter of the failed batch keeps the user IDs of its tokens
+	entries, err := DeadLetterStore.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, 500, len(entries[0].Notification.UserIDs))
+	assert.Equal(t, "user-500", entries[0].Notification.UserIDs[0])
+	assert.NoError(t, CheckMessage(entries[0].Notification))
+
+	req.UserIDs = userIDs[:10]
+	assert.EqualError(t, CheckMessage(req),
+		"the message must specify one user ID per token, got 10 user IDs for 1200 tokens")
+}
+
+func TestAndroidTokenBatches(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &recordingFCMClient{onSend: failBatch("fail")}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := make([]string, 1200)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+	tokens[700] = "fail"
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.EqualError(t, err, "batch error")
+	assert.Equal(t, 3, len(client.batches))
+	assert.Equal(t, 500, len(client.batches[0]))
+	assert.Equal(t, 500, len(client.batches[1]))
+	assert.Equal(t, 200, len(client.batches[2]))
+
+	// token order is preserved
+	assert.Equal(t, 1200, len(resp.Logs))
+	for i, l := range resp.Logs {
+		assert.Equal(t, tokens[i], l.Token)
+	}
+
+	// only the tokens of the failed batch are marked as failed
+	assert.Equal(t, core.SucceededPush, resp.Logs[499].Type)
+	assert.Equal(t, core.FailedPush, resp.Logs[500].Type)
+	assert.Equal(t, core.FailedPush, resp.Logs[999].Type)
+	assert.Equal(t, core.SucceededPush, resp.Logs[1000].Type)
+	assert.Equal(t, "1000", resp.Logs[1000].MessageID)
+
+	assert.Equal(t, 1200, resp.Total)
+	assert.Equal(t, 700, resp.Success)
+	assert.Equal(t, 500, resp.Failure)
+}
+
+// slowFCMClient sends every batch successfully after a delay and records the
+// max number of concurrent batches.
+type slowFCMClient struct {
+	blockingFCMClient
+	delay    time.Duration
+	inflight int32
+	max      int32
+}
+
+func (c *slowFCMClient) SendEachForMulticast(
+	_ context.Context,
+	m *messaging.MulticastMessage,
+) (*messaging.BatchResponse, error) {
+	n := atomic.AddInt32(&c.inflight, 1)
+	defer atomic.AddInt32(&c.inflight, -1)
+	for {
+		current := atomic.LoadInt32(&c.max)
+		if n <= current || atomic.CompareAndSwapInt32(&c.max, current, n) {
+			break
+		}
+	}
+
+	time.Sleep(c.delay)
+
+	return successResponse(m.Tokens), nil
+}
+
+func TestAndroidConcurrentBatches(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	cfg.Android.Concurrency = 3
+	client := &slowFCMClient{delay: 20 * time.Millisecond}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	tokens := make([]string, 2600)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&client.max))
+
+	// token order is preserved
+	assert.Equal(t, 2600, len(resp.Logs))
+	for i, l := range resp.Logs {
+		assert.Equal(t, tokens[i], l.Token)
+		assert.Equal(t, tokens[i], l.MessageID)
+		assert.Equal(t, i, *l.Index)
+	}
+
+	assert.Equal(t, 2600, resp.Success)
+	assert.Equal(t, int64(2600), status.StatStorage.GetAndroidSuccess())
+}
+
+func TestAndroidBatchHandler(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.Concurrency = 2
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &slowFCMClient{delay: time.Millisecond})
+
+	tokens := make([]string, 1200)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}
+
+	var batches []*ResponsePush
+	ctx := WithBatchHandler(context.Background(), func(resp *ResponsePush, err error) {
+		assert.NoError(t, err)
+		batches = append(batches, resp)
+	})
+
+	resp, err := PushToAndroidV1(ctx, req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200, resp.Success)
+
+	// one call per batch of 500 tokens
+	assert.Equal(t, 3, len(batches))
+	sort.Slice(batches, func(i, j int) bool {
+		return *batches[i].Logs[0].Index < *batches[j].Logs[0].Index
+	})
+	for i, total := range []int{500, 500, 200} {
+		assert.Equal(t, total, batches[i].Total)
+		assert.Equal(t, total, batches[i].Success)
+		assert.Equal(t, 0, batches[i].Failure)
+		assert.Equal(t, total, len(batches[i].Logs))
+		assert.Equal(t, i*500, *batches[i].Logs[0].Index)
+	}
+}
+
+func TestAndroidBatchHandlerRetry(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.MaxRetry = 1
+	cfg.Android.RetryInterval = 0
+	cfg.Android.DedupTokens = true
+
+	var lock sync.Mutex
+	calls := map[string]int{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		calls[body.Message.Token]++
+		count := calls[body.Message.Token]
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if body.Message.Token == "5" && count == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(fcmErrorBody("UNAVAILABLE", "UNAVAILABLE")))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/foo-123/messages/1"}`))
+	}))
+
+	tokens := make([]string, 600)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+	tokens[599] = "5"
+
+	var batches []*ResponsePush
+	ctx := WithBatchHandler(context.Background(), func(resp *ResponsePush, err error) {
+		assert.NoError(t, err)
+		batches = append(batches, resp)
+	})
+
+	resp, err := PushToAndroidV1(ctx, &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 600, resp.Success)
+	assert.Equal(t, 2, calls["5"])
+
+	// the retried token is reported once with its batch, the results are
+	// final and sum up to the response
+	assert.Equal(t, 2, len(batches))
+	for i, total := range []int{500, 100} {
+		assert.Equal(t, total, batches[i].Total)
+		assert.Equal(t, total, batches[i].Success)
+		assert.Equal(t, 0, batches[i].Failure)
+	}
+	// the failed attempt and the retry of the token
+	assert.Equal(t, 501, len(batches[0].Logs))
+	// the duplicate of the token is reported with its own batch, with the
+	// logs of the token
+	assert.Equal(t, 101, len(batches[1].Logs))
+	assert.Equal(t, 599, *batches[1].Logs[len(batches[1].Logs)-1].Index)
+}
+
+func BenchmarkPushToAndroidV1(b *testing.B) {
+	tokens := make([]string, 5000)
+	for i := range tokens {
+		tokens[i] = strconv.Itoa(i)
+	}
+
+	for _, concurrency := range []int{1, 10} {
+		b.Run("concurrency-"+strconv.Itoa(concurrency), func(b *testing.B) {
+			cfg, _ := config.LoadConf()
+			cfg.Android.Concurrency = concurrency
+
+			key := fcmV1ClientKey(cfg.Android.ProjectID, cfg.Android.ServiceAccountKey)
+			fcmV1ClientsLock.Lock()
+			fcmV1Clients[key] = &slowFCMClient{delay: 10 * time.Millisecond}
+			fcmV1ClientsLock.Unlock()
+			defer func() {
+				fcmV1ClientsLock.Lock()
+				delete(fcmV1Clients, key)
+				fcmV1ClientsLock.Unlock()
+			}()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := &PushNotification{
+					Message:  "Test",
+					Platform: core.PlatFormAndroid,
+					Tokens:   tokens,
+				}
+				if _, err := PushToAndroidV1(context.Background(), req, cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAndroidNotificationDefaults(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.DefaultIcon = "ic_notification"
+	cfg.Android.DefaultColor = "#ff0000"
+	cfg.Android.DefaultSound = "default"
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+	}
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "ic_notification", msg.Android.Notification.Icon)
+	assert.Equal(t, "#ff0000", msg.Android.Notification.Color)
+	assert.Equal(t, "default", msg.Android.Notification.Sound)
+
+	// the request overrides the defaults
+	req.Sound = "bell"
+	req.Notification = &FCMNotification{
+		Icon:  "ic_sale",
+		Color: "#00ff00",
+	}
+
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "ic_sale", msg.Android.Notification.Icon)
+	assert.Equal(t, "#00ff00", msg.Android.Notification.Color)
+	assert.Equal(t, "bell", msg.Android.Notification.Sound)
+}
+
+func TestAndroidSoundObject(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"XXXXXXXXX"},
+		Sound: map[string]interface{}{
+			"critical": 1,
+			"name":     "alarm",
+			"volume":   0.5,
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "alarm", msg.Android.Notification.Sound)
+
+	req.Sound = Sound{Name: "bell"}
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "bell", msg.Android.Notification.Sound)
+
+	req.Sound = 1
+	_, err = getAndroidNotificationV1(req, cfg)
+	assert.EqualError(t, err, "invalid sound format")
+}
+
+func TestAndroidRestrictedPackageNameAndCollapseKey(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.RestrictedPackageName = "com.example.app"
+
+	req := &PushNotification{
+		Message:     "Test",
+		Platform:    core.PlatFormAndroid,
+		Tokens:      []string{"XXXXXXXXX"},
+		CollapseKey: strings.Repeat("a", 32),
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.app", msg.Android.RestrictedPackageName)
+
+	req.RestrictedPackageName = "com.example.app.beta"
+	msg, err = getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.app.beta", msg.Android.RestrictedPackageName)
+
+	req.CollapseKey = strings.Repeat("a", 33)
+	assert.EqualError(t, CheckMessage(req), "the message's collapse key must be at most 32 characters, got 33")
+}
+
+func TestAndroidAutoCollapse(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.AutoCollapseFields = []string{"user_id", "type"}
+
+	newReq := func(data D) *PushNotification {
+		return &PushNotification{
+			Message:      "You have 3 new messages",
+			Platform:     core.PlatFormAndroid,
+			Tokens:       []string{"XXXXXXXXX"},
+			Data:         data,
+			AutoCollapse: true,
+		}
+	}
+
+	// the identical inputs give the same collapse key whatever the order of
+	// the data keys
+	req := newReq(D{"user_id": 42, "type": "inbox", "count": 3})
+	assert.NoError(t, checkAndroidMessageV1(context.Background(), req, cfg))
+	assert.Equal(t, "42:inbox", req.CollapseKey)
+
+	other := newReq(D{"count": 4, "type": "inbox", "user_id": 42})
+	assert.NoError(t, checkAndroidMessageV1(context.Background(), other, cfg))
+	assert.Equal(t, req.CollapseKey, other.CollapseKey)
+
+	msg, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "42:inbox", msg.Android.CollapseKey)
+
+	// the collapse key of the request is kept
+	req = newReq(D{"user_id": 42, "type": "inbox"})
+	req.CollapseKey = "manual"
+	assert.NoError(t, checkAndroidMessageV1(context.Background(), req, cfg))
+	assert.Equal(t, "manual", req.CollapseKey)
+
+	assert.EqualError(t, checkAndroidMessageV1(context.Background(), newReq(D{"user_id": 42}), cfg),
+		"the auto collapse requires the data key type")
+	assert.EqualError(t, checkAndroidMessageV1(context.Background(), newReq(D{"user_id": 42, "type": strings.Repeat("a", 30)}), cfg),
+		"the auto collapse key must be at most 32 characters, got 33")
+
+	cfg.Android.AutoCollapseFields = nil
+	assert.EqualError(t, checkAndroidMessageV1(context.Background(), newReq(D{"user_id": 42}), cfg),
+		"the auto collapse requires android.auto_collapse_fields")
+
+	req = newReq(nil)
+	req.Platform = core.PlatFormIos
+	assert.EqualError(t, CheckMessage(req), "the auto collapse is only supported by Android")
+}
+
+// writeServiceAccountKey writes a service account key file which fetches
+// the access token from the given token URL.
+func writeServiceAccountKey(t *testing.T, tokenURL string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	content, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "test-endpoint",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email": "gorush@test-endpoint.iam.gserviceaccount.com",
+		"token_uri":    tokenURL,
+	})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.json")
+	assert.NoError(t, os.WriteFile(path, content, 0o600))
+
+	return path
+}
+
+func TestAndroidCustomEndpoint(t *testing.T) {
+	var lock sync.Mutex
+	paths := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/test-endpoint/messages/1"}`))
+	}))
+	defer ts.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "test-endpoint"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.Endpoint = ts.URL
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+	assert.Contains(t, paths, "/projects/test-endpoint/messages:send")
+
+	// the credential JSON content is used in place of the key file
+	content, err := os.ReadFile(cfg.Android.ServiceAccountKey)
+	assert.NoError(t, err)
+	cfg.Android.ProjectID = "test-credential"
+	cfg.Android.ServiceAccountKey = ""
+	cfg.Android.Credential = string(content)
+
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+	assert.Contains(t, paths, "/projects/test-credential/messages:send")
+}
+
+func TestAndroidHTTPTransport(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	trans, err := fcmV1Transport(cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, trans)
+
+	cfg.Android.HTTPTransport.MaxIdleConns = 200
+	cfg.Android.HTTPTransport.IdleConnTimeout = 30
+	cfg.Android.HTTPTransport.KeepAlive = 15
+
+	trans, err = fcmV1Transport(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, trans.MaxIdleConns)
+	assert.Equal(t, 100, trans.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, trans.IdleConnTimeout)
+	assert.NotNil(t, trans.DialContext)
+
+	// the tuned client is still authorized with the credential
+	var lock sync.Mutex
+	auths := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+
+		lock.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"name": "projects/test-transport/messages/1"}`))
+	}))
+	defer ts.Close()
+
+	cfg.Android.ProjectID = "test-transport"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.Endpoint = ts.URL
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+	assert.Equal(t, []string{"Bearer test-token"}, auths)
+}
+
+func TestAndroidProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/test-proxy/messages/1"}`))
+	}))
+	defer ts.Close()
+
+	// the proxy records the requests and forwards them to the server
+	var lock sync.Mutex
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		proxied = append(proxied, r.URL.Path)
+		lock.Unlock()
+
+		req, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		req.Header = r.Header.Clone()
+
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		_, _ = io.Copy(w, res.Body)
+	}))
+	defer proxy.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "test-proxy"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.Endpoint = ts.URL
+	cfg.Android.Proxy = proxy.URL
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"/token", "/projects/test-proxy/messages:send"}, proxied)
+}
+
+func TestAndroidCACertFile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/test-ca/messages/1"}`))
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0o600))
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "test-ca"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.Endpoint = ts.URL
+	resetClients := func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}
+	defer resetClients()
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+	}
+
+	// the test server isn't trusted by the system cert pool
+	cfg.Android.Timeout = 1
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.FailedPush, resp.Logs[0].Type)
+
+	resetClients()
+	cfg.Android.CACertFile = caFile
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, core.SucceededPush, resp.Logs[0].Type)
+}
+
+func TestFCMV1CertPool(t *testing.T) {
+	_, err := fcmV1CertPool(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	assert.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0o600))
+	_, err = fcmV1CertPool(invalid)
+	assert.EqualError(t, err, "no PEM certificate found in "+invalid)
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.Enabled = true
+	cfg.Android.Credential = `{"type": "service_account"}`
+	cfg.Android.CACertFile = invalid
+	assert.EqualError(t, CheckPushConf(cfg), "invalid android ca_cert_file: no PEM certificate found in "+invalid)
+}
+
+func TestParseProxyURL(t *testing.T) {
+	proxyURL, err := parseProxyURL("http://proxy.local:3128")
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.local:3128", proxyURL.Host)
+
+	_, err = parseProxyURL("socks5://proxy.local:1080")
+	assert.NoError(t, err)
+
+	_, err = parseProxyURL("proxy.local:3128")
+	assert.Error(t, err)
+
+	_, err = parseProxyURL("ftp://proxy.local")
+	assert.EqualError(t, err, `unsupported proxy scheme "ftp"`)
+
+	_, err = parseProxyURL("http:///path")
+	assert.EqualError(t, err, `missing proxy host in "http:///path"`)
+}
+
+func TestReloadFCMV1Client(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "test-endpoint"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, "https://oauth2.googleapis.com/token")
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	old, err := InitFCMV1Client(context.Background(), cfg, "")
+	assert.NoError(t, err)
+
+	// rotate the credential in place
+	rotated := writeServiceAccountKey(t, "https://oauth2.googleapis.com/token")
+	content, err := os.ReadFile(rotated)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(cfg.Android.ServiceAccountKey, content, 0o600))
+
+	client, err := ReloadFCMV1Client(context.Background(), cfg)
+	assert.NoError(t, err)
+	assert.NotSame(t, old, client)
+
+	current, err := InitFCMV1Client(context.Background(), cfg, "")
+	assert.NoError(t, err)
+	assert.Same(t, client, current)
+
+	// a broken credential keeps the current client
+	assert.NoError(t, os.WriteFile(cfg.Android.ServiceAccountKey, []byte("{"), 0o600))
+	_, err = ReloadFCMV1Client(context.Background(), cfg)
+	assert.Error(t, err)
+
+	current, err = InitFCMV1Client(context.Background(), cfg, "")
+	assert.NoError(t, err)
+	assert.Same(t, client, current)
+}
+
+func TestAndroidRateLimit(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "rate-project"
+	cfg.Android.RateLimit = 2
+	cfg.Android.RateLimitWait = 0
+	t.Cleanup(func() {
+		fcmV1LimitersLock.Lock()
+		delete(fcmV1Limiters, "rate-project")
+		fcmV1LimitersLock.Unlock()
+	})
+
+	setFCMTestClient(t, "rate-project", cfg,
+		newFCMTestClient(t, http.StatusOK, `{"name": "projects/rate-project/messages/1"}`))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+
+	// the bucket is empty and no wait is allowed
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrFCMRateLimited)
+	assert.Equal(t, 2, resp.Failure)
+	assert.Equal(t, ErrorTypeRateLimited, resp.Logs[0].ErrorType)
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidError())
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorRateLimited))
+
+	// wait for the bucket, a batch larger than the bucket is sent as well
+	cfg.Android.RateLimitWait = 5
+	req.Tokens = []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"}
+	start := time.Now()
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, resp.Success)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// the wait can't exceed rate_limit_wait
+	cfg.Android.RateLimitWait = 1
+	req.Tokens = []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc", "ddddddddd", "eeeeeeeee", "fffffffff"}
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrFCMRateLimited)
+	assert.Equal(t, 6, resp.Failure)
+}
+
+func TestCheckFCMV1Health(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer ts.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	// each probe checks again
+	cfg.Android.HealthCheckInterval = 0
+
+	// the dummy token is rejected once the credential is authorized
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusBadRequest, fcmErrorBody("INVALID_ARGUMENT", "INVALID_ARGUMENT")))
+	assert.NoError(t, CheckFCMV1Health(context.Background(), cfg))
+
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusForbidden, fcmErrorBody("PERMISSION_DENIED", "SENDER_ID_MISMATCH")))
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusUnauthorized, `{"error": {"status": "UNAUTHENTICATED", "message": "test error"}}`))
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+
+	// the client can't be created
+	cfg.Android.ServiceAccountKey = filepath.Join(t.TempDir(), "missing.json")
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+}
+
+func TestCheckFCMV1HealthInterval(t *testing.T) {
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer ts.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.HealthCheckInterval = 30
+	key := fcmV1ClientKey(cfg.Android.ProjectID, cfg.Android.ServiceAccountKey)
+	defer func() {
+		fcmHealthChecksLock.Lock()
+		delete(fcmHealthChecks, key)
+		fcmHealthChecksLock.Unlock()
+	}()
+
+	var sends atomic.Int32
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, newFCMTestHandlerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sends.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": {"status": "UNAUTHENTICATED", "message": "test error"}}`))
+	}))
+
+	// the probes within the interval reuse the result, the failure included
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+	assert.Equal(t, int32(1), fetches.Load())
+	assert.Equal(t, int32(1), sends.Load())
+
+	// the next probe checks again once the interval is over
+	fcmHealthChecksLock.Lock()
+	fcmHealthChecks[key] = fcmHealthCheck{at: time.Now().Add(-time.Minute), err: fcmHealthChecks[key].err}
+	fcmHealthChecksLock.Unlock()
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+	assert.Equal(t, int32(2), sends.Load())
+}
+
+func TestCheckFCMV1HealthRevokedCredential(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Invalid JWT Signature."}`))
+	}))
+	defer ts.Close()
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "test-endpoint"
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, ts.URL+"/token")
+	cfg.Android.Endpoint = ts.URL
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	assert.Error(t, CheckFCMV1Health(context.Background(), cfg))
+}
+
+func TestInitFCMV1ClientProjectID(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = ""
+	cfg.Android.ServiceAccountKey = writeServiceAccountKey(t, "https://oauth2.googleapis.com/token")
+	defer func() {
+		fcmV1ClientsLock.Lock()
+		fcmV1Clients = make(map[string]FCMClient)
+		fcmV1ClientsLock.Unlock()
+	}()
+
+	// the project of the service account key
+	client, err := InitFCMV1Client(context.Background(), cfg, "")
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+
+	fcmV1ClientsLock.RLock()
+	_, ok := fcmV1Clients[fcmV1ClientKey("test-endpoint", cfg.Android.ServiceAccountKey)]
+	fcmV1ClientsLock.RUnlock()
+	assert.True(t, ok)
+
+	cfg.Android.ServiceAccountKey = ""
+	cfg.Android.Credential = `{"type": "service_account"}`
+	_, err = InitFCMV1Client(context.Background(), cfg, "")
+	assert.EqualError(t, err, "InitFCMV1Client: missing android project_id, it isn't found in the service account key either")
+
+	cfg.Android.Credential = ""
+	cfg.Android.ServiceAccountKey = filepath.Join(t.TempDir(), "missing.json")
+	_, err = InitFCMV1Client(context.Background(), cfg, "")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestAndroidDebugLog(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{})
+
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	log.Level = logrus.ErrorLevel
+	logx.SetLogFormat(log, "json")
+
+	origin := logx.LogAccess
+	logx.LogAccess = log
+	defer func() { logx.LogAccess = origin }()
+
+	req := &PushNotification{
+		RequestID: "req-1",
+		Message:   "Test",
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	// nothing is logged below the error level without debug
+	_, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	req.Debug = true
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, "req-1", record["request_id"])
+		records = append(records, record)
+	}
+
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "fcm request", records[0]["msg"])
+	payload := records[0]["payload"].(string)
+	assert.Contains(t, payload, logx.HashToken("aaaaaaaaa"))
+	assert.NotContains(t, payload, "aaaaaaaaa")
+
+	assert.Equal(t, "fcm response", records[1]["msg"])
+	assert.Equal(t, logx.HashToken("aaaaaaaaa"), records[1]["token"])
+	assert.Equal(t, true, records[1]["success"])
+	assert.Equal(t, float64(1), records[2]["index"])
+}
+
+func TestAndroidRequestIDLogs(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.Format = "json"
+
+	_, unregistered := newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")).
+		Send(context.Background(), &messaging.Message{Token: "XXXXXXXXX"})
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, &recordingFCMClient{onSend: rejectTokens(unregistered, "gone")})
+
+	var access, failure bytes.Buffer
+	newLog := func(buf *bytes.Buffer) *logrus.Logger {
+		log := logrus.New()
+		log.Out = buf
+		log.Level = logrus.DebugLevel
+		logx.SetLogFormat(log, "json")
+		return log
+	}
+
+	originAccess, originError := logx.LogAccess, logx.LogError
+	logx.LogAccess, logx.LogError = newLog(&access), newLog(&failure)
+	defer func() { logx.LogAccess, logx.LogError = originAccess, originError }()
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"alive", "gone"},
+	}
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, req.RequestID)
+	assert.Equal(t, req.RequestID, resp.RequestID)
+	for _, l := range resp.Logs {
+		assert.Equal(t, req.RequestID, l.RequestID)
+	}
+
+	// every record of both logs has the request ID
+	for _, buf := range []*bytes.Buffer{&access, &failure} {
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.NotEmpty(t, lines)
+		for _, line := range lines {
+			var record map[string]interface{}
+			assert.NoError(t, json.Unmarshal([]byte(line), &record))
+			assert.Equal(t, req.RequestID, record["request_id"], line)
+		}
+	}
+	assert.Contains(t, access.String(), "Start push notification")
+	assert.Contains(t, failure.String(), core.FailedPush)
+}
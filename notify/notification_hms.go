@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/appleboy/gorush/config"
 	"github.com/appleboy/gorush/core"
@@ -163,7 +164,8 @@ func GetHuaweiNotification(req *PushNotification) (*model.MessageRequest, error)
 
 // PushToHuawei provide send notification to Android server.
 func PushToHuawei(req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePush, err error) {
-	logx.LogAccess.Debug("Start push notification for Huawei")
+	ctx := requestContext(context.Background(), req)
+	logx.AccessEntry(ctx).Debug("Start push notification for Huawei")
 
 	var (
 		client     *client.HMSClient
@@ -178,41 +180,68 @@ func PushToHuawei(req *PushNotification, cfg *config.ConfYaml) (resp *ResponsePu
 	// check message
 	err = CheckMessage(req)
 	if err != nil {
-		logx.LogError.Error("request error: " + err.Error())
+		logx.ErrorEntry(ctx).Error("request error: " + err.Error())
 		return nil, err
 	}
 
+	// the sends fail fast while Huawei is disabled at runtime
+	if !PlatformEnabled(core.PlatFormHuawei) {
+		logx.ErrorEntry(ctx).Error("HMS send skipped: " + ErrPlatformDisabled.Error())
+		resp = &ResponsePush{RequestID: req.RequestID}
+		resp.Logs = append(resp.Logs, logPush(cfg, core.FailedPush, req.To, req, ErrPlatformDisabled))
+		status.StatStorage.AddHuaweiErrorByTenant(req.TenantID, 1)
+		return resp, ErrPlatformDisabled
+	}
+
+	// the valid notifications are in flight until they are sent
+	status.StatStorage.IncInFlight()
+	defer status.StatStorage.DecInFlight()
+
 	client, err = InitHMSClient(cfg, cfg.Huawei.AppSecret, cfg.Huawei.AppID)
 	if err != nil {
 		// HMS server error
-		logx.LogError.Error("HMS server error: " + err.Error())
+		logx.ErrorEntry(ctx).Error("HMS server error: " + err.Error())
 		return nil, err
 	}
 
-	resp = &ResponsePush{}
+	resp = &ResponsePush{RequestID: req.RequestID}
 
 Retry:
 	isError := false
 
 	notification, _ := GetHuaweiNotification(req)
 
-	res, err := client.SendMessage(context.Background(), notification)
+	var res *model.MessageResponse
+	release, err := acquirePush(ctx)
+	if err == nil {
+		start := time.Now()
+		res, err = client.SendMessage(ctx, notification)
+		observeSendLatency("huawei", start, err != nil || res.Code != "80000000")
+		release()
+	}
 	if err != nil {
 		// Send Message error
 		errLog := logPush(cfg, core.FailedPush, req.To, req, err)
 		resp.Logs = append(resp.Logs, errLog)
-		logx.LogError.Error("HMS server send message error: " + err.Error())
+		logx.ErrorEntry(ctx).Error("HMS server send message error: " + err.Error())
+		recordRetry("huawei", retryCount, 1, 0)
 		return resp, err
 	}
 
 	// Huawei Push Send API does not support exact results for each token
 	if res.Code == "80000000" {
-		status.StatStorage.AddHuaweiSuccess(int64(1))
-		logx.LogAccess.Debug("Huwaei Send Notification is completed successfully!")
+		status.StatStorage.AddHuaweiSuccessByTenant(req.TenantID, int64(1))
+		logx.AccessEntry(ctx).Debug("Huwaei Send Notification is completed successfully!")
 	} else {
 		isError = true
-		status.StatStorage.AddHuaweiError(int64(1))
-		logx.LogAccess.Debug("Huawei Send Notification is failed! Code: " + res.Code)
+		status.StatStorage.AddHuaweiErrorByTenant(req.TenantID, int64(1))
+		logx.AccessEntry(ctx).Debug("Huawei Send Notification is failed! Code: " + res.Code)
+	}
+	// the message counts once, the API doesn't report the tokens
+	if isError {
+		recordRetry("huawei", retryCount, 1, 0)
+	} else {
+		recordRetry("huawei", retryCount, 1, 1)
 	}
 
 	if isError && retryCount < maxRetry {
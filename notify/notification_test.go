@@ -1,6 +1,8 @@
 package notify
 
 import (
+	"net/http"
+	"os"
 	"testing"
 
 	"github.com/appleboy/gorush/config"
@@ -23,7 +25,76 @@ func TestCorrectConf(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAndroidCredentialConf(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	cfg.Android.Enabled = true
+	cfg.Android.ServiceAccountKey = ""
+	cfg.Android.Credential = `{"type": "service_account"}`
+	assert.NoError(t, CheckPushConf(cfg))
+
+	cfg.Android.Credential = "{invalid"
+	assert.EqualError(t, CheckPushConf(cfg), "android credential is not a valid JSON")
+
+	cfg.Android.Credential = ""
+	assert.EqualError(t, CheckPushConf(cfg), "missing service account key")
+}
+
+func TestAndroidProjectIDConf(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	cfg.Android.Enabled = true
+	cfg.Android.ProjectID = ""
+	cfg.Android.Credential = `{"type": "service_account"}`
+	assert.EqualError(t, CheckPushConf(cfg), "missing project id")
+
+	// the project id of the service account key is used
+	cfg.Android.Credential = `{"type": "service_account", "project_id": "key-project"}`
+	assert.NoError(t, CheckPushConf(cfg))
+	assert.Equal(t, "key-project", cfg.Android.ProjectID)
+
+	// the read errors of the service account key are returned
+	cfg.Android.ProjectID = ""
+	cfg.Android.Credential = ""
+	cfg.Android.ServiceAccountKey = "not_found.json"
+	err := CheckPushConf(cfg)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorContains(t, err, "invalid android service account key")
+}
+
+func TestAndroidDefaultPriorityConf(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	cfg.Android.Enabled = true
+	cfg.Android.Credential = `{"type": "service_account"}`
+	cfg.Android.DefaultPriority = "high"
+	assert.NoError(t, CheckPushConf(cfg))
+
+	cfg.Android.DefaultPriority = "urgent"
+	assert.EqualError(t, CheckPushConf(cfg), "android default priority must be normal or high")
+
+	cfg.Android.DefaultPriority = ""
+	cfg.Android.HTTPTransport.KeepAlive = -1
+	assert.EqualError(t, CheckPushConf(cfg), "android http_transport values must not be negative")
+}
+
+func TestAndroidProxyConf(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	cfg.Android.Enabled = true
+	cfg.Android.Credential = `{"type": "service_account"}`
+	cfg.Android.Proxy = "http://proxy.local:3128"
+	assert.NoError(t, CheckPushConf(cfg))
+
+	cfg.Android.Proxy = "ftp://proxy.local"
+	assert.EqualError(t, CheckPushConf(cfg), `invalid android proxy: unsupported proxy scheme "ftp"`)
+}
+
 func TestSetProxyURL(t *testing.T) {
+	// SetProxy replaces the default transport of the next tests
+	defaultTransport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
 	err := SetProxy("87.236.233.92:8080")
 	assert.Error(t, err)
 	assert.Equal(t, "parse \"87.236.233.92:8080\": invalid URI for request", err.Error())
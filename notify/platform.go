@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPlatformDisabled is the error of the sends of a platform disabled at
+// runtime.
+var ErrPlatformDisabled = errors.New("platform disabled")
+
+var (
+	// disabledPlatforms are the platforms disabled at runtime, e.g. during an
+	// incident of the provider. They're enabled again on restart.
+	disabledPlatforms     = make(map[int]bool)
+	disabledPlatformsLock sync.RWMutex
+)
+
+// SetPlatformEnabled enables or disables the sends of the platform without a
+// restart, the notifications of a disabled platform fail with
+// ErrPlatformDisabled without calling the provider.
+func SetPlatformEnabled(platform int, enabled bool) {
+	disabledPlatformsLock.Lock()
+	defer disabledPlatformsLock.Unlock()
+
+	if enabled {
+		delete(disabledPlatforms, platform)
+		return
+	}
+	disabledPlatforms[platform] = true
+}
+
+// PlatformEnabled reports whether the platform wasn't disabled at runtime.
+func PlatformEnabled(platform int) bool {
+	disabledPlatformsLock.RLock()
+	defer disabledPlatformsLock.RUnlock()
+
+	return !disabledPlatforms[platform]
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/status"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAndroidPlatformDisabled(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+	t.Cleanup(func() { SetPlatformEnabled(core.PlatFormAndroid, true) })
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+
+	// the sends are skipped without calling FCM, the other platforms go on
+	SetPlatformEnabled(core.PlatFormAndroid, false)
+	assert.False(t, PlatformEnabled(core.PlatFormAndroid))
+	assert.True(t, PlatformEnabled(core.PlatFormIos))
+
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.ErrorIs(t, err, ErrPlatformDisabled)
+	assert.Equal(t, 2, resp.Total)
+	assert.Equal(t, 2, resp.Failure)
+	for k, log := range resp.Logs {
+		assert.Equal(t, req.Tokens[k], log.Token)
+		assert.Equal(t, k, *log.Index)
+		assert.Equal(t, ErrorTypePlatformDisabled, log.ErrorType)
+		assert.Equal(t, core.ErrorCodeServerError, log.ErrorCode)
+	}
+	assert.Equal(t, 1, len(client.batches))
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorDisabled))
+
+	// the topics are skipped as well
+	resp, err = PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Topic:    "news",
+	}, cfg)
+	assert.ErrorIs(t, err, ErrPlatformDisabled)
+	assert.Equal(t, "news", resp.Logs[0].Token)
+	assert.Equal(t, int64(3), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorDisabled))
+
+	SetPlatformEnabled(core.PlatFormAndroid, true)
+	resp, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 2, len(client.batches))
+}
+
+func TestIOSPlatformDisabled(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	SetPlatformEnabled(core.PlatFormIos, false)
+	t.Cleanup(func() { SetPlatformEnabled(core.PlatFormIos, true) })
+
+	status.StatStorage.Reset()
+
+	resp, err := PushToIOS(&PushNotification{
+		Message:  "Welcome",
+		Platform: core.PlatFormIos,
+		Tokens:   []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+	}, cfg)
+	assert.ErrorIs(t, err, ErrPlatformDisabled)
+	assert.Len(t, resp.Logs, 1)
+	assert.Equal(t, int64(1), status.StatStorage.GetIosError())
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+)
+
+// fcmQuotaPressureWindow is how long a project stays under quota pressure
+// after FCM answered a quota error.
+var fcmQuotaPressureWindow = time.Minute
+
+var (
+	// fcmV1QuotaErrors are the times of the last quota error per project,
+	// they're shared by all the workers.
+	fcmV1QuotaErrors     = make(map[string]time.Time)
+	fcmV1QuotaErrorsLock sync.Mutex
+)
+
+// underQuotaPressure reports whether the project of the request is under
+// quota pressure: FCM answered a quota error within fcmQuotaPressureWindow,
+// or android.rate_limit can't let n messages through without waiting.
+func underQuotaPressure(cfg *config.ConfYaml, req *PushNotification, n int) bool {
+	projectID := fcmV1ProjectID(cfg, req)
+
+	fcmV1QuotaErrorsLock.Lock()
+	at, ok := fcmV1QuotaErrors[projectID]
+	fcmV1QuotaErrorsLock.Unlock()
+	if ok && time.Since(at) < fcmQuotaPressureWindow {
+		return true
+	}
+
+	if cfg.Android.RateLimit <= 0 {
+		return false
+	}
+
+	limiter := fcmV1Limiter(cfg, projectID)
+	return limiter.Tokens() < float64(min(n, limiter.Burst()))
+}
+
+// recordFCMQuotaError puts the project of the request under quota pressure
+// when the send or one of its messages failed with a quota error. Nothing is
+// recorded unless android.downgrade_priority is set.
+func recordFCMQuotaError(cfg *config.ConfYaml, req *PushNotification, res *messaging.BatchResponse, err error) {
+	if !cfg.Android.DowngradePriority {
+		return
+	}
+
+	quota := messaging.IsQuotaExceeded(err)
+	if res != nil {
+		for _, result := range res.Responses {
+			if messaging.IsQuotaExceeded(result.Error) {
+				quota = true
+				break
+			}
+		}
+	}
+	if !quota {
+		return
+	}
+
+	fcmV1QuotaErrorsLock.Lock()
+	fcmV1QuotaErrors[fcmV1ProjectID(cfg, req)] = time.Now()
+	fcmV1QuotaErrorsLock.Unlock()
+}
+
+// downgradeAndroidPriority returns a copy of the message with the normal
+// Android priority when android.downgrade_priority is set, the message is
+// high priority, the request isn't critical and the project is under quota
+// pressure for n messages. The message is returned as is otherwise.
+func downgradeAndroidPriority(
+	ctx context.Context,
+	cfg *config.ConfYaml,
+	req *PushNotification,
+	m *messaging.MulticastMessage,
+	n int,
+) *messaging.MulticastMessage {
+	if !cfg.Android.DowngradePriority || req.Critical || m.Android == nil || m.Android.Priority != HIGH {
+		return m
+	}
+
+	if !underQuotaPressure(cfg, req, n) {
+		return m
+	}
+
+	logx.AccessEntry(ctx).Debugf("send the message of %q as normal priority under quota pressure", fcmV1ProjectID(cfg, req))
+
+	android := *m.Android
+	android.Priority = NORMAL
+	downgraded := *m
+	downgraded.Android = &android
+	return &downgraded
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDowngradeAndroidPriority(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.ProjectID = "downgrade-project"
+	cfg.Android.RateLimit = 1
+	cfg.Android.DowngradePriority = true
+	t.Cleanup(func() {
+		fcmV1LimitersLock.Lock()
+		delete(fcmV1Limiters, "downgrade-project")
+		fcmV1LimitersLock.Unlock()
+	})
+
+	req := &PushNotification{Platform: core.PlatFormAndroid}
+	m := &messaging.MulticastMessage{Android: &messaging.AndroidConfig{Priority: HIGH}}
+
+	// the bucket has room for the message
+	assert.Same(t, m, downgradeAndroidPriority(context.Background(), cfg, req, m, 1))
+
+	// the bucket is empty
+	assert.True(t, fcmV1Limiter(cfg, "downgrade-project").AllowN(time.Now(), 1))
+	downgraded := downgradeAndroidPriority(context.Background(), cfg, req, m, 1)
+	assert.Equal(t, NORMAL, downgraded.Android.Priority)
+	assert.Equal(t, HIGH, m.Android.Priority)
+
+	// the critical requests keep the high priority
+	critical := &PushNotification{Platform: core.PlatFormAndroid, Critical: true}
+	assert.Same(t, m, downgradeAndroidPriority(context.Background(), cfg, critical, m, 1))
+
+	normal := &messaging.MulticastMessage{Android: &messaging.AndroidConfig{Priority: NORMAL}}
+	assert.Same(t, normal, downgradeAndroidPriority(context.Background(), cfg, req, normal, 1))
+
+	cfg.Android.DowngradePriority = false
+	assert.Same(t, m, downgradeAndroidPriority(context.Background(), cfg, req, m, 1))
+}
+
+func TestAndroidQuotaPressure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		downgrade bool
+		critical  bool
+		priority  string
+	}{
+		{"downgrade", true, false, NORMAL},
+		{"critical", true, true, HIGH},
+		{"disabled", false, false, HIGH},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, _ := config.LoadConf()
+			cfg.Android.ProjectID = "quota-project"
+			cfg.Android.DowngradePriority = tc.downgrade
+			t.Cleanup(func() {
+				fcmV1QuotaErrorsLock.Lock()
+				delete(fcmV1QuotaErrors, "quota-project")
+				fcmV1QuotaErrorsLock.Unlock()
+			})
+
+			req := &PushNotification{
+				Message:  "Test",
+				Platform: core.PlatFormAndroid,
+				Tokens:   []string{"aaaaaaaaa"},
+				Priority: HIGH,
+				Critical: tc.critical,
+			}
+
+			// FCM answers a quota error
+			setFCMTestClient(t, "quota-project", cfg, newFCMTestClient(t, http.StatusTooManyRequests,
+				fcmErrorBody("RESOURCE_EXHAUSTED", "QUOTA_EXCEEDED")))
+			resp, err := PushToAndroidV1(context.Background(), req, cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, ErrorTypeQuota, resp.Logs[0].ErrorType)
+
+			client := &recordingFCMClient{}
+			setFCMTestClient(t, "quota-project", cfg, client)
+			resp, err = PushToAndroidV1(context.Background(), req, cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, resp.Success)
+			assert.Equal(t, 1, len(client.messages))
+			assert.Equal(t, tc.priority, client.messages[0].Android.Priority)
+		})
+	}
+}
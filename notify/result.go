@@ -0,0 +1,293 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const resultKeyPrefix = "gorush-result-"
+
+// resultSweepInterval is the interval of the drops of the expired results of
+// the memory store.
+const resultSweepInterval = time.Minute
+
+// ErrResultStoreDisabled is the error of the result queries without
+// core.result_store.
+var ErrResultStoreDisabled = errors.New("result store is disabled")
+
+// RequestResult is the result of the notifications of a request, merged as
+// they are sent.
+type RequestResult struct {
+	// Pending is the number of notifications of the request not sent yet.
+	Pending int
+	ResponsePush
+}
+
+// ResultStore keeps the results of the requests by request ID until they
+// expire, the async requests are polled with them.
+type ResultStore interface {
+	// Submit records n notifications of the request are queued.
+	Submit(ctx context.Context, id string, n int, ttl time.Duration) error
+	// Complete records the result of a notification of the request.
+	Complete(ctx context.Context, id string, resp *ResponsePush, ttl time.Duration) error
+	// Get returns the result of the request, false if unknown or expired.
+	Get(ctx context.Context, id string) (*RequestResult, bool)
+}
+
+// newRequestResult returns the empty result of the request.
+func newRequestResult(id string) RequestResult {
+	return RequestResult{ResponsePush: ResponsePush{
+		RequestID:     id,
+		Logs:          []logx.LogPushEntry{},
+		InvalidTokens: []string{},
+	}}
+}
+
+// mergeResponse adds the result of a notification to the request result.
+func mergeResponse(result *ResponsePush, resp *ResponsePush) {
+	result.Logs = append(result.Logs, resp.Logs...)
+	result.InvalidTokens = append(result.InvalidTokens, resp.InvalidTokens...)
+	result.Success += resp.Success
+	result.Failure += resp.Failure
+	result.Total += resp.Total
+	if result.Error == "" {
+		result.Error = resp.Error
+	}
+}
+
+type resultEntry struct {
+	result  RequestResult
+	expires time.Time
+}
+
+// MemoryResultStore keeps the results in memory.
+type MemoryResultStore struct {
+	mu      sync.Mutex
+	entries map[string]*resultEntry
+	now     func() time.Time
+	// swept is the time of the last drop of the expired entries.
+	swept time.Time
+}
+
+// NewMemoryResultStore returns an empty in memory store.
+func NewMemoryResultStore() *MemoryResultStore {
+	return &MemoryResultStore{
+		entries: make(map[string]*resultEntry),
+		now:     time.Now,
+	}
+}
+
+// entry returns the entry of the request and extends it by ttl, an expired
+// entry is reset. The other expired entries are dropped every
+// resultSweepInterval.
+func (s *MemoryResultStore) entry(id string, ttl time.Duration) *resultEntry {
+	now := s.now()
+	if now.Sub(s.swept) >= resultSweepInterval {
+		s.swept = now
+		for k, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	entry, ok := s.entries[id]
+	if !ok || !now.Before(entry.expires) {
+		entry = &resultEntry{result: newRequestResult(id)}
+		s.entries[id] = entry
+	}
+	entry.expires = now.Add(ttl)
+	return entry
+}
+
+// Submit implements ResultStore.
+func (s *MemoryResultStore) Submit(_ context.Context, id string, n int, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entry(id, ttl).result.Pending += n
+	return nil
+}
+
+// Complete implements ResultStore.
+func (s *MemoryResultStore) Complete(_ context.Context, id string, resp *ResponsePush, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entry(id, ttl)
+	// the requests sent without being queued aren't submitted
+	entry.result.Pending = max(entry.result.Pending-1, 0)
+	mergeResponse(&entry.result.ResponsePush, resp)
+	return nil
+}
+
+// Get implements ResultStore.
+func (s *MemoryResultStore) Get(_ context.Context, id string) (*RequestResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok || !s.now().Before(entry.expires) {
+		return nil, false
+	}
+
+	result := entry.result
+	result.Logs = append(result.Logs[:0:0], result.Logs...)
+	result.InvalidTokens = append(result.InvalidTokens[:0:0], result.InvalidTokens...)
+	return &result, true
+}
+
+// RedisResultStore keeps the results in redis, it's shared by all the
+// gorush instances using the same redis. The pending count is a key of its
+// own and the result of every notification is an item of a list.
+type RedisResultStore struct {
+	client redis.Cmdable
+}
+
+// NewRedisResultStore returns a store using the stat.redis settings.
+func NewRedisResultStore(cfg *config.ConfYaml) *RedisResultStore {
+	if cfg.Stat.Redis.Cluster {
+		return &RedisResultStore{client: redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    strings.Split(cfg.Stat.Redis.Addr, ","),
+			Password: cfg.Stat.Redis.Password,
+		})}
+	}
+
+	return &RedisResultStore{client: redis.NewClient(&redis.Options{
+		Addr:     cfg.Stat.Redis.Addr,
+		Password: cfg.Stat.Redis.Password,
+		DB:       cfg.Stat.Redis.DB,
+	})}
+}
+
+func redisResultKeys(id string) (pending, results string) {
+	// the hash tag keeps both keys on the same cluster slot
+	key := resultKeyPrefix + "{" + id + "}"
+	return key + "-pending", key + "-results"
+}
+
+// Submit implements ResultStore.
+func (s *RedisResultStore) Submit(ctx context.Context, id string, n int, ttl time.Duration) error {
+	pending, _ := redisResultKeys(id)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.IncrBy(ctx, pending, int64(n))
+		pipe.Expire(ctx, pending, ttl)
+		return nil
+	})
+	return err
+}
+
+// Complete implements ResultStore.
+func (s *RedisResultStore) Complete(ctx context.Context, id string, resp *ResponsePush, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	pending, results := redisResultKeys(id)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Decr(ctx, pending)
+		pipe.Expire(ctx, pending, ttl)
+		pipe.RPush(ctx, results, b)
+		pipe.Expire(ctx, results, ttl)
+		return nil
+	})
+	return err
+}
+
+// Get implements ResultStore.
+func (s *RedisResultStore) Get(ctx context.Context, id string) (*RequestResult, bool) {
+	pendingKey, resultsKey := redisResultKeys(id)
+	pending, err := s.client.Get(ctx, pendingKey).Int()
+	if err != nil {
+		return nil, false
+	}
+
+	items, err := s.client.LRange(ctx, resultsKey, 0, -1).Result()
+	if err != nil {
+		return nil, false
+	}
+
+	result := newRequestResult(id)
+	// the requests sent without being queued aren't submitted
+	result.Pending = max(pending, 0)
+	for _, item := range items {
+		resp := &ResponsePush{}
+		if err := json.Unmarshal([]byte(item), resp); err != nil {
+			return nil, false
+		}
+		mergeResponse(&result.ResponsePush, resp)
+	}
+	return &result, true
+}
+
+// NewResultStore returns the store of core.result_store, nil if disabled.
+func NewResultStore(cfg *config.ConfYaml) (ResultStore, error) {
+	switch cfg.Core.ResultStore {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemoryResultStore(), nil
+	case "redis":
+		return NewRedisResultStore(cfg), nil
+	default:
+		return nil, errors.New("result store must be none, memory or redis")
+	}
+}
+
+// InitResult initializes RequestResults when the result store is enabled.
+func InitResult(cfg *config.ConfYaml) error {
+	RequestResults = nil
+	store, err := NewResultStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	RequestResults = store
+	return nil
+}
+
+func resultTTL(cfg *config.ConfYaml) time.Duration {
+	return time.Duration(cfg.Core.ResultTTL) * time.Second
+}
+
+// SubmitResult records the notifications of the request are queued, their
+// result is pending until they are sent.
+func SubmitResult(ctx context.Context, cfg *config.ConfYaml, id string, n int) {
+	if RequestResults == nil || id == "" || n == 0 {
+		return
+	}
+
+	if err := RequestResults.Submit(ctx, id, n, resultTTL(cfg)); err != nil {
+		logx.ErrorEntry(ctx).Error("result store error: " + err.Error())
+	}
+}
+
+// CompleteResult records the result of a notification of the request.
+func CompleteResult(ctx context.Context, cfg *config.ConfYaml, id string, resp *ResponsePush) {
+	if RequestResults == nil || id == "" {
+		return
+	}
+
+	if err := RequestResults.Complete(ctx, id, resp, resultTTL(cfg)); err != nil {
+		logx.ErrorEntry(ctx).Error("result store error: " + err.Error())
+	}
+}
+
+// GetResult returns the result of the request, false if unknown or expired.
+func GetResult(ctx context.Context, id string) (*RequestResult, bool, error) {
+	if RequestResults == nil {
+		return nil, false, ErrResultStoreDisabled
+	}
+
+	result, ok := RequestResults.Get(ctx, id)
+	return result, ok, nil
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryResultStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryResultStore()
+	store.now = func() time.Time { return now }
+
+	_, ok := store.Get(ctx, "unknown")
+	assert.False(t, ok)
+
+	// the queued notifications are pending
+	assert.NoError(t, store.Submit(ctx, "req", 2, time.Minute))
+	result, ok := store.Get(ctx, "req")
+	assert.True(t, ok)
+	assert.Equal(t, 2, result.Pending)
+	assert.Equal(t, "req", result.RequestID)
+	assert.Empty(t, result.Logs)
+
+	// the results are merged as they are sent
+	assert.NoError(t, store.Complete(ctx, "req", &ResponsePush{
+		Success: 2,
+		Total:   2,
+	}, time.Minute))
+	assert.NoError(t, store.Complete(ctx, "req", &ResponsePush{
+		InvalidTokens: []string{"bad"},
+		Success:       1,
+		Failure:       1,
+		Total:         2,
+		Error:         "failed",
+	}, time.Minute))
+	result, ok = store.Get(ctx, "req")
+	assert.True(t, ok)
+	assert.Equal(t, 0, result.Pending)
+	assert.Equal(t, 3, result.Success)
+	assert.Equal(t, 1, result.Failure)
+	assert.Equal(t, 4, result.Total)
+	assert.Equal(t, []string{"bad"}, result.InvalidTokens)
+	assert.Equal(t, "failed", result.Error)
+
+	// the result returned is a copy
+	result.InvalidTokens[0] = "changed"
+	result, _ = store.Get(ctx, "req")
+	assert.Equal(t, []string{"bad"}, result.InvalidTokens)
+
+	// the result expires ttl after its last update
+	now = now.Add(time.Minute)
+	_, ok = store.Get(ctx, "req")
+	assert.False(t, ok)
+
+	// an expired entry is reset on its next update
+	assert.NoError(t, store.Submit(ctx, "other", 1, time.Second))
+	assert.NoError(t, store.Submit(ctx, "req", 1, time.Minute))
+	result, ok = store.Get(ctx, "req")
+	assert.True(t, ok)
+	assert.Equal(t, 1, result.Pending)
+	assert.Equal(t, 0, result.Total)
+
+	// the other expired entries are dropped every resultSweepInterval only
+	now = now.Add(time.Second)
+	assert.NoError(t, store.Submit(ctx, "req", 1, time.Minute))
+	assert.Len(t, store.entries, 2)
+	now = now.Add(resultSweepInterval)
+	assert.NoError(t, store.Submit(ctx, "new", 1, time.Minute))
+	assert.Len(t, store.entries, 1)
+}
+
+func TestNewResultStore(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	store, err := NewResultStore(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryResultStore{}, store)
+
+	cfg.Core.ResultStore = "none"
+	store, err = NewResultStore(cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	cfg.Core.ResultStore = "memory"
+	store, err = NewResultStore(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryResultStore{}, store)
+
+	cfg.Core.ResultStore = "redis"
+	store, err = NewResultStore(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &RedisResultStore{}, store)
+
+	cfg.Core.ResultStore = "invalid"
+	_, err = NewResultStore(cfg)
+	assert.Error(t, err)
+}
+
+func TestGetResultDisabled(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Core.ResultStore = "none"
+	assert.NoError(t, InitResult(cfg))
+
+	_, _, err := GetResult(context.Background(), "req")
+	assert.Equal(t, ErrResultStoreDisabled, err)
+}
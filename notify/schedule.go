@@ -0,0 +1,411 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+)
+
+// ErrSchedulerDisabled is the error of the notifications scheduled while the
+// scheduler isn't running.
+var ErrSchedulerDisabled = errors.New("the scheduler isn't running")
+
+// ErrScheduleNotFound is the error of the canceled notifications which aren't
+// scheduled, either sent already or unknown.
+var ErrScheduleNotFound = errors.New("no scheduled notification with this request id")
+
+// ScheduleEntry is a notification waiting for its send time.
+type ScheduleEntry struct {
+	Notification *PushNotification `json:"notification"`
+	SendAt       int64             `json:"send_at"`
+
+	// id is the row of the entry in the sqlite store.
+	id int64
+}
+
+// ScheduleStore keeps the scheduled notifications until their send time.
+type ScheduleStore interface {
+	// Add stores the entry.
+	Add(entry *ScheduleEntry) error
+	// Due returns the entries whose send time is before now and claims
+	// them, the earliest first. The claimed entries aren't returned again.
+	Due(now time.Time) ([]*ScheduleEntry, error)
+	// Done removes the claimed entry once it's sent.
+	Done(entry *ScheduleEntry) error
+	// Remove removes the entries of the request ID and returns their
+	// number.
+	Remove(requestID string) (int, error)
+	// Close releases the store.
+	Close() error
+}
+
+// MemoryScheduleStore keeps the entries in memory, they are lost on restart.
+type MemoryScheduleStore struct {
+	mu      sync.Mutex
+	entries []*ScheduleEntry
+}
+
+// NewMemoryScheduleStore returns an empty in-memory store.
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{}
+}
+
+// Add implements ScheduleStore.
+func (m *MemoryScheduleStore) Add(entry *ScheduleEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+// Due implements ScheduleStore.
+func (m *MemoryScheduleStore) Due(now time.Time) ([]*ScheduleEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due, later []*ScheduleEntry
+	for _, entry := range m.entries {
+		if entry.SendAt <= now.Unix() {
+			due = append(due, entry)
+		} else {
+			later = append(later, entry)
+		}
+	}
+	m.entries = later
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].SendAt < due[j].SendAt })
+	return due, nil
+}
+
+// Done implements ScheduleStore, the due entries are removed already.
+func (m *MemoryScheduleStore) Done(*ScheduleEntry) error { return nil }
+
+// Remove implements ScheduleStore.
+func (m *MemoryScheduleStore) Remove(requestID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []*ScheduleEntry
+	for _, entry := range m.entries {
+		if entry.Notification.RequestID != requestID {
+			kept = append(kept, entry)
+		}
+	}
+
+	removed := len(m.entries) - len(kept)
+	m.entries = kept
+	return removed, nil
+}
+
+// Close implements ScheduleStore.
+func (m *MemoryScheduleStore) Close() error { return nil }
+
+// SQLiteScheduleStore keeps the entries in a sqlite database, so they
+// survive the restarts. The due entries are claimed until they're sent, the
+// ones claimed before a crash are sent again on restart.
+type SQLiteScheduleStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteScheduleStore opens the sqlite database at path and creates the
+// schedules table if needed.
+func NewSQLiteScheduleStore(path string) (*SQLiteScheduleStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schedules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  request_id TEXT NOT NULL,
+  send_at INTEGER NOT NULL,
+  notification TEXT NOT NULL,
+  claimed INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS schedules_send_at ON schedules (send_at);
+CREATE INDEX IF NOT EXISTS schedules_request_id ON schedules (request_id);
+`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// the entries claimed by the previous run weren't all sent
+	if _, err := db.Exec("UPDATE schedules SET claimed = 0 WHERE claimed = 1"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteScheduleStore{db: db}, nil
+}
+
+// Add implements ScheduleStore.
+func (s *SQLiteScheduleStore) Add(entry *ScheduleEntry) error {
+	_, err := s.db.Exec("INSERT INTO schedules (request_id, send_at, notification) VALUES (?, ?, ?)",
+		entry.Notification.RequestID, entry.SendAt, string(entry.Notification.Bytes()))
+	return err
+}
+
+// Due implements ScheduleStore.
+func (s *SQLiteScheduleStore) Due(now time.Time) ([]*ScheduleEntry, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		"SELECT id, send_at, notification FROM schedules WHERE send_at <= ? AND claimed = 0 ORDER BY send_at, id",
+		now.Unix(),
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	var entries []*ScheduleEntry
+	for rows.Next() {
+		var notification string
+		entry := &ScheduleEntry{Notification: &PushNotification{}}
+		if err := rows.Scan(&entry.id, &entry.SendAt, &notification); err != nil {
+			_ = rows.Close()
+			_ = tx.Rollback()
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(notification), entry.Notification); err != nil {
+			_ = rows.Close()
+			_ = tx.Rollback()
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Close(); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE schedules SET claimed = 1 WHERE send_at <= ? AND claimed = 0", now.Unix()); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return entries, tx.Commit()
+}
+
+// Done implements ScheduleStore.
+func (s *SQLiteScheduleStore) Done(entry *ScheduleEntry) error {
+	_, err := s.db.Exec("DELETE FROM schedules WHERE id = ?", entry.id)
+	return err
+}
+
+// Remove implements ScheduleStore, the claimed entries are being sent.
+func (s *SQLiteScheduleStore) Remove(requestID string) (int, error) {
+	res, err := s.db.Exec("DELETE FROM schedules WHERE request_id = ? AND claimed = 0", requestID)
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := res.RowsAffected()
+	return int(removed), err
+}
+
+// Close implements ScheduleStore.
+func (s *SQLiteScheduleStore) Close() error {
+	return s.db.Close()
+}
+
+// Scheduler sends the scheduled notifications once their send time is
+// reached. The store is checked every interval, so a notification is sent
+// up to interval late.
+type Scheduler struct {
+	store    ScheduleStore
+	interval time.Duration
+	send     func(ctx context.Context, req *PushNotification)
+
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	sending sync.WaitGroup
+}
+
+// NewScheduler starts sending the due notifications of the store with send.
+func NewScheduler(
+	store ScheduleStore,
+	interval time.Duration,
+	send func(ctx context.Context, req *PushNotification),
+) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		interval: interval,
+		send:     send,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+// Schedule stores the notification until sendAt.
+func (s *Scheduler) Schedule(req *PushNotification, sendAt time.Time) error {
+	return s.store.Add(&ScheduleEntry{
+		Notification: req,
+		SendAt:       sendAt.Unix(),
+	})
+}
+
+// Cancel removes the notifications of the request ID which aren't sent yet,
+// it fails with ErrScheduleNotFound if there is none.
+func (s *Scheduler) Cancel(requestID string) error {
+	removed, err := s.store.Remove(requestID)
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// Close stops the scheduler, waits for the due notifications being sent and
+// closes the store, the other notifications of a persistent store are sent
+// after the restart.
+func (s *Scheduler) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+	s.sending.Wait()
+	return s.store.Close()
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.sendDue(now)
+		}
+	}
+}
+
+// sendDue sends the notifications whose send time is reached.
+func (s *Scheduler) sendDue(now time.Time) {
+	entries, err := s.store.Due(now)
+	if err != nil {
+		logx.LogError.Error("schedule store error: " + err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		s.sending.Add(1)
+		go func(entry *ScheduleEntry) {
+			defer s.sending.Done()
+
+			req := entry.Notification
+			req.SendAt = ""
+			s.send(logx.WithRequestID(context.Background(), req.RequestID), req)
+
+			// the entry is removed once sent, it's sent again after a crash
+			if err := s.store.Done(entry); err != nil {
+				logx.LogError.Error("schedule store error: " + err.Error())
+			}
+		}(entry)
+	}
+}
+
+// NewScheduleStore returns the store of schedule.engine.
+func NewScheduleStore(cfg *config.ConfYaml) (ScheduleStore, error) {
+	switch cfg.Schedule.Engine {
+	case "", "memory":
+		return NewMemoryScheduleStore(), nil
+	case "sqlite":
+		return NewSQLiteScheduleStore(cfg.Schedule.Path)
+	default:
+		return nil, errors.New("schedule engine must be memory or sqlite")
+	}
+}
+
+// InitScheduler initializes PushScheduler, the due notifications are sent
+// with SendNotification.
+func InitScheduler(cfg *config.ConfYaml) error {
+	PushScheduler = nil
+	store, err := NewScheduleStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(cfg.Schedule.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	PushScheduler = NewScheduler(store, interval, func(ctx context.Context, req *PushNotification) {
+		if _, err := SendNotification(ctx, req, cfg); err != nil {
+			logx.ErrorEntry(ctx).Error("scheduled notification error: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// CancelNotification cancels the scheduled notifications of the request ID,
+// it fails with ErrScheduleNotFound if they are sent already or unknown.
+func CancelNotification(ctx context.Context, requestID string) error {
+	if PushScheduler == nil || requestID == "" {
+		return ErrScheduleNotFound
+	}
+	ctx = logx.WithRequestID(ctx, requestID)
+
+	if err := PushScheduler.Cancel(requestID); err != nil {
+		if !errors.Is(err, ErrScheduleNotFound) {
+			logx.ErrorEntry(ctx).Error("schedule store error: " + err.Error())
+		}
+		return err
+	}
+
+	logx.AccessEntry(ctx).Info("cancel the scheduled notifications")
+	return nil
+}
+
+// scheduledTime returns the send time of the notification, zero if it's
+// sent right away.
+func scheduledTime(req *PushNotification) (time.Time, error) {
+	if req.SendAt == "" {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, req.SendAt)
+	if err != nil {
+		return time.Time{}, errors.New("the send_at must be a RFC3339 time, got " + req.SendAt)
+	}
+	return at, nil
+}
+
+// scheduleNotification stores the notification until its send time.
+func scheduleNotification(ctx context.Context, req *PushNotification, at time.Time) (*ResponsePush, error) {
+	if PushScheduler == nil {
+		return nil, ErrSchedulerDisabled
+	}
+
+	if err := PushScheduler.Schedule(req, at); err != nil {
+		logx.ErrorEntry(ctx).Error("schedule store error: " + err.Error())
+		return nil, err
+	}
+
+	logx.AccessEntry(ctx).Infof("schedule the notification at %s", at.Format(time.RFC3339))
+	return &ResponsePush{RequestID: req.RequestID}, nil
+}
@@ -0,0 +1,302 @@
+package notify
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testScheduleStore(t *testing.T, store ScheduleStore) {
+	now := time.Now()
+	assert.NoError(t, store.Add(&ScheduleEntry{
+		Notification: &PushNotification{Message: "later"},
+		SendAt:       now.Add(time.Hour).Unix(),
+	}))
+	assert.NoError(t, store.Add(&ScheduleEntry{
+		Notification: &PushNotification{Message: "second"},
+		SendAt:       now.Add(-time.Second).Unix(),
+	}))
+	assert.NoError(t, store.Add(&ScheduleEntry{
+		Notification: &PushNotification{Message: "first"},
+		SendAt:       now.Add(-time.Minute).Unix(),
+	}))
+
+	entries, err := store.Due(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "first", entries[0].Notification.Message)
+	assert.Equal(t, "second", entries[1].Notification.Message)
+
+	// the due entries are claimed
+	for _, entry := range entries {
+		assert.NoError(t, store.Done(entry))
+	}
+	entries, err = store.Due(now)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = store.Due(now.Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "later", entries[0].Notification.Message)
+
+	// the entries of a request are removed together
+	for _, id := range []string{"abc", "abc", "def"} {
+		assert.NoError(t, store.Add(&ScheduleEntry{
+			Notification: &PushNotification{RequestID: id},
+			SendAt:       now.Add(time.Hour).Unix(),
+		}))
+	}
+
+	removed, err := store.Remove("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	removed, err = store.Remove("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	entries, err = store.Due(now.Add(2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "def", entries[0].Notification.RequestID)
+}
+
+func TestMemoryScheduleStore(t *testing.T) {
+	store := NewMemoryScheduleStore()
+	defer store.Close()
+
+	testScheduleStore(t, store)
+}
+
+func TestSQLiteScheduleStore(t *testing.T) {
+	store, err := NewSQLiteScheduleStore(filepath.Join(t.TempDir(), "schedule.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	testScheduleStore(t, store)
+}
+
+func TestSQLiteScheduleStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.db")
+	store, err := NewSQLiteScheduleStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(&ScheduleEntry{
+		Notification: &PushNotification{Message: "Test", Tokens: []string{"aaaaaaaaa"}},
+		SendAt:       time.Now().Unix(),
+	}))
+	assert.NoError(t, store.Close())
+
+	// the entries survive the restart
+	store, err = NewSQLiteScheduleStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	entries, err := store.Due(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, []string{"aaaaaaaaa"}, entries[0].Notification.Tokens)
+}
+
+func TestSQLiteScheduleStoreClaimed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.db")
+	store, err := NewSQLiteScheduleStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(&ScheduleEntry{
+		Notification: &PushNotification{Message: "Test", RequestID: "req-1"},
+		SendAt:       time.Now().Unix(),
+	}))
+
+	entries, err := store.Due(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	// the claimed entries are being sent, they can't be canceled
+	removed, err := store.Remove("req-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	// the instance crashes before the send completed
+	assert.NoError(t, store.Close())
+	store, err = NewSQLiteScheduleStore(path)
+	assert.NoError(t, err)
+
+	// the entry is sent again after the restart
+	entries, err = store.Due(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "req-1", entries[0].Notification.RequestID)
+
+	// the entry is removed once sent
+	assert.NoError(t, store.Done(entries[0]))
+	assert.NoError(t, store.Close())
+	store, err = NewSQLiteScheduleStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	entries, err = store.Due(time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestNewScheduleStore(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	store, err := NewScheduleStore(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryScheduleStore{}, store)
+
+	cfg.Schedule.Engine = "sqlite"
+	cfg.Schedule.Path = filepath.Join(t.TempDir(), "schedule.db")
+	store, err = NewScheduleStore(cfg)
+	assert.NoError(t, err)
+	assert.IsType(t, &SQLiteScheduleStore{}, store)
+	assert.NoError(t, store.Close())
+
+	cfg.Schedule.Engine = "redis"
+	_, err = NewScheduleStore(cfg)
+	assert.EqualError(t, err, "schedule engine must be memory or sqlite")
+}
+
+func TestCheckMessageSendAt(t *testing.T) {
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		SendAt:   "tomorrow",
+	}
+	assert.EqualError(t, CheckMessage(req), "the send_at must be a RFC3339 time, got tomorrow")
+
+	req.SendAt = time.Now().Add(time.Hour).Format(time.RFC3339)
+	assert.NoError(t, CheckMessage(req))
+}
+
+func TestScheduleWithoutScheduler(t *testing.T) {
+	cfg, _ := config.LoadConf()
+
+	_, err := SendNotification(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		SendAt:   time.Now().Add(time.Hour).Format(time.RFC3339),
+	}, cfg)
+	assert.Equal(t, ErrSchedulerDisabled, err)
+}
+
+func TestAndroidScheduledNotification(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	assert.NoError(t, InitScheduler(cfg))
+	defer func() {
+		assert.NoError(t, PushScheduler.Close())
+		PushScheduler = nil
+	}()
+
+	resp, err := SendNotification(context.Background(), &PushNotification{
+		Message:   "Test",
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa"},
+		RequestID: "abc",
+		SendAt:    time.Now().Add(time.Second).Format(time.RFC3339),
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", resp.RequestID)
+	assert.Equal(t, 0, resp.Success)
+
+	client.lock.Lock()
+	assert.Empty(t, client.batches)
+	client.lock.Unlock()
+
+	assert.Eventually(t, func() bool {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		return len(client.batches) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSchedulerPastSendAt(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	// a past send time is sent right away, without the scheduler
+	resp, err := SendNotification(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		SendAt:   time.Now().Add(-time.Minute).Format(time.RFC3339),
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 1, len(client.batches))
+}
+
+func TestCancelScheduledNotification(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	assert.Equal(t, ErrScheduleNotFound, CancelNotification(context.Background(), "abc"))
+
+	assert.NoError(t, InitScheduler(cfg))
+	defer func() {
+		assert.NoError(t, PushScheduler.Close())
+		PushScheduler = nil
+	}()
+
+	_, err := SendNotification(context.Background(), &PushNotification{
+		Message:   "Test",
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa"},
+		RequestID: "abc",
+		SendAt:    time.Now().Add(time.Second).Format(time.RFC3339),
+	}, cfg)
+	assert.NoError(t, err)
+
+	// cancel before the send
+	assert.NoError(t, CancelNotification(context.Background(), "abc"))
+	assert.Equal(t, ErrScheduleNotFound, CancelNotification(context.Background(), "abc"))
+
+	time.Sleep(2500 * time.Millisecond)
+	client.lock.Lock()
+	assert.Empty(t, client.batches)
+	client.lock.Unlock()
+}
+
+func TestCancelSentNotification(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	assert.NoError(t, InitScheduler(cfg))
+	defer func() {
+		assert.NoError(t, PushScheduler.Close())
+		PushScheduler = nil
+	}()
+
+	_, err := SendNotification(context.Background(), &PushNotification{
+		Message:   "Test",
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa"},
+		RequestID: "abc",
+		SendAt:    time.Now().Add(time.Second).Format(time.RFC3339),
+	}, cfg)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		return len(client.batches) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// cancel after the send
+	assert.Equal(t, ErrScheduleNotFound, CancelNotification(context.Background(), "abc"))
+}
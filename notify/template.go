@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+	"github.com/appleboy/gorush/status"
+)
+
+// ErrTemplateExecution is the error of the tokens whose template can't be
+// rendered with their vars.
+var ErrTemplateExecution = errors.New("template execution failed")
+
+// NotificationTemplate is the text/template source of the title and body of
+// the notification, rendered with the Vars of every token. An empty source
+// keeps the Title or Message of the request.
+type NotificationTemplate struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+// parse compiles the title and body templates, a missing var fails the
+// rendering instead of printing "<no value>".
+func (t *NotificationTemplate) parse() (title, body *template.Template, err error) {
+	if t.Title != "" {
+		if title, err = template.New("title").Option("missingkey=error").Parse(t.Title); err != nil {
+			return nil, nil, err
+		}
+	}
+	if t.Body != "" {
+		if body, err = template.New("body").Option("missingkey=error").Parse(t.Body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return title, body, nil
+}
+
+// renderAndroidTemplate renders the template of the request for every token
+// and returns the titles and bodies aligned with Tokens, and the errors of
+// the tokens which failed to render by position.
+func renderAndroidTemplate(req *PushNotification) ([]string, []string, map[int]error) {
+	title, body, err := req.Template.parse()
+	errs := make(map[int]error)
+	if err != nil {
+		// checked by CheckMessage
+		for k := range req.Tokens {
+			errs[k] = fmt.Errorf("%w: %v", ErrTemplateExecution, err)
+		}
+		return nil, nil, errs
+	}
+
+	render := func(t *template.Template, vars map[string]string) (string, error) {
+		if t == nil {
+			return "", nil
+		}
+
+		var sb strings.Builder
+		if err := t.Execute(&sb, vars); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrTemplateExecution, err)
+		}
+		return sb.String(), nil
+	}
+
+	titles := make([]string, len(req.Tokens))
+	bodies := make([]string, len(req.Tokens))
+	for k := range req.Tokens {
+		var vars map[string]string
+		if len(req.Vars) > 0 {
+			vars = req.Vars[k]
+		}
+
+		if titles[k], err = render(title, vars); err == nil {
+			bodies[k], err = render(body, vars)
+		}
+		if err != nil {
+			errs[k] = err
+		}
+	}
+
+	return titles, bodies, errs
+}
+
+// skipUnrenderedAndroidTokens records a failed log for the tokens at indexes
+// whose template failed to render and returns the indexes of the others.
+func skipUnrenderedAndroidTokens(
+	ctx context.Context,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	tokens []string,
+	indexes []int,
+	errs map[int]error,
+	resp *ResponsePush,
+) []int {
+	if len(errs) == 0 {
+		return indexes
+	}
+
+	valid := make([]int, 0, len(indexes))
+	for _, k := range indexes {
+		err, ok := errs[k]
+		if !ok {
+			valid = append(valid, k)
+			continue
+		}
+
+		logx.AccessEntry(ctx).Debugf("skip the token at index %d: %s", k, err.Error())
+		index := k
+		errLog := logPushFCMError(cfg, tokens[k], req, err)
+		errLog.Index = &index
+		resp.Logs = append(resp.Logs, errLog)
+	}
+
+	if skipped := len(indexes) - len(valid); skipped > 0 {
+		status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(skipped))
+	}
+	return valid
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAndroidTemplate(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	client := &personalizedFCMClient{notifications: map[string][2]*messaging.Notification{}}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	req := &PushNotification{
+		Title:    "Hello",
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"},
+		Template: &NotificationTemplate{
+			Title: "Hi {{.name}}",
+			Body:  "{{.count}} new messages",
+		},
+		Vars: []map[string]string{
+			{"name": "Alice", "count": "3"},
+			{"name": "Bob", "count": "1"},
+			// the missing var fails the token
+			{"name": "Carol"},
+		},
+	}
+
+	assert.NoError(t, CheckMessage(req))
+
+	resp, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, resp.Success)
+	assert.Equal(t, 3, len(resp.Logs))
+
+	for _, n := range client.notifications["aaaaaaaaa"] {
+		assert.Equal(t, "Hi Alice", n.Title)
+		assert.Equal(t, "3 new messages", n.Body)
+	}
+	for _, n := range client.notifications["bbbbbbbbb"] {
+		assert.Equal(t, "Hi Bob", n.Title)
+		assert.Equal(t, "1 new messages", n.Body)
+	}
+
+	assert.NotContains(t, client.notifications, "ccccccccc")
+	// the token is failed before the sends
+	assert.Equal(t, "ccccccccc", resp.Logs[0].Token)
+	assert.Equal(t, core.FailedPush, resp.Logs[0].Type)
+	assert.Equal(t, 2, *resp.Logs[0].Index)
+	assert.Equal(t, core.ErrorCodeInvalidPayload, resp.Logs[0].ErrorCode)
+	assert.Contains(t, resp.Logs[0].Error, ErrTemplateExecution.Error())
+
+	// the request is unchanged
+	assert.Nil(t, req.Titles)
+	assert.Nil(t, req.Bodies)
+}
+
+func TestCheckTemplate(t *testing.T) {
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		Template: &NotificationTemplate{Title: "Hi {{.name"},
+	}
+	assert.ErrorContains(t, CheckMessage(req), "invalid template: ")
+
+	req.Template.Title = "Hi {{.name}}"
+	req.Titles = []string{"Hi"}
+	assert.EqualError(t, CheckMessage(req), "the message can't specify both a template and titles or bodies")
+
+	req.Titles = nil
+	req.Vars = []map[string]string{{"name": "Alice"}, {"name": "Bob"}}
+	assert.EqualError(t, CheckMessage(req), "the message must specify the vars of every token, got 2 vars for 1 tokens")
+
+	req.Vars = nil
+	req.Tokens = nil
+	req.Topic = "news"
+	assert.EqualError(t, CheckMessage(req), "the template requires tokens")
+
+	req.Platform = core.PlatFormIos
+	req.Tokens = []string{"aaaaaaaaa"}
+	req.Topic = ""
+	assert.EqualError(t, CheckMessage(req), "the template is only supported by Android")
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+)
+
+const (
+	// tokenFeedbackBatchSize is the max number of tokens posted at once.
+	tokenFeedbackBatchSize = 100
+	// tokenFeedbackBufferSize is the max number of tokens waiting to be posted.
+	tokenFeedbackBufferSize = 8192
+)
+
+// tokenFeedbackInterval is the max wait of a token before it's posted with
+// the tokens found meanwhile.
+var tokenFeedbackInterval = time.Second
+
+// TokenFeedback is a token found permanently invalid, it should be removed
+// from the device store.
+type TokenFeedback struct {
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+// TokenFeedbackWriter posts the invalid tokens to the token removal webhook
+// in the background, batched to avoid a request per token. Tokens are
+// dropped when the buffer is full.
+type TokenFeedbackWriter struct {
+	url      string
+	timeout  int64
+	header   []string
+	maxRetry int
+	tokens   chan *TokenFeedback
+	done     chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewTokenFeedbackWriter starts posting the queued tokens to url, the
+// requests are sent with the timeout, header and retries of the feedback
+// hook.
+func NewTokenFeedbackWriter(url string, cfg *config.ConfYaml, size int) *TokenFeedbackWriter {
+	w := &TokenFeedbackWriter{
+		url:      url,
+		timeout:  cfg.Core.FeedbackTimeout,
+		header:   cfg.Core.FeedbackHeader,
+		maxRetry: cfg.Core.FeedbackMaxRetry,
+		tokens:   make(chan *TokenFeedback, size),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+// Record queues the token without blocking.
+func (w *TokenFeedbackWriter) Record(token, reason string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.tokens <- &TokenFeedback{Token: token, Reason: reason}:
+	default:
+		logx.LogError.Error("token feedback buffer is full, drop an invalid token")
+	}
+}
+
+// Close posts the queued tokens and stops the writer.
+func (w *TokenFeedbackWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.tokens)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	return nil
+}
+
+func (w *TokenFeedbackWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(tokenFeedbackInterval)
+	defer ticker.Stop()
+
+	var batch []*TokenFeedback
+	for {
+		select {
+		case token, ok := <-w.tokens:
+			if !ok {
+				w.post(batch)
+				return
+			}
+			batch = append(batch, token)
+			if len(batch) >= tokenFeedbackBatchSize {
+				w.post(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.post(batch)
+			batch = nil
+		}
+	}
+}
+
+// post sends the batch of tokens, failures are retried like the feedback
+// hook, then logged.
+func (w *TokenFeedbackWriter) post(batch []*TokenFeedback) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.maxRetry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(feedbackRetryInterval << (attempt - 1))
+		}
+
+		if err = postFeedback(context.Background(), batch, w.url, w.timeout, w.header); err == nil {
+			return
+		}
+	}
+	logx.LogError.Errorf("token feedback error, drop %d invalid tokens: %s", len(batch), err)
+}
+
+// InitTokenFeedback initializes TokenFeedbackRecorder when
+// android.token_feedback_url is set.
+func InitTokenFeedback(cfg *config.ConfYaml) {
+	TokenFeedbackRecorder = nil
+	if cfg.Android.TokenFeedbackURL != "" {
+		TokenFeedbackRecorder = NewTokenFeedbackWriter(cfg.Android.TokenFeedbackURL, cfg, tokenFeedbackBufferSize)
+	}
+}
+
+// recordInvalidToken queues the permanently invalid token for the token
+// removal webhook when it's enabled.
+func recordInvalidToken(token, reason string) {
+	if TokenFeedbackRecorder == nil {
+		return
+	}
+
+	TokenFeedbackRecorder.Record(token, reason)
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenFeedbackServer records the batches posted to the token removal
+// webhook.
+type tokenFeedbackServer struct {
+	*httptest.Server
+	lock    sync.Mutex
+	batches [][]TokenFeedback
+}
+
+func newTokenFeedbackServer(t *testing.T) *tokenFeedbackServer {
+	s := &tokenFeedbackServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []TokenFeedback
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+
+		s.lock.Lock()
+		s.batches = append(s.batches, batch)
+		s.lock.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestTokenFeedbackWriter(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	ts := newTokenFeedbackServer(t)
+
+	w := NewTokenFeedbackWriter(ts.URL, cfg, 10)
+	w.Record("aaaaaaaaa", "unregistered")
+	w.Record("bbbbbbbbb", "unregistered")
+
+	// the tokens are posted together once the interval is over
+	assert.Eventually(t, func() bool {
+		ts.lock.Lock()
+		defer ts.lock.Unlock()
+		return len(ts.batches) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []TokenFeedback{
+		{Token: "aaaaaaaaa", Reason: "unregistered"},
+		{Token: "bbbbbbbbb", Reason: "unregistered"},
+	}, ts.batches[0])
+
+	// the queued tokens are posted on close
+	w.Record("ccccccccc", "unregistered")
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 2, len(ts.batches))
+	assert.Equal(t, []TokenFeedback{{Token: "ccccccccc", Reason: "unregistered"}}, ts.batches[1])
+
+	// the closed writer drops the tokens
+	w.Record("ddddddddd", "unregistered")
+	assert.Equal(t, 2, len(ts.batches))
+}
+
+func TestTokenFeedbackBatchSize(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	ts := newTokenFeedbackServer(t)
+
+	w := NewTokenFeedbackWriter(ts.URL, cfg, 1000)
+	for i := 0; i < tokenFeedbackBatchSize+1; i++ {
+		w.Record("aaaaaaaaa", "unregistered")
+	}
+	assert.NoError(t, w.Close())
+
+	assert.Equal(t, 2, len(ts.batches))
+	assert.Equal(t, tokenFeedbackBatchSize, len(ts.batches[0]))
+	assert.Equal(t, 1, len(ts.batches[1]))
+}
+
+func TestAndroidTokenFeedback(t *testing.T) {
+	ts := newTokenFeedbackServer(t)
+
+	cfg, _ := config.LoadConf()
+	cfg.Android.TokenFeedbackURL = ts.URL
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg,
+		newFCMTestClient(t, http.StatusNotFound, fcmErrorBody("NOT_FOUND", "UNREGISTERED")))
+
+	InitTokenFeedback(cfg)
+	defer func() { TokenFeedbackRecorder = nil }()
+
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa", "bbbbbbbbb"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aaaaaaaaa", "bbbbbbbbb"}, resp.InvalidTokens)
+
+	// a single request posts the invalid tokens
+	assert.NoError(t, TokenFeedbackRecorder.Close())
+	assert.Equal(t, [][]TokenFeedback{{
+		{Token: "aaaaaaaaa", Reason: "unregistered"},
+		{Token: "bbbbbbbbb", Reason: "unregistered"},
+	}}, ts.batches)
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/logx"
+	"github.com/appleboy/gorush/status"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// ErrFCMTokenThrottled is the error of the tokens sent more than
+// android.per_token_rate_limit times within android.per_token_rate_window.
+var ErrFCMTokenThrottled = errors.New("throttled")
+
+// tokenLimitersSize is the max number of tokens whose rate is tracked, the
+// least recently sent ones are forgotten first.
+const tokenLimitersSize = 100000
+
+var (
+	// tokenLimiters limits the sending rate per token, they're shared by all
+	// the workers.
+	tokenLimiters     *lru.Cache[string, *rate.Limiter]
+	tokenLimitersLock sync.Mutex
+)
+
+// tokenLimiter returns the rate limiter of the token, the limiter is created
+// on first use.
+func tokenLimiter(cfg *config.ConfYaml, token string) *rate.Limiter {
+	tokenLimitersLock.Lock()
+	defer tokenLimitersLock.Unlock()
+
+	if tokenLimiters == nil {
+		tokenLimiters, _ = lru.New[string, *rate.Limiter](tokenLimitersSize)
+	}
+
+	limiter, ok := tokenLimiters.Get(token)
+	if !ok {
+		window := time.Duration(cfg.Android.PerTokenRateWindow) * time.Second
+		if window <= 0 {
+			window = time.Minute
+		}
+
+		// the bucket is full at first and refilled over the window
+		limit := cfg.Android.PerTokenRateLimit
+		limiter = rate.NewLimiter(rate.Every(window/time.Duration(limit)), limit)
+		tokenLimiters.Add(token, limiter)
+	}
+
+	return limiter
+}
+
+// allowTokenSend reports whether the token is within its rate limit, the send
+// is counted when it is.
+func allowTokenSend(cfg *config.ConfYaml, token string) bool {
+	if cfg.Android.PerTokenRateLimit <= 0 {
+		return true
+	}
+
+	return tokenLimiter(cfg, token).Allow()
+}
+
+// skipThrottledAndroidTokens records a failed log with the throttled error
+// type for the tokens at indexes over their rate limit and returns the
+// indexes of the others.
+func skipThrottledAndroidTokens(
+	ctx context.Context,
+	req *PushNotification,
+	cfg *config.ConfYaml,
+	tokens []string,
+	indexes []int,
+	resp *ResponsePush,
+) []int {
+	if cfg.Android.PerTokenRateLimit <= 0 {
+		return indexes
+	}
+
+	allowed := make([]int, 0, len(indexes))
+	for _, k := range indexes {
+		if allowTokenSend(cfg, tokens[k]) {
+			allowed = append(allowed, k)
+			continue
+		}
+
+		logx.AccessEntry(ctx).Debugf("throttle the token at index %d", k)
+		index := k
+		errLog := logPushFCMError(cfg, tokens[k], req, ErrFCMTokenThrottled)
+		errLog.Index = &index
+		resp.Logs = append(resp.Logs, errLog)
+	}
+
+	if throttled := len(indexes) - len(allowed); throttled > 0 {
+		status.StatStorage.AddAndroidErrorByTenant(req.TenantID, int64(throttled))
+	}
+	return allowed
+}
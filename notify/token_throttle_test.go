@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/status"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAndroidPerTokenRateLimit(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Log.HideToken = false
+	cfg.Android.PerTokenRateLimit = 3
+	cfg.Android.PerTokenRateWindow = 3600
+	t.Cleanup(func() {
+		tokenLimitersLock.Lock()
+		tokenLimiters = nil
+		tokenLimitersLock.Unlock()
+	})
+
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	status.StatStorage.Reset()
+
+	// the hammered token is sent 3 times, the other token isn't throttled
+	tokens := []string{"hammered", "hammered", "hammered", "hammered", "other", "hammered"}
+	resp, err := PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   tokens,
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"hammered", "hammered", "hammered", "other"}}, client.batches)
+	assert.Equal(t, 6, resp.Total)
+	assert.Equal(t, 4, resp.Success)
+	assert.Equal(t, 2, resp.Failure)
+
+	var throttled []int
+	for _, l := range resp.Logs {
+		if l.Type == core.FailedPush {
+			throttled = append(throttled, *l.Index)
+			assert.Equal(t, ErrorTypeThrottled, l.ErrorType)
+			assert.Equal(t, core.ErrorCodeQuotaExceeded, l.ErrorCode)
+			assert.Equal(t, "hammered", l.Token)
+		}
+	}
+	assert.Equal(t, []int{3, 5}, throttled)
+	assert.Equal(t, int64(2), status.StatStorage.GetAndroidErrorByType(status.AndroidErrorThrottled))
+
+	// the limit is kept across the requests
+	client.batches = nil
+	resp, err = PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"hammered", "other"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"other"}}, client.batches)
+	assert.Equal(t, 1, resp.Success)
+	assert.Equal(t, 1, resp.Failure)
+
+	// the limit is disabled by default
+	cfg.Android.PerTokenRateLimit = 0
+	client.batches = nil
+	resp, err = PushToAndroidV1(context.Background(), &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"hammered"},
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"hammered"}}, client.batches)
+	assert.Equal(t, 1, resp.Success)
+}
+
+func TestTokenLimiterEviction(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.PerTokenRateLimit = 1
+	t.Cleanup(func() {
+		tokenLimitersLock.Lock()
+		tokenLimiters = nil
+		tokenLimitersLock.Unlock()
+	})
+
+	assert.True(t, allowTokenSend(cfg, "token"))
+	assert.False(t, allowTokenSend(cfg, "token"))
+
+	// the least recently sent tokens are forgotten past the size of the cache
+	tokenLimitersLock.Lock()
+	tokenLimiters.Resize(1)
+	tokenLimitersLock.Unlock()
+	assert.True(t, allowTokenSend(cfg, "other"))
+	assert.True(t, allowTokenSend(cfg, "token"))
+}
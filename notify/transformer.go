@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/appleboy/gorush/config"
+)
+
+// Transformer modifies the notification after CheckMessage and before the
+// FCM message is built, e.g. to inject default data keys or to enforce the
+// org policy. The notification must still be valid after the change, an
+// error fails the notification.
+type Transformer interface {
+	Transform(cfg *config.ConfYaml, req *PushNotification) error
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(cfg *config.ConfYaml, req *PushNotification) error
+
+// Transform implements Transformer.
+func (f TransformerFunc) Transform(cfg *config.ConfYaml, req *PushNotification) error {
+	return f(cfg, req)
+}
+
+// NoopTransformer keeps the notifications unchanged.
+type NoopTransformer struct{}
+
+// Transform implements Transformer.
+func (NoopTransformer) Transform(*config.ConfYaml, *PushNotification) error { return nil }
+
+// EnforceChannelTransformer sends all the notifications on the
+// android.default_channel_id channel, whatever the android_channel_id of the
+// request.
+type EnforceChannelTransformer struct{}
+
+// Transform implements Transformer.
+func (EnforceChannelTransformer) Transform(cfg *config.ConfYaml, req *PushNotification) error {
+	// an empty channel is already sent as the default one
+	if cfg.Android.DefaultChannelID != "" && req.Notification != nil {
+		req.Notification.ChannelID = cfg.Android.DefaultChannelID
+	}
+	return nil
+}
+
+var (
+	transformers = map[string]Transformer{
+		"none":            NoopTransformer{},
+		"enforce_channel": EnforceChannelTransformer{},
+	}
+	transformersLock sync.RWMutex
+)
+
+// RegisterTransformer makes the transformer selectable by name with
+// android.transformer, it must be called before InitTransformer.
+func RegisterTransformer(name string, t Transformer) {
+	transformersLock.Lock()
+	defer transformersLock.Unlock()
+
+	transformers[name] = t
+}
+
+// InitTransformer initializes AndroidTransformer with the transformer
+// registered as android.transformer.
+func InitTransformer(cfg *config.ConfYaml) error {
+	transformersLock.RLock()
+	defer transformersLock.RUnlock()
+
+	name := cfg.Android.Transformer
+	if name == "" {
+		name = "none"
+	}
+
+	t, ok := transformers[name]
+	if !ok {
+		names := make([]string, 0, len(transformers))
+		for name := range transformers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return errors.New("transformer must be one of " + strings.Join(names, ", "))
+	}
+
+	AndroidTransformer = t
+	return nil
+}
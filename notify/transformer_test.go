@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitTransformer(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	t.Cleanup(func() { AndroidTransformer = NoopTransformer{} })
+
+	assert.NoError(t, InitTransformer(cfg))
+	assert.Equal(t, NoopTransformer{}, AndroidTransformer)
+
+	cfg.Android.Transformer = "enforce_channel"
+	assert.NoError(t, InitTransformer(cfg))
+	assert.Equal(t, EnforceChannelTransformer{}, AndroidTransformer)
+
+	cfg.Android.Transformer = "unknown"
+	assert.EqualError(t, InitTransformer(cfg), "transformer must be one of enforce_channel, none")
+}
+
+func TestAndroidTransformer(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Android.Transformer = "test_default_data"
+	client := &recordingFCMClient{}
+	setFCMTestClient(t, cfg.Android.ProjectID, cfg, client)
+
+	RegisterTransformer("test_default_data", TransformerFunc(func(_ *config.ConfYaml, req *PushNotification) error {
+		if req.Data == nil {
+			req.Data = D{}
+		}
+		req.Data["source"] = "gorush"
+		return nil
+	}))
+	t.Cleanup(func() {
+		transformersLock.Lock()
+		delete(transformers, "test_default_data")
+		transformersLock.Unlock()
+		AndroidTransformer = NoopTransformer{}
+	})
+	assert.NoError(t, InitTransformer(cfg))
+
+	req := &PushNotification{
+		Message:  "Test",
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"aaaaaaaaa"},
+		Data:     D{"id": "1"},
+	}
+	_, err := PushToAndroidV1(context.Background(), req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.messages))
+	assert.Equal(t, map[string]string{"id": "1", "source": "gorush"}, client.messages[0].Data)
+
+	// the error of the transformer fails the notification
+	AndroidTransformer = TransformerFunc(func(*config.ConfYaml, *PushNotification) error {
+		return errors.New("policy violation")
+	})
+	_, err = PushToAndroidV1(context.Background(), req, cfg)
+	assert.EqualError(t, err, "policy violation")
+	assert.Equal(t, 1, len(client.messages))
+}
+
+func TestEnforceChannelTransformer(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	req := &PushNotification{
+		Message:      "Test",
+		Platform:     core.PlatFormAndroid,
+		Tokens:       []string{"aaaaaaaaa"},
+		Notification: &FCMNotification{ChannelID: "promo"},
+	}
+
+	// nothing to enforce without a default channel
+	assert.NoError(t, EnforceChannelTransformer{}.Transform(cfg, req))
+	assert.Equal(t, "promo", req.Notification.ChannelID)
+
+	cfg.Android.DefaultChannelID = "default"
+	assert.NoError(t, EnforceChannelTransformer{}.Transform(cfg, req))
+
+	notification, err := getAndroidNotificationV1(req, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "default", notification.Android.Notification.ChannelID)
+}
@@ -1,12 +1,14 @@
 package router
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/appleboy/gorush/config"
@@ -32,6 +34,9 @@ import (
 
 var doOnce sync.Once
 
+// requestIDHeader carries the request ID of the push requests.
+const requestIDHeader = "X-Request-ID"
+
 func abortWithError(c *gin.Context, code int, message string) {
 	c.AbortWithStatusJSON(code, gin.H{
 		"code":    code,
@@ -45,8 +50,18 @@ func rootHandler(c *gin.Context) {
 	})
 }
 
-func heartbeatHandler(c *gin.Context) {
-	c.AbortWithStatus(http.StatusOK)
+func heartbeatHandler(cfg *config.ConfYaml) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.Android.Enabled && cfg.Android.HealthCheck {
+			if err := notify.CheckFCMV1Health(c.Request.Context(), cfg); err != nil {
+				logx.LogError.Error(err)
+				abortWithError(c, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+		}
+
+		c.AbortWithStatus(http.StatusOK)
+	}
 }
 
 func versionHandler(c *gin.Context) {
@@ -56,33 +71,108 @@ func versionHandler(c *gin.Context) {
 	})
 }
 
-func pushHandler(cfg *config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var form notify.RequestPush
-		var msg string
-
-		if err := c.ShouldBindWith(&form, binding.JSON); err != nil {
-			msg = "Missing notifications field."
+// decodeRequestBody decompresses the request body per its Content-Encoding,
+// gzip or none, and bounds the decompressed body with core.max_body_size.
+// The request is aborted with 415 for the other encodings.
+func decodeRequestBody(c *gin.Context, cfg *config.ConfYaml) bool {
+	switch encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip":
+		body, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
 			logx.LogAccess.Debug(err)
-			abortWithError(c, http.StatusBadRequest, msg)
-			return
+			abortWithError(c, http.StatusBadRequest, "Invalid gzip body.")
+			return false
 		}
+		c.Request.Body = body
+	default:
+		msg := fmt.Sprintf("Unsupported Content-Encoding(%s)", encoding)
+		logx.LogAccess.Debug(msg)
+		abortWithError(c, http.StatusUnsupportedMediaType, msg)
+		return false
+	}
+
+	if cfg.Core.MaxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.Core.MaxBodySize)
+	}
+	return true
+}
+
+// bindNotifications binds the notifications of the request, the request is
+// aborted with 400 when they are missing or over core.max_notification and
+// with 413 when a notification is over core.max_tokens_per_request.
+func bindNotifications(c *gin.Context, cfg *config.ConfYaml) (notify.RequestPush, bool) {
+	var form notify.RequestPush
+	var msg string
 
-		if len(form.Notifications) == 0 {
-			msg = "Notifications field is empty."
+	if !decodeRequestBody(c, cfg) {
+		return form, false
+	}
+
+	if err := c.ShouldBindWith(&form, binding.JSON); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			msg = fmt.Sprintf("Request body over limit(%d)", maxBytesErr.Limit)
 			logx.LogAccess.Debug(msg)
-			abortWithError(c, http.StatusBadRequest, msg)
-			return
+			abortWithError(c, http.StatusRequestEntityTooLarge, msg)
+			return form, false
 		}
 
-		if int64(len(form.Notifications)) > cfg.Core.MaxNotification {
-			msg = fmt.Sprintf("Number of notifications(%d) over limit(%d)", len(form.Notifications), cfg.Core.MaxNotification)
-			logx.LogAccess.Debug(msg)
-			abortWithError(c, http.StatusBadRequest, msg)
+		msg = "Missing notifications field."
+		logx.LogAccess.Debug(err)
+		abortWithError(c, http.StatusBadRequest, msg)
+		return form, false
+	}
+
+	if len(form.Notifications) == 0 {
+		msg = "Notifications field is empty."
+		logx.LogAccess.Debug(msg)
+		abortWithError(c, http.StatusBadRequest, msg)
+		return form, false
+	}
+
+	if int64(len(form.Notifications)) > cfg.Core.MaxNotification {
+		msg = fmt.Sprintf("Number of notifications(%d) over limit(%d)", len(form.Notifications), cfg.Core.MaxNotification)
+		logx.LogAccess.Debug(msg)
+		abortWithError(c, http.StatusBadRequest, msg)
+		return form, false
+	}
+
+	for i := range form.Notifications {
+		if err := notify.CheckTokenCount(&form.Notifications[i], cfg); err != nil {
+			logx.LogAccess.Debug(err)
+			abortWithError(c, http.StatusRequestEntityTooLarge, err.Error())
+			return form, false
+		}
+		if err := notify.CheckTenant(&form.Notifications[i], cfg); err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return form, false
+		}
+	}
+
+	return form, true
+}
+
+func pushHandler(cfg *config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = logx.NewRequestID()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		form, ok := bindNotifications(c, cfg)
+		if !ok {
 			return
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
+		for i := range form.Notifications {
+			if form.Notifications[i].RequestID == "" {
+				form.Notifications[i].RequestID = requestID
+			}
+		}
+
+		ctx, cancel := context.WithCancel(logx.WithRequestID(context.Background(), requestID))
 		go func() {
 			// Deprecated: the CloseNotifier interface predates Go's context package.
 			// New code should use Request.Context instead.
@@ -96,16 +186,296 @@ func pushHandler(cfg *config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
 			}
 		}()
 
-		counts, logs := handleNotification(ctx, cfg, form, q)
+		counts, resp := handleNotification(ctx, cfg, form, q)
+
+		body := gin.H{
+			"success":        "ok",
+			"counts":         counts,
+			"logs":           resp.Logs,
+			"invalid_tokens": resp.InvalidTokens,
+			"success_count":  resp.Success,
+			"failure_count":  resp.Failure,
+			"total_count":    resp.Total,
+			"request_id":     requestID,
+		}
+		if resp.Error != "" {
+			body["error"] = resp.Error
+		}
+
+		c.JSON(pushStatusCode(cfg, resp), body)
+	}
+}
+
+// pushStatusCode returns the HTTP status of the push results with
+// core.multi_status in sync mode: 200 when every push succeeded, 207 when a
+// part of them failed and 502 when all of them failed. It's 200 otherwise.
+// A failed fail fast notification is 502 in sync mode.
+func pushStatusCode(cfg *config.ConfYaml, resp *notify.ResponsePush) int {
+	if cfg.Core.Sync && resp.Error != "" {
+		return http.StatusBadGateway
+	}
+
+	if !cfg.Core.MultiStatus || !cfg.Core.Sync || resp.Total == 0 {
+		return http.StatusOK
+	}
+
+	switch {
+	case resp.Success >= resp.Total:
+		return http.StatusOK
+	case resp.Success == 0:
+		return http.StatusBadGateway
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+// validationError is the error of a notification rejected by validateHandler.
+type validationError struct {
+	Index     int            `json:"index"`
+	Platform  int            `json:"platform"`
+	ErrorCode core.ErrorCode `json:"error_code"`
+	Message   string         `json:"message"`
+}
+
+// validateHandler checks the notifications like the push handler without
+// sending them, no provider credential is needed. It responds 200 when all
+// of them are valid and with the error of every invalid one otherwise. The
+// status is the highest one suggested by the errors: 400 for an invalid
+// request, 413 for too many tokens or a payload too large and 500 for a
+// missing server config.
+func validateHandler(cfg *config.ConfYaml) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		form, ok := bindNotifications(c, cfg)
+		if !ok {
+			return
+		}
+
+		errs := []validationError{}
+		statusCode := http.StatusOK
+		for i := range form.Notifications {
+			notification := &form.Notifications[i]
+			if err := notify.ValidateNotification(c.Request.Context(), notification, cfg); err != nil {
+				statusCode = max(statusCode, notify.ValidationStatus(err))
+
+				code := core.ErrorCodeInvalidPayload
+				if errors.Is(err, notify.ErrFCMTokenFormat) {
+					code = core.ErrorCodeInvalidToken
+				}
+
+				errs = append(errs, validationError{
+					Index:     i,
+					Platform:  notification.Platform,
+					ErrorCode: code,
+					Message:   err.Error(),
+				})
+			}
+		}
+
+		if len(errs) > 0 {
+			c.JSON(statusCode, gin.H{
+				"ok":     false,
+				"errors": errs,
+			})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"success": "ok",
-			"counts":  counts,
-			"logs":    logs,
+			"ok": true,
 		})
 	}
 }
 
+// resultHandler responds the result of the notifications of the request ID
+// with the body of the push handler: 202 while a part of them isn't sent yet
+// and 200 once all of them were sent.
+func resultHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.Param("id")
+		result, ok, err := notify.GetResult(c.Request.Context(), requestID)
+		if errors.Is(err, notify.ErrResultStoreDisabled) {
+			abortWithError(c, http.StatusNotFound, "Result store is disabled.")
+			return
+		}
+		if !ok {
+			abortWithError(c, http.StatusNotFound, fmt.Sprintf("Unknown request ID(%s).", requestID))
+			return
+		}
+
+		code := http.StatusOK
+		if result.Pending > 0 {
+			code = http.StatusAccepted
+		}
+
+		body := gin.H{
+			"pending_count":  result.Pending,
+			"logs":           result.Logs,
+			"invalid_tokens": result.InvalidTokens,
+			"success_count":  result.Success,
+			"failure_count":  result.Failure,
+			"total_count":    result.Total,
+			"request_id":     requestID,
+		}
+		if result.Error != "" {
+			body["error"] = result.Error
+		}
+
+		c.JSON(code, body)
+	}
+}
+
+// cancelHandler cancels the scheduled notifications of the request ID.
+func cancelHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.Param("id")
+		ctx := logx.WithRequestID(c.Request.Context(), requestID)
+
+		if err := notify.CancelNotification(ctx, requestID); err != nil {
+			if errors.Is(err, notify.ErrScheduleNotFound) {
+				abortWithError(c, http.StatusNotFound, err.Error())
+				return
+			}
+			abortWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":    "ok",
+			"request_id": requestID,
+		})
+	}
+}
+
+// platformNames are the platforms of the runtime switch by name.
+var platformNames = map[string]int{
+	"ios":     core.PlatFormIos,
+	"android": core.PlatFormAndroid,
+	"huawei":  core.PlatFormHuawei,
+}
+
+// platformSwitch is the body of the runtime switch of a platform.
+type platformSwitch struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// platformsHandler returns whether the sends of every platform are enabled
+// at runtime.
+func platformsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		platforms := make(gin.H, len(platformNames))
+		for name, platform := range platformNames {
+			platforms[name] = notify.PlatformEnabled(platform)
+		}
+
+		c.JSON(http.StatusOK, platforms)
+	}
+}
+
+// platformHandler enables or disables the sends of the platform until
+// restart, e.g. during an incident of the provider.
+func platformHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("platform")
+		platform, ok := platformNames[name]
+		if !ok {
+			abortWithError(c, http.StatusNotFound, fmt.Sprintf("Unknown platform(%s).", name))
+			return
+		}
+
+		var body platformSwitch
+		if err := c.ShouldBindWith(&body, binding.JSON); err != nil || body.Enabled == nil {
+			abortWithError(c, http.StatusBadRequest, "Missing enabled field.")
+			return
+		}
+
+		notify.SetPlatformEnabled(platform, *body.Enabled)
+		logx.LogAccess.Infof("set the sends of %s enabled to %t", name, *body.Enabled)
+
+		c.JSON(http.StatusOK, gin.H{
+			"platform": name,
+			"enabled":  *body.Enabled,
+		})
+	}
+}
+
+// tokenBatch is the body of the token batch uploads.
+type tokenBatch struct {
+	Tokens []string `json:"tokens"`
+}
+
+// batchUploadHandler stores the tokens of a campaign ahead of the sends,
+// the notifications with the returned batch_id are sent to them. The body is
+// limited like the pushes, to core.max_body_size and
+// core.max_tokens_per_request.
+func batchUploadHandler(cfg *config.ConfYaml) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !decodeRequestBody(c, cfg) {
+			return
+		}
+
+		var body tokenBatch
+		if err := c.ShouldBindWith(&body, binding.JSON); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				msg := fmt.Sprintf("Request body over limit(%d)", maxBytesErr.Limit)
+				logx.LogAccess.Debug(msg)
+				abortWithError(c, http.StatusRequestEntityTooLarge, msg)
+				return
+			}
+
+			logx.LogAccess.Debug(err)
+			abortWithError(c, http.StatusBadRequest, "Missing tokens field.")
+			return
+		}
+		if len(body.Tokens) == 0 {
+			abortWithError(c, http.StatusBadRequest, "Missing tokens field.")
+			return
+		}
+
+		if limit := cfg.Core.MaxTokensPerRequest; limit > 0 && int64(len(body.Tokens)) > limit {
+			msg := fmt.Sprintf("%s: the batch has %d tokens, over the limit of %d", notify.ErrTooManyTokens, len(body.Tokens), limit)
+			logx.LogAccess.Debug(msg)
+			abortWithError(c, http.StatusRequestEntityTooLarge, msg)
+			return
+		}
+
+		id, err := notify.UploadTokenBatch(body.Tokens)
+		if errors.Is(err, notify.ErrBatchStoreDisabled) {
+			abortWithError(c, http.StatusNotFound, "Token batch store is disabled.")
+			return
+		}
+		if err != nil {
+			logx.LogError.Error("token batch upload error: " + err.Error())
+			abortWithError(c, http.StatusInternalServerError, "Token batch upload failed.")
+			return
+		}
+
+		logx.LogAccess.Infof("uploaded the token batch %s of %d tokens", id, len(body.Tokens))
+		c.JSON(http.StatusCreated, gin.H{
+			"batch_id": id,
+			"count":    len(body.Tokens),
+		})
+	}
+}
+
+// batchDeleteHandler removes the token batch once the campaign is sent.
+func batchDeleteHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := notify.DeleteTokenBatch(id)
+		if errors.Is(err, notify.ErrBatchStoreDisabled) {
+			abortWithError(c, http.StatusNotFound, "Token batch store is disabled.")
+			return
+		}
+		if err != nil {
+			logx.LogError.Error("token batch delete error: " + err.Error())
+			abortWithError(c, http.StatusInternalServerError, "Token batch delete failed.")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"batch_id": id})
+	}
+}
+
 func configHandler(cfg *config.ConfYaml) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.YAML(http.StatusCreated, cfg)
@@ -211,11 +581,19 @@ func routerEngine(cfg *config.ConfYaml, q *queue.Queue) *gin.Engine {
 	r.GET(cfg.API.StatGoURI, api.GinHandler)
 	r.GET(cfg.API.StatAppURI, appStatusHandler(q))
 	r.GET(cfg.API.ConfigURI, configHandler(cfg))
+	r.GET(cfg.API.PlatformURI, platformsHandler())
+	r.PUT(cfg.API.PlatformURI+"/:platform", platformHandler())
+	r.POST(cfg.API.BatchURI, batchUploadHandler(cfg))
+	r.DELETE(cfg.API.BatchURI+"/:id", batchDeleteHandler())
 	r.GET(cfg.API.SysStatURI, sysStatsHandler())
 	r.POST(cfg.API.PushURI, pushHandler(cfg, q))
+	r.POST(cfg.API.PushURI+"/validate", validateHandler(cfg))
+	r.GET(cfg.API.StatusURI+"/:id", resultHandler())
+	r.GET(cfg.API.PushURI+"/:id", resultHandler())
+	r.DELETE(cfg.API.PushURI+"/:id", cancelHandler())
 	r.GET(cfg.API.MetricURI, metricsHandler)
-	r.GET(cfg.API.HealthURI, heartbeatHandler)
-	r.HEAD(cfg.API.HealthURI, heartbeatHandler)
+	r.GET(cfg.API.HealthURI, heartbeatHandler(cfg))
+	r.HEAD(cfg.API.HealthURI, heartbeatHandler(cfg))
 	r.GET("/version", versionHandler)
 	r.GET("/", rootHandler)
 
@@ -224,11 +602,12 @@ func routerEngine(cfg *config.ConfYaml, q *queue.Queue) *gin.Engine {
 
 // markFailedNotification adds failure logs for all tokens in push notification
 func markFailedNotification(
+	ctx context.Context,
 	cfg *config.ConfYaml,
 	notification *notify.PushNotification,
 	reason string,
 ) []logx.LogPushEntry {
-	logx.LogError.Error(reason)
+	logx.ErrorEntry(ctx).Error(reason)
 	logs := make([]logx.LogPushEntry, 0)
 	for _, token := range notification.Tokens {
 		logs = append(logs, logx.GetLogPushEntry(&logx.InputLog{
@@ -240,6 +619,7 @@ func markFailedNotification(
 			Error:     errors.New(reason),
 			HideToken: cfg.Log.HideToken,
 			Format:    cfg.Log.Format,
+			RequestID: notification.RequestID,
 		}))
 	}
 
@@ -248,12 +628,13 @@ func markFailedNotification(
 
 // HandleNotification add notification to queue list.
 func handleNotification(
-	_ context.Context,
+	ctx context.Context,
 	cfg *config.ConfYaml,
 	req notify.RequestPush,
 	q *queue.Queue,
-) (int, []logx.LogPushEntry) {
+) (int, *notify.ResponsePush) {
 	var count int
+	var lock sync.Mutex
 	wg := sync.WaitGroup{}
 	newNotification := []*notify.PushNotification{}
 
@@ -280,39 +661,65 @@ func handleNotification(
 		newNotification = append(newNotification, notification)
 	}
 
-	logs := make([]logx.LogPushEntry, 0, count)
+	result := &notify.ResponsePush{
+		Logs:          make([]logx.LogPushEntry, 0, count),
+		InvalidTokens: make([]string, 0),
+	}
 	for _, notification := range newNotification {
 		if cfg.Core.Sync {
 			wg.Add(1)
 		}
 
+		// the result is pending until the notification is sent
+		notify.SubmitResult(ctx, cfg, notification.RequestID, 1)
+
 		if core.IsLocalQueue(core.Queue(cfg.Queue.Engine)) && cfg.Core.Sync {
 			func(msg *notify.PushNotification, cfg *config.ConfYaml) {
 				if err := q.QueueTask(func(ctx context.Context) error {
 					defer wg.Done()
 					resp, err := notify.SendNotification(ctx, msg, cfg)
-					if err != nil {
+					// the logs of the fail fast notifications are kept
+					if err != nil && !errors.Is(err, notify.ErrFailFast) {
 						return err
 					}
 
 					// add log
-					logs = append(logs, resp.Logs...)
+					lock.Lock()
+					result.Logs = append(result.Logs, resp.Logs...)
+					result.InvalidTokens = append(result.InvalidTokens, resp.InvalidTokens...)
+					result.Success += resp.Success
+					result.Failure += resp.Failure
+					result.Total += resp.Total
+					if result.Error == "" {
+						result.Error = resp.Error
+					}
+					lock.Unlock()
 
-					return nil
+					return err
 				}); err != nil {
-					logx.LogError.Error(err)
+					logx.ErrorEntry(ctx).Error(err)
 				}
 			}(notification, cfg)
 		} else if err := q.Queue(notification); err != nil {
-			resp := markFailedNotification(cfg, notification, "max capacity reached")
+			resp := markFailedNotification(ctx, cfg, notification, "max capacity reached")
+			notify.CompleteResult(ctx, cfg, notification.RequestID, &notify.ResponsePush{
+				RequestID: notification.RequestID,
+				Logs:      resp,
+				Failure:   len(resp),
+				Total:     len(resp),
+			})
 			// add log
-			logs = append(logs, resp...)
+			lock.Lock()
+			result.Logs = append(result.Logs, resp...)
+			result.Failure += len(resp)
+			result.Total += len(resp)
+			lock.Unlock()
 			wg.Done()
 		}
 
 		count += len(notification.Tokens)
-		// Count topic message
-		if notification.To != "" {
+		// Count the topic, condition and device group messages once, like the sends
+		if notification.IsTopic() || notification.IsDeviceGroup() {
 			count++
 		}
 	}
@@ -323,5 +730,5 @@ func handleNotification(
 
 	status.StatStorage.AddTotalCount(int64(count))
 
-	return count, logs
+	return count, result
 }
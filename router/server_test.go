@@ -1,13 +1,23 @@
 package router
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -308,6 +318,619 @@ func TestEmptyNotifications(t *testing.T) {
 		})
 }
 
+func TestPushRequestID(t *testing.T) {
+	cfg := initTest()
+
+	r := gofight.New()
+
+	// the request ID of the client is kept
+	r.POST("/api/push").
+		SetHeader(gofight.H{"X-Request-ID": "req-1"}).
+		SetJSON(gofight.D{
+			"notifications": []notify.PushNotification{},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, "req-1", r.HeaderMap.Get("X-Request-ID"))
+		})
+
+	// a request ID is generated otherwise
+	r = gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []notify.PushNotification{},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Len(t, r.HeaderMap.Get("X-Request-ID"), 16)
+		})
+}
+
+func TestCancelPush(t *testing.T) {
+	cfg := initTest()
+	assert.NoError(t, notify.InitScheduler(cfg))
+	defer func() {
+		assert.NoError(t, notify.PushScheduler.Close())
+		notify.PushScheduler = nil
+	}()
+
+	assert.NoError(t, notify.PushScheduler.Schedule(&notify.PushNotification{
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa"},
+		Message:   "Welcome",
+		RequestID: "req-1",
+	}, time.Now().Add(time.Hour)))
+
+	r := gofight.New()
+	r.DELETE("/api/push/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+		})
+
+	// the notification is canceled already
+	r = gofight.New()
+	r.DELETE("/api/push/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNotFound, r.Code)
+		})
+}
+
+func TestPlatformSwitch(t *testing.T) {
+	cfg := initTest()
+	t.Cleanup(func() { notify.SetPlatformEnabled(core.PlatFormAndroid, true) })
+
+	r := gofight.New()
+	r.PUT("/api/platform/android").
+		SetJSON(gofight.D{"enabled": false}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+		})
+	assert.False(t, notify.PlatformEnabled(core.PlatFormAndroid))
+
+	r = gofight.New()
+	r.GET("/api/platform").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			data := r.Body.Bytes()
+			android, _ := jsonparser.GetBoolean(data, "android")
+			ios, _ := jsonparser.GetBoolean(data, "ios")
+
+			assert.Equal(t, http.StatusOK, r.Code)
+			assert.False(t, android)
+			assert.True(t, ios)
+		})
+
+	r = gofight.New()
+	r.PUT("/api/platform/android").
+		SetJSON(gofight.D{"enabled": true}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+		})
+	assert.True(t, notify.PlatformEnabled(core.PlatFormAndroid))
+
+	r = gofight.New()
+	r.PUT("/api/platform/android").
+		SetJSON(gofight.D{}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, r.Code)
+		})
+
+	r = gofight.New()
+	r.PUT("/api/platform/windows").
+		SetJSON(gofight.D{"enabled": false}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNotFound, r.Code)
+		})
+}
+
+func TestValidatePush(t *testing.T) {
+	cfg := initTest()
+	// no FCM credential is needed
+	cfg.Android.Credential = ""
+	cfg.Android.ServiceAccountKey = ""
+
+	r := gofight.New()
+	r.POST("/api/push/validate").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   []string{"aaaaaaaaa"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+				{
+					"tokens":   []string{"11aa01229f15f0f0c52029d8cf8cd0aeaf2365fe4cebc4af26cd6d76b7919ef7"},
+					"platform": core.PlatFormIos,
+					"message":  "Welcome",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+
+			ok, _ := jsonparser.GetBoolean(r.Body.Bytes(), "ok")
+			assert.True(t, ok)
+		})
+}
+
+func TestValidateInvalidPush(t *testing.T) {
+	cfg := initTest()
+	cfg.Android.StrictTokenValidation = true
+
+	r := gofight.New()
+	r.POST("/api/push/validate").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   []string{"aaaaaaaaa"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+				{
+					"tokens":   []string{"aaaaaaaaa"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+					"priority": "urgent",
+				},
+				{
+					"tokens":   []string{"bad token"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, r.Code)
+
+			body := r.Body.Bytes()
+			ok, _ := jsonparser.GetBoolean(body, "ok")
+			assert.False(t, ok)
+
+			index, _ := jsonparser.GetInt(body, "errors", "[0]", "index")
+			assert.Equal(t, int64(1), index)
+			code, _ := jsonparser.GetString(body, "errors", "[0]", "error_code")
+			assert.Equal(t, string(core.ErrorCodeInvalidPayload), code)
+			message, _ := jsonparser.GetString(body, "errors", "[0]", "message")
+			assert.Equal(t, `the message's priority must be normal or high, got "urgent"`, message)
+
+			index, _ = jsonparser.GetInt(body, "errors", "[1]", "index")
+			assert.Equal(t, int64(2), index)
+			code, _ = jsonparser.GetString(body, "errors", "[1]", "error_code")
+			assert.Equal(t, string(core.ErrorCodeInvalidToken), code)
+		})
+
+	// the request is checked like the pushes
+	r = gofight.New()
+	r.POST("/api/push/validate").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, r.Code)
+		})
+}
+
+func TestValidatePushStatus(t *testing.T) {
+	cfg := initTest()
+	cfg.Android.MaxDataSize = 64
+
+	huaweiTokens := make([]string, 501)
+	for i := range huaweiTokens {
+		huaweiTokens[i] = "token"
+	}
+
+	tests := []struct {
+		name          string
+		notifications []gofight.D
+		code          int
+	}{
+		{
+			name: "missing tokens",
+			notifications: []gofight.D{
+				{"platform": core.PlatFormAndroid, "message": "Welcome"},
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "payload too large",
+			notifications: []gofight.D{
+				{
+					"tokens":    []string{"aaaaaaaaa"},
+					"platform":  core.PlatFormAndroid,
+					"data_only": true,
+					"data":      gofight.D{"payload": strings.Repeat("a", 100)},
+				},
+			},
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "too many tokens",
+			notifications: []gofight.D{
+				{"tokens": huaweiTokens, "platform": core.PlatFormHuawei, "message": "Welcome"},
+			},
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "missing server config",
+			notifications: []gofight.D{
+				{
+					"tokens":        []string{"aaaaaaaaa"},
+					"platform":      core.PlatFormAndroid,
+					"message":       "Welcome",
+					"auto_collapse": true,
+				},
+			},
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "highest status",
+			notifications: []gofight.D{
+				{"platform": core.PlatFormAndroid, "message": "Welcome"},
+				{"tokens": huaweiTokens, "platform": core.PlatFormHuawei, "message": "Welcome"},
+			},
+			code: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gofight.New()
+			r.POST("/api/push/validate").
+				SetJSON(gofight.D{"notifications": tt.notifications}).
+				Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+					assert.Equal(t, tt.code, r.Code)
+
+					ok, _ := jsonparser.GetBoolean(r.Body.Bytes(), "ok")
+					assert.False(t, ok)
+				})
+		})
+	}
+}
+
+func TestPushResult(t *testing.T) {
+	cfg := initTest()
+	ctx := context.Background()
+
+	// without the result store
+	r := gofight.New()
+	r.GET("/status/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNotFound, r.Code)
+		})
+
+	notify.RequestResults = notify.NewMemoryResultStore()
+	t.Cleanup(func() { notify.RequestResults = nil })
+
+	r = gofight.New()
+	r.GET("/status/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNotFound, r.Code)
+		})
+
+	// the notifications are pending until they are sent
+	notify.SubmitResult(ctx, cfg, "req-1", 2)
+	notify.CompleteResult(ctx, cfg, "req-1", &notify.ResponsePush{Success: 1, Total: 1})
+
+	r = gofight.New()
+	r.GET("/status/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusAccepted, r.Code)
+
+			pending, _ := jsonparser.GetInt(r.Body.Bytes(), "pending_count")
+			assert.Equal(t, int64(1), pending)
+		})
+
+	notify.CompleteResult(ctx, cfg, "req-1", &notify.ResponsePush{
+		InvalidTokens: []string{"bad"},
+		Failure:       1,
+		Total:         1,
+	})
+
+	// the result is also served on api.push_uri
+	r = gofight.New()
+	r.GET("/api/push/req-1").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+
+			data := r.Body.Bytes()
+			pending, _ := jsonparser.GetInt(data, "pending_count")
+			success, _ := jsonparser.GetInt(data, "success_count")
+			failure, _ := jsonparser.GetInt(data, "failure_count")
+			total, _ := jsonparser.GetInt(data, "total_count")
+			token, _ := jsonparser.GetString(data, "invalid_tokens", "[0]")
+			assert.Equal(t, int64(0), pending)
+			assert.Equal(t, int64(1), success)
+			assert.Equal(t, int64(1), failure)
+			assert.Equal(t, int64(2), total)
+			assert.Equal(t, "bad", token)
+		})
+}
+
+// initFCMTest points the Android config to a local FCM server, the tokens
+// starting with "bad" are unregistered.
+func initFCMTest(t *testing.T, cfg *config.ConfYaml) {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "test-token", "token_type": "Bearer", "expires_in": 3600}`))
+			return
+		}
+
+		var body struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if strings.HasPrefix(body.Message.Token, "bad") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"status": "NOT_FOUND", "message": "unregistered", "details": [` +
+				`{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "projects/test-router/messages/1"}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	content, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "test-router",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email": "gorush@test-router.iam.gserviceaccount.com",
+		"token_uri":    ts.URL + "/token",
+	})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.json")
+	assert.NoError(t, os.WriteFile(path, content, 0o600))
+
+	cfg.Android.Enabled = true
+	cfg.Android.ProjectID = "test-router"
+	cfg.Android.ServiceAccountKey = path
+	cfg.Android.Endpoint = ts.URL
+}
+
+func TestPushMultiStatus(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.Sync = true
+	cfg.Core.MultiStatus = true
+	initFCMTest(t, cfg)
+
+	tests := []struct {
+		name   string
+		tokens []string
+		code   int
+	}{
+		{"all succeeded", []string{"aaaaaaaaa", "bbbbbbbbb"}, http.StatusOK},
+		{"partial", []string{"aaaaaaaaa", "bad-token"}, http.StatusMultiStatus},
+		{"all failed", []string{"bad-token", "bad-other"}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		r := gofight.New()
+		r.POST("/api/push").
+			SetJSON(gofight.D{
+				"notifications": []gofight.D{
+					{
+						"tokens":   tt.tokens,
+						"platform": core.PlatFormAndroid,
+						"message":  "Welcome",
+					},
+				},
+			}).
+			Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, tt.code, r.Code, tt.name)
+
+				total, _ := jsonparser.GetInt(r.Body.Bytes(), "total_count")
+				assert.Equal(t, int64(2), total, tt.name)
+			})
+	}
+
+	// the results don't change the status without multi_status
+	cfg.Core.MultiStatus = false
+	r := gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   []string{"bad-token"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+
+			failure, _ := jsonparser.GetInt(r.Body.Bytes(), "failure_count")
+			assert.Equal(t, int64(1), failure)
+		})
+}
+
+func TestPushByBatchID(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.Sync = true
+	initFCMTest(t, cfg)
+	notify.TokenBatches = notify.NewMemoryTokenBatchStore()
+	t.Cleanup(func() { notify.TokenBatches = nil })
+
+	var id string
+	r := gofight.New()
+	r.POST("/api/batch").
+		SetJSON(gofight.D{
+			"tokens": []string{"aaaaaaaaa", "bad-token", "bbbbbbbbb"},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			data := r.Body.Bytes()
+			id, _ = jsonparser.GetString(data, "batch_id")
+			count, _ := jsonparser.GetInt(data, "count")
+
+			assert.Equal(t, http.StatusCreated, r.Code)
+			assert.NotEmpty(t, id)
+			assert.Equal(t, int64(3), count)
+		})
+
+	r = gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"batch_id": id,
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			data := r.Body.Bytes()
+			success, _ := jsonparser.GetInt(data, "success_count")
+			failure, _ := jsonparser.GetInt(data, "failure_count")
+			invalid, _ := jsonparser.GetString(data, "invalid_tokens", "[0]")
+
+			assert.Equal(t, http.StatusOK, r.Code)
+			assert.Equal(t, int64(2), success)
+			assert.Equal(t, int64(1), failure)
+			assert.Equal(t, "bad-token", invalid)
+		})
+
+	r = gofight.New()
+	r.DELETE("/api/batch/"+id).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+		})
+	_, err := notify.TokenBatches.Tokens(id)
+	assert.ErrorIs(t, err, notify.ErrBatchNotFound)
+
+	r = gofight.New()
+	r.POST("/api/batch").
+		SetJSON(gofight.D{"tokens": []string{}}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, r.Code)
+		})
+
+	// the gzip uploads are limited like the pushes
+	cfg.Core.MaxBodySize = 1024
+	tokens := make([]string, 1000)
+	for i := range tokens {
+		tokens[i] = "aaaaaaaaa"
+	}
+	r = gofight.New()
+	r.POST("/api/batch").
+		SetHeader(gofight.H{"Content-Encoding": "gzip"}).
+		SetBody(gzipBody(t, gofight.D{"tokens": tokens[:10]})).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			count, _ := jsonparser.GetInt(r.Body.Bytes(), "count")
+
+			assert.Equal(t, http.StatusCreated, r.Code)
+			assert.Equal(t, int64(10), count)
+		})
+
+	r = gofight.New()
+	r.POST("/api/batch").
+		SetHeader(gofight.H{"Content-Encoding": "gzip"}).
+		SetBody(gzipBody(t, gofight.D{"tokens": tokens})).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			message, _ := jsonparser.GetString(r.Body.Bytes(), "message")
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, r.Code)
+			assert.Equal(t, "Request body over limit(1024)", message)
+		})
+
+	cfg.Core.MaxBodySize = 0
+	cfg.Core.MaxTokensPerRequest = 2
+	r = gofight.New()
+	r.POST("/api/batch").
+		SetJSON(gofight.D{"tokens": tokens[:3]}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			message, _ := jsonparser.GetString(r.Body.Bytes(), "message")
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, r.Code)
+			assert.Equal(t, "too many tokens: the batch has 3 tokens, over the limit of 2", message)
+		})
+
+	notify.TokenBatches = nil
+	r = gofight.New()
+	r.POST("/api/batch").
+		SetJSON(gofight.D{"tokens": []string{"aaaaaaaaa"}}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusNotFound, r.Code)
+		})
+}
+
+func TestPushFailFast(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.Sync = true
+	initFCMTest(t, cfg)
+
+	tokens := []string{"aaaaaaaaa", "bbbbbbbbb", "bad-token", "ccccccccc", "bad-other"}
+
+	r := gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":    tokens,
+					"platform":  core.PlatFormAndroid,
+					"message":   "Welcome",
+					"fail_fast": true,
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			data := r.Body.Bytes()
+			msg, _ := jsonparser.GetString(data, "error")
+			success, _ := jsonparser.GetInt(data, "success_count")
+			failure, _ := jsonparser.GetInt(data, "failure_count")
+			logs := 0
+			_, _ = jsonparser.ArrayEach(data, func([]byte, jsonparser.ValueType, int, error) { logs++ }, "logs")
+
+			assert.Equal(t, http.StatusBadGateway, r.Code)
+			assert.Contains(t, msg, "fail fast: the token at index 2 failed")
+			// every token is sent and logged
+			assert.Equal(t, int64(3), success)
+			assert.Equal(t, int64(2), failure)
+			assert.Equal(t, len(tokens), logs)
+		})
+
+	// the partial success is the default
+	r = gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   tokens,
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			_, _, _, err := jsonparser.Get(r.Body.Bytes(), "error")
+
+			assert.Equal(t, http.StatusOK, r.Code)
+			assert.Error(t, err)
+		})
+}
+
+func TestPushStatusCode(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.MultiStatus = true
+
+	// the async pushes aren't sent yet
+	assert.Equal(t, http.StatusOK, pushStatusCode(cfg, &notify.ResponsePush{Total: 2}))
+
+	cfg.Core.Sync = true
+	assert.Equal(t, http.StatusOK, pushStatusCode(cfg, &notify.ResponsePush{}))
+	assert.Equal(t, http.StatusOK, pushStatusCode(cfg, &notify.ResponsePush{Total: 2, Success: 2}))
+	assert.Equal(t, http.StatusMultiStatus, pushStatusCode(cfg, &notify.ResponsePush{Total: 2, Success: 1, Failure: 1}))
+	assert.Equal(t, http.StatusBadGateway, pushStatusCode(cfg, &notify.ResponsePush{Total: 2, Failure: 2}))
+}
+
 func TestMutableContent(t *testing.T) {
 	cfg := initTest()
 
@@ -365,6 +988,154 @@ func TestOutOfRangeMaxNotifications(t *testing.T) {
 		})
 }
 
+// gzipBody returns the gzipped JSON of v.
+func gzipBody(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	assert.NoError(t, json.NewEncoder(zw).Encode(v))
+	assert.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func TestGzipPushHandler(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.Sync = true
+	initFCMTest(t, cfg)
+
+	r := gofight.New()
+	r.POST("/api/push").
+		SetHeader(gofight.H{"Content-Encoding": "gzip"}).
+		SetBody(gzipBody(t, gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   []string{"aaaaaaaaa", "bbbbbbbbb", "ccccccccc"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome",
+				},
+			},
+		})).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+
+			success, _ := jsonparser.GetInt(r.Body.Bytes(), "success_count")
+			assert.Equal(t, int64(3), success)
+		})
+
+	// the body isn't gzipped
+	r = gofight.New()
+	r.POST("/api/push").
+		SetHeader(gofight.H{"Content-Encoding": "gzip"}).
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusBadRequest, r.Code)
+		})
+}
+
+func TestGzipPushBodyLimit(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.MaxBodySize = 1024
+
+	tokens := make([]string, 1000)
+	for i := range tokens {
+		tokens[i] = "aaaaaaaaa"
+	}
+	body := gzipBody(t, gofight.D{
+		"notifications": []gofight.D{
+			{
+				"tokens":   tokens,
+				"platform": core.PlatFormAndroid,
+				"message":  "Welcome",
+			},
+		},
+	})
+	// the compressed body is under the limit, the decompressed one isn't
+	assert.Less(t, len(body), 1024)
+
+	r := gofight.New()
+	r.POST("/api/push").
+		SetHeader(gofight.H{"Content-Encoding": "gzip"}).
+		SetBody(body).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusRequestEntityTooLarge, r.Code)
+
+			message, _ := jsonparser.GetString(r.Body.Bytes(), "message")
+			assert.Equal(t, "Request body over limit(1024)", message)
+		})
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	cfg := initTest()
+
+	r := gofight.New()
+	r.POST("/api/push").
+		SetHeader(gofight.H{"Content-Encoding": "br"}).
+		SetBody("{}").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusUnsupportedMediaType, r.Code)
+		})
+}
+
+func TestMaxTokensPerRequest(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.MaxTokensPerRequest = 2
+
+	r := gofight.New()
+	r.POST("/api/push").
+		SetJSON(gofight.D{
+			"notifications": []gofight.D{
+				{
+					"tokens":   []string{"aaaaa"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome API From Android",
+				},
+				{
+					"tokens":   []string{"aaaaa", "bbbbb", "ccccc"},
+					"platform": core.PlatFormAndroid,
+					"message":  "Welcome API From Android",
+				},
+			},
+		}).
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusRequestEntityTooLarge, r.Code)
+
+			message, _ := jsonparser.GetString(r.Body.Bytes(), "message")
+			assert.Equal(t, "too many tokens: the message has 3 tokens, over the limit of 2", message)
+		})
+}
+
+func TestPushTenantID(t *testing.T) {
+	cfg := initTest()
+	cfg.Core.Tenants = []string{"acme"}
+
+	for tenant, want := range map[string]string{
+		"acme/../eu": `the tenant_id "acme/../eu" must be 1 to 64 letters, digits, '_', '.' or '-'`,
+		"globex":     `the tenant_id "globex" is not listed in core.tenants`,
+	} {
+		r := gofight.New()
+		r.POST("/api/push").
+			SetJSON(gofight.D{
+				"notifications": []gofight.D{
+					{
+						"tokens":    []string{"aaaaa"},
+						"platform":  core.PlatFormAndroid,
+						"message":   "Welcome API From Android",
+						"tenant_id": tenant,
+					},
+				},
+			}).
+			Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, http.StatusBadRequest, r.Code)
+
+				message, _ := jsonparser.GetString(r.Body.Bytes(), "message")
+				assert.Equal(t, want, message)
+			})
+	}
+}
+
 func TestSuccessPushHandler(t *testing.T) {
 	t.Skip()
 	cfg := initTest()
@@ -432,6 +1203,35 @@ func TestHEADHeartbeatHandler(t *testing.T) {
 		})
 }
 
+func TestHeartbeatHandlerFCMHealthCheck(t *testing.T) {
+	cfg := initTest()
+	cfg.Android.Enabled = true
+	cfg.Android.HealthCheck = true
+	cfg.Android.ServiceAccountKey = "../config/testdata/missing.json"
+
+	r := gofight.New()
+
+	r.GET("/healthz").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			code, _ := jsonparser.GetInt(r.Body.Bytes(), "code")
+
+			assert.Equal(t, http.StatusServiceUnavailable, r.Code)
+			assert.Equal(t, int64(http.StatusServiceUnavailable), code)
+		})
+
+	r.HEAD("/healthz").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusServiceUnavailable, r.Code)
+		})
+
+	// the check is disabled
+	cfg.Android.HealthCheck = false
+	r.GET("/healthz").
+		Run(routerEngine(cfg, q), func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, http.StatusOK, r.Code)
+		})
+}
+
 func TestVersionHandler(t *testing.T) {
 	SetVersion("3.0.0")
 	cfg := initTest()
@@ -486,9 +1286,9 @@ func TestSenMultipleNotifications(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	assert.Equal(t, 1, count)
-	assert.Equal(t, 0, len(logs))
+	assert.Equal(t, 0, len(resp.Logs))
 }
 
 func TestDisabledAndroidNotifications(t *testing.T) {
@@ -519,9 +1319,9 @@ func TestDisabledAndroidNotifications(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	assert.Equal(t, 1, count)
-	assert.Equal(t, 0, len(logs))
+	assert.Equal(t, 0, len(resp.Logs))
 }
 
 func TestSyncModeForNotifications(t *testing.T) {
@@ -557,9 +1357,9 @@ func TestSyncModeForNotifications(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	assert.Equal(t, 1, count)
-	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, 1, len(resp.Logs))
 }
 
 func TestSyncModeForTopicNotification(t *testing.T) {
@@ -600,9 +1400,9 @@ func TestSyncModeForTopicNotification(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	assert.Equal(t, 2, count)
-	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, 1, len(resp.Logs))
 }
 
 func TestSyncModeForDeviceGroupNotification(t *testing.T) {
@@ -627,9 +1427,46 @@ func TestSyncModeForDeviceGroupNotification(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	assert.Equal(t, 1, count)
-	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, 1, len(resp.Logs))
+}
+
+func TestTopicNotificationCount(t *testing.T) {
+	ctx := context.Background()
+	cfg := initTest()
+	cfg.Core.Sync = true
+	initFCMTest(t, cfg)
+
+	req := notify.RequestPush{
+		Notifications: []notify.PushNotification{
+			{
+				Topic:    "news",
+				Platform: core.PlatFormAndroid,
+				Message:  "This is a Firebase Cloud Messaging Topic Message!",
+			},
+			{
+				To:       "/topics/foo-bar",
+				Platform: core.PlatFormAndroid,
+				Message:  "This is a Firebase Cloud Messaging Topic Message!",
+			},
+			{
+				Condition: "'dogs' in topics || 'cats' in topics",
+				Platform:  core.PlatFormAndroid,
+				Message:   "This is a Firebase Cloud Messaging Condition Message!",
+			},
+			{
+				To:       "aUniqueKey",
+				Platform: core.PlatFormAndroid,
+				Message:  "This is a Firebase Cloud Messaging Device Group Message!",
+			},
+		},
+	}
+
+	// each message is counted once, like it's sent
+	count, resp := handleNotification(ctx, cfg, req, q)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, 4, resp.Success)
 }
 
 func TestDisabledIosNotifications(t *testing.T) {
@@ -660,8 +1497,8 @@ func TestDisabledIosNotifications(t *testing.T) {
 		},
 	}
 
-	count, logs := handleNotification(ctx, cfg, req, q)
+	count, resp := handleNotification(ctx, cfg, req, q)
 	// assert.Equal(t, 2, count)
 	assert.Equal(t, 0, count)
-	assert.Equal(t, 0, len(logs))
+	assert.Equal(t, 0, len(resp.Logs))
 }
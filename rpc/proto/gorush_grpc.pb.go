@@ -23,6 +23,12 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type GorushClient interface {
 	Send(ctx context.Context, in *NotificationRequest, opts ...grpc.CallOption) (*NotificationReply, error)
+	// SendStream always sends the notification in sync mode and replies the
+	// result of every batch of Android tokens once it's completed.
+	SendStream(ctx context.Context, in *NotificationRequest, opts ...grpc.CallOption) (Gorush_SendStreamClient, error)
+	// Cancel removes the scheduled notifications of the request ID which
+	// aren't sent yet, it fails with NOT_FOUND otherwise.
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*CancelReply, error)
 }
 
 type gorushClient struct {
@@ -42,11 +48,58 @@ func (c *gorushClient) Send(ctx context.Context, in *NotificationRequest, opts .
 	return out, nil
 }
 
+func (c *gorushClient) SendStream(ctx context.Context, in *NotificationRequest, opts ...grpc.CallOption) (Gorush_SendStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Gorush_ServiceDesc.Streams[0], "/proto.Gorush/SendStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gorushSendStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Gorush_SendStreamClient interface {
+	Recv() (*NotificationReply, error)
+	grpc.ClientStream
+}
+
+type gorushSendStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *gorushSendStreamClient) Recv() (*NotificationReply, error) {
+	m := new(NotificationReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *gorushClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*CancelReply, error) {
+	out := new(CancelReply)
+	err := c.cc.Invoke(ctx, "/proto.Gorush/Cancel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GorushServer is the server API for Gorush service.
 // All implementations should embed UnimplementedGorushServer
 // for forward compatibility
 type GorushServer interface {
 	Send(context.Context, *NotificationRequest) (*NotificationReply, error)
+	// SendStream always sends the notification in sync mode and replies the
+	// result of every batch of Android tokens once it's completed.
+	SendStream(*NotificationRequest, Gorush_SendStreamServer) error
+	// Cancel removes the scheduled notifications of the request ID which
+	// aren't sent yet, it fails with NOT_FOUND otherwise.
+	Cancel(context.Context, *CancelRequest) (*CancelReply, error)
 }
 
 // UnimplementedGorushServer should be embedded to have forward compatible implementations.
@@ -56,6 +109,12 @@ type UnimplementedGorushServer struct {
 func (UnimplementedGorushServer) Send(context.Context, *NotificationRequest) (*NotificationReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Send not implemented")
 }
+func (UnimplementedGorushServer) SendStream(*NotificationRequest, Gorush_SendStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method SendStream not implemented")
+}
+func (UnimplementedGorushServer) Cancel(context.Context, *CancelRequest) (*CancelReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
 
 // UnsafeGorushServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to GorushServer will
@@ -86,6 +145,45 @@ func _Gorush_Send_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Gorush_SendStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(NotificationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GorushServer).SendStream(m, &gorushSendStreamServer{stream})
+}
+
+type Gorush_SendStreamServer interface {
+	Send(*NotificationReply) error
+	grpc.ServerStream
+}
+
+type gorushSendStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *gorushSendStreamServer) Send(m *NotificationReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Gorush_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GorushServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Gorush/Cancel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GorushServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Gorush_ServiceDesc is the grpc.ServiceDesc for Gorush service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -97,8 +195,18 @@ var Gorush_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Send",
 			Handler:    _Gorush_Send_Handler,
 		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Gorush_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendStream",
+			Handler:       _Gorush_SendStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "gorush.proto",
 }
 
@@ -3,6 +3,7 @@ package rpc
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"runtime/debug"
@@ -10,7 +11,6 @@ import (
 	"sync"
 
 	"github.com/appleboy/gorush/config"
-	"github.com/appleboy/gorush/core"
 	"github.com/appleboy/gorush/logx"
 	"github.com/appleboy/gorush/notify"
 	"github.com/appleboy/gorush/rpc/proto"
@@ -22,6 +22,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
@@ -62,8 +63,93 @@ func (s *Server) Check(ctx context.Context, in *proto.HealthCheckRequest) (*prot
 
 // Send implements helloworld.GreeterServer
 func (s *Server) Send(ctx context.Context, in *proto.NotificationRequest) (*proto.NotificationReply, error) {
+	notification := pushNotification(in)
+	notification.RequestID = requestID(ctx)
+	ctx = logx.WithRequestID(ctx, notification.RequestID)
+
+	if s.cfg.Core.Sync {
+		resp, err := notify.SendNotification(ctx, notification, s.cfg)
+		if err != nil {
+			logx.ErrorEntry(ctx).Error(err)
+		}
+
+		return pushReply(resp, err, len(in.Tokens)), nil
+	}
+
+	go func() {
+		_, err := notify.SendNotification(ctx, notification, s.cfg)
+		if err != nil {
+			logx.ErrorEntry(ctx).Error(err)
+		}
+	}()
+
+	return &proto.NotificationReply{
+		Success: true,
+		Counts:  int32(len(notification.Tokens)),
+	}, nil
+}
+
+// SendStream implements `rpc SendStream`. The final results of the Android
+// tokens are replied batch by batch, the other platforms and topics are
+// replied once.
+func (s *Server) SendStream(in *proto.NotificationRequest, stream proto.Gorush_SendStreamServer) error {
+	notification := pushNotification(in)
+	notification.RequestID = requestID(stream.Context())
+
+	var (
+		streamed  bool
+		streamErr error
+	)
+
+	ctx := notify.WithBatchHandler(logx.WithRequestID(stream.Context(), notification.RequestID), func(resp *notify.ResponsePush, err error) {
+		streamed = true
+		if streamErr == nil {
+			streamErr = stream.Send(pushReply(resp, err, resp.Total))
+		}
+	})
+
+	resp, err := notify.SendNotification(ctx, notification, s.cfg)
+	if err != nil {
+		logx.ErrorEntry(ctx).Error(err)
+	}
+
+	if streamErr != nil {
+		return streamErr
+	}
+
+	if !streamed {
+		return stream.Send(pushReply(resp, err, len(in.Tokens)))
+	}
+
+	return nil
+}
+
+// Cancel implements `rpc Cancel`.
+func (s *Server) Cancel(ctx context.Context, in *proto.CancelRequest) (*proto.CancelReply, error) {
+	if err := notify.CancelNotification(ctx, in.RequestID); err != nil {
+		if errors.Is(err, notify.ErrScheduleNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &proto.CancelReply{Success: true}, nil
+}
+
+// requestID returns the x-request-id metadata of the call, or else a new
+// request ID.
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-request-id"); len(ids) > 0 && ids[0] != "" {
+		return ids[0]
+	}
+	return logx.NewRequestID()
+}
+
+// pushNotification converts the gRPC request into a notification.
+func pushNotification(in *proto.NotificationRequest) *notify.PushNotification {
 	badge := int(in.Badge)
-	notification := notify.PushNotification{
+	notification := &notify.PushNotification{
 		ID:               in.ID,
 		Platform:         int(in.Platform),
 		Tokens:           in.Tokens,
@@ -77,6 +163,9 @@ func (s *Server) Send(ctx context.Context, in *proto.NotificationRequest) (*prot
 		ThreadID:         in.ThreadID,
 		MutableContent:   in.MutableContent,
 		Image:            in.Image,
+		AndroidImage:     in.AndroidImage,
+		TenantID:         in.TenantID,
+		SendAt:           in.SendAt,
 		Priority:         strings.ToLower(in.GetPriority().String()),
 		PushType:         in.PushType,
 		Development:      in.Development,
@@ -86,10 +175,6 @@ func (s *Server) Send(ctx context.Context, in *proto.NotificationRequest) (*prot
 		notification.Badge = &badge
 	}
 
-	if in.Topic != "" && in.Platform == core.PlatFormAndroid {
-		notification.To = in.Topic
-	}
-
 	if in.Alert != nil {
 		notification.Alert = notify.Alert{
 			Title:        in.Alert.Title,
@@ -109,17 +194,60 @@ func (s *Server) Send(ctx context.Context, in *proto.NotificationRequest) (*prot
 		notification.Data = in.Data.AsMap()
 	}
 
-	go func() {
-		_, err := notify.SendNotification(ctx, &notification, s.cfg)
-		if err != nil {
-			logx.LogError.Error(err)
+	if in.FcmOptions != nil {
+		notification.FCMOptions = &notify.FCMOptions{
+			AnalyticsLabel: in.FcmOptions.AnalyticsLabel,
+			Link:           in.FcmOptions.Link,
 		}
-	}()
+	}
 
-	return &proto.NotificationReply{
-		Success: true,
-		Counts:  int32(len(notification.Tokens)),
-	}, nil
+	return notification
+}
+
+// pushReply converts the result of a sync push into a gRPC reply.
+func pushReply(resp *notify.ResponsePush, err error, counts int) *proto.NotificationReply {
+	reply := &proto.NotificationReply{
+		Success: err == nil,
+		Counts:  int32(counts),
+	}
+
+	if resp != nil {
+		reply.Logs = pushLogs(resp.Logs)
+		reply.InvalidTokens = resp.InvalidTokens
+		reply.SuccessCount = int32(resp.Success)
+		reply.FailureCount = int32(resp.Failure)
+	}
+
+	return reply
+}
+
+// pushLogs converts the push logs into gRPC reply logs.
+func pushLogs(logs []logx.LogPushEntry) []*proto.PushLog {
+	result := make([]*proto.PushLog, 0, len(logs))
+	for _, l := range logs {
+		result = append(result, &proto.PushLog{
+			ID:        l.ID,
+			Type:      l.Type,
+			Platform:  l.Platform,
+			Token:     l.Token,
+			Message:   l.Message,
+			Error:     l.Error,
+			MessageID: l.MessageID,
+			ErrorType: l.ErrorType,
+			Index:     pushLogIndex(l.Index),
+		})
+	}
+
+	return result
+}
+
+func pushLogIndex(index *int) *int32 {
+	if index == nil {
+		return nil
+	}
+
+	v := int32(*index)
+	return &v
 }
 
 // RunGRPCServer run gorush grpc server
@@ -141,6 +269,11 @@ func RunGRPCServer(ctx context.Context, cfg *config.ConfYaml) error {
 		grpc_recovery.UnaryServerInterceptor(recoveryOpt),
 	}
 
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_prometheus.StreamServerInterceptor,
+		grpc_recovery.StreamServerInterceptor(recoveryOpt),
+	}
+
 	var s *grpc.Server
 
 	if cfg.Core.SSL && cfg.Core.CertPath != "" && cfg.Core.KeyPath != "" {
@@ -160,11 +293,13 @@ func RunGRPCServer(ctx context.Context, cfg *config.ConfYaml) error {
 			grpc.Creds(credentials.NewTLS(tlsConfig)),
 			grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
 		)
 	} else {
 		s = grpc.NewServer(
 			grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
 		)
 	}
 
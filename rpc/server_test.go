@@ -1,5 +1,22 @@
 package rpc
 
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appleboy/gorush/config"
+	"github.com/appleboy/gorush/core"
+	"github.com/appleboy/gorush/logx"
+	"github.com/appleboy/gorush/notify"
+	"github.com/appleboy/gorush/rpc/proto"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
 // const gRPCAddr = "localhost:9000"
 
 // func initTest() *config.ConfYaml {
@@ -40,3 +57,101 @@ package rpc
 // 	}
 // 	conn.Close()
 // }
+
+type sendStream struct {
+	grpc.ServerStream
+	replies []*proto.NotificationReply
+}
+
+func (s *sendStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *sendStream) Send(reply *proto.NotificationReply) error {
+	s.replies = append(s.replies, reply)
+	return nil
+}
+
+func TestSendStreamWithoutBatches(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	stream := &sendStream{}
+
+	// the request error is replied once
+	err := NewServer(cfg).SendStream(&proto.NotificationRequest{
+		Platform: core.PlatFormAndroid,
+		Message:  "test",
+	}, stream)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(stream.replies))
+	assert.False(t, stream.replies[0].Success)
+	assert.Equal(t, int32(0), stream.replies[0].Counts)
+}
+
+func TestCancel(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	assert.NoError(t, notify.InitScheduler(cfg))
+	defer func() {
+		assert.NoError(t, notify.PushScheduler.Close())
+		notify.PushScheduler = nil
+	}()
+
+	assert.NoError(t, notify.PushScheduler.Schedule(&notify.PushNotification{
+		Platform:  core.PlatFormAndroid,
+		Tokens:    []string{"aaaaaaaaa"},
+		Message:   "test",
+		RequestID: "abc",
+	}, time.Now().Add(time.Hour)))
+
+	reply, err := NewServer(cfg).Cancel(context.Background(), &proto.CancelRequest{RequestID: "abc"})
+	assert.NoError(t, err)
+	assert.True(t, reply.Success)
+
+	_, err = NewServer(cfg).Cancel(context.Background(), &proto.CancelRequest{RequestID: "abc"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPushNotificationFCMOptions(t *testing.T) {
+	notification := pushNotification(&proto.NotificationRequest{
+		Platform: core.PlatFormAndroid,
+		Tokens:   []string{"token_a"},
+		FcmOptions: &proto.FCMOptions{
+			AnalyticsLabel: "campaign",
+			Link:           "https://example.com/campaign",
+		},
+	})
+
+	assert.Equal(t, "campaign", notification.FCMOptions.AnalyticsLabel)
+	assert.Equal(t, "https://example.com/campaign", notification.FCMOptions.Link)
+
+	notification = pushNotification(&proto.NotificationRequest{Platform: core.PlatFormAndroid})
+	assert.Nil(t, notification.FCMOptions)
+}
+
+func TestPushLogs(t *testing.T) {
+	index := 4
+	logs := pushLogs([]logx.LogPushEntry{
+		{
+			Type:      core.SucceededPush,
+			Platform:  "android",
+			Token:     "token_a",
+			MessageID: "projects/foo-123/messages/1",
+			Index:     &index,
+		},
+		{
+			Type:      core.FailedPush,
+			Platform:  "android",
+			Token:     "token_b",
+			Error:     "invalid token",
+			ErrorType: "invalid_token",
+		},
+	})
+
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, "projects/foo-123/messages/1", logs[0].MessageID)
+	assert.Equal(t, "token_a", logs[0].Token)
+	assert.Equal(t, int32(4), logs[0].GetIndex())
+	assert.Nil(t, logs[1].Index)
+	assert.Empty(t, logs[1].MessageID)
+	assert.Equal(t, "invalid token", logs[1].Error)
+	assert.Equal(t, "invalid_token", logs[1].ErrorType)
+}
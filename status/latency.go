@@ -0,0 +1,78 @@
+package status
+
+import "time"
+
+// SendLatencyBuckets are the upper bounds in seconds of the send latency
+// histogram.
+var SendLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Outcomes of the sends observed by ObserveSendLatency.
+const (
+	SendSuccess = "success"
+	SendError   = "error"
+)
+
+// LatencyKey identifies a send latency histogram.
+type LatencyKey struct {
+	Platform string
+	Outcome  string
+}
+
+// Latency is a send latency histogram, the buckets count the sends by upper
+// bound of SendLatencyBuckets and are cumulative.
+type Latency struct {
+	Count   uint64
+	Sum     float64
+	Buckets map[float64]uint64
+}
+
+// ObserveSendLatency records the duration of a send to the provider of the
+// platform. The histograms are kept in memory, they describe this instance
+// only.
+func (s *StateStorage) ObserveSendLatency(platform, outcome string, d time.Duration) {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+
+	key := LatencyKey{Platform: platform, Outcome: outcome}
+	latency, ok := s.latencies[key]
+	if !ok {
+		latency = &Latency{Buckets: make(map[float64]uint64, len(SendLatencyBuckets))}
+		for _, bound := range SendLatencyBuckets {
+			latency.Buckets[bound] = 0
+		}
+		s.latencies[key] = latency
+	}
+
+	seconds := d.Seconds()
+	latency.Count++
+	latency.Sum += seconds
+	for _, bound := range SendLatencyBuckets {
+		if seconds <= bound {
+			latency.Buckets[bound]++
+		}
+	}
+}
+
+// SendLatencies returns a copy of the send latency histograms.
+func (s *StateStorage) SendLatencies() map[LatencyKey]Latency {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+
+	latencies := make(map[LatencyKey]Latency, len(s.latencies))
+	for key, latency := range s.latencies {
+		buckets := make(map[float64]uint64, len(latency.Buckets))
+		for bound, count := range latency.Buckets {
+			buckets[bound] = count
+		}
+		latencies[key] = Latency{Count: latency.Count, Sum: latency.Sum, Buckets: buckets}
+	}
+
+	return latencies
+}
+
+func (s *StateStorage) resetLatencies() {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+
+	s.latencies = make(map[LatencyKey]*Latency)
+}
@@ -0,0 +1,39 @@
+package status
+
+// Results of the retries counted by AddRetry, every resent token counts as
+// an attempt and then as a success or a failure.
+const (
+	RetryAttempt = "attempt"
+	RetrySuccess = "success"
+	RetryFailure = "failure"
+)
+
+// RetryPlatforms are the platforms of the retry counters.
+var RetryPlatforms = []string{"ios", "android", "huawei"}
+
+// RetryResults lists the results of the retry counters.
+var RetryResults = []string{RetryAttempt, RetrySuccess, RetryFailure}
+
+func retryKey(platform, result string) string {
+	return "gorush-" + platform + "-retry-" + result
+}
+
+// AddRetry record counts of the tokens resent to the provider of the
+// platform by result, the first sends aren't counted.
+func (s *StateStorage) AddRetry(platform, result string, count int64) {
+	s.store.Add(retryKey(platform, result), count)
+}
+
+// GetRetry show counts of the tokens resent to the provider of the platform
+// by result.
+func (s *StateStorage) GetRetry(platform, result string) int64 {
+	return s.store.Get(retryKey(platform, result))
+}
+
+func (s *StateStorage) resetRetries() {
+	for _, platform := range RetryPlatforms {
+		for _, result := range RetryResults {
+			s.store.Set(retryKey(platform, result), 0)
+		}
+	}
+}
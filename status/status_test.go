@@ -2,6 +2,7 @@ package status
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -47,6 +48,113 @@ func TestStatForMemoryEngine(t *testing.T) {
 	assert.Equal(t, int64(400), val)
 	val = StatStorage.GetAndroidError()
 	assert.Equal(t, int64(500), val)
+
+	StatStorage.AddAndroidErrorByType(AndroidErrorUnregistered)
+	StatStorage.AddAndroidErrorByType(AndroidErrorUnregistered)
+	StatStorage.AddAndroidErrorByType(AndroidErrorInternal)
+	val = StatStorage.GetAndroidErrorByType(AndroidErrorUnregistered)
+	assert.Equal(t, int64(2), val)
+	val = StatStorage.GetAndroidErrorByType(AndroidErrorInternal)
+	assert.Equal(t, int64(1), val)
+	val = StatStorage.GetAndroidErrorByType(AndroidErrorQuotaExceeded)
+	assert.Equal(t, int64(0), val)
+
+	StatStorage.Reset()
+	val = StatStorage.GetAndroidErrorByType(AndroidErrorUnregistered)
+	assert.Equal(t, int64(0), val)
+}
+
+func TestStatByTenant(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Stat.Engine = "memory"
+	assert.NoError(t, InitAppStatus(cfg))
+
+	StatStorage.AddAndroidSuccessByTenant("alpha", 3)
+	StatStorage.AddAndroidErrorByTenant("alpha", 1)
+	StatStorage.AddAndroidSuccessByTenant("beta", 2)
+	StatStorage.AddIosSuccessByTenant("beta", 5)
+	StatStorage.AddHuaweiErrorByTenant("", 4)
+
+	// the global counters include all the tenants
+	assert.Equal(t, int64(5), StatStorage.GetAndroidSuccess())
+	assert.Equal(t, int64(1), StatStorage.GetAndroidError())
+	assert.Equal(t, int64(5), StatStorage.GetIosSuccess())
+	assert.Equal(t, int64(4), StatStorage.GetHuaweiError())
+
+	assert.Equal(t, int64(3), StatStorage.GetAndroidSuccessByTenant("alpha"))
+	assert.Equal(t, int64(1), StatStorage.GetAndroidErrorByTenant("alpha"))
+	assert.Equal(t, int64(0), StatStorage.GetIosSuccessByTenant("alpha"))
+	assert.Equal(t, int64(2), StatStorage.GetAndroidSuccessByTenant("beta"))
+	assert.Equal(t, int64(0), StatStorage.GetAndroidErrorByTenant("beta"))
+	assert.Equal(t, int64(5), StatStorage.GetIosSuccessByTenant("beta"))
+	assert.Equal(t, []string{"alpha", "beta"}, StatStorage.Tenants())
+
+	StatStorage.Reset()
+	assert.Equal(t, int64(0), StatStorage.GetAndroidSuccessByTenant("alpha"))
+	assert.Equal(t, int64(0), StatStorage.GetIosSuccessByTenant("beta"))
+}
+
+func TestTenantsAfterRestart(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Stat.Engine = "buntdb"
+	cfg.Stat.BuntDB.Path = filepath.Join(t.TempDir(), "bunt.db")
+	assert.NoError(t, InitAppStatus(cfg))
+
+	StatStorage.AddAndroidSuccessByTenant("alpha", 3)
+	StatStorage.AddIosErrorByTenant("beta", 1)
+	assert.NoError(t, StatStorage.Close())
+
+	// the tenants are listed again along with their counters
+	assert.NoError(t, InitAppStatus(cfg))
+	defer StatStorage.Close()
+	assert.Equal(t, []string{"alpha", "beta"}, StatStorage.Tenants())
+	assert.Equal(t, int64(3), StatStorage.GetAndroidSuccessByTenant("alpha"))
+	assert.Equal(t, int64(1), StatStorage.GetIosErrorByTenant("beta"))
+}
+
+func TestStatGauges(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Stat.Engine = "memory"
+	assert.NoError(t, InitAppStatus(cfg))
+
+	StatStorage.SetQueueDepth(3)
+	assert.Equal(t, int64(3), StatStorage.GetQueueDepth())
+
+	StatStorage.IncInFlight()
+	StatStorage.IncInFlight()
+	assert.Equal(t, int64(2), StatStorage.GetInFlight())
+	StatStorage.DecInFlight()
+	assert.Equal(t, int64(1), StatStorage.GetInFlight())
+}
+
+func TestSendLatency(t *testing.T) {
+	cfg, _ := config.LoadConf()
+	cfg.Stat.Engine = "memory"
+	assert.NoError(t, InitAppStatus(cfg))
+
+	StatStorage.ObserveSendLatency("android", SendSuccess, 20*time.Millisecond)
+	StatStorage.ObserveSendLatency("android", SendSuccess, 2*time.Second)
+	StatStorage.ObserveSendLatency("android", SendError, time.Minute)
+
+	latencies := StatStorage.SendLatencies()
+	assert.Equal(t, 2, len(latencies))
+
+	latency := latencies[LatencyKey{Platform: "android", Outcome: SendSuccess}]
+	assert.Equal(t, uint64(2), latency.Count)
+	assert.InDelta(t, 2.02, latency.Sum, 0.0001)
+	assert.Equal(t, uint64(0), latency.Buckets[0.01])
+	assert.Equal(t, uint64(1), latency.Buckets[0.025])
+	assert.Equal(t, uint64(1), latency.Buckets[1])
+	assert.Equal(t, uint64(2), latency.Buckets[2.5])
+	assert.Equal(t, uint64(2), latency.Buckets[30])
+
+	// the sends slower than the last bucket are only in the count
+	latency = latencies[LatencyKey{Platform: "android", Outcome: SendError}]
+	assert.Equal(t, uint64(1), latency.Count)
+	assert.Equal(t, uint64(0), latency.Buckets[30])
+
+	StatStorage.Reset()
+	assert.Empty(t, StatStorage.SendLatencies())
 }
 
 func TestRedisServerSuccess(t *testing.T) {
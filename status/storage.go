@@ -1,21 +1,104 @@
 package status
 
 import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
 	"github.com/appleboy/gorush/core"
 )
 
+// FCM error types counted by AddAndroidErrorByType.
+const (
+	AndroidErrorUnregistered     = "unregistered"
+	AndroidErrorInvalidArgument  = "invalid_argument"
+	AndroidErrorQuotaExceeded    = "quota_exceeded"
+	AndroidErrorUnavailable      = "unavailable"
+	AndroidErrorInternal         = "internal"
+	AndroidErrorSenderIDMismatch = "sender_id_mismatch"
+	AndroidErrorThirdPartyAuth   = "third_party_auth"
+	AndroidErrorTimeout          = "timeout"
+	AndroidErrorRateLimited      = "rate_limited"
+	AndroidErrorCircuitOpen      = "circuit_open"
+	AndroidErrorInvalidFormat    = "invalid_format"
+	AndroidErrorDisabled         = "platform_disabled"
+	AndroidErrorThrottled        = "throttled"
+	AndroidErrorUnknown          = "unknown"
+)
+
+// AndroidErrorTypes lists all the FCM error types.
+var AndroidErrorTypes = []string{
+	AndroidErrorUnregistered,
+	AndroidErrorInvalidArgument,
+	AndroidErrorQuotaExceeded,
+	AndroidErrorUnavailable,
+	AndroidErrorInternal,
+	AndroidErrorSenderIDMismatch,
+	AndroidErrorThirdPartyAuth,
+	AndroidErrorTimeout,
+	AndroidErrorRateLimited,
+	AndroidErrorCircuitOpen,
+	AndroidErrorInvalidFormat,
+	AndroidErrorDisabled,
+	AndroidErrorThrottled,
+	AndroidErrorUnknown,
+}
+
+func androidErrorTypeKey(kind string) string {
+	return core.AndroidErrorKey + "-" + kind
+}
+
+// tenantCountKeys are the counters kept per tenant.
+var tenantCountKeys = []string{
+	core.IosSuccessKey,
+	core.IosErrorKey,
+	core.AndroidSuccessKey,
+	core.AndroidErrorKey,
+	core.HuaweiSuccessKey,
+	core.HuaweiErrorKey,
+}
+
+func tenantKey(key, tenant string) string {
+	return key + "-tenant-" + tenant
+}
+
 type StateStorage struct {
 	store core.Storage
+
+	mu      sync.RWMutex
+	tenants map[string]struct{}
+
+	// the gauges are kept in memory, they describe this instance only
+	queueDepth atomic.Int64
+	inFlight   atomic.Int64
+
+	latencyMu sync.Mutex
+	latencies map[LatencyKey]*Latency
 }
 
 func NewStateStorage(store core.Storage) *StateStorage {
 	return &StateStorage{
-		store: store,
+		store:     store,
+		tenants:   make(map[string]struct{}),
+		latencies: make(map[LatencyKey]*Latency),
 	}
 }
 
 func (s *StateStorage) Init() error {
-	return s.store.Init()
+	if err := s.store.Init(); err != nil {
+		return err
+	}
+
+	// list the tenants counted before the restart
+	if store, ok := s.store.(core.KeyStorage); ok {
+		s.mu.Lock()
+		for _, key := range store.Keys(core.TenantKeyPrefix) {
+			s.tenants[strings.TrimPrefix(key, core.TenantKeyPrefix)] = struct{}{}
+		}
+		s.mu.Unlock()
+	}
+	return nil
 }
 
 func (s *StateStorage) Close() error {
@@ -29,8 +112,18 @@ func (s *StateStorage) Reset() {
 	s.store.Set(core.IosErrorKey, 0)
 	s.store.Set(core.AndroidSuccessKey, 0)
 	s.store.Set(core.AndroidErrorKey, 0)
+	for _, kind := range AndroidErrorTypes {
+		s.store.Set(androidErrorTypeKey(kind), 0)
+	}
 	s.store.Set(core.HuaweiSuccessKey, 0)
 	s.store.Set(core.HuaweiErrorKey, 0)
+	for _, tenant := range s.Tenants() {
+		for _, key := range tenantCountKeys {
+			s.store.Set(tenantKey(key, tenant), 0)
+		}
+	}
+	s.resetRetries()
+	s.resetLatencies()
 }
 
 // AddTotalCount record push notification count.
@@ -58,6 +151,12 @@ func (s *StateStorage) AddAndroidError(count int64) {
 	s.store.Add(core.AndroidErrorKey, count)
 }
 
+// AddAndroidErrorByType record an error Android push notification by FCM
+// error type, AddAndroidError still needs to be called for the total.
+func (s *StateStorage) AddAndroidErrorByType(kind string) {
+	s.store.Add(androidErrorTypeKey(kind), 1)
+}
+
 // AddHuaweiSuccess record counts of success Huawei push notification.
 func (s *StateStorage) AddHuaweiSuccess(count int64) {
 	s.store.Add(core.HuaweiSuccessKey, count)
@@ -93,6 +192,11 @@ func (s *StateStorage) GetAndroidError() int64 {
 	return s.store.Get(core.AndroidErrorKey)
 }
 
+// GetAndroidErrorByType show error counts of Android notification by FCM error type.
+func (s *StateStorage) GetAndroidErrorByType(kind string) int64 {
+	return s.store.Get(androidErrorTypeKey(kind))
+}
+
 // GetHuaweiSuccess show success counts of Huawei notification.
 func (s *StateStorage) GetHuaweiSuccess() int64 {
 	return s.store.Get(core.HuaweiSuccessKey)
@@ -102,3 +206,126 @@ func (s *StateStorage) GetHuaweiSuccess() int64 {
 func (s *StateStorage) GetHuaweiError() int64 {
 	return s.store.Get(core.HuaweiErrorKey)
 }
+
+// SetQueueDepth records the number of notifications waiting for a worker.
+func (s *StateStorage) SetQueueDepth(depth int64) {
+	s.queueDepth.Store(depth)
+}
+
+// GetQueueDepth show the number of notifications waiting for a worker.
+func (s *StateStorage) GetQueueDepth() int64 {
+	return s.queueDepth.Load()
+}
+
+// IncInFlight records a notification being sent.
+func (s *StateStorage) IncInFlight() {
+	s.inFlight.Add(1)
+}
+
+// DecInFlight records a notification sent.
+func (s *StateStorage) DecInFlight() {
+	s.inFlight.Add(-1)
+}
+
+// GetInFlight show the number of notifications being sent.
+func (s *StateStorage) GetInFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Tenants lists the tenants counted, sorted. The storages implementing
+// core.KeyStorage keep them across the restarts.
+func (s *StateStorage) Tenants() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenants := make([]string, 0, len(s.tenants))
+	for tenant := range s.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// addByTenant records the global counter and the counter of the tenant, an
+// empty tenant only records the global counter.
+func (s *StateStorage) addByTenant(key, tenant string, count int64) {
+	s.store.Add(key, count)
+	if tenant == "" {
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.tenants[tenant]; !ok {
+		s.tenants[tenant] = struct{}{}
+		s.store.Set(core.TenantKeyPrefix+tenant, 1)
+	}
+	s.mu.Unlock()
+
+	s.store.Add(tenantKey(key, tenant), count)
+}
+
+// AddIosSuccessByTenant record counts of success iOS push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddIosSuccessByTenant(tenant string, count int64) {
+	s.addByTenant(core.IosSuccessKey, tenant, count)
+}
+
+// AddIosErrorByTenant record counts of error iOS push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddIosErrorByTenant(tenant string, count int64) {
+	s.addByTenant(core.IosErrorKey, tenant, count)
+}
+
+// AddAndroidSuccessByTenant record counts of success Android push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddAndroidSuccessByTenant(tenant string, count int64) {
+	s.addByTenant(core.AndroidSuccessKey, tenant, count)
+}
+
+// AddAndroidErrorByTenant record counts of error Android push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddAndroidErrorByTenant(tenant string, count int64) {
+	s.addByTenant(core.AndroidErrorKey, tenant, count)
+}
+
+// AddHuaweiSuccessByTenant record counts of success Huawei push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddHuaweiSuccessByTenant(tenant string, count int64) {
+	s.addByTenant(core.HuaweiSuccessKey, tenant, count)
+}
+
+// AddHuaweiErrorByTenant record counts of error Huawei push notification of
+// the tenant, the global count included.
+func (s *StateStorage) AddHuaweiErrorByTenant(tenant string, count int64) {
+	s.addByTenant(core.HuaweiErrorKey, tenant, count)
+}
+
+// GetIosSuccessByTenant show success counts of iOS notification of the tenant.
+func (s *StateStorage) GetIosSuccessByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.IosSuccessKey, tenant))
+}
+
+// GetIosErrorByTenant show error counts of iOS notification of the tenant.
+func (s *StateStorage) GetIosErrorByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.IosErrorKey, tenant))
+}
+
+// GetAndroidSuccessByTenant show success counts of Android notification of the tenant.
+func (s *StateStorage) GetAndroidSuccessByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.AndroidSuccessKey, tenant))
+}
+
+// GetAndroidErrorByTenant show error counts of Android notification of the tenant.
+func (s *StateStorage) GetAndroidErrorByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.AndroidErrorKey, tenant))
+}
+
+// GetHuaweiSuccessByTenant show success counts of Huawei notification of the tenant.
+func (s *StateStorage) GetHuaweiSuccessByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.HuaweiSuccessKey, tenant))
+}
+
+// GetHuaweiErrorByTenant show error counts of Huawei notification of the tenant.
+func (s *StateStorage) GetHuaweiErrorByTenant(tenant string) int64 {
+	return s.store.Get(tenantKey(core.HuaweiErrorKey, tenant))
+}
@@ -45,6 +45,29 @@ func (s *Storage) Get(key string) int64 {
 	return s.getBadger(key)
 }
 
+// Keys lists the keys with the prefix.
+func (s *Storage) Keys(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(s.name, "keys error:", err.Error())
+	}
+	return keys
+}
+
 // Init client storage.
 func (s *Storage) Init() error {
 	var err error
@@ -43,5 +43,9 @@ func TestBadgerEngine(t *testing.T) {
 	val = badger.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	badger.Set(core.TenantKeyPrefix+"alpha", 1)
+	badger.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, badger.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, badger.Close())
 }
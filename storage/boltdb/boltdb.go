@@ -1,11 +1,13 @@
 package boltdb
 
 import (
+	"bytes"
 	"log"
 	"sync"
 
 	"github.com/appleboy/gorush/config"
 	"github.com/asdine/storm/v3"
+	bolt "go.etcd.io/bbolt"
 )
 
 // New func implements the storage interface for gorush (https://github.com/appleboy/gorush)
@@ -40,6 +42,29 @@ func (s *Storage) Get(key string) int64 {
 	return s.getBoltDB(key)
 }
 
+// Keys lists the keys with the prefix.
+func (s *Storage) Keys(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	err := s.db.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(s.config.Stat.BoltDB.Bucket))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("BoltDB keys error:", err.Error())
+	}
+	return keys
+}
+
 // Init client storage.
 func (s *Storage) Init() error {
 	var err error
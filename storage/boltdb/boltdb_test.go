@@ -43,5 +43,9 @@ func TestBoltDBEngine(t *testing.T) {
 	val = boltDB.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	boltDB.Set(core.TenantKeyPrefix+"alpha", 1)
+	boltDB.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, boltDB.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, boltDB.Close())
 }
@@ -42,6 +42,24 @@ func (s *Storage) Get(key string) int64 {
 	return s.getBuntDB(key)
 }
 
+// Keys lists the keys with the prefix.
+func (s *Storage) Keys(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	err := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(prefix+"*", func(key, _ string) bool {
+			keys = append(keys, key)
+			return true
+		})
+	})
+	if err != nil {
+		log.Println("BuntDB keys error:", err.Error())
+	}
+	return keys
+}
+
 // Init client storage.
 func (s *Storage) Init() error {
 	var err error
@@ -49,5 +49,9 @@ func TestBuntDBEngine(t *testing.T) {
 	val = buntDB.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	buntDB.Set(core.TenantKeyPrefix+"alpha", 1)
+	buntDB.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, buntDB.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, buntDB.Close())
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/appleboy/gorush/config"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func (s *Storage) setLevelDB(key string, count int64) {
@@ -52,6 +53,20 @@ func (s *Storage) Get(key string) int64 {
 	return s.getLevelDB(key)
 }
 
+// Keys lists the keys with the prefix.
+func (s *Storage) Keys(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []string
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	return keys
+}
+
 // Init client storage.
 func (s *Storage) Init() error {
 	var err error
@@ -49,5 +49,9 @@ func TestLevelDBEngine(t *testing.T) {
 	val = levelDB.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	levelDB.Set(core.TenantKeyPrefix+"alpha", 1)
+	levelDB.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, levelDB.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, levelDB.Close())
 }
@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"strings"
 	"sync"
 
 	"go.uber.org/atomic"
@@ -20,9 +21,9 @@ func (s *Storage) getValueBtKey(key string) *atomic.Int64 {
 	if val, ok := s.mem.Load(key); ok {
 		return val.(*atomic.Int64)
 	}
-	val := atomic.NewInt64(0)
-	s.mem.Store(key, val)
-	return val
+	// another goroutine may store the key first
+	val, _ := s.mem.LoadOrStore(key, atomic.NewInt64(0))
+	return val.(*atomic.Int64)
 }
 
 func (s *Storage) Add(key string, count int64) {
@@ -37,6 +38,18 @@ func (s *Storage) Get(key string) int64 {
 	return s.getValueBtKey(key).Load()
 }
 
+// Keys lists the keys with the prefix.
+func (s *Storage) Keys(prefix string) []string {
+	var keys []string
+	s.mem.Range(func(key, _ any) bool {
+		if k := key.(string); strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	return keys
+}
+
 // Init client storage.
 func (*Storage) Init() error {
 	return nil
@@ -40,5 +40,21 @@ func TestMemoryEngine(t *testing.T) {
 	val = memory.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	// concurrent adds of a new key
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			memory.Add(core.HuaweiErrorKey, 1)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	val = memory.Get(core.HuaweiErrorKey)
+	assert.Equal(t, int64(10), val)
+
+	memory.Set(core.TenantKeyPrefix+"alpha", 1)
+	memory.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, memory.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, memory.Close())
 }
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/appleboy/gorush/config"
 
@@ -41,6 +42,34 @@ func (s *Storage) Get(key string) int64 {
 	return count
 }
 
+// Keys lists the keys with the prefix, on all the masters of a cluster.
+func (s *Storage) Keys(prefix string) []string {
+	cluster, ok := s.client.(*redis.ClusterClient)
+	if !ok {
+		return scanKeys(s.ctx, s.client, prefix)
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	_ = cluster.ForEachMaster(s.ctx, func(ctx context.Context, client *redis.Client) error {
+		found := scanKeys(ctx, client, prefix)
+		mu.Lock()
+		keys = append(keys, found...)
+		mu.Unlock()
+		return nil
+	})
+	return keys
+}
+
+func scanKeys(ctx context.Context, client redis.Cmdable, prefix string) []string {
+	var keys []string
+	iter := client.Scan(ctx, 0, prefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys
+}
+
 // Init client storage.
 func (s *Storage) Init() error {
 	if s.config.Stat.Redis.Cluster {
@@ -54,5 +54,9 @@ func TestRedisEngine(t *testing.T) {
 	val = redis.Get(core.HuaweiSuccessKey)
 	assert.Equal(t, int64(10), val)
 
+	redis.Set(core.TenantKeyPrefix+"alpha", 1)
+	redis.Set(core.TenantKeyPrefix+"beta", 1)
+	assert.ElementsMatch(t, []string{core.TenantKeyPrefix + "alpha", core.TenantKeyPrefix + "beta"}, redis.Keys(core.TenantKeyPrefix))
+
 	assert.NoError(t, redis.Close())
 }